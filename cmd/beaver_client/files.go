@@ -0,0 +1,35 @@
+package main
+
+import (
+	"github.com/amalshaji/beaver/internal/client"
+	"github.com/spf13/cobra"
+)
+
+var (
+	listing  bool
+	spa      bool
+	filesCmd = &cobra.Command{
+		Use:   "files [DIR]",
+		Short: "Serve the files of a directory through a tunnel, without a local server",
+		Long: `Serve the files of a directory through a tunnel, without a local server.
+Range requests are supported and the content types are guessed from the extensions. Hidden files are not served.`,
+		Example: `  beaver files ./dist --spa
+  beaver files ./coverage --listing`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			startTunnels([]client.TunnelConfig{{Subdomain: subdomain, Dir: args[0], Listing: listing, SPA: spa, Auth: basicAuth, AllowIPs: allowIPs, DenyIPs: denyIPs}}, nil)
+		},
+	}
+)
+
+func init() {
+	filesCmd.Flags().StringVar(&subdomain, "subdomain", "", "Subdomain to tunnel http requests (default \"<random_subdomain>\")")
+	filesCmd.Flags().BoolVar(&listing, "listing", false, "List the files of the directories without an index.html")
+	filesCmd.Flags().BoolVar(&spa, "spa", false, "Serve index.html for the paths which do not exist, for single page apps")
+	filesCmd.Flags().StringVar(&basicAuth, "auth", "", "Protect the tunnel with HTTP Basic Auth, as `username:password`")
+	filesCmd.Flags().StringSliceVar(&allowIPs, "allow-ip", nil, "Only accept requests from these addresses, as CIDRs or IPs")
+	filesCmd.Flags().StringSliceVar(&denyIPs, "deny-ip", nil, "Refuse requests from these addresses, as CIDRs or IPs")
+	filesCmd.Flags().StringVar(&recordFile, "record", "", "Append the requests and their responses to a `file`, to replay them later")
+
+	rootCmd.AddCommand(filesCmd)
+}
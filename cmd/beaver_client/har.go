@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/amalshaji/beaver/internal/har"
+	"github.com/amalshaji/beaver/internal/traffic"
+	"github.com/labstack/gommon/color"
+	"github.com/spf13/cobra"
+)
+
+var (
+	harSubdomain string
+	harPort      int
+	exportHarCmd = &cobra.Command{
+		Use:   "export-har [FILE]",
+		Short: "Export the requests captured by the running client to a HAR file",
+		Long:  "Export the requests captured by the inspector of the running client to a HAR 1.2 file, or to the standard output when FILE is omitted",
+		Args:  cobra.MaximumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			exchanges, err := newAPIClient().Requests(harSubdomain)
+			if err != nil {
+				exitWithError(err)
+			}
+
+			out := os.Stdout
+			if len(args) == 1 && args[0] != "-" {
+				if out, err = os.Create(args[0]); err != nil {
+					exitWithError(err)
+				}
+				defer out.Close()
+			}
+
+			if err := har.New(har.Creator{Name: "beaver", Version: VERSION}, exchanges).Write(out); err != nil {
+				exitWithError(err)
+			}
+			if out != os.Stdout {
+				fmt.Println(color.Green(fmt.Sprintf("Exported %d requests to %s", len(exchanges), args[0])))
+			}
+		},
+	}
+	importHarCmd = &cobra.Command{
+		Use:   "import-har [FILE]",
+		Short: "Replay the requests of a HAR file against a local port",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			file, err := os.Open(args[0])
+			if err != nil {
+				exitWithError(err)
+			}
+			defer file.Close()
+
+			h, err := har.Read(file)
+			if err != nil {
+				exitWithError(err)
+			}
+			exchanges, err := h.Exchanges()
+			if err != nil {
+				exitWithError(err)
+			}
+
+			replayHar(exchanges)
+		},
+	}
+)
+
+// replayHar sends the requests in order to the local port and prints their new status next to the recorded one
+func replayHar(exchanges []*traffic.Exchange) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "METHOD\tURL\tRECORDED\tSTATUS\tLATENCY")
+
+	failed := 0
+	sendRecorded(exchanges, harPort, harSubdomain, func(recorded, replayed *traffic.Exchange) {
+		if replayed.Response == nil || (recorded.Response != nil && replayed.Response.StatusCode != recorded.Response.StatusCode) {
+			failed++
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", recorded.Request.Method, recorded.Request.URL, recordedStatus(recorded), status(replayed), replayed.Duration.Round(time.Millisecond))
+	})
+	w.Flush()
+
+	if failed > 0 {
+		fmt.Println(color.Red(fmt.Sprintf("%d requests failed or got a different status", failed)))
+		os.Exit(1)
+	}
+}
+
+func recordedStatus(exchange *traffic.Exchange) string {
+	if exchange.Response == nil {
+		return "-"
+	}
+	return fmt.Sprint(exchange.Response.StatusCode)
+}
+
+func init() {
+	exportHarCmd.Flags().StringVar(&harSubdomain, "subdomain", "", "Only export the requests of this tunnel")
+
+	importHarCmd.Flags().IntVar(&harPort, "port", 0, "Local port to send the requests to")
+	importHarCmd.Flags().StringVar(&harSubdomain, "subdomain", "", "Only replay the requests of this tunnel")
+	importHarCmd.MarkFlagRequired("port")
+
+	rootCmd.AddCommand(exportHarCmd, importHarCmd)
+}
@@ -3,15 +3,30 @@ package main
 import (
 	"fmt"
 	"strconv"
+	"strings"
 
 	"github.com/amalshaji/beaver/internal/client"
+	"github.com/amalshaji/beaver/internal/fault"
+	"github.com/amalshaji/beaver/internal/rewrite"
 	"github.com/spf13/cobra"
 )
 
 var (
-	port      int
-	subdomain string
-	httpCmd   = &cobra.Command{
+	port        int
+	subdomain   string
+	recordFile  string
+	mocksFile   string
+	faults      []string
+	basicAuth   string
+	allowIPs    []string
+	denyIPs     []string
+	webhookSpec string
+	rewriteHost string
+	stripPrefix string
+	addPrefix   string
+	reqHeaders  []string
+	respHeaders []string
+	httpCmd     = &cobra.Command{
 		Use:   "http [PORT]",
 		Short: "Tunnel local http servers",
 		Args: func(cmd *cobra.Command, args []string) error {
@@ -30,16 +45,72 @@ var (
 
 			return nil
 		},
+		Example: `  beaver http 3000 --fault latency=200ms,jitter=50ms,error=5%
+  beaver http 3000 --fault path=/api/*,reset=10% --fault bandwidth=64KB
+  beaver http 3000 --verify-webhook github:$GITHUB_WEBHOOK_SECRET
+  beaver http 3000 --host-header myapp.test --strip-prefix /api --request-header "X-Internal-Token: secret"`,
 		Run: func(cmd *cobra.Command, args []string) {
+			var rules []fault.Rule
+			for _, f := range faults {
+				rule, err := fault.ParseRule(f)
+				if err != nil {
+					exitWithError(err)
+				}
+				rules = append(rules, rule)
+			}
+
+			rewriteRules, err := parseRewriteRules()
+			if err != nil {
+				exitWithError(err)
+			}
+
 			var tunnels = make([]client.TunnelConfig, 0)
-			tunnels = append(tunnels, client.TunnelConfig{Port: port, Subdomain: subdomain})
-			startTunnels(tunnels)
+			tunnels = append(tunnels, client.TunnelConfig{Port: port, Subdomain: subdomain, Mocks: mocksFile, Faults: rules, Auth: basicAuth, AllowIPs: allowIPs, DenyIPs: denyIPs, Webhook: webhookSpec, Rewrite: rewriteRules})
+			startTunnels(tunnels, nil)
 		},
 	}
 )
 
 func init() {
 	httpCmd.Flags().StringVar(&subdomain, "subdomain", "", "Subdomain to tunnel http requests (default \"<random_subdomain>\")")
+	httpCmd.Flags().StringVar(&recordFile, "record", "", "Append the requests and their responses to a `file`, to replay them later")
+	httpCmd.Flags().StringVar(&mocksFile, "mocks", "", "Answer the requests from a mocks `file` while the local server is unreachable")
+	httpCmd.Flags().StringVar(&basicAuth, "auth", "", "Protect the tunnel with HTTP Basic Auth, as `username:password`")
+	httpCmd.Flags().StringSliceVar(&allowIPs, "allow-ip", nil, "Only accept requests from these addresses, as CIDRs or IPs")
+	httpCmd.Flags().StringSliceVar(&denyIPs, "deny-ip", nil, "Refuse requests from these addresses, as CIDRs or IPs")
+	httpCmd.Flags().StringVar(&webhookSpec, "verify-webhook", "", "Refuse the webhooks without a valid signature, as `provider:secret` (github, stripe or slack) or header=X-Signature,algorithm=sha256,encoding=hex,prefix=sha256=,secret=...")
+	httpCmd.Flags().StringArrayVar(&faults, "fault", nil, "Inject faults into the requests: latency, jitter, error (%), status, reset (%), bandwidth (per second) and path, can be repeated, the first rule matching a path applies")
+	httpCmd.Flags().StringVar(&rewriteHost, "host-header", "", "Host header sent to the local server")
+	httpCmd.Flags().StringVar(&stripPrefix, "strip-prefix", "", "Path prefix removed from the requests, eg: /api")
+	httpCmd.Flags().StringVar(&addPrefix, "add-prefix", "", "Path prefix added to the requests, after --strip-prefix")
+	httpCmd.Flags().StringArrayVar(&reqHeaders, "request-header", nil, "Header set on the requests, as `Name: value`, can be repeated")
+	httpCmd.Flags().StringArrayVar(&respHeaders, "response-header", nil, "Header set on the responses, as `Name: value`, can be repeated")
 
 	rootCmd.AddCommand(httpCmd)
 }
+
+// parseRewriteRules returns the rewrite rules of the flags, nil when there are none.
+// Headers are removed and rewritten from the config file.
+func parseRewriteRules() (*rewrite.Rules, error) {
+	if rewriteHost == "" && stripPrefix == "" && addPrefix == "" && len(reqHeaders) == 0 && len(respHeaders) == 0 {
+		return nil, nil
+	}
+
+	rules := &rewrite.Rules{Host: rewriteHost, StripPrefix: stripPrefix, AddPrefix: addPrefix}
+	for _, headers := range []struct {
+		values []string
+		rules  *rewrite.HeaderRules
+	}{{reqHeaders, &rules.Request}, {respHeaders, &rules.Response}} {
+		for _, header := range headers.values {
+			name, value, ok := strings.Cut(header, ":")
+			if !ok {
+				return nil, fmt.Errorf("invalid header %q, expected Name: value", header)
+			}
+			if headers.rules.Set == nil {
+				headers.rules.Set = make(map[string]string)
+			}
+			headers.rules.Set[strings.TrimSpace(name)] = strings.TrimSpace(value)
+		}
+	}
+	return rules, nil
+}
@@ -2,36 +2,125 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"log"
 	"os"
 	"os/signal"
 	"syscall"
 
 	"github.com/amalshaji/beaver/internal/client"
+	"github.com/labstack/gommon/color"
 )
 
-func startTunnels(tunnels []client.TunnelConfig) {
+// startTunnels runs the tunnels until the process is stopped.
+// reload returns the tunnels to apply when the daemon is asked to reload its configuration, it may be nil.
+func startTunnels(tunnels []client.TunnelConfig, reload func() ([]client.TunnelConfig, error)) {
 	ctx := context.Background()
-	var proxies []*client.Client
 
-	for _, proxyTunnel := range tunnels {
-		config, err := client.LoadConfiguration(configFile, proxyTunnel.Subdomain, proxyTunnel.Port, showWsReadErrors)
+	// A single client session serves every tunnel over a shared pool of connections
+	config, err := client.LoadConfiguration(configFile, tunnels, showWsReadErrors)
+	if err != nil {
+		log.Fatalf("Unable to load configuration: %s", err)
+	}
+
+	if client.IsDaemon() {
+		logFile, err := client.NewRotatingFile(config.LogFile, client.LogMaxSize, client.LogBackups)
 		if err != nil {
-			log.Fatalf("Unable to load configuration: %s", err)
+			log.Fatalf("Unable to open log file: %s", err)
+		}
+		defer logFile.Close()
+		log.SetOutput(logFile)
+		color.Disable()
+
+		if err := client.WritePidFile(config.PidFile); err != nil {
+			log.Fatal(err)
+		}
+		defer client.RemovePidFile(config.PidFile)
+	}
+
+	proxy := client.NewClient(&config)
+
+	if recordFile != "" {
+		if err := proxy.Record(recordFile); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// Show the dashboard on interactive terminals, plain logs otherwise
+	var dashboard *client.Dashboard
+	stopDashboard := make(chan struct{})
+	dashboardDone := make(chan struct{})
+	if !plainLogs && !client.IsDaemon() && client.IsTerminal(os.Stdout) {
+		dashboard = client.NewDashboard(proxy, os.Stdout)
+		log.SetOutput(dashboard)
+		go func() {
+			dashboard.Run(stopDashboard)
+			close(dashboardDone)
+		}()
+	}
+
+	proxy.Start(ctx)
+
+	// Let `beaver status` and `beaver tunnel` manage the running session
+	if err := proxy.ServeAPI(config.ControlSocket); err != nil {
+		log.Printf("Control API disabled: %v", err)
+	}
+
+	// Browse and replay the requests from a local web UI
+	if !config.Inspector.Disabled {
+		if url, err := proxy.ServeInspector(config.Inspector.Addr); err != nil {
+			log.Printf("Inspector disabled: %v", err)
+		} else {
+			log.Printf("Inspector running at %s", url)
 		}
-		proxy := client.NewClient(&config)
-		proxies = append(proxies, proxy)
-		proxy.Start(ctx)
+	}
+	if recordFile != "" {
+		log.Printf("Recording requests to %s", recordFile)
 	}
 
-	// Wait signals
+	// Wait signals, or stop when the server disconnects the session.
+	// In the background, SIGHUP reloads the config file instead of stopping the tunnels.
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
-	<-sigCh
+	if client.IsDaemon() && reload != nil {
+		client.NotifyReload(sigCh)
+	}
+
+L:
+	for {
+		select {
+		case sig := <-sigCh:
+			if !client.IsReloadSignal(sig) {
+				break L
+			}
+			tunnels, err := reload()
+			if err == nil {
+				err = proxy.ReloadTunnels(tunnels)
+			}
+			if err != nil {
+				log.Printf("Unable to reload configuration: %v", err)
+			}
+		case <-proxy.Done():
+			break L
+		}
+	}
 
 	// When receives the signal, shutdown
-	for _, proxy := range proxies {
-		proxy.Shutdown()
+	proxy.Shutdown()
+
+	if dashboard != nil {
+		close(stopDashboard)
+		<-dashboardDone
+		log.SetOutput(os.Stderr)
+
+		// The logs were hidden by the dashboard, eg: why the server disconnected the session
+		for _, line := range dashboard.Logs() {
+			fmt.Fprintln(os.Stderr, line)
+		}
+	}
+
+	if err := proxy.Err(); err != nil {
+		log.Fatal(color.Red(err.Error()))
 	}
 }
 
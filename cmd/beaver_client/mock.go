@@ -0,0 +1,35 @@
+package main
+
+import (
+	"github.com/amalshaji/beaver/internal/client"
+	"github.com/spf13/cobra"
+)
+
+var mockCmd = &cobra.Command{
+	Use:   "mock [FILE]",
+	Short: "Answer the requests of a tunnel from a mocks file, without a local server",
+	Long: `Answer the requests of a tunnel from a mocks file, without a local server. The file maps methods and paths to canned responses:
+
+routes:
+  - method: POST
+    path: /webhooks/:provider
+    status: 202
+    body: '{"received": true, "provider": "{{ .Params.provider }}"}'
+
+The same file can answer the requests of a local server while it is down, with beaver http PORT --mocks FILE.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		startTunnels([]client.TunnelConfig{{Subdomain: subdomain, Mocks: args[0], Auth: basicAuth, AllowIPs: allowIPs, DenyIPs: denyIPs, Webhook: webhookSpec}}, nil)
+	},
+}
+
+func init() {
+	mockCmd.Flags().StringVar(&subdomain, "subdomain", "", "Subdomain to tunnel http requests (default \"<random_subdomain>\")")
+	mockCmd.Flags().StringVar(&basicAuth, "auth", "", "Protect the tunnel with HTTP Basic Auth, as `username:password`")
+	mockCmd.Flags().StringSliceVar(&allowIPs, "allow-ip", nil, "Only accept requests from these addresses, as CIDRs or IPs")
+	mockCmd.Flags().StringSliceVar(&denyIPs, "deny-ip", nil, "Refuse requests from these addresses, as CIDRs or IPs")
+	mockCmd.Flags().StringVar(&webhookSpec, "verify-webhook", "", "Refuse the webhooks without a valid signature, as `provider:secret` (github, stripe or slack) or header=X-Signature,algorithm=sha256,encoding=hex,prefix=sha256=,secret=...")
+	mockCmd.Flags().StringVar(&recordFile, "record", "", "Append the requests and their responses to a `file`, to replay them later")
+
+	rootCmd.AddCommand(mockCmd)
+}
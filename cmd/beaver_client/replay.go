@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/amalshaji/beaver/internal/client"
+	"github.com/amalshaji/beaver/internal/inspector"
+	"github.com/amalshaji/beaver/internal/traffic"
+	"github.com/labstack/gommon/color"
+	"github.com/spf13/cobra"
+)
+
+// Number of changed body lines shown per request
+const maxReportedLines = 20
+
+var (
+	replayPort      int
+	replaySubdomain string
+	ignoreHeaders   []string
+	ignorePaths     []string
+	replayCmd       = &cobra.Command{
+		Use:   "replay [FILE]",
+		Short: "Send the requests of a recording to a local port and compare the responses",
+		Long: `Send the requests recorded with --record to a local port, in order, and compare the responses with the recorded ones.
+The command fails when a status, a header or a body differs, leave out the values changing on every request with --ignore-header and --ignore-path.`,
+		Example: `  beaver http 3000 --record session.jsonl
+  beaver replay session.jsonl --port 3000 --ignore-header X-Request-Id --ignore-path '$.id' --ignore-path '$..createdAt'`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			// The date of the responses always changes
+			ignore := inspector.Ignore{Headers: append([]string{"Date"}, ignoreHeaders...)}
+			for _, path := range ignorePaths {
+				jsonPath, err := inspector.ParseJSONPath(path)
+				if err != nil {
+					exitWithError(err)
+				}
+				ignore.Paths = append(ignore.Paths, jsonPath)
+			}
+
+			exchanges, err := inspector.ReadRecording(args[0])
+			if err != nil {
+				exitWithError(err)
+			}
+
+			passed, failed := 0, 0
+			sendRecorded(exchanges, replayPort, replaySubdomain, func(recorded, replayed *traffic.Exchange) {
+				// The local server could not be reached, there is nothing to compare
+				if replayed.Response == nil {
+					failed++
+					fmt.Printf("%s %s %s %s\n", color.Red("✗"), recorded.Request.Method, recorded.Request.URL, replayed.Error)
+					return
+				}
+
+				diff := inspector.CompareIgnoring(recorded, replayed, ignore)
+				if diff.Equal() {
+					passed++
+					fmt.Printf("%s %s %s %s\n", color.Green("✓"), recorded.Request.Method, recorded.Request.URL, status(replayed))
+					return
+				}
+				failed++
+				fmt.Printf("%s %s %s %s\n", color.Red("✗"), recorded.Request.Method, recorded.Request.URL, status(replayed))
+				printDiff(diff)
+			})
+
+			fmt.Printf("\n%d passed, %d failed\n", passed, failed)
+			if failed > 0 {
+				os.Exit(1)
+			}
+		},
+	}
+)
+
+// sendRecorded sends the recorded requests of a tunnel, or of all of them when subdomain is empty, to a local port in order
+func sendRecorded(exchanges []*traffic.Exchange, port int, subdomain string, fn func(recorded, replayed *traffic.Exchange)) {
+	httpClient := client.NewLocalHTTPClient()
+	baseURL := fmt.Sprintf("http://localhost:%d", port)
+
+	for _, recorded := range exchanges {
+		if subdomain != "" && recorded.Subdomain != subdomain && !strings.HasPrefix(recorded.Request.Host, subdomain+".") {
+			continue
+		}
+		fn(recorded, traffic.Send(context.Background(), httpClient, baseURL, recorded.Request, inspector.RecordMaxBodySize))
+	}
+}
+
+// status returns the status of a response, or the error of a failed request
+func status(exchange *traffic.Exchange) string {
+	if exchange.Response == nil {
+		return exchange.Error
+	}
+	return fmt.Sprint(exchange.Response.StatusCode)
+}
+
+func printDiff(diff *inspector.Diff) {
+	for _, change := range diff.Fields {
+		fmt.Printf("    %s: %s → %s\n", change.Name, quoteEmpty(change.A), quoteEmpty(change.B))
+	}
+	for _, change := range diff.ResponseHeaders {
+		fmt.Printf("    header %s: %s → %s\n", change.Name, quoteEmpty(change.A), quoteEmpty(change.B))
+	}
+
+	reported := 0
+	for _, line := range diff.ResponseBody {
+		if line.Op == inspector.LineEqual {
+			continue
+		}
+		if reported == maxReportedLines {
+			fmt.Println("    ...")
+			break
+		}
+		reported++
+
+		text := fmt.Sprintf("    body %s %s", line.Op, line.Text)
+		if line.Op == inspector.LineAdded {
+			fmt.Println(color.Green(text))
+		} else {
+			fmt.Println(color.Red(text))
+		}
+	}
+}
+
+func quoteEmpty(s string) string {
+	if s == "" {
+		return `""`
+	}
+	return s
+}
+
+func init() {
+	replayCmd.Flags().IntVar(&replayPort, "port", 0, "Local port to send the requests to")
+	replayCmd.Flags().StringVar(&replaySubdomain, "subdomain", "", "Only replay the requests of this tunnel")
+	replayCmd.Flags().StringSliceVar(&ignoreHeaders, "ignore-header", nil, "Response header to leave out of the comparison, can be repeated (Date is always left out)")
+	replayCmd.Flags().StringSliceVar(&ignorePaths, "ignore-path", nil, "JSON path of a body value to leave out of the comparison, eg: $.items[*].id, can be repeated")
+	replayCmd.MarkFlagRequired("port")
+
+	rootCmd.AddCommand(replayCmd)
+}
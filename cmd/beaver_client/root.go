@@ -10,6 +10,7 @@ import (
 var (
 	configFile       string
 	showWsReadErrors bool
+	plainLogs        bool
 	rootCmd          = &cobra.Command{
 		Use:   "beaver",
 		Short: "Tunnel local ports to public URLs",
@@ -30,6 +31,7 @@ func getDefaultConfigFilePath() string {
 func init() {
 	rootCmd.PersistentFlags().StringVar(&configFile, "config", getDefaultConfigFilePath(), "Path to the client config file")
 	rootCmd.PersistentFlags().BoolVar(&showWsReadErrors, "showWsReadErrors", false, "Log websocket read errors")
+	rootCmd.PersistentFlags().BoolVar(&plainLogs, "plain", false, "Print plain logs instead of the terminal dashboard")
 
 	rootCmd.PersistentFlags().MarkHidden("showWsReadErrors")
 }
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/amalshaji/beaver/internal/client"
+	"github.com/labstack/gommon/color"
+	"github.com/spf13/cobra"
+)
+
+var (
+	shareExpires time.Duration
+	shareNote    string
+	shareCmd     = &cobra.Command{
+		Use:   "share [SUBDOMAIN]",
+		Short: "Create a link sharing a tunnel, it skips basic auth and OpenID Connect until it expires",
+		Example: `  beaver share demo --expires 2h --note "review with the design team"
+  beaver share list demo
+  beaver share revoke 12`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			link, err := newShareClient().CreateShareLink(args[0], shareExpires, shareNote)
+			if err != nil {
+				exitWithError(err)
+			}
+			fmt.Println(color.Green(fmt.Sprintf("Share link %d expires at %s", link.ID, link.ExpiresAt.Local().Format(time.RFC1123))))
+			fmt.Println(link.URL)
+		},
+	}
+	shareListCmd = &cobra.Command{
+		Use:   "list [SUBDOMAIN]",
+		Short: "List the share links of a tunnel",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			links, err := newShareClient().ShareLinks(args[0])
+			if err != nil {
+				exitWithError(err)
+			}
+
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "ID\tCREATED BY\tEXPIRES\tSTATUS\tNOTE")
+			for _, link := range links {
+				status := "active"
+				switch {
+				case link.RevokedAt != nil:
+					status = "revoked"
+				case time.Now().After(link.ExpiresAt):
+					status = "expired"
+				}
+				fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\n", link.ID, link.CreatedBy, link.ExpiresAt.Local().Format(time.RFC1123), status, link.Note)
+			}
+			w.Flush()
+		},
+	}
+	shareRevokeCmd = &cobra.Command{
+		Use:   "revoke [ID]",
+		Short: "Revoke a share link, its visitors lose access right away",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			id, err := strconv.ParseUint(args[0], 10, 0)
+			if err != nil {
+				exitWithError(fmt.Errorf("id must be a number"))
+			}
+			if _, err := newShareClient().RevokeShareLink(uint(id)); err != nil {
+				exitWithError(err)
+			}
+			fmt.Println(color.Yellow(fmt.Sprintf("Share link %d revoked", id)))
+		},
+	}
+)
+
+func newShareClient() *client.ShareClient {
+	return client.NewShareClient(client.LoadRuntimeConfig(configFile))
+}
+
+func init() {
+	shareCmd.Flags().DurationVar(&shareExpires, "expires", 24*time.Hour, "Time before the link expires")
+	shareCmd.Flags().StringVar(&shareNote, "note", "", "Note to remember who the link was shared with")
+
+	shareCmd.AddCommand(shareListCmd)
+	shareCmd.AddCommand(shareRevokeCmd)
+	rootCmd.AddCommand(shareCmd)
+}
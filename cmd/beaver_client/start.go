@@ -6,12 +6,14 @@ import (
 	"os"
 
 	"github.com/amalshaji/beaver/internal/client"
+	"github.com/labstack/gommon/color"
 	"github.com/spf13/cobra"
 	"gopkg.in/yaml.v3"
 )
 
 var (
 	all      bool
+	detach   bool
 	startCmd = &cobra.Command{
 		Use:   "start [--all] or [tunnel1 tunnel2]",
 		Short: "Start tunnels defined in the config file",
@@ -22,31 +24,61 @@ var (
 			return nil
 		},
 		Run: func(cmd *cobra.Command, args []string) {
-			proxyTunnels, err := loadProxyTunnelConfig()
-			if err != nil {
-				log.Fatal(err)
+			// Start the same command again in the background
+			if detach && !client.IsDaemon() {
+				startDetached()
+				return
 			}
 
-			var filteredTunnels = make([]client.TunnelConfig, 0)
-
-			if all {
-				filteredTunnels = proxyTunnels.Tunnels
-			} else {
-				tunnelsToStart := os.Args[2:]
-				for _, tunnel := range tunnelsToStart {
-					for _, proxyTunnel := range proxyTunnels.Tunnels {
-						if proxyTunnel.Name == tunnel {
-							filteredTunnels = append(filteredTunnels, proxyTunnel)
-						}
-					}
-				}
+			tunnels, err := selectTunnels(args)
+			if err != nil {
+				log.Fatal(err)
 			}
 
-			startTunnels(filteredTunnels)
+			// Apply the tunnels of the edited config file on reload
+			startTunnels(tunnels, func() ([]client.TunnelConfig, error) {
+				return selectTunnels(args)
+			})
 		},
 	}
 )
 
+// selectTunnels returns the tunnels of the config file to start
+func selectTunnels(names []string) ([]client.TunnelConfig, error) {
+	proxyTunnels, err := loadProxyTunnelConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	if all {
+		return proxyTunnels.Tunnels, nil
+	}
+
+	var filteredTunnels = make([]client.TunnelConfig, 0)
+	for _, tunnel := range names {
+		for _, proxyTunnel := range proxyTunnels.Tunnels {
+			if proxyTunnel.Name == tunnel {
+				filteredTunnels = append(filteredTunnels, proxyTunnel)
+			}
+		}
+	}
+	return filteredTunnels, nil
+}
+
+func startDetached() {
+	config := client.LoadRuntimeConfig(configFile)
+
+	if pid, err := client.ReadPidFile(config.PidFile); err == nil {
+		exitWithError(fmt.Errorf("already running in the background (pid %d)", pid))
+	}
+
+	pid, err := client.Detach(os.Args[1:], config.PidFile, config.LogFile)
+	if err != nil {
+		exitWithError(fmt.Errorf("unable to start in the background: %w, see %s", err, config.LogFile))
+	}
+	fmt.Println(color.Green(fmt.Sprintf("Running in the background (pid %d), logs at %s", pid, config.LogFile)))
+}
+
 func loadProxyTunnelConfig() (*client.ProxyTunnels, error) {
 	var config *client.ProxyTunnels
 
@@ -64,6 +96,8 @@ func loadProxyTunnelConfig() (*client.ProxyTunnels, error) {
 
 func init() {
 	startCmd.Flags().BoolVar(&all, "all", false, "Start all tunnels listed in the config")
+	startCmd.Flags().BoolVar(&detach, "detach", false, "Run the tunnels in the background, see `beaver status` and `beaver stop`")
+	startCmd.Flags().StringVar(&recordFile, "record", "", "Append the requests and their responses to a `file`, to replay them later")
 
 	rootCmd.AddCommand(startCmd)
 }
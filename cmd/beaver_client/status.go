@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/amalshaji/beaver/internal/client"
+
+	"github.com/spf13/cobra"
+)
+
+var statusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show the tunnels of the running client",
+	Run: func(cmd *cobra.Command, args []string) {
+		if pid, err := client.ReadPidFile(client.LoadRuntimeConfig(configFile).PidFile); err == nil {
+			fmt.Printf("Running in the background (pid %d)\n\n", pid)
+		}
+
+		statuses, err := newAPIClient().Status()
+		if err != nil {
+			exitWithError(err)
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "NAME\tURL\tLOCAL\tSTATUS\tCONNECTIONS")
+		for _, status := range statuses {
+			state := "connecting"
+			switch {
+			case status.Error != "":
+				state = status.Error
+			case status.Paused:
+				state = "paused"
+			case status.Connected:
+				state = "online"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\n", status.Name, status.URL, status.Local, state, status.Connections)
+		}
+		w.Flush()
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(statusCmd)
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/amalshaji/beaver/internal/client"
+	"github.com/labstack/gommon/color"
+	"github.com/spf13/cobra"
+)
+
+var stopCmd = &cobra.Command{
+	Use:   "stop",
+	Short: "Stop the tunnels running in the background",
+	Run: func(cmd *cobra.Command, args []string) {
+		pid, err := client.Stop(client.LoadRuntimeConfig(configFile).PidFile, 30*time.Second)
+		if err != nil {
+			exitWithError(err)
+		}
+		fmt.Println(color.Green(fmt.Sprintf("Stopped (pid %d)", pid)))
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(stopCmd)
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/amalshaji/beaver/internal/client"
+	"github.com/labstack/gommon/color"
+	"github.com/spf13/cobra"
+)
+
+var (
+	tunnelName string
+	tunnelCmd  = &cobra.Command{
+		Use:   "tunnel",
+		Short: "Manage the tunnels of the running client",
+	}
+	tunnelAddCmd = &cobra.Command{
+		Use:   "add [PORT]",
+		Short: "Add a tunnel to the running client",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			port, err := strconv.Atoi(args[0])
+			if err != nil {
+				exitWithError(fmt.Errorf("port must be a number"))
+			}
+
+			// The running client resolves relative paths from its own directory
+			mocks := mocksFile
+			if mocks != "" {
+				if mocks, err = filepath.Abs(mocks); err != nil {
+					exitWithError(err)
+				}
+			}
+
+			tunnel, err := newAPIClient().AddTunnel(client.TunnelConfig{Name: tunnelName, Subdomain: subdomain, Port: port, Mocks: mocks, Auth: basicAuth, AllowIPs: allowIPs, DenyIPs: denyIPs, Webhook: webhookSpec})
+			if err != nil {
+				exitWithError(err)
+			}
+			fmt.Println(color.Green(fmt.Sprintf("Tunnel %s added", tunnel.Subdomain)))
+		},
+	}
+	tunnelRemoveCmd = &cobra.Command{
+		Use:   "remove [SUBDOMAIN]",
+		Short: "Remove a tunnel from the running client",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := newAPIClient().RemoveTunnel(args[0]); err != nil {
+				exitWithError(err)
+			}
+			fmt.Println(color.Green(fmt.Sprintf("Tunnel %s removed", args[0])))
+		},
+	}
+	tunnelPauseCmd = &cobra.Command{
+		Use:   "pause [SUBDOMAIN]",
+		Short: "Stop forwarding the requests of a tunnel, it keeps its subdomain",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := newAPIClient().PauseTunnel(args[0], true); err != nil {
+				exitWithError(err)
+			}
+			fmt.Println(color.Yellow(fmt.Sprintf("Tunnel %s paused", args[0])))
+		},
+	}
+	tunnelResumeCmd = &cobra.Command{
+		Use:   "resume [SUBDOMAIN]",
+		Short: "Forward the requests of a paused tunnel again",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := newAPIClient().PauseTunnel(args[0], false); err != nil {
+				exitWithError(err)
+			}
+			fmt.Println(color.Green(fmt.Sprintf("Tunnel %s resumed", args[0])))
+		},
+	}
+)
+
+func newAPIClient() *client.APIClient {
+	return client.NewAPIClient(client.LoadRuntimeConfig(configFile).ControlSocket)
+}
+
+func exitWithError(err error) {
+	fmt.Println(color.Red(err.Error()))
+	os.Exit(1)
+}
+
+func init() {
+	tunnelAddCmd.Flags().StringVar(&tunnelName, "name", "", "Name of the tunnel")
+	tunnelAddCmd.Flags().StringVar(&subdomain, "subdomain", "", "Subdomain to tunnel http requests (default \"<random_subdomain>\")")
+	tunnelAddCmd.Flags().StringVar(&basicAuth, "auth", "", "Protect the tunnel with HTTP Basic Auth, as `username:password`")
+	tunnelAddCmd.Flags().StringSliceVar(&allowIPs, "allow-ip", nil, "Only accept requests from these addresses, as CIDRs or IPs")
+	tunnelAddCmd.Flags().StringSliceVar(&denyIPs, "deny-ip", nil, "Refuse requests from these addresses, as CIDRs or IPs")
+	tunnelAddCmd.Flags().StringVar(&webhookSpec, "verify-webhook", "", "Refuse the webhooks without a valid signature, as `provider:secret` (github, stripe or slack) or header=X-Signature,algorithm=sha256,encoding=hex,prefix=sha256=,secret=...")
+	tunnelAddCmd.Flags().StringVar(&mocksFile, "mocks", "", "Answer the requests from a mocks `file` while the local server is unreachable")
+
+	tunnelCmd.AddCommand(tunnelAddCmd, tunnelRemoveCmd, tunnelPauseCmd, tunnelResumeCmd)
+	rootCmd.AddCommand(tunnelCmd)
+}
@@ -3,6 +3,7 @@ module github.com/amalshaji/beaver
 go 1.20
 
 require (
+	github.com/andybalholm/brotli v1.0.5
 	github.com/gorilla/websocket v1.5.0
 	github.com/labstack/echo/v4 v4.10.0
 	github.com/labstack/gommon v0.4.0
@@ -0,0 +1,218 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/amalshaji/beaver/internal/traffic"
+)
+
+var ErrAPIUnavailable = errors.New("no running client")
+
+// ServeAPI serves the control API over HTTP on a unix socket until the client is shut down :
+//
+//	GET    /tunnels                      list the tunnels with their public URLs and connection counts
+//	POST   /tunnels                      add a tunnel, eg: {"name": "api", "subdomain": "api", "port": 9000}
+//	DELETE /tunnels/:subdomain           remove a tunnel
+//	POST   /tunnels/:subdomain/pause     stop forwarding the requests of a tunnel, it keeps its subdomain
+//	POST   /tunnels/:subdomain/resume    forward the requests of a paused tunnel again
+//	GET    /requests                     requests captured by the inspector, the latest first (?subdomain= to filter)
+func (c *Client) ServeAPI(socket string) error {
+	// Only one running client can own the socket, a leftover file from a crashed one is replaced
+	if conn, err := net.Dial("unix", socket); err == nil {
+		conn.Close()
+		return fmt.Errorf("control socket %s is used by another client", socket)
+	}
+	os.Remove(socket)
+	if err := os.MkdirAll(filepath.Dir(socket), 0o700); err != nil {
+		return err
+	}
+
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		return fmt.Errorf("unable to listen on %s : %w", socket, err)
+	}
+
+	server := &http.Server{Handler: http.HandlerFunc(c.serveAPI)}
+	go func() {
+		<-c.done
+		server.Close()
+		os.Remove(socket)
+	}()
+
+	go func() {
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("Control API stopped : %v", err)
+		}
+	}()
+	return nil
+}
+
+func (c *Client) serveAPI(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(r.URL.Path, "/")
+	parts := strings.Split(path, "/")
+
+	if path == "requests" && r.Method == http.MethodGet {
+		exchanges := c.Requests(r.URL.Query().Get("subdomain"))
+		if exchanges == nil {
+			exchanges = []*traffic.Exchange{}
+		}
+		apiJSON(w, http.StatusOK, exchanges)
+		return
+	}
+
+	if parts[0] != "tunnels" || len(parts) > 3 {
+		apiError(w, http.StatusNotFound, "not found")
+		return
+	}
+
+	switch {
+	case len(parts) == 1 && r.Method == http.MethodGet:
+		apiJSON(w, http.StatusOK, c.Status())
+
+	case len(parts) == 1 && r.Method == http.MethodPost:
+		var tunnel TunnelConfig
+		if err := json.NewDecoder(r.Body).Decode(&tunnel); err != nil {
+			apiError(w, http.StatusBadRequest, "invalid payload")
+			return
+		}
+		tunnel, err := c.AddTunnel(tunnel)
+		if err != nil {
+			apiError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		apiJSON(w, http.StatusOK, tunnel)
+
+	case len(parts) == 2 && r.Method == http.MethodDelete:
+		if err := c.RemoveTunnel(parts[1]); err != nil {
+			apiError(w, apiErrorStatus(err), err.Error())
+			return
+		}
+		apiJSON(w, http.StatusOK, map[string]string{"message": "ok"})
+
+	case len(parts) == 3 && r.Method == http.MethodPost && (parts[2] == "pause" || parts[2] == "resume"):
+		if err := c.PauseTunnel(parts[1], parts[2] == "pause"); err != nil {
+			apiError(w, apiErrorStatus(err), err.Error())
+			return
+		}
+		apiJSON(w, http.StatusOK, map[string]string{"message": "ok"})
+
+	default:
+		apiError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func apiErrorStatus(err error) int {
+	if errors.Is(err, ErrTunnelNotFound) {
+		return http.StatusNotFound
+	}
+	return http.StatusBadRequest
+}
+
+func apiJSON(w http.ResponseWriter, status int, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
+
+func apiError(w http.ResponseWriter, status int, message string) {
+	apiJSON(w, status, map[string]string{"error": message})
+}
+
+// APIClient calls the control API of a running client
+type APIClient struct {
+	client *http.Client
+}
+
+// NewAPIClient returns an APIClient for the client listening on socket
+func NewAPIClient(socket string) *APIClient {
+	return &APIClient{
+		client: &http.Client{
+			Timeout: 5 * time.Second,
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					var dialer net.Dialer
+					return dialer.DialContext(ctx, "unix", socket)
+				},
+			},
+		},
+	}
+}
+
+func (a *APIClient) do(method string, path string, body any, out any) error {
+	var reader io.Reader
+	if body != nil {
+		payload, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = strings.NewReader(string(payload))
+	}
+
+	req, err := http.NewRequest(method, "http://beaver"+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := a.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrAPIUnavailable, err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		var apiErr map[string]string
+		json.NewDecoder(res.Body).Decode(&apiErr)
+		return errors.New(apiErr["error"])
+	}
+	if out != nil {
+		return json.NewDecoder(res.Body).Decode(out)
+	}
+	return nil
+}
+
+// Status lists the tunnels of the running client
+func (a *APIClient) Status() ([]TunnelStatus, error) {
+	var statuses []TunnelStatus
+	err := a.do(http.MethodGet, "/tunnels", nil, &statuses)
+	return statuses, err
+}
+
+// AddTunnel adds a tunnel to the running client
+func (a *APIClient) AddTunnel(tunnel TunnelConfig) (TunnelConfig, error) {
+	err := a.do(http.MethodPost, "/tunnels", tunnel, &tunnel)
+	return tunnel, err
+}
+
+// RemoveTunnel removes a tunnel from the running client
+func (a *APIClient) RemoveTunnel(subdomain string) error {
+	return a.do(http.MethodDelete, "/tunnels/"+subdomain, nil, nil)
+}
+
+// PauseTunnel pauses or resumes a tunnel of the running client
+func (a *APIClient) PauseTunnel(subdomain string, paused bool) error {
+	action := "resume"
+	if paused {
+		action = "pause"
+	}
+	return a.do(http.MethodPost, "/tunnels/"+subdomain+"/"+action, nil, nil)
+}
+
+// Requests returns the requests captured by the running client, the latest first
+func (a *APIClient) Requests(subdomain string) ([]*traffic.Exchange, error) {
+	var exchanges []*traffic.Exchange
+	err := a.do(http.MethodGet, "/requests?subdomain="+url.QueryEscape(subdomain), nil, &exchanges)
+	return exchanges, err
+}
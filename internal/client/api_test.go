@@ -0,0 +1,52 @@
+package client
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestControlAPI(t *testing.T) {
+	client := newTestClient("ws://localhost/register")
+	client.tunnels = []TunnelConfig{{Name: "web", Subdomain: "web", Port: 8000}}
+	defer client.Shutdown()
+
+	socket := filepath.Join(t.TempDir(), "beaver.sock")
+	assert.NoError(t, client.ServeAPI(socket))
+	// A single client owns the socket
+	assert.Error(t, newTestClient("ws://localhost/register").ServeAPI(socket))
+
+	api := NewAPIClient(socket)
+
+	tunnel, err := api.AddTunnel(TunnelConfig{Name: "api", Port: 9000})
+	assert.NoError(t, err)
+	assert.Len(t, tunnel.Subdomain, 6)
+	_, err = api.AddTunnel(TunnelConfig{Subdomain: "web", Port: 9001})
+	assert.EqualError(t, err, "duplicate subdomain: 'web'")
+	_, err = api.AddTunnel(TunnelConfig{Subdomain: "docs"})
+	assert.EqualError(t, err, "invalid port: 0")
+
+	assert.NoError(t, api.PauseTunnel("web", true))
+	assert.True(t, client.isPaused("web"))
+	assert.NoError(t, api.PauseTunnel("web", false))
+	assert.False(t, client.isPaused("web"))
+	assert.EqualError(t, api.PauseTunnel("docs", true), ErrTunnelNotFound.Error())
+
+	assert.NoError(t, api.RemoveTunnel(tunnel.Subdomain))
+	assert.EqualError(t, api.RemoveTunnel(tunnel.Subdomain), ErrTunnelNotFound.Error())
+	assert.EqualError(t, api.RemoveTunnel("web"), "unable to remove the last tunnel")
+	assert.Equal(t, []TunnelConfig{{Name: "web", Subdomain: "web", Port: 8000}}, client.Tunnels())
+
+	requests, err := api.Requests("")
+	assert.NoError(t, err)
+	assert.Empty(t, requests)
+
+	// The socket is removed once the client stops
+	client.Shutdown()
+	assert.Eventually(t, func() bool {
+		_, err := api.Status()
+		return err != nil
+	}, time.Second, 10*time.Millisecond)
+}
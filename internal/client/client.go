@@ -3,8 +3,12 @@ package client
 import (
 	"context"
 	"net/http"
+	"sync"
 
 	"github.com/gorilla/websocket"
+
+	"github.com/amalshaji/beaver/internal/inspector"
+	"github.com/amalshaji/beaver/internal/mock"
 )
 
 // Client connects to one or more Server using HTTP websockets.
@@ -15,31 +19,112 @@ type Client struct {
 	client *http.Client
 	dialer *websocket.Dialer
 	pools  map[string]*Pool
+
+	// Tunnels of the session, they can be changed at runtime, see api.go
+	tunnels    []TunnelConfig
+	paused     map[string]bool
+	tunnelLock sync.RWMutex
+
+	// Recent requests and counters, see stats.go
+	stats *Stats
+	// Captured requests, nil when the inspector is disabled, see inspector.go
+	inspector *inspector.Store
+	// Recording of the served requests, nil unless enabled, see inspector.go
+	recorder *inspector.Recorder
+	// Mocks files of the tunnels, by path, see mock.go
+	mocks     map[string]*mock.File
+	mocksLock sync.Mutex
+
+	lock sync.Mutex
+	done chan struct{}
+	err  error
 }
 
 // NewClient creates a new Client.
 func NewClient(config *Config) (c *Client) {
 	c = new(Client)
 	c.Config = config
-	c.client = &http.Client{
+	c.client = NewLocalHTTPClient()
+	c.dialer = &websocket.Dialer{}
+	c.pools = make(map[string]*Pool)
+	c.tunnels = append([]TunnelConfig(nil), config.tunnels...)
+	c.paused = make(map[string]bool)
+	c.mocks = make(map[string]*mock.File)
+	c.stats = NewStats(100)
+	if !config.Inspector.Disabled {
+		c.inspector = inspector.NewStore(config.Inspector.Size, config.Inspector.MaxBodySize)
+	}
+	c.done = make(chan struct{})
+	return
+}
+
+// NewLocalHTTPClient returns a client to send requests to the local servers,
+// redirects are returned to the caller instead of being followed
+func NewLocalHTTPClient() *http.Client {
+	return &http.Client{
 		CheckRedirect: func(req *http.Request, via []*http.Request) error {
 			return http.ErrUseLastResponse
 		},
 	}
-	c.dialer = &websocket.Dialer{}
-	c.pools = make(map[string]*Pool)
-	return
 }
 
 // Start the Proxy
 func (c *Client) Start(ctx context.Context) {
-	pool := NewPool(c, c.Config.Target)
-	c.pools[c.Config.id] = pool
-	go pool.Start(ctx)
+	// One pool per server, or a single pool moving between them
+	if c.Config.Strategy == StrategyAll {
+		for _, target := range c.Config.Targets {
+			c.pools[target] = NewPool(c, target)
+		}
+	} else {
+		c.pools[c.Config.id] = NewPool(c, c.Config.Targets...)
+	}
+
+	for _, pool := range c.pools {
+		go pool.Start(ctx)
+		go c.controlLoop(ctx, pool)
+	}
+}
+
+// Done is closed when the server disconnects the client for good
+func (c *Client) Done() <-chan struct{} {
+	return c.done
+}
+
+// Err returns the error which stopped the client, if any
+func (c *Client) Err() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.err
+}
+
+// fatal stops the client because of an error it cannot recover from
+func (c *Client) fatal(err error) {
+	c.lock.Lock()
+	if c.err == nil {
+		c.err = err
+	}
+	c.lock.Unlock()
+
+	c.stop()
+}
+
+// stop marks the client as done
+func (c *Client) stop() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	select {
+	case <-c.done:
+	default:
+		close(c.done)
+	}
 }
 
 // Shutdown the Proxy
 func (c *Client) Shutdown() {
+	c.stop()
+
 	for _, pool := range c.pools {
 		pool.Shutdown()
 	}
@@ -0,0 +1,136 @@
+package client
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+// fakeServer accepts the tunnel connections and the control channel of a client, like a server.
+// Its process can be changed, as if another one took over the listener.
+type fakeServer struct {
+	*httptest.Server
+	upgrader websocket.Upgrader
+
+	lock    sync.Mutex
+	process string
+	// Answer the registrations and the readiness checks with this status instead of accepting them
+	status int
+	// Registrations received, the headers of the last one, open tunnel connections by process, and control channels
+	registrations int
+	header        http.Header
+	connections   map[string]int
+	controls      int
+}
+
+func newFakeServer(t *testing.T, process string) *fakeServer {
+	server := &fakeServer{process: process, connections: make(map[string]int)}
+	server.Server = httptest.NewServer(http.HandlerFunc(server.serveHTTP))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func (server *fakeServer) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	server.lock.Lock()
+	process, status := server.process, server.status
+	if r.URL.Path == "/register" {
+		server.registrations++
+		server.header = r.Header.Clone()
+	}
+	server.lock.Unlock()
+
+	if r.URL.Path == "/ready" && status == 0 {
+		w.Write([]byte(`{"message":"ok"}`))
+		return
+	}
+	if (r.URL.Path == "/register" || r.URL.Path == "/ready") && status != 0 {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		w.Write([]byte(`{"error":"` + http.StatusText(status) + `"}`))
+		return
+	}
+
+	ws, err := server.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer ws.Close()
+
+	server.track(r.URL.Path, process, 1)
+	defer server.track(r.URL.Path, process, -1)
+
+	// Wait for the client to close the connection
+	for {
+		if _, _, err := ws.NextReader(); err != nil {
+			return
+		}
+	}
+}
+
+func (server *fakeServer) track(path string, process string, delta int) {
+	server.lock.Lock()
+	defer server.lock.Unlock()
+
+	if path == "/control" {
+		server.controls += delta
+	} else {
+		server.connections[process] += delta
+	}
+}
+
+// lastHeader returns the headers of the last registration
+func (server *fakeServer) lastHeader() http.Header {
+	server.lock.Lock()
+	defer server.lock.Unlock()
+
+	return server.header
+}
+
+// countControls returns the open control channels
+func (server *fakeServer) countControls() int {
+	server.lock.Lock()
+	defer server.lock.Unlock()
+
+	return server.controls
+}
+
+// setProcess changes the process accepting the new connections
+func (server *fakeServer) setProcess(process string) {
+	server.lock.Lock()
+	defer server.lock.Unlock()
+
+	server.process = process
+}
+
+// setStatus makes the server refuse the registrations with status, or accept them with 0
+func (server *fakeServer) setStatus(status int) {
+	server.lock.Lock()
+	defer server.lock.Unlock()
+
+	server.status = status
+}
+
+// count returns the open tunnel connections of a process
+func (server *fakeServer) count(process string) int {
+	server.lock.Lock()
+	defer server.lock.Unlock()
+
+	return server.connections[process]
+}
+
+// countRegistrations returns the registrations received, accepted or not
+func (server *fakeServer) countRegistrations() int {
+	server.lock.Lock()
+	defer server.lock.Unlock()
+
+	return server.registrations
+}
+
+// target returns the register endpoint of the server
+func (server *fakeServer) target() string {
+	return "ws" + strings.TrimPrefix(server.URL, "http") + "/register"
+}
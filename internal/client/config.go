@@ -2,10 +2,17 @@ package client
 
 import (
 	"fmt"
+	"net/netip"
 	"os"
+	"path/filepath"
 	"strings"
 
+	"github.com/amalshaji/beaver/internal/fault"
+	"github.com/amalshaji/beaver/internal/mock"
+	"github.com/amalshaji/beaver/internal/rewrite"
+	"github.com/amalshaji/beaver/internal/traffic"
 	"github.com/amalshaji/beaver/internal/utils"
+	"github.com/amalshaji/beaver/internal/webhook"
 	gonanoid "github.com/matoous/go-nanoid/v2"
 	uuid "github.com/nu7hatch/gouuid"
 
@@ -13,25 +20,71 @@ import (
 )
 
 type TunnelConfig struct {
-	Name      string
-	Subdomain string
-	Port      int
+	Name      string `json:"name"`
+	Subdomain string `json:"subdomain"`
+	// Local port, 0 for a tunnel answered by its mocks only or serving a directory
+	Port int `json:"port"`
+	// Mocks file answering the requests when the local server is unreachable, see mock.go
+	Mocks string `json:"mocks,omitempty"`
+	// Directory served instead of a local server, see files.go
+	Dir     string `json:"dir,omitempty"`
+	Listing bool   `json:"listing,omitempty"`
+	SPA     bool   `json:"spa,omitempty"`
+	// Faults injected into the requests, the first rule matching a path applies
+	Faults []fault.Rule `json:"faults,omitempty"`
+	// Basic auth credentials required by the server to reach the tunnel, as username:password
+	Auth string `json:"auth,omitempty"`
+	// Addresses the server accepts requests from (CIDRs or IPs), denied ones are refused first
+	AllowIPs []string `json:"allow_ips,omitempty"`
+	DenyIPs  []string `json:"deny_ips,omitempty"`
+	// Signature the server verifies on the webhooks, as provider:secret or key=value pairs, see webhook.ParseSpec
+	Webhook string `json:"webhook,omitempty"`
+	// Rules rewriting the requests before they reach the local server, and its responses
+	Rewrite *rewrite.Rules `json:"rewrite,omitempty"`
+}
+
+// InspectorConfig configures the local web UI to inspect and replay the requests, see inspector.go
+type InspectorConfig struct {
+	Disabled bool
+	Addr     string
+	// Number of requests to keep
+	Size int
+	// Bodies are captured up to this size (bytes)
+	MaxBodySize int64
 }
 
 type ProxyTunnels struct {
 	Tunnels []TunnelConfig
 }
 
+// Strategies to use the targets
+const (
+	// StrategyFailover connects to the first reachable target, in order, and fails back to the first one
+	StrategyFailover = "failover"
+	// StrategyAll connects to every target at the same time
+	StrategyAll = "all"
+)
+
 type Config struct {
-	id               string
-	subdomain        string
-	port             int
+	id string
+	// Tunnels of the client session, they share the same pool of connections
+	tunnels          []TunnelConfig
 	showWsReadErrors bool
 
-	Target       string
+	Target string
+	// Servers to connect to, Target is used when empty
+	Targets      []string
+	Strategy     string
 	PoolIdleSize int
 	PoolMaxSize  int
 	SecretKey    string
+	// Unix socket of the control API of the running client
+	ControlSocket string
+	// Files of the client running in the background, see daemon.go
+	PidFile string
+	LogFile string
+	// Local web UI to inspect and replay the requests
+	Inspector InspectorConfig
 }
 
 // ProxyConfig configures an ProxyConfig
@@ -43,10 +96,18 @@ func (config *Config) setDefaults() {
 	}
 	config.id = id.String()
 
-	if config.Target == "" {
+	if config.Target == "" && len(config.Targets) == 0 {
 		config.Target = "wss://x.amal.sh"
 	}
 
+	if len(config.Targets) == 0 {
+		config.Targets = []string{config.Target}
+	}
+
+	if config.Strategy == "" {
+		config.Strategy = StrategyFailover
+	}
+
 	if config.PoolIdleSize == 0 {
 		config.PoolIdleSize = 1
 	}
@@ -55,10 +116,33 @@ func (config *Config) setDefaults() {
 		config.PoolMaxSize = 100
 	}
 
+	if config.ControlSocket == "" {
+		config.ControlSocket = defaultPath("beaver.sock")
+	}
+
+	if config.PidFile == "" {
+		config.PidFile = defaultPath("beaver.pid")
+	}
+
+	if config.LogFile == "" {
+		config.LogFile = defaultPath("beaver.log")
+	}
+
+	if config.Inspector.Addr == "" {
+		config.Inspector.Addr = "127.0.0.1:4040"
+	}
+
+	if config.Inspector.Size == 0 {
+		config.Inspector.Size = 100
+	}
+
+	if config.Inspector.MaxBodySize == 0 {
+		config.Inspector.MaxBodySize = traffic.DefaultMaxBodySize
+	}
 }
 
-// LoadConfiguration loads configuration from a YAML file
-func LoadConfiguration(configFile string, subdomain string, port int, showWsReadErrors bool) (Config, error) {
+// LoadConfiguration loads configuration from a YAML file, for a client session serving the given tunnels
+func LoadConfiguration(configFile string, tunnels []TunnelConfig, showWsReadErrors bool) (Config, error) {
 	var config Config
 
 	bytes, err := os.ReadFile(configFile)
@@ -73,25 +157,117 @@ func LoadConfiguration(configFile string, subdomain string, port int, showWsRead
 
 	config.setDefaults()
 
-	if !strings.HasSuffix(config.Target, "/register") {
-		config.Target = config.Target + "/register"
+	if config.Strategy != StrategyFailover && config.Strategy != StrategyAll {
+		return Config{}, fmt.Errorf("invalid strategy: '%s'; must be %s or %s", config.Strategy, StrategyFailover, StrategyAll)
 	}
 
-	if subdomain == "" {
-		subdomain, err = gonanoid.Generate("abcdefghijklmnopqrstuvwxyz", 6)
-		if err != nil {
-			panic(err)
+	for i, target := range config.Targets {
+		config.Targets[i] = registerURL(target)
+	}
+	config.Target = config.Targets[0]
+
+	if len(tunnels) == 0 {
+		return Config{}, fmt.Errorf("no tunnel to start")
+	}
+
+	subdomains := make(map[string]struct{})
+	for _, tunnel := range tunnels {
+		if err := prepareTunnel(&tunnel); err != nil {
+			return Config{}, err
 		}
-	} else {
-		err = utils.ValidateSubdomain(subdomain)
-		if err != nil {
-			return Config{}, fmt.Errorf("invalid subdomain: '%s'; %s", subdomain, err.Error())
+
+		if _, ok := subdomains[tunnel.Subdomain]; ok {
+			return Config{}, fmt.Errorf("duplicate subdomain: '%s'", tunnel.Subdomain)
 		}
+		subdomains[tunnel.Subdomain] = struct{}{}
+
+		config.tunnels = append(config.tunnels, tunnel)
 	}
 
-	config.subdomain = subdomain
-	config.port = port
 	config.showWsReadErrors = showWsReadErrors
 
 	return config, nil
 }
+
+// prepareTunnel generates a random subdomain for the tunnel if it has none, or validates it, and checks its mocks file or directory
+func prepareTunnel(tunnel *TunnelConfig) (err error) {
+	if tunnel.Subdomain == "" {
+		tunnel.Subdomain, err = gonanoid.Generate("abcdefghijklmnopqrstuvwxyz", 6)
+		if err != nil {
+			panic(err)
+		}
+	} else if err := utils.ValidateSubdomain(tunnel.Subdomain); err != nil {
+		return fmt.Errorf("invalid subdomain: '%s'; %s", tunnel.Subdomain, err.Error())
+	}
+
+	if tunnel.Auth != "" {
+		username, password, ok := strings.Cut(tunnel.Auth, ":")
+		if !ok || username == "" || password == "" {
+			return fmt.Errorf("invalid auth for tunnel %s; expected username:password", tunnel.Subdomain)
+		}
+	}
+
+	for _, r := range append(append([]string(nil), tunnel.AllowIPs...), tunnel.DenyIPs...) {
+		if _, err := netip.ParsePrefix(r); err != nil {
+			if _, err := netip.ParseAddr(r); err != nil {
+				return fmt.Errorf("invalid IP range for tunnel %s: '%s'; expected a CIDR or an IP", tunnel.Subdomain, r)
+			}
+		}
+	}
+
+	if tunnel.Webhook != "" {
+		if _, err := webhook.ParseSpec(tunnel.Webhook); err != nil {
+			return fmt.Errorf("invalid webhook signature for tunnel %s; %s", tunnel.Subdomain, err)
+		}
+	}
+
+	if tunnel.Rewrite != nil {
+		if err := tunnel.Rewrite.Validate(); err != nil {
+			return fmt.Errorf("invalid rewrite rules for tunnel %s; %s", tunnel.Subdomain, err)
+		}
+	}
+
+	if tunnel.Mocks != "" {
+		// The client may run in the background from another directory
+		if tunnel.Mocks, err = filepath.Abs(tunnel.Mocks); err != nil {
+			return err
+		}
+		if _, err := mock.Load(tunnel.Mocks); err != nil {
+			return fmt.Errorf("invalid mocks: %w", err)
+		}
+	}
+
+	if tunnel.Dir != "" {
+		if tunnel.Port != 0 || tunnel.Mocks != "" {
+			return fmt.Errorf("tunnel %s serves a directory, it cannot have a port or mocks", tunnel.Subdomain)
+		}
+		if tunnel.Dir, err = filepath.Abs(tunnel.Dir); err != nil {
+			return err
+		}
+		if info, err := os.Stat(tunnel.Dir); err != nil {
+			return err
+		} else if !info.IsDir() {
+			return fmt.Errorf("%s is not a directory", tunnel.Dir)
+		}
+	}
+	return nil
+}
+
+// defaultPath returns a file of the beaver directory in the home directory
+func defaultPath(name string) string {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return filepath.Join(os.TempDir(), name)
+	}
+	return filepath.Join(homeDir, ".beaver", name)
+}
+
+// LoadRuntimeConfig loads the settings used to manage a running client from a YAML file, it ignores the tunnels
+func LoadRuntimeConfig(configFile string) Config {
+	var config Config
+	if bytes, err := os.ReadFile(configFile); err == nil {
+		yaml.Unmarshal(bytes, &config)
+	}
+	config.setDefaults()
+	return config
+}
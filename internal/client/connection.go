@@ -3,28 +3,36 @@ package client
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
-	"net/url"
+	"strconv"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
-	"github.com/labstack/gommon/color"
 
+	"github.com/amalshaji/beaver/internal/rewrite"
+	"github.com/amalshaji/beaver/internal/traffic"
 	"github.com/amalshaji/beaver/internal/utils"
 )
 
-// Keep a map of connections(subdomains) for logging
+var (
+	ErrConnectionLimit   = errors.New("refused by the server")
+	ErrServerUnavailable = errors.New("server unavailable")
+)
+
+// Keep a map of connections(client sessions) for logging
 var activeTunnelConnections = make(map[string]struct{})
 
-func registerNewConnection(subdomain string) {
-	activeTunnelConnections[subdomain] = struct{}{}
+func registerNewConnection(id string) {
+	activeTunnelConnections[id] = struct{}{}
 }
 
-func isNewConnection(subdomain string) bool {
-	_, ok := activeTunnelConnections[subdomain]
+func isNewConnection(id string) bool {
+	_, ok := activeTunnelConnections[id]
 	return !ok
 }
 
@@ -37,9 +45,18 @@ const (
 
 // Connection handle a single websocket (HTTP/TCP) connection to an Server
 type Connection struct {
-	pool   *Pool
-	ws     *websocket.Conn
-	status int
+	pool *Pool
+	ws   *websocket.Conn
+	// Written by serve and read by the pool, the dashboard and the API
+	status atomic.Int32
+}
+
+func (c *Connection) getStatus() int {
+	return int(c.status.Load())
+}
+
+func (c *Connection) setStatus(status int) {
+	c.status.Store(int32(status))
 }
 
 func (c *Connection) IsInitialConnection() bool {
@@ -50,78 +67,86 @@ func (c *Connection) IsInitialConnection() bool {
 func NewConnection(pool *Pool) *Connection {
 	c := new(Connection)
 	c.pool = pool
-	c.status = CONNECTING
+	c.setStatus(CONNECTING)
 	return c
 }
 
 // Connect to the IsolatorServer using a HTTP websocket
 func (connection *Connection) Connect(ctx context.Context) (err error) {
 	if connection.IsInitialConnection() {
-		log.Printf("Creating tunnel connection for :%d\n", connection.pool.client.Config.port)
+		log.Printf("Creating tunnel connection for %s\n", connection.pool.client.ports())
+	}
+
+	target := connection.pool.getTarget()
+	subdomains, localServers := connection.pool.client.registrationHeaders()
+
+	header := http.Header{
+		"X-SECRET-KEY":       {connection.pool.client.Config.SecretKey},
+		"X-TUNNEL-SUBDOMAIN": {subdomains},
+		"X-LOCAL-SERVER":     {localServers},
+		"X-GREETING-MESSAGE": {fmt.Sprintf(
+			"%s_%d",
+			connection.pool.client.Config.id,
+			connection.pool.client.Config.PoolIdleSize,
+		)},
+	}
+	if auth := connection.pool.client.authHeaders(); len(auth) > 0 {
+		header["X-TUNNEL-AUTH"] = auth
+	}
+	if allow, deny := connection.pool.client.ipFilterHeaders(); len(allow) > 0 || len(deny) > 0 {
+		header["X-TUNNEL-ALLOW-IPS"] = allow
+		header["X-TUNNEL-DENY-IPS"] = deny
+	}
+	if webhooks := connection.pool.client.webhookHeaders(); len(webhooks) > 0 {
+		header["X-TUNNEL-WEBHOOK"] = webhooks
 	}
 
 	var res *http.Response
 	// Create a new TCP(/TLS) connection ( no use of net.http )
 	connection.ws, res, err = connection.pool.client.dialer.DialContext(
 		ctx,
-		connection.pool.target,
-		http.Header{
-			"X-SECRET-KEY":       {connection.pool.client.Config.SecretKey},
-			"X-TUNNEL-SUBDOMAIN": {connection.pool.client.Config.subdomain},
-			"X-LOCAL-SERVER": {fmt.Sprintf(
-				"http://localhost:%d",
-				connection.pool.client.Config.port,
-			)},
-			"X-GREETING-MESSAGE": {fmt.Sprintf(
-				"%s_%d",
-				connection.pool.client.Config.id,
-				connection.pool.client.Config.PoolIdleSize,
-			)},
-		},
+		target,
+		header,
 	)
 
 	if err != nil {
-		defer func() {
-			if r := recover(); r != nil {
-				log.Fatal(err)
+		if res == nil {
+			// The server is unreachable, it might be restarting or another target might be up
+			if isNewConnection(connection.pool.client.Config.id) && len(connection.pool.client.Config.Targets) == 1 {
+				connection.pool.client.fatal(err)
+				return err
 			}
-		}()
-		// bodyBytes, _ := io.ReadAll(res.Body)
+			return fmt.Errorf("%w: %v", ErrServerUnavailable, err)
+		}
 		defer res.Body.Close()
 
 		var body map[string]string
 		_ = json.NewDecoder(res.Body).Decode(&body)
-		log.Fatal(color.Red(body["error"]))
-	}
 
-	var httpScheme string
-
-	URL, _ := url.Parse(connection.pool.target)
-	if URL.Scheme == "ws" {
-		httpScheme = "http"
-	} else {
-		httpScheme = "https"
-	}
-	httpPort := URL.Port()
-	if httpPort != "" {
-		httpPort = ":" + httpPort
+		switch res.StatusCode {
+		case http.StatusTooManyRequests:
+			// The server refuses connections beyond the user's limit, keep the open ones
+			return fmt.Errorf("%w: %s", ErrConnectionLimit, body["error"])
+		case http.StatusServiceUnavailable:
+			// The server is draining
+			return fmt.Errorf("%w: %s", ErrServerUnavailable, body["error"])
+		}
+		err = errors.New(body["error"])
+		connection.pool.client.fatal(err)
+		return err
 	}
 
-	if isNewConnection(connection.pool.client.Config.subdomain) {
-		log.Println(
-			color.Green(
-				fmt.Sprintf("Tunnel connected %s://%s.%s%s -> http://localhost:%d",
-					httpScheme,
-					connection.pool.client.Config.subdomain,
-					URL.Hostname(),
-					httpPort,
-					connection.pool.client.Config.port),
-			),
-		)
+	// Follow the pool sizes advertised by the server
+	idleSize, _ := strconv.Atoi(res.Header.Get("X-POOL-IDLE-SIZE"))
+	maxSize, _ := strconv.Atoi(res.Header.Get("X-POOL-MAX-SIZE"))
+	connection.pool.setLimits(idleSize, maxSize)
+	connection.pool.resetBackoff()
 
+	if isNewConnection(connection.pool.client.Config.id) {
 		// register the new connection
-		registerNewConnection(connection.pool.client.Config.subdomain)
+		registerNewConnection(connection.pool.client.Config.id)
 	}
+	connection.pool.activate(target, parseRejectedTunnels(res.Header))
 
 	// Send the greeting message with proxy id and wanted pool size.
 
@@ -147,13 +172,14 @@ func (connection *Connection) serve(ctx context.Context) {
 			err := connection.ws.WriteControl(websocket.PingMessage, []byte{}, time.Now().Add(time.Second))
 			if err != nil {
 				connection.Close()
+				return
 			}
 		}
 	}()
 
 	for {
 		// Read request
-		connection.status = IDLE
+		connection.setStatus(IDLE)
 		_, jsonRequest, err := connection.ws.ReadMessage()
 		if err != nil {
 			if connection.pool.client.Config.showWsReadErrors {
@@ -162,7 +188,7 @@ func (connection *Connection) serve(ctx context.Context) {
 			break
 		}
 
-		connection.status = RUNNING
+		connection.setStatus(RUNNING)
 
 		// Trigger a pool refresh to open new connections if needed
 		go connection.pool.connector(ctx)
@@ -192,9 +218,58 @@ func (connection *Connection) serve(ctx context.Context) {
 		}
 		req.Body = io.NopCloser(bodyReader)
 
+		// Route the request to the local server of its tunnel
+		tunnel, ok := connection.pool.client.tunnelForHost(req.Host)
+		if !ok || connection.pool.client.isPaused(tunnel.Subdomain) {
+			io.Copy(io.Discard, bodyReader)
+
+			message := fmt.Sprintf("Unknown tunnel for host %s\n", req.Host)
+			if ok {
+				message = fmt.Sprintf("Tunnel %s is paused\n", tunnel.Subdomain)
+			}
+			err = connection.error(message)
+			if err != nil {
+				break
+			}
+			continue
+		}
+		req.URL.Scheme = "http"
+		req.URL.Host = fmt.Sprintf("localhost:%d", tunnel.Port)
+
+		// The rules of the server are applied first, it sends the Host it rewrote
+		if host := req.Header.Get(rewrite.HostHeader); host != "" {
+			req.Host = host
+		}
+		req.Header.Del(rewrite.HostHeader)
+
+		// Capture the request for the inspector as the server sent it, the headers set by the rules of the tunnel
+		// are not kept and a replay is rewritten again
+		exchange, requestCapture := connection.pool.client.newExchange(req, tunnel)
+		if requestCapture != nil {
+			req.Body = io.NopCloser(io.TeeReader(bodyReader, requestCapture))
+		}
+
+		if tunnel.Rewrite != nil {
+			tunnel.Rewrite.RewriteRequest(req)
+		}
+
+		event := RequestEvent{
+			Time:      time.Now(),
+			Subdomain: tunnel.Subdomain,
+			Port:      tunnel.Port,
+			Method:    req.Method,
+			Path:      req.URL.RequestURI(),
+		}
+
 		// Execute request
-		resp, err := connection.pool.client.client.Do(req)
+		resp, err := connection.pool.client.transport(tunnel).RoundTrip(req)
 		if err != nil {
+			event.Status = 527
+			event.Latency = time.Since(event.Time)
+			event.Error = err.Error()
+			connection.pool.client.stats.Record(event)
+			connection.pool.client.recordExchange(exchange, requestCapture, nil, nil, err)
+
 			err = connection.error(fmt.Sprintf("Unable to execute request : %v\n", err))
 			if err != nil {
 				break
@@ -209,15 +284,20 @@ func (connection *Connection) serve(ctx context.Context) {
 		}
 
 		log.Printf("[%d] [%s] %d %s",
-			connection.pool.client.Config.port,
+			tunnel.Port,
 			req.Method,
 			resp.StatusCode,
 			urlPath,
 		)
 
+		if tunnel.Rewrite != nil {
+			tunnel.Rewrite.RewriteResponse(resp.Header)
+		}
+
 		// Serialize response
 		jsonResponse, err := json.Marshal(utils.SerializeHTTPResponse(resp))
 		if err != nil {
+			resp.Body.Close()
 			err = connection.error(fmt.Sprintf("Unable to serialize response : %v\n", err))
 			if err != nil {
 				break
@@ -229,6 +309,7 @@ func (connection *Connection) serve(ctx context.Context) {
 		err = connection.ws.WriteMessage(websocket.TextMessage, jsonResponse)
 		if err != nil {
 			log.Printf("Unable to write response : %v", err)
+			resp.Body.Close()
 			break
 		}
 
@@ -236,14 +317,28 @@ func (connection *Connection) serve(ctx context.Context) {
 		bodyWriter, err := connection.ws.NextWriter(websocket.BinaryMessage)
 		if err != nil {
 			log.Printf("Unable to get response body writer : %v", err)
+			resp.Body.Close()
 			break
 		}
-		_, err = io.Copy(bodyWriter, resp.Body)
+		var responseCapture *traffic.Capture
+		var body io.Writer = bodyWriter
+		if exchange != nil {
+			responseCapture = traffic.NewCapture(connection.pool.client.captureLimit())
+			body = io.MultiWriter(bodyWriter, responseCapture)
+		}
+		_, err = io.Copy(body, resp.Body)
 		if err != nil {
 			log.Printf("Unable to get pipe response body : %v", err)
+			resp.Body.Close()
 			break
 		}
 		bodyWriter.Close()
+		resp.Body.Close()
+
+		event.Status = resp.StatusCode
+		event.Latency = time.Since(event.Time)
+		connection.pool.client.stats.Record(event)
+		connection.pool.client.recordExchange(exchange, requestCapture, resp, responseCapture, nil)
 	}
 }
 
@@ -0,0 +1,147 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/amalshaji/beaver/internal/utils"
+	"github.com/gorilla/websocket"
+	"github.com/labstack/gommon/color"
+)
+
+// controlURL returns the control channel endpoint of a register target
+func controlURL(target string) string {
+	return strings.TrimSuffix(target, "/register") + "/control"
+}
+
+// registerURL returns the register endpoint of a server
+func registerURL(target string) string {
+	if strings.HasSuffix(target, "/register") {
+		return target
+	}
+	return strings.TrimSuffix(target, "/") + "/register"
+}
+
+// controlLoop keeps the control channel of the session open until the client is shut down
+func (c *Client) controlLoop(ctx context.Context, pool *Pool) {
+	backoff := time.Second
+
+	for {
+		ws, res, err := c.dialer.DialContext(ctx, controlURL(pool.getTarget()), http.Header{
+			"X-SECRET-KEY": {c.Config.SecretKey},
+			"X-CLIENT-ID":  {c.Config.id},
+		})
+		if err != nil {
+			// Servers without a control channel
+			if res != nil && res.StatusCode == http.StatusNotFound {
+				return
+			}
+		} else {
+			backoff = time.Second
+			if !pool.setControl(ws) {
+				ws.Close()
+				return
+			}
+			c.readControlMessages(ws, pool)
+		}
+
+		select {
+		case <-c.done:
+			return
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+
+		if backoff < 30*time.Second {
+			backoff *= 2
+		}
+	}
+}
+
+// setControl keeps the control channel of the pool, it returns false once the pool is shut down
+func (pool *Pool) setControl(ws *websocket.Conn) bool {
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+
+	select {
+	case <-pool.done:
+		return false
+	default:
+	}
+	pool.control = ws
+	return true
+}
+
+// readControlMessages handles the messages of the server until the control channel is closed
+func (c *Client) readControlMessages(ws *websocket.Conn, pool *Pool) {
+	defer ws.Close()
+
+	// Keep the control channel alive
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		ticker := time.NewTicker(30 * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				ws.WriteControl(websocket.PingMessage, []byte{}, time.Now().Add(time.Second))
+			}
+		}
+	}()
+
+	for {
+		var message utils.ControlMessage
+		if err := ws.ReadJSON(&message); err != nil {
+			if c.Config.showWsReadErrors {
+				log.Println(err.Error())
+			}
+			return
+		}
+		c.handleControlMessage(&message, pool)
+	}
+}
+
+func (c *Client) handleControlMessage(message *utils.ControlMessage, pool *Pool) {
+	switch message.Type {
+	case utils.ControlNotice:
+		log.Println(color.Yellow(fmt.Sprintf("Notice from server: %s", message.Message)))
+
+	case utils.ControlKick:
+		reason := message.Message
+		if reason == "" {
+			reason = "no reason given"
+		}
+		log.Println(color.Red(fmt.Sprintf("Disconnected by the server: %s", reason)))
+		c.stop()
+
+	case utils.ControlDrain:
+		if message.Target == "" && message.Reconnect {
+			log.Println(color.Yellow("Server is being upgraded, reconnecting"))
+			pool.retarget(pool.getTarget())
+			return
+		}
+		if message.Target == "" {
+			log.Println(color.Yellow("Server is draining, the tunnel will reconnect once it is available"))
+			pool.drain()
+			return
+		}
+		log.Println(color.Yellow(fmt.Sprintf("Server is draining, moving tunnel to %s", message.Target)))
+		pool.retarget(registerURL(message.Target))
+
+	case utils.ControlConfig:
+		pool.setLimits(message.PoolIdleSize, message.PoolMaxSize)
+
+	default:
+		if c.Config.showWsReadErrors {
+			log.Printf("Unknown control message type: %s", message.Type)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package client
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/amalshaji/beaver/internal/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReconnectToTheUpgradedServer(t *testing.T) {
+	server := newFakeServer(t, "old")
+	client := newTestClient(server.target())
+	pool := NewPool(client, server.target())
+	defer pool.Shutdown()
+
+	pool.connector(context.Background())
+	assert.Eventually(t, func() bool { return server.count("old") == 1 }, time.Second, 10*time.Millisecond)
+
+	// A new process took over the listener, the old one keeps its connections until they close
+	server.setProcess("new")
+	client.handleControlMessage(&utils.ControlMessage{Type: utils.ControlDrain, Reconnect: true}, pool)
+	assert.Eventually(t, func() bool { return server.count("old") == 0 }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, server.target(), pool.getTarget())
+
+	pool.connector(context.Background())
+	assert.Eventually(t, func() bool { return server.count("new") == 1 }, time.Second, 10*time.Millisecond)
+}
+
+func TestMoveToTheDrainTarget(t *testing.T) {
+	server := newFakeServer(t, "primary")
+	backup := newFakeServer(t, "backup")
+	client := newTestClient(server.target())
+	pool := NewPool(client, server.target())
+	defer pool.Shutdown()
+
+	pool.connector(context.Background())
+	assert.Eventually(t, func() bool { return server.count("primary") == 1 }, time.Second, 10*time.Millisecond)
+
+	// The drain target is the address of the server, eg: wss://tunnel2.example.com
+	client.handleControlMessage(&utils.ControlMessage{Type: utils.ControlDrain, Target: strings.TrimSuffix(backup.target(), "/register")}, pool)
+	assert.Equal(t, backup.target(), pool.getTarget())
+	assert.Eventually(t, func() bool { return server.count("primary") == 0 }, time.Second, 10*time.Millisecond)
+
+	pool.connector(context.Background())
+	assert.Eventually(t, func() bool { return backup.count("backup") == 1 }, time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,113 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DaemonEnv is set in the environment of the client started in the background by Detach
+const DaemonEnv = "BEAVER_DAEMON"
+
+// Log files are rotated at 10MB, keeping 3 previous files
+const (
+	LogMaxSize = 10 << 20
+	LogBackups = 3
+)
+
+var (
+	ErrNotRunning        = errors.New("no client running in the background")
+	ErrDetachUnsupported = errors.New("running in the background is not supported on this platform")
+)
+
+// IsDaemon reports whether the process was started by Detach
+func IsDaemon() bool {
+	return os.Getenv(DaemonEnv) != ""
+}
+
+// ReadPidFile returns the pid of the running daemon
+func ReadPidFile(path string) (int, error) {
+	bytes, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, ErrNotRunning
+		}
+		return 0, err
+	}
+
+	pid, err := strconv.Atoi(strings.TrimSpace(string(bytes)))
+	if err != nil {
+		return 0, fmt.Errorf("invalid pidfile %s : %w", path, err)
+	}
+
+	// A leftover pidfile from a crashed daemon
+	if !processRunning(pid) {
+		return 0, ErrNotRunning
+	}
+	return pid, nil
+}
+
+// WritePidFile records the pid of the current process, unless another daemon is running
+func WritePidFile(path string) error {
+	if pid, err := ReadPidFile(path); err == nil && pid != os.Getpid() {
+		return fmt.Errorf("already running in the background (pid %d)", pid)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	return os.WriteFile(path, []byte(strconv.Itoa(os.Getpid())), 0o600)
+}
+
+// RemovePidFile removes the pidfile if it belongs to the current process
+func RemovePidFile(path string) {
+	bytes, err := os.ReadFile(path)
+	if err != nil {
+		return
+	}
+	if strings.TrimSpace(string(bytes)) == strconv.Itoa(os.Getpid()) {
+		os.Remove(path)
+	}
+}
+
+// waitForPidFile waits for the daemon to record its pid, or to exit
+func waitForPidFile(path string, process *os.Process, exited <-chan struct{}, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		select {
+		case <-exited:
+			return fmt.Errorf("the client exited while starting")
+		case <-time.After(100 * time.Millisecond):
+		}
+
+		if pid, err := ReadPidFile(path); err == nil && pid == process.Pid {
+			return nil
+		}
+	}
+	return fmt.Errorf("the client did not start within %s", timeout)
+}
+
+// Stop asks the daemon to shut down and waits until it exits
+func Stop(pidFile string, timeout time.Duration) (int, error) {
+	pid, err := ReadPidFile(pidFile)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := terminate(pid); err != nil {
+		return pid, err
+	}
+
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if !processRunning(pid) {
+			return pid, nil
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return pid, fmt.Errorf("the client (pid %d) did not stop within %s", pid, timeout)
+}
@@ -0,0 +1,31 @@
+//go:build !unix
+
+package client
+
+import "os"
+
+// Detach is only supported on unix systems
+func Detach(args []string, pidFile string, logFile string) (int, error) {
+	return 0, ErrDetachUnsupported
+}
+
+// NotifyReload does nothing, there is no reload signal on this platform
+func NotifyReload(c chan<- os.Signal) {}
+
+// IsReloadSignal always returns false, there is no reload signal on this platform
+func IsReloadSignal(sig os.Signal) bool {
+	return false
+}
+
+func processRunning(pid int) bool {
+	process, err := os.FindProcess(pid)
+	return err == nil && process != nil
+}
+
+func terminate(pid int) error {
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return err
+	}
+	return process.Kill()
+}
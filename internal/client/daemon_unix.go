@@ -0,0 +1,70 @@
+//go:build unix
+
+package client
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// Detach starts the same command again in the background, in its own session so it survives the terminal.
+// It returns the pid of the daemon once it is running.
+func Detach(args []string, pidFile string, logFile string) (int, error) {
+	executable, err := os.Executable()
+	if err != nil {
+		return 0, err
+	}
+
+	// Errors happening before the daemon opens its log end up in the log file as well
+	log, err := NewRotatingFile(logFile, LogMaxSize, LogBackups)
+	if err != nil {
+		return 0, err
+	}
+	defer log.Close()
+
+	devNull, err := os.Open(os.DevNull)
+	if err != nil {
+		return 0, err
+	}
+	defer devNull.Close()
+
+	process, err := os.StartProcess(executable, append([]string{executable}, args...), &os.ProcAttr{
+		Env:   append(os.Environ(), DaemonEnv+"=1"),
+		Files: []*os.File{devNull, log.file, log.file},
+		Sys:   &syscall.SysProcAttr{Setsid: true},
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	exited := make(chan struct{})
+	go func() {
+		process.Wait()
+		close(exited)
+	}()
+
+	if err := waitForPidFile(pidFile, process, exited, 10*time.Second); err != nil {
+		return 0, err
+	}
+	return process.Pid, nil
+}
+
+// NotifyReload relays the reload signal (SIGHUP) to c
+func NotifyReload(c chan<- os.Signal) {
+	signal.Notify(c, syscall.SIGHUP)
+}
+
+// IsReloadSignal reports whether sig asks the daemon to reload its configuration
+func IsReloadSignal(sig os.Signal) bool {
+	return sig == syscall.SIGHUP
+}
+
+func processRunning(pid int) bool {
+	return syscall.Kill(pid, 0) == nil
+}
+
+func terminate(pid int) error {
+	return syscall.Kill(pid, syscall.SIGTERM)
+}
@@ -0,0 +1,28 @@
+//go:build unix
+
+package client
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNotifyReload(t *testing.T) {
+	signals := make(chan os.Signal, 1)
+	NotifyReload(signals)
+	defer signal.Stop(signals)
+
+	assert.NoError(t, syscall.Kill(os.Getpid(), syscall.SIGHUP))
+	select {
+	case sig := <-signals:
+		assert.True(t, IsReloadSignal(sig))
+	case <-time.After(time.Second):
+		t.Fatal("SIGHUP was not relayed")
+	}
+	assert.False(t, IsReloadSignal(syscall.SIGTERM))
+}
@@ -0,0 +1,207 @@
+package client
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/gommon/color"
+	"golang.org/x/term"
+)
+
+// ANSI sequences used to draw the dashboard
+const (
+	enterAltScreen = "\x1b[?1049h\x1b[?25l"
+	exitAltScreen  = "\x1b[?25h\x1b[?1049l"
+	clearScreen    = "\x1b[H\x1b[2J"
+)
+
+// IsTerminal reports whether f is an interactive terminal
+func IsTerminal(f *os.File) bool {
+	return term.IsTerminal(int(f.Fd()))
+}
+
+// Dashboard is a full screen view of the tunnels, the pools and the recent requests of a client.
+// It also collects the log lines, which would otherwise be drawn over it.
+type Dashboard struct {
+	client  *Client
+	out     *os.File
+	started time.Time
+
+	logs []string
+	lock sync.Mutex
+}
+
+// NewDashboard returns a dashboard drawing on out, which must be a terminal
+func NewDashboard(client *Client, out *os.File) *Dashboard {
+	return &Dashboard{client: client, out: out, started: time.Now()}
+}
+
+// Write collects log lines, the dashboard is meant to be used as the log output
+func (d *Dashboard) Write(p []byte) (int, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	for _, line := range strings.Split(strings.TrimRight(string(p), "\n"), "\n") {
+		d.logs = append(d.logs, line)
+	}
+	if len(d.logs) > 100 {
+		d.logs = d.logs[len(d.logs)-100:]
+	}
+	return len(p), nil
+}
+
+// Logs returns the collected log lines, to print them once the dashboard is closed
+func (d *Dashboard) Logs() []string {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	return append([]string(nil), d.logs...)
+}
+
+// Run redraws the dashboard until stop is closed, then restores the terminal
+func (d *Dashboard) Run(stop <-chan struct{}) {
+	io.WriteString(d.out, enterAltScreen)
+	defer io.WriteString(d.out, exitAltScreen)
+
+	ticker := time.NewTicker(500 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		io.WriteString(d.out, clearScreen+d.render())
+
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// size returns the size of the terminal, with a sensible default
+func (d *Dashboard) size() (int, int) {
+	width, height, err := term.GetSize(int(d.out.Fd()))
+	if err != nil || width <= 0 || height <= 0 {
+		return 100, 30
+	}
+	return width, height
+}
+
+func (d *Dashboard) render() string {
+	width, height := d.size()
+
+	var lines []string
+	add := func(format string, args ...any) {
+		line := fmt.Sprintf(format, args...)
+		lines = append(lines, line)
+	}
+
+	add("%s  %s", color.Bold("beaver"), color.Grey(fmt.Sprintf("up %s, ctrl+c to quit", time.Since(d.started).Truncate(time.Second))))
+	add("")
+
+	// Tunnels
+	add(color.Bold(fmt.Sprintf("%-36s %-24s %-12s %9s %7s", "TUNNEL", "LOCAL", "STATUS", "REQUESTS", "ERRORS")))
+	for _, status := range d.client.Status() {
+		state := color.Yellow("connecting")
+		switch {
+		case status.Error != "":
+			state = color.Red("unavailable")
+		case status.Paused:
+			state = color.Yellow("paused")
+		case status.Connected:
+			state = color.Green("online")
+		}
+		stats := d.client.stats.Tunnel(status.Subdomain)
+		errors := fmt.Sprintf("%7d", stats.Errors)
+		if stats.Errors > 0 {
+			errors = color.Red(errors)
+		}
+		add("%-36s %-24s %s %9d %s", truncate(status.URL, 36), truncate(status.Local, 24), pad(state, 12), stats.Requests, errors)
+		if status.Error != "" {
+			add("  %s", color.Red(truncate(status.Error, width-2)))
+		}
+	}
+	add("")
+
+	// Pools
+	add(color.Bold(fmt.Sprintf("%-36s %10s %6s %8s %6s", "SERVER", "CONNECTING", "IDLE", "RUNNING", "TOTAL")))
+	var targets []string
+	sizes := make(map[string]*PoolSize)
+	for _, pool := range d.client.pools {
+		pool.lock.RLock()
+		targets = append(targets, pool.target)
+		sizes[pool.target] = pool.Size()
+		pool.lock.RUnlock()
+	}
+	sort.Strings(targets)
+	for _, target := range targets {
+		size := sizes[target]
+		add("%-36s %10d %6d %8d %6d", truncate(target, 36), size.connecting, size.idle, size.running, size.total)
+	}
+	add("")
+
+	// Recent requests and logs share the rest of the screen
+	remaining := height - len(lines) - 4
+	if remaining < 4 {
+		remaining = 4
+	}
+	requests := d.client.stats.Recent(remaining - remaining/3)
+
+	add(color.Bold(fmt.Sprintf("%-8s %-7s %-6s %9s  %s", "TIME", "METHOD", "STATUS", "LATENCY", "PATH")))
+	for _, event := range requests {
+		status := fmt.Sprintf("%-6d", event.Status)
+		switch {
+		case event.Failed():
+			status = color.Red(status)
+		case event.Status >= 400:
+			status = color.Yellow(status)
+		default:
+			status = color.Green(status)
+		}
+		add("%-8s %-7s %s %9s  %s", event.Time.Format("15:04:05"), event.Method, status, formatLatency(event.Latency), truncate(event.Subdomain+" "+event.Path, width-36))
+	}
+	add("")
+
+	logs := d.Logs()
+	if n := remaining / 3; len(logs) > n {
+		logs = logs[len(logs)-n:]
+	}
+	for _, line := range logs {
+		add("%s", color.Grey(truncate(line, width)))
+	}
+
+	if len(lines) > height {
+		lines = lines[:height]
+	}
+	return strings.Join(lines, "\r\n")
+}
+
+func truncate(s string, n int) string {
+	if n <= 1 || len(s) <= n {
+		return s
+	}
+	return s[:n-1] + "…"
+}
+
+// pad pads a colored string to n visible characters
+func pad(s string, n int) string {
+	visible := len(s)
+	if i := strings.Index(s, "m"); strings.HasPrefix(s, "\x1b[") && i > 0 {
+		visible = len(strings.TrimSuffix(s[i+1:], "\x1b[0m"))
+	}
+	if visible >= n {
+		return s
+	}
+	return s + strings.Repeat(" ", n-visible)
+}
+
+func formatLatency(latency time.Duration) string {
+	if latency < time.Second {
+		return fmt.Sprintf("%dms", latency.Milliseconds())
+	}
+	return fmt.Sprintf("%.2fs", latency.Seconds())
+}
@@ -0,0 +1,71 @@
+package client
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDashboardRender(t *testing.T) {
+	client := newTestClient("ws://localhost/register")
+	client.tunnels = []TunnelConfig{
+		{Subdomain: "web", Port: 8000},
+		{Subdomain: "docs", Dir: "/srv/site"},
+		{Subdomain: "api", Mocks: "mocks/api.yaml"},
+	}
+	pool := NewPool(client, "ws://localhost/register")
+	client.pools[client.Config.id] = pool
+
+	client.stats.Record(RequestEvent{Time: time.Now(), Subdomain: "web", Method: "GET", Path: "/", Status: 200, Latency: 12 * time.Millisecond})
+	client.stats.Record(RequestEvent{Time: time.Now(), Subdomain: "web", Method: "POST", Path: "/login", Status: 502, Latency: 2 * time.Second})
+
+	// Not a terminal, the default size applies
+	out, err := os.Create(filepath.Join(t.TempDir(), "out"))
+	assert.NoError(t, err)
+	defer out.Close()
+	dashboard := NewDashboard(client, out)
+	dashboard.Write([]byte("first line\nsecond line\n"))
+
+	screen := dashboard.render()
+	lines := strings.Split(screen, "\r\n")
+	assert.LessOrEqual(t, len(lines), 30)
+
+	// Each tunnel shows its local server
+	for subdomain, local := range map[string]string{"web": "http://localhost:8000", "docs": "file://site", "api": "mock://api.yaml"} {
+		line := lineContaining(lines, subdomain+".")
+		assert.Contains(t, line, local, subdomain)
+		assert.Contains(t, line, "connecting", subdomain)
+	}
+	// Requests and errors of the tunnel
+	fields := strings.Fields(regexp.MustCompile("\x1b\\[[0-9;]*m").ReplaceAllString(lineContaining(lines, "web."), ""))
+	assert.Equal(t, []string{"2", "1"}, fields[len(fields)-2:])
+
+	// The pools, the latest request first and the logs
+	assert.Contains(t, lineContaining(lines, "ws://localhost/register"), "0")
+	post, get := strings.Index(screen, "/login"), strings.Index(screen, "web /\r\n")
+	assert.True(t, post >= 0 && get > post)
+	assert.Contains(t, screen, "2.00s")
+	assert.Contains(t, screen, "12ms")
+	assert.Contains(t, screen, "second line")
+}
+
+func lineContaining(lines []string, s string) string {
+	for _, line := range lines {
+		if strings.Contains(line, s) {
+			return line
+		}
+	}
+	return ""
+}
+
+func TestTruncateAndPad(t *testing.T) {
+	assert.Equal(t, "abc", truncate("abc", 3))
+	assert.Equal(t, "ab…", truncate("abcd", 3))
+	assert.Equal(t, "online  ", pad("online", 8))
+	assert.Equal(t, "\x1b[32monline\x1b[0m  ", pad("\x1b[32monline\x1b[0m", 8))
+}
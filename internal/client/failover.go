@@ -0,0 +1,107 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Time between two checks of the primary target while the tunnel is served by a backup
+const failbackInterval = 30 * time.Second
+
+// publicURL returns the public URL of the tunnel on a server
+func publicURL(target string, subdomain string) string {
+	URL, _ := url.Parse(target)
+
+	httpScheme := "https"
+	if URL.Scheme == "ws" {
+		httpScheme = "http"
+	}
+	httpPort := URL.Port()
+	if httpPort != "" {
+		httpPort = ":" + httpPort
+	}
+
+	return fmt.Sprintf("%s://%s.%s%s", httpScheme, subdomain, URL.Hostname(), httpPort)
+}
+
+// readyURL returns the readiness endpoint of a server
+func readyURL(target string) string {
+	target = strings.TrimSuffix(target, "/register")
+	if strings.HasPrefix(target, "ws") {
+		target = "http" + strings.TrimPrefix(target, "ws")
+	}
+	return target + "/ready"
+}
+
+// failover moves the pool to the next target after the current one failed.
+// It returns false once every target failed in a row, so the pool backs off before trying again.
+// This MUST be surrounded by pool.lock.Lock()
+func (pool *Pool) failover(failed string) bool {
+	if len(pool.targets) < 2 {
+		return false
+	}
+
+	// Another connection already moved the pool
+	if pool.target != failed {
+		return true
+	}
+
+	pool.attempts++
+	if pool.attempts >= len(pool.targets) {
+		if isNewConnection(pool.client.Config.id) {
+			pool.client.fatal(errors.New("unable to reach any of the servers"))
+			return false
+		}
+		pool.attempts = 0
+		return false
+	}
+
+	next := pool.targets[0]
+	for i, target := range pool.targets {
+		if target == failed {
+			next = pool.targets[(i+1)%len(pool.targets)]
+		}
+	}
+	pool.target = next
+
+	log.Printf("Server %s unavailable, trying %s", failed, next)
+	return true
+}
+
+// failback moves the pool back to the primary target once it is ready again
+func (pool *Pool) failback(ctx context.Context) {
+	pool.lock.Lock()
+	if len(pool.targets) < 2 || pool.target == pool.targets[0] || time.Since(pool.probedAt) < failbackInterval {
+		pool.lock.Unlock()
+		return
+	}
+	pool.probedAt = time.Now()
+	primary := pool.targets[0]
+	pool.lock.Unlock()
+
+	go func() {
+		ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, readyURL(primary), nil)
+		if err != nil {
+			return
+		}
+		res, err := pool.client.client.Do(req)
+		if err != nil {
+			return
+		}
+		res.Body.Close()
+
+		if res.StatusCode == http.StatusOK {
+			log.Printf("Server %s is available again", primary)
+			pool.retarget(primary)
+		}
+	}()
+}
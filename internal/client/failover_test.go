@@ -0,0 +1,93 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFailoverAndFailback(t *testing.T) {
+	primary := newFakeServer(t, "primary")
+	backup := newFakeServer(t, "backup")
+	primary.setStatus(http.StatusServiceUnavailable)
+
+	client := newTestClient(primary.target(), backup.target())
+	pool := NewPool(client, primary.target(), backup.target())
+	defer pool.Shutdown()
+
+	// The primary is draining, the pool moves to the backup without backing off
+	pool.connector(context.Background())
+	assert.Eventually(t, func() bool { return pool.getTarget() == backup.target() }, time.Second, 10*time.Millisecond)
+	pool.connector(context.Background())
+	assert.Eventually(t, func() bool { return backup.count("backup") == 1 }, time.Second, 10*time.Millisecond)
+
+	// The pool stays on the backup until the primary is ready again
+	pool.failback(context.Background())
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, backup.target(), pool.getTarget())
+
+	primary.setStatus(0)
+	pool.lock.Lock()
+	pool.probedAt = time.Time{}
+	pool.lock.Unlock()
+	pool.failback(context.Background())
+	assert.Eventually(t, func() bool { return pool.getTarget() == primary.target() }, time.Second, 10*time.Millisecond)
+	assert.Eventually(t, func() bool { return backup.count("backup") == 0 }, time.Second, 10*time.Millisecond)
+
+	pool.connector(context.Background())
+	assert.Eventually(t, func() bool { return primary.count("primary") == 1 }, time.Second, 10*time.Millisecond)
+}
+
+func TestFailoverBacksOffOnceEveryTargetFailed(t *testing.T) {
+	primary := newFakeServer(t, "primary")
+	backup := newFakeServer(t, "backup")
+	primary.setStatus(http.StatusServiceUnavailable)
+	backup.setStatus(http.StatusServiceUnavailable)
+
+	client := newTestClient(primary.target(), backup.target())
+	registerNewConnection(client.Config.id)
+	pool := NewPool(client, primary.target(), backup.target())
+	defer pool.Shutdown()
+
+	pool.connector(context.Background())
+	assert.Eventually(t, func() bool { return pool.getTarget() == backup.target() }, time.Second, 10*time.Millisecond)
+	pool.connector(context.Background())
+	assert.Eventually(t, func() bool {
+		pool.lock.RLock()
+		defer pool.lock.RUnlock()
+		return !pool.retryAt.IsZero()
+	}, time.Second, 10*time.Millisecond)
+
+	assert.Equal(t, 1, primary.countRegistrations())
+	assert.Equal(t, 1, backup.countRegistrations())
+	select {
+	case <-client.Done():
+		t.Fatal(client.Err())
+	default:
+	}
+}
+
+func TestStrategyAll(t *testing.T) {
+	first := newFakeServer(t, "first")
+	second := newFakeServer(t, "second")
+
+	client := newTestClient(first.target(), second.target())
+	client.Config.Strategy = StrategyAll
+	client.Start(context.Background())
+
+	// Each server gets the tunnel connections and a control channel
+	assert.Eventually(t, func() bool {
+		return first.count("first") > 0 && second.count("second") > 0 &&
+			first.countControls() == 1 && second.countControls() == 1
+	}, 3*time.Second, 10*time.Millisecond)
+	assert.Len(t, client.pools, 2)
+
+	client.Shutdown()
+	assert.Eventually(t, func() bool {
+		return first.count("first") == 0 && second.count("second") == 0 &&
+			first.countControls() == 0 && second.countControls() == 0
+	}, time.Second, 10*time.Millisecond)
+}
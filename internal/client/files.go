@@ -0,0 +1,74 @@
+package client
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/amalshaji/beaver/internal/fileserver"
+)
+
+// serveFiles serves a request from the directory of its tunnel
+func serveFiles(req *http.Request, tunnel TunnelConfig) *http.Response {
+	handler := fileserver.New(tunnel.Dir, fileserver.Options{Listing: tunnel.Listing, SPA: tunnel.SPA})
+	return handlerResponse(handler, req)
+}
+
+// handlerResponse runs a handler in the background and returns its response once the headers are written,
+// the body is streamed as the handler writes it and must be closed
+func handlerResponse(handler http.Handler, req *http.Request) *http.Response {
+	reader, writer := io.Pipe()
+	w := &pipeResponseWriter{header: make(http.Header), body: writer, ready: make(chan int, 1)}
+
+	go func() {
+		handler.ServeHTTP(w, req)
+		w.WriteHeader(http.StatusOK)
+		writer.Close()
+	}()
+
+	status := <-w.ready
+	contentLength, err := strconv.ParseInt(w.snapshot.Get("Content-Length"), 10, 64)
+	if err != nil {
+		contentLength = -1
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        w.snapshot,
+		Body:          reader,
+		ContentLength: contentLength,
+		Request:       req,
+	}
+}
+
+// pipeResponseWriter is the http.ResponseWriter of handlerResponse
+type pipeResponseWriter struct {
+	header   http.Header
+	snapshot http.Header
+	body     *io.PipeWriter
+	ready    chan int
+	written  bool
+}
+
+func (w *pipeResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *pipeResponseWriter) WriteHeader(status int) {
+	if w.written {
+		return
+	}
+	w.written = true
+	// The handler may keep changing its header map, the response gets the headers written
+	w.snapshot = w.header.Clone()
+	w.ready <- status
+}
+
+func (w *pipeResponseWriter) Write(b []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	return w.body.Write(b)
+}
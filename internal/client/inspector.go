@@ -0,0 +1,179 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"path/filepath"
+	"time"
+
+	"github.com/amalshaji/beaver/internal/inspector"
+	"github.com/amalshaji/beaver/internal/rewrite"
+	"github.com/amalshaji/beaver/internal/traffic"
+)
+
+// newExchange starts capturing a request for the inspector and the recording, it returns nils when both are disabled.
+// The body is captured by reading the request through the returned Capture.
+func (c *Client) newExchange(req *http.Request, tunnel TunnelConfig) (*traffic.Exchange, *traffic.Capture) {
+	if c.inspector == nil && c.recorder == nil {
+		return nil, nil
+	}
+
+	exchange := &traffic.Exchange{
+		Time:      time.Now(),
+		Subdomain: tunnel.Subdomain,
+		Port:      tunnel.Port,
+		Request:   traffic.NewRequest(req),
+	}
+	return exchange, traffic.NewCapture(c.captureLimit())
+}
+
+// captureLimit returns the size limit of the captured bodies, recordings keep larger bodies than the inspector.
+// The bodies are captured once, the inspector keeps copies truncated to its own limit.
+func (c *Client) captureLimit() int64 {
+	if c.recorder != nil {
+		return inspector.RecordMaxBodySize
+	}
+	return c.inspector.MaxBodySize()
+}
+
+// recordExchange stores a served request once its response body has been sent
+func (c *Client) recordExchange(exchange *traffic.Exchange, requestCapture *traffic.Capture, res *http.Response, responseCapture *traffic.Capture, err error) {
+	if exchange == nil {
+		return
+	}
+
+	exchange.Duration = time.Since(exchange.Time)
+	exchange.Request.SetBody(requestCapture)
+	if err != nil {
+		exchange.Error = err.Error()
+	}
+	if res != nil {
+		exchange.Response = traffic.NewResponse(res)
+		exchange.Response.SetBody(responseCapture)
+	}
+
+	// The store truncates a copy of the bodies, the recording gets them whole
+	if c.inspector != nil {
+		c.inspector.Add(exchange)
+	}
+	if c.recorder != nil {
+		if err := c.recorder.Record(exchange); err != nil {
+			log.Printf("Unable to record request : %v", err)
+		}
+	}
+}
+
+// Record appends the requests served by the tunnels, and their responses, to a file until the client is shut down.
+// It must be called before Start.
+func (c *Client) Record(path string) error {
+	// The client may run in the background, from another directory
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+
+	recorder, err := inspector.NewRecorder(path)
+	if err != nil {
+		return fmt.Errorf("unable to record to %s : %w", path, err)
+	}
+	c.recorder = recorder
+	go func() {
+		<-c.done
+		recorder.Close()
+	}()
+	return nil
+}
+
+// Requests returns the captured requests, the latest first, of a tunnel or of all of them when subdomain is empty
+func (c *Client) Requests(subdomain string) []*traffic.Exchange {
+	if c.inspector == nil {
+		return nil
+	}
+
+	var exchanges []*traffic.Exchange
+	for _, exchange := range c.inspector.List() {
+		if subdomain == "" || exchange.Subdomain == subdomain {
+			exchanges = append(exchanges, exchange)
+		}
+	}
+	return exchanges
+}
+
+// ServeInspector serves the web UI and API of the request inspector on addr until the client is shut down
+func (c *Client) ServeInspector(addr string) (string, error) {
+	if c.inspector == nil {
+		return "", errors.New("the inspector is disabled")
+	}
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return "", fmt.Errorf("unable to listen on %s : %w", addr, err)
+	}
+
+	server := &http.Server{Handler: inspector.NewHandler(c.inspector, c.replay, addr)}
+	go func() {
+		<-c.done
+		server.Close()
+	}()
+
+	go func() {
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("Inspector stopped : %v", err)
+		}
+	}()
+	return fmt.Sprintf("http://%s", listener.Addr()), nil
+}
+
+// replay sends a captured request, possibly edited, to the local server of its tunnel and records the new exchange
+func (c *Client) replay(ctx context.Context, original *traffic.Exchange, req *traffic.Request) (*traffic.Exchange, error) {
+	var tunnel TunnelConfig
+	found := false
+	for _, t := range c.Tunnels() {
+		if t.Subdomain == original.Subdomain {
+			tunnel, found = t, true
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("%w: %s", ErrTunnelNotFound, original.Subdomain)
+	}
+
+	if req.Truncated {
+		return nil, traffic.ErrBodyTruncated
+	}
+
+	// The requests are captured before the rules of the tunnel are applied
+	var transport http.RoundTripper = c.transport(tunnel)
+	if tunnel.Rewrite != nil {
+		transport = rewriteTransport{rules: tunnel.Rewrite, next: transport}
+	}
+	httpClient := &http.Client{Transport: transport, CheckRedirect: c.client.CheckRedirect}
+	exchange := traffic.Send(ctx, httpClient, localServer(tunnel), req, c.inspector.MaxBodySize())
+	exchange.Subdomain = tunnel.Subdomain
+	exchange.Port = tunnel.Port
+	exchange.ReplayOf = original.ID
+
+	log.Printf("[%d] [%s] replay of #%d %s", tunnel.Port, req.Method, original.ID, req.URL)
+	return c.inspector.Add(exchange), nil
+}
+
+// rewriteTransport applies the rules of a tunnel to the replayed requests and their responses, like Connection.serve
+type rewriteTransport struct {
+	rules *rewrite.Rules
+	next  http.RoundTripper
+}
+
+func (t rewriteTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	t.rules.RewriteRequest(req)
+
+	res, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	t.rules.RewriteResponse(res.Header)
+	return res, nil
+}
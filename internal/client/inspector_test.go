@@ -0,0 +1,89 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/amalshaji/beaver/internal/inspector"
+	"github.com/amalshaji/beaver/internal/rewrite"
+	"github.com/amalshaji/beaver/internal/traffic"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecordKeepsLargerBodies(t *testing.T) {
+	client := newTestClient("ws://localhost/register")
+	client.inspector = inspector.NewStore(10, 16)
+	path := filepath.Join(t.TempDir(), "session.jsonl")
+	assert.NoError(t, client.Record(path))
+
+	body := strings.Repeat("a", 1024)
+	req := httptest.NewRequest("POST", "http://web.localhost/hook", strings.NewReader(body))
+	exchange, requestCapture := client.newExchange(req, TunnelConfig{Subdomain: "web", Port: 8000})
+	assert.Equal(t, int64(inspector.RecordMaxBodySize), client.captureLimit())
+	io.Copy(requestCapture, req.Body)
+
+	res := &http.Response{StatusCode: 200, Header: http.Header{}}
+	responseCapture := traffic.NewCapture(client.captureLimit())
+	responseCapture.Write(bytes.Repeat([]byte("b"), 2048))
+	client.recordExchange(exchange, requestCapture, res, responseCapture, nil)
+	client.Shutdown()
+
+	// The inspector keeps its own limit
+	stored := client.Requests("web")
+	assert.Len(t, stored, 1)
+	assert.Len(t, stored[0].Request.Body, 16)
+	assert.True(t, stored[0].Request.Truncated)
+	assert.Len(t, stored[0].Response.Body, 16)
+
+	recorded, err := inspector.ReadRecording(path)
+	assert.NoError(t, err)
+	assert.Len(t, recorded, 1)
+	assert.Equal(t, body, string(recorded[0].Request.Body))
+	assert.False(t, recorded[0].Request.Truncated)
+	assert.Len(t, recorded[0].Response.Body, 2048)
+}
+
+func TestReplayRewritesTheCapturedRequest(t *testing.T) {
+	var received *http.Request
+	local := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r
+		w.Header().Set("Server", "WEBrick")
+	}))
+	defer local.Close()
+	u, _ := url.Parse(local.URL)
+	port, _ := strconv.Atoi(u.Port())
+
+	client := newTestClient("ws://localhost/register")
+	client.inspector = inspector.NewStore(10, 1024)
+	client.tunnels = []TunnelConfig{{Subdomain: "web", Port: port, Rewrite: &rewrite.Rules{
+		Host:        "myapp.test",
+		StripPrefix: "/api",
+		Request:     rewrite.HeaderRules{Set: map[string]string{"Authorization": "Bearer internal"}},
+		Response:    rewrite.HeaderRules{Remove: []string{"Server"}},
+	}}}
+
+	// The request is captured before the rules are applied, the headers they set are not shown
+	req := httptest.NewRequest("GET", "http://web.localhost/api/users", nil)
+	exchange, _ := client.newExchange(req, client.tunnels[0])
+	client.tunnels[0].Rewrite.RewriteRequest(req)
+	assert.Equal(t, "/api/users", exchange.Request.URL)
+	assert.Equal(t, "web.localhost", exchange.Request.Host)
+	assert.Empty(t, exchange.Request.Header.Get("Authorization"))
+
+	replayed, err := client.replay(context.Background(), exchange, exchange.Request)
+	assert.NoError(t, err)
+	assert.Empty(t, replayed.Error)
+	assert.Equal(t, "/users", received.URL.Path)
+	assert.Equal(t, "myapp.test", received.Host)
+	assert.Equal(t, "Bearer internal", received.Header.Get("Authorization"))
+	assert.Empty(t, replayed.Request.Header.Get("Authorization"))
+	assert.Empty(t, replayed.Response.Header.Get("Server"))
+}
@@ -0,0 +1,88 @@
+package client
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// RotatingFile is a log file which is rotated once it reaches maxSize,
+// keeping at most backups previous files (beaver.log.1, beaver.log.2, ...)
+type RotatingFile struct {
+	path    string
+	maxSize int64
+	backups int
+
+	file *os.File
+	size int64
+	lock sync.Mutex
+}
+
+// NewRotatingFile opens the log file, appending to it
+func NewRotatingFile(path string, maxSize int64, backups int) (*RotatingFile, error) {
+	r := &RotatingFile{path: path, maxSize: maxSize, backups: backups}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *RotatingFile) open() error {
+	if err := os.MkdirAll(filepath.Dir(r.path), 0o700); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	r.file = file
+	r.size = info.Size()
+	return nil
+}
+
+// rotate shifts the previous files and starts a new one.
+// This MUST be surrounded by r.lock.Lock()
+func (r *RotatingFile) rotate() error {
+	r.file.Close()
+
+	os.Remove(fmt.Sprintf("%s.%d", r.path, r.backups))
+	for i := r.backups - 1; i > 0; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", r.path, i), fmt.Sprintf("%s.%d", r.path, i+1))
+	}
+	if r.backups > 0 {
+		os.Rename(r.path, r.path+".1")
+	} else {
+		os.Remove(r.path)
+	}
+
+	return r.open()
+}
+
+func (r *RotatingFile) Write(p []byte) (int, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.size > 0 && r.size+int64(len(p)) > r.maxSize {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// Close closes the current file
+func (r *RotatingFile) Close() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	return r.file.Close()
+}
@@ -0,0 +1,47 @@
+package client
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logs", "beaver.log")
+	file, err := NewRotatingFile(path, 10, 2)
+	assert.NoError(t, err)
+
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		_, err := file.Write([]byte(line))
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, file.Close())
+
+	// Each write would have gone past the max size, the oldest file is dropped
+	for name, expected := range map[string]string{"beaver.log": "fourth\n", "beaver.log.1": "third\n", "beaver.log.2": "second\n"} {
+		content, err := os.ReadFile(filepath.Join(filepath.Dir(path), name))
+		assert.NoError(t, err)
+		assert.Equal(t, expected, string(content), name)
+	}
+	_, err = os.Stat(path + ".3")
+	assert.True(t, os.IsNotExist(err))
+
+	// The file is appended to after a restart
+	file, err = NewRotatingFile(path, 100, 2)
+	assert.NoError(t, err)
+	file.Write([]byte("fifth\n"))
+	file.Close()
+	content, _ := os.ReadFile(path)
+	assert.Equal(t, "fourth\nfifth\n", string(content))
+
+	// Without backups, the file starts over
+	file, err = NewRotatingFile(path, int64(len("fourth\nfifth\n")), 0)
+	assert.NoError(t, err)
+	file.Write([]byte(strings.Repeat("x", 4)))
+	file.Close()
+	content, _ = os.ReadFile(path)
+	assert.Equal(t, "xxxx", string(content))
+}
@@ -0,0 +1,101 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"github.com/amalshaji/beaver/internal/fault"
+	"github.com/amalshaji/beaver/internal/mock"
+)
+
+// send sends a request to the local server of its tunnel, or serves it from the directory of the tunnel.
+// The mocks of the tunnel answer it when the tunnel has no local server, or when the local server is unreachable.
+func (c *Client) send(req *http.Request, tunnel TunnelConfig) (*http.Response, error) {
+	if tunnel.Dir != "" {
+		return serveFiles(req, tunnel), nil
+	}
+
+	if tunnel.Port == 0 {
+		resp, err := c.mockResponse(req, tunnel)
+		if errors.Is(err, mock.ErrNoRoute) {
+			return notFound(req, err), nil
+		}
+		return resp, err
+	}
+
+	resp, err := c.client.Do(req)
+	if err == nil || tunnel.Mocks == "" {
+		return resp, err
+	}
+
+	mocked, mockErr := c.mockResponse(req, tunnel)
+	if mockErr != nil {
+		if !errors.Is(mockErr, mock.ErrNoRoute) {
+			log.Printf("[%d] Unable to answer from %s : %v", tunnel.Port, filepath.Base(tunnel.Mocks), mockErr)
+		}
+		return nil, err
+	}
+	log.Printf("[%d] Local server unreachable, answering from %s", tunnel.Port, filepath.Base(tunnel.Mocks))
+	return mocked, nil
+}
+
+// mockResponse answers a request from the mocks of its tunnel
+func (c *Client) mockResponse(req *http.Request, tunnel TunnelConfig) (*http.Response, error) {
+	c.mocksLock.Lock()
+	file, ok := c.mocks[tunnel.Mocks]
+	if !ok {
+		file = mock.NewFile(tunnel.Mocks)
+		c.mocks[tunnel.Mocks] = file
+	}
+	c.mocksLock.Unlock()
+
+	mocks, err := file.Mocks()
+	if err != nil {
+		if mocks == nil {
+			return nil, err
+		}
+		// The file was edited, the previous routes are used until it is fixed
+		log.Printf("Unable to reload %s : %v", filepath.Base(tunnel.Mocks), err)
+	}
+	return mocks.Respond(req)
+}
+
+// notFound is the response of a mock tunnel to the requests none of its routes match
+func notFound(req *http.Request, err error) *http.Response {
+	body := fmt.Sprintf("%s\n", err)
+	return &http.Response{
+		Status:        "404 Not Found",
+		StatusCode:    http.StatusNotFound,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": {"text/plain; charset=utf-8"}, mock.Header: {"true"}},
+		Body:          io.NopCloser(strings.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+}
+
+// tunnelTransport sends the requests of a tunnel
+type tunnelTransport struct {
+	client *Client
+	tunnel TunnelConfig
+}
+
+func (t tunnelTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return t.client.send(req, t.tunnel)
+}
+
+// transport returns the transport of the requests of a tunnel, with its faults injected
+func (c *Client) transport(tunnel TunnelConfig) http.RoundTripper {
+	transport := tunnelTransport{client: c, tunnel: tunnel}
+	if len(tunnel.Faults) == 0 {
+		return transport
+	}
+	return &fault.Transport{Rules: tunnel.Faults, Next: transport}
+}
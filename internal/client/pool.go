@@ -2,10 +2,13 @@ package client
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"sync"
 	"time"
+
+	"github.com/gorilla/websocket"
 )
 
 // Pool manage a pool of connection to a remote Server
@@ -13,18 +16,44 @@ type Pool struct {
 	client *Client
 	target string
 
+	// Servers the pool fails over to, in order of preference, see failover.go
+	targets  []string
+	attempts int
+	probedAt time.Time
+
+	// What the server reported about each tunnel, by subdomain
+	tunnels map[string]tunnelState
+
 	connections []*Connection
 	lock        sync.RWMutex
 
+	// Control channel of the session on the target, see control.go
+	control *websocket.Conn
+
+	// Pool sizes, adjusted from the limits advertised by the server
+	idleSize int
+	maxSize  int
+
+	// Reconnection backoff while the server is unavailable
+	failures int
+	retryAt  time.Time
+
+	// The server is draining, no new connection is opened until it closes the current ones
+	draining bool
+
 	done chan struct{}
 }
 
-// NewPool creates a new Pool
-func NewPool(client *Client, target string) (pool *Pool) {
+// NewPool creates a new Pool connecting to the first target
+func NewPool(client *Client, targets ...string) (pool *Pool) {
 	pool = new(Pool)
 	pool.client = client
-	pool.target = target
+	pool.target = targets[0]
+	pool.targets = targets
+	pool.tunnels = make(map[string]tunnelState)
 	pool.connections = make([]*Connection, 0)
+	pool.idleSize = client.Config.PoolIdleSize
+	pool.maxSize = client.Config.PoolMaxSize
 	pool.done = make(chan struct{})
 	return
 }
@@ -43,6 +72,7 @@ func (pool *Pool) Start(ctx context.Context) {
 				break L
 			case <-ticker.C:
 				pool.connector(ctx)
+				pool.failback(ctx)
 			}
 		}
 	}()
@@ -55,20 +85,32 @@ func (pool *Pool) connector(ctx context.Context) {
 
 	poolSize := pool.Size()
 
+	if pool.draining {
+		if poolSize.total > 0 {
+			return
+		}
+		pool.draining = false
+	}
+
+	if time.Now().Before(pool.retryAt) {
+		return
+	}
+
 	// Create enough connection to fill the pool
-	toCreate := pool.client.Config.PoolIdleSize - poolSize.idle
+	toCreate := pool.idleSize - poolSize.idle
 
 	// Create only one connection if the pool is empty
 	if poolSize.total == 0 {
 		toCreate = 1
 	}
 
-	// Ensure to open at most PoolMaxSize connections
-	if poolSize.total+toCreate > pool.client.Config.PoolMaxSize {
-		toCreate = pool.client.Config.PoolMaxSize - poolSize.total
+	// Ensure to open at most maxSize connections
+	if poolSize.total+toCreate > pool.maxSize {
+		toCreate = pool.maxSize - poolSize.total
 	}
 
 	// Try to reach ideal pool size
+	target := pool.target
 	for i := 0; i < toCreate; i++ {
 		conn := NewConnection(pool)
 		pool.add(conn)
@@ -76,16 +118,116 @@ func (pool *Pool) connector(ctx context.Context) {
 		go func() {
 			err := conn.Connect(ctx)
 			if err != nil {
-				log.Printf("Unable to connect to %s : %s", pool.target, err)
+				log.Printf("Unable to connect to %s : %s", pool.getTarget(), err)
 
 				pool.lock.Lock()
 				defer pool.lock.Unlock()
+
+				// Stop growing the pool until the server advertises a new limit
+				if errors.Is(err, ErrConnectionLimit) {
+					pool.limitReached()
+				}
+
+				// Move to the next target, or back off while the server is down or draining
+				if errors.Is(err, ErrServerUnavailable) && !pool.failover(target) {
+					pool.backoff()
+				}
 				pool.remove(conn)
 			}
 		}()
 	}
 }
 
+// setLimits applies the pool sizes advertised by the server.
+// The configured PoolMaxSize stays an upper bound.
+func (pool *Pool) setLimits(idleSize, maxSize int) {
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+
+	if maxSize > 0 {
+		pool.maxSize = maxSize
+		if pool.maxSize > pool.client.Config.PoolMaxSize {
+			pool.maxSize = pool.client.Config.PoolMaxSize
+		}
+	}
+	if idleSize > 0 {
+		pool.idleSize = idleSize
+		if pool.idleSize > pool.maxSize {
+			pool.idleSize = pool.maxSize
+		}
+	}
+}
+
+// limitReached keeps the pool at the connections the server accepted, at least one so the pool
+// reconnects once they close, and backs off before trying again.
+// This MUST be surrounded by pool.lock.Lock()
+func (pool *Pool) limitReached() {
+	pool.maxSize = len(pool.connections) - 1
+	if pool.maxSize < 1 {
+		pool.maxSize = 1
+	}
+	pool.backoff()
+}
+
+// backoff delays the next connection attempt, exponentially up to 30 seconds.
+// This MUST be surrounded by pool.lock.Lock()
+func (pool *Pool) backoff() {
+	pool.failures++
+
+	delay := time.Duration(1<<uint(pool.failures)) * time.Second
+	if pool.failures > 5 || delay > 30*time.Second {
+		delay = 30 * time.Second
+	}
+	pool.retryAt = time.Now().Add(delay)
+
+	log.Printf("Retrying in %s", delay)
+}
+
+// resetBackoff is called once a connection is established
+func (pool *Pool) resetBackoff() {
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+
+	pool.failures = 0
+	pool.retryAt = time.Time{}
+	pool.attempts = 0
+}
+
+// drain stops opening connections until the server has closed the current ones
+func (pool *Pool) drain() {
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+
+	pool.draining = true
+}
+
+// getTarget returns the server the pool connects to
+func (pool *Pool) getTarget() string {
+	pool.lock.RLock()
+	defer pool.lock.RUnlock()
+
+	return pool.target
+}
+
+// retarget moves the pool to another server.
+// Idle connections are closed right away and reopened by the connector, running ones finish their request first.
+func (pool *Pool) retarget(target string) {
+	pool.lock.Lock()
+	pool.target = target
+
+	var idle []*Connection
+	for _, connection := range pool.connections {
+		if connection.getStatus() == IDLE {
+			idle = append(idle, connection)
+		}
+	}
+	pool.lock.Unlock()
+
+	for _, connection := range idle {
+		connection.Close()
+	}
+}
+
 // Add a connection to the pool
 func (pool *Pool) add(conn *Connection) {
 	pool.connections = append(pool.connections, conn)
@@ -105,9 +247,15 @@ func (pool *Pool) remove(conn *Connection) {
 	pool.connections = filtered
 }
 
-// Shutdown close all connection in the pool
+// Shutdown close all connection in the pool and its control channel
 func (pool *Pool) Shutdown() {
+	pool.lock.Lock()
 	close(pool.done)
+	if pool.control != nil {
+		pool.control.Close()
+	}
+	pool.lock.Unlock()
+
 	for _, conn := range pool.connections {
 		conn.Close()
 	}
@@ -130,7 +278,7 @@ func (pool *Pool) Size() (poolSize *PoolSize) {
 	poolSize = new(PoolSize)
 	poolSize.total = len(pool.connections)
 	for _, connection := range pool.connections {
-		switch connection.status {
+		switch connection.getStatus() {
 		case CONNECTING:
 			poolSize.connecting++
 		case IDLE:
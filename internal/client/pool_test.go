@@ -0,0 +1,124 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/amalshaji/beaver/internal/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestClient(targets ...string) *Client {
+	return NewClient(&Config{
+		id:           "test",
+		Targets:      targets,
+		Strategy:     StrategyFailover,
+		PoolIdleSize: 2,
+		PoolMaxSize:  4,
+	})
+}
+
+func TestPoolConnectionLimit(t *testing.T) {
+	server := newFakeServer(t, "server")
+	server.setStatus(http.StatusTooManyRequests)
+
+	pool := NewPool(newTestClient(server.target()), server.target())
+	pool.connector(context.Background())
+	assert.Eventually(t, func() bool {
+		pool.lock.RLock()
+		defer pool.lock.RUnlock()
+		return len(pool.connections) == 0
+	}, time.Second, 10*time.Millisecond)
+
+	// The pool keeps room for one connection and retries later
+	pool.lock.RLock()
+	assert.Equal(t, 1, pool.maxSize)
+	assert.True(t, pool.retryAt.After(time.Now()))
+	pool.lock.RUnlock()
+
+	pool.connector(context.Background())
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, 1, server.countRegistrations())
+
+	pool.lock.Lock()
+	pool.retryAt = time.Time{}
+	pool.lock.Unlock()
+	pool.connector(context.Background())
+	assert.Eventually(t, func() bool {
+		return server.countRegistrations() == 2
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestPoolLimits(t *testing.T) {
+	pool := NewPool(newTestClient("ws://localhost/register"), "ws://localhost/register")
+
+	pool.setLimits(8, 16)
+	assert.Equal(t, 4, pool.maxSize)
+	assert.Equal(t, 4, pool.idleSize)
+
+	pool.setLimits(1, 2)
+	assert.Equal(t, 2, pool.maxSize)
+	assert.Equal(t, 1, pool.idleSize)
+
+	// Unset limits keep the current ones
+	pool.setLimits(0, 0)
+	assert.Equal(t, 2, pool.maxSize)
+	assert.Equal(t, 1, pool.idleSize)
+}
+
+func TestPoolBackoffWhenServerUnavailable(t *testing.T) {
+	server := newFakeServer(t, "server")
+	server.setStatus(http.StatusServiceUnavailable)
+
+	client := newTestClient(server.target())
+	pool := NewPool(client, server.target())
+	for i := 1; i <= 3; i++ {
+		pool.connector(context.Background())
+		assert.Eventually(t, func() bool {
+			pool.lock.RLock()
+			defer pool.lock.RUnlock()
+			return len(pool.connections) == 0
+		}, time.Second, 10*time.Millisecond)
+
+		// The delay doubles after each failure
+		pool.lock.Lock()
+		assert.Equal(t, i, pool.failures)
+		assert.WithinDuration(t, time.Now().Add(time.Duration(1<<i)*time.Second), pool.retryAt, 100*time.Millisecond)
+		pool.retryAt = time.Time{}
+		pool.lock.Unlock()
+	}
+	assert.Equal(t, 3, server.countRegistrations())
+
+	// The client keeps running while the server is away
+	select {
+	case <-client.Done():
+		t.Fatal(client.Err())
+	default:
+	}
+
+	pool.resetBackoff()
+	assert.Equal(t, 0, pool.failures)
+	assert.True(t, pool.retryAt.IsZero())
+}
+
+func TestPoolWaitsForTheDrainingServer(t *testing.T) {
+	client := newTestClient("ws://localhost/register")
+	pool := NewPool(client, "ws://localhost/register")
+	connection := &Connection{pool: pool}
+	connection.setStatus(RUNNING)
+	pool.add(connection)
+
+	client.handleControlMessage(&utils.ControlMessage{Type: utils.ControlDrain}, pool)
+	assert.True(t, pool.draining)
+
+	// No connection is opened until the server closed the current ones
+	pool.connector(context.Background())
+	assert.Len(t, pool.connections, 1)
+
+	pool.connections = nil
+	pool.retryAt = time.Now().Add(time.Minute)
+	pool.connector(context.Background())
+	assert.False(t, pool.draining)
+}
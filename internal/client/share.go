@@ -0,0 +1,113 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// ShareLink is a signed link letting its visitors through the login gates of a tunnel, until it expires or is revoked
+type ShareLink struct {
+	ID        uint
+	CreatedAt time.Time
+	Subdomain string
+	ExpiresAt time.Time
+	RevokedAt *time.Time
+	CreatedBy string
+	Note      string
+	// Only returned when the link is created
+	URL string
+}
+
+// ShareClient manages the share links of the tunnels on the server, with the secret key of the client
+type ShareClient struct {
+	client    *http.Client
+	baseURL   string
+	secretKey string
+}
+
+// NewShareClient returns a ShareClient for the first server of the config
+func NewShareClient(config Config) *ShareClient {
+	return &ShareClient{
+		client:    &http.Client{Timeout: 10 * time.Second},
+		baseURL:   apiURL(config.Target),
+		secretKey: config.SecretKey,
+	}
+}
+
+// apiURL returns the admin API of a server from its register endpoint
+func apiURL(target string) string {
+	base := strings.TrimSuffix(strings.TrimSuffix(target, "/"), "/register")
+	if strings.HasPrefix(base, "wss://") {
+		base = "https://" + strings.TrimPrefix(base, "wss://")
+	} else if strings.HasPrefix(base, "ws://") {
+		base = "http://" + strings.TrimPrefix(base, "ws://")
+	}
+	return base + "/api/v1"
+}
+
+func (s *ShareClient) do(method string, path string, body any, out any) error {
+	var reader io.Reader
+	if body != nil {
+		payload, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = strings.NewReader(string(payload))
+	}
+
+	req, err := http.NewRequest(method, s.baseURL+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-SECRET-KEY", s.secretKey)
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		var apiErr map[string]string
+		json.NewDecoder(res.Body).Decode(&apiErr)
+		if apiErr["error"] == "" {
+			return fmt.Errorf("server returned %s", res.Status)
+		}
+		return errors.New(apiErr["error"])
+	}
+	if out != nil {
+		return json.NewDecoder(res.Body).Decode(out)
+	}
+	return nil
+}
+
+// CreateShareLink creates a share link for a tunnel of the client, valid for the duration
+func (s *ShareClient) CreateShareLink(subdomain string, duration time.Duration, note string) (ShareLink, error) {
+	var link ShareLink
+	err := s.do(http.MethodPost, "/tunnels/"+url.PathEscape(subdomain)+"/share-links", map[string]string{
+		"Duration": duration.String(),
+		"Note":     note,
+	}, &link)
+	return link, err
+}
+
+// ShareLinks lists the share links of a tunnel of the client, the latest first
+func (s *ShareClient) ShareLinks(subdomain string) ([]ShareLink, error) {
+	var links []ShareLink
+	err := s.do(http.MethodGet, "/tunnels/"+url.PathEscape(subdomain)+"/share-links", nil, &links)
+	return links, err
+}
+
+// RevokeShareLink revokes a share link, its visitors lose access right away
+func (s *ShareClient) RevokeShareLink(id uint) (ShareLink, error) {
+	var link ShareLink
+	err := s.do(http.MethodDelete, fmt.Sprintf("/share-links/%d", id), nil, &link)
+	return link, err
+}
@@ -0,0 +1,89 @@
+package client
+
+import (
+	"sync"
+	"time"
+)
+
+// RequestEvent is a request served by the client
+type RequestEvent struct {
+	Time      time.Time     `json:"time"`
+	Subdomain string        `json:"subdomain"`
+	Port      int           `json:"port"`
+	Method    string        `json:"method"`
+	Path      string        `json:"path"`
+	Status    int           `json:"status"`
+	Latency   time.Duration `json:"latency"`
+	Error     string        `json:"error,omitempty"`
+}
+
+// Failed reports whether the request could not be served or the local server failed
+func (event *RequestEvent) Failed() bool {
+	return event.Error != "" || event.Status >= 500
+}
+
+// TunnelStats counts the requests of a tunnel
+type TunnelStats struct {
+	Requests int `json:"requests"`
+	Errors   int `json:"errors"`
+}
+
+// Stats keeps the most recent requests and per tunnel counters
+type Stats struct {
+	size    int
+	recent  []RequestEvent
+	tunnels map[string]*TunnelStats
+	lock    sync.RWMutex
+}
+
+// NewStats keeps up to size recent requests
+func NewStats(size int) *Stats {
+	return &Stats{size: size, tunnels: make(map[string]*TunnelStats)}
+}
+
+// Record adds a served request
+func (s *Stats) Record(event RequestEvent) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.recent = append(s.recent, event)
+	if len(s.recent) > s.size {
+		s.recent = s.recent[len(s.recent)-s.size:]
+	}
+
+	stats, ok := s.tunnels[event.Subdomain]
+	if !ok {
+		stats = new(TunnelStats)
+		s.tunnels[event.Subdomain] = stats
+	}
+	stats.Requests++
+	if event.Failed() {
+		stats.Errors++
+	}
+}
+
+// Recent returns up to n of the most recent requests, the latest first
+func (s *Stats) Recent(n int) []RequestEvent {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	if n > len(s.recent) {
+		n = len(s.recent)
+	}
+	events := make([]RequestEvent, 0, n)
+	for i := len(s.recent) - 1; i >= len(s.recent)-n; i-- {
+		events = append(events, s.recent[i])
+	}
+	return events
+}
+
+// Tunnel returns the counters of a tunnel
+func (s *Stats) Tunnel(subdomain string) TunnelStats {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	if stats, ok := s.tunnels[subdomain]; ok {
+		return *stats
+	}
+	return TunnelStats{}
+}
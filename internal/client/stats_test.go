@@ -0,0 +1,32 @@
+package client
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStats(t *testing.T) {
+	stats := NewStats(3)
+	for _, event := range []RequestEvent{
+		{Subdomain: "web", Path: "/1", Status: 200},
+		{Subdomain: "web", Path: "/2", Status: 404},
+		{Subdomain: "web", Path: "/3", Status: 500},
+		{Subdomain: "api", Path: "/4", Status: 527, Error: "connection refused"},
+	} {
+		stats.Record(event)
+	}
+
+	// Only the last requests are kept, the latest first
+	var paths []string
+	for _, event := range stats.Recent(10) {
+		paths = append(paths, event.Path)
+	}
+	assert.Equal(t, []string{"/4", "/3", "/2"}, paths)
+	assert.Len(t, stats.Recent(1), 1)
+
+	// The counters cover every request, the client errors are not failures
+	assert.Equal(t, TunnelStats{Requests: 3, Errors: 1}, stats.Tunnel("web"))
+	assert.Equal(t, TunnelStats{Requests: 1, Errors: 1}, stats.Tunnel("api"))
+	assert.Equal(t, TunnelStats{}, stats.Tunnel("unknown"))
+}
@@ -0,0 +1,395 @@
+package client
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/labstack/gommon/color"
+)
+
+var ErrTunnelNotFound = errors.New("tunnel not found")
+
+// TunnelStatus describes a tunnel of the client session on one of the servers
+type TunnelStatus struct {
+	Name      string `json:"name"`
+	Subdomain string `json:"subdomain"`
+	Port      int    `json:"port"`
+	// Local server of the tunnel, eg: http://localhost:8000, or file:// and mock:// for the files and the mocks
+	Local     string `json:"local"`
+	URL       string `json:"url"`
+	Connected bool   `json:"connected"`
+	// Open connections to the server, shared by the tunnels of the session
+	Connections int    `json:"connections"`
+	Paused      bool   `json:"paused"`
+	Error       string `json:"error,omitempty"`
+}
+
+// tunnelState is what a server reported about a tunnel
+type tunnelState struct {
+	target string
+	err    string
+}
+
+// localServer returns the local server of a tunnel
+func localServer(tunnel TunnelConfig) string {
+	switch {
+	case tunnel.Dir != "":
+		return "file://" + url.PathEscape(filepath.Base(tunnel.Dir))
+	case tunnel.Port == 0:
+		return "mock://" + url.PathEscape(filepath.Base(tunnel.Mocks))
+	}
+	return fmt.Sprintf("http://localhost:%d", tunnel.Port)
+}
+
+// Tunnels returns the tunnels of the client session
+func (c *Client) Tunnels() []TunnelConfig {
+	c.tunnelLock.RLock()
+	defer c.tunnelLock.RUnlock()
+
+	return append([]TunnelConfig(nil), c.tunnels...)
+}
+
+// registrationHeaders returns the subdomains and local servers of the tunnels, as the server expects them
+func (c *Client) registrationHeaders() (subdomains string, localServers string) {
+	var s, l []string
+	for _, tunnel := range c.Tunnels() {
+		s = append(s, tunnel.Subdomain)
+		l = append(l, localServer(tunnel))
+	}
+	return strings.Join(s, ","), strings.Join(l, ",")
+}
+
+// authHeaders returns the basic auth credentials of the tunnels, as the server expects them
+func (c *Client) authHeaders() []string {
+	var values []string
+	for _, tunnel := range c.Tunnels() {
+		if tunnel.Auth != "" {
+			values = append(values, tunnel.Subdomain+"="+base64.StdEncoding.EncodeToString([]byte(tunnel.Auth)))
+		}
+	}
+	return values
+}
+
+// webhookHeaders returns the signatures the server verifies on the webhooks of the tunnels, as it expects them
+func (c *Client) webhookHeaders() []string {
+	var values []string
+	for _, tunnel := range c.Tunnels() {
+		if tunnel.Webhook != "" {
+			values = append(values, tunnel.Subdomain+"="+base64.StdEncoding.EncodeToString([]byte(tunnel.Webhook)))
+		}
+	}
+	return values
+}
+
+// ipFilterHeaders returns the addresses the tunnels accept requests from, as the server expects them
+func (c *Client) ipFilterHeaders() (allow []string, deny []string) {
+	for _, tunnel := range c.Tunnels() {
+		if len(tunnel.AllowIPs) > 0 {
+			allow = append(allow, tunnel.Subdomain+"="+strings.Join(tunnel.AllowIPs, " "))
+		}
+		if len(tunnel.DenyIPs) > 0 {
+			deny = append(deny, tunnel.Subdomain+"="+strings.Join(tunnel.DenyIPs, " "))
+		}
+	}
+	return
+}
+
+// ports returns the local ports of the tunnels, for logging
+func (c *Client) ports() string {
+	var ports []string
+	for _, tunnel := range c.Tunnels() {
+		ports = append(ports, fmt.Sprintf(":%d", tunnel.Port))
+	}
+	return strings.Join(ports, ", ")
+}
+
+// tunnelForHost returns the tunnel a request is meant for, from its Host header
+func (c *Client) tunnelForHost(host string) (TunnelConfig, bool) {
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		host = hostname
+	}
+
+	c.tunnelLock.RLock()
+	defer c.tunnelLock.RUnlock()
+
+	// Prefer the longest subdomain, in case one is a prefix of another
+	var match TunnelConfig
+	found := false
+	for _, tunnel := range c.tunnels {
+		if host != tunnel.Subdomain && !strings.HasPrefix(host, tunnel.Subdomain+".") {
+			continue
+		}
+		if !found || len(tunnel.Subdomain) > len(match.Subdomain) {
+			match = tunnel
+			found = true
+		}
+	}
+	return match, found
+}
+
+// parseRejectedTunnels reads the tunnels the server refused to register, by subdomain
+func parseRejectedTunnels(header http.Header) map[string]string {
+	rejected := make(map[string]string)
+	for _, value := range header.Values("X-TUNNEL-REJECTED") {
+		subdomain, reason, _ := strings.Cut(value, ":")
+		rejected[strings.TrimSpace(subdomain)] = strings.TrimSpace(reason)
+	}
+	return rejected
+}
+
+// activate records the server the tunnels are connected to, and logs the tunnels whose status changed
+func (pool *Pool) activate(target string, rejected map[string]string) {
+	tunnels := pool.client.Tunnels()
+
+	pool.lock.Lock()
+	var changed []TunnelConfig
+	previous := make(map[string]tunnelState)
+	for _, tunnel := range tunnels {
+		state := tunnelState{target: target, err: rejected[tunnel.Subdomain]}
+		if pool.tunnels[tunnel.Subdomain] == state {
+			continue
+		}
+		previous[tunnel.Subdomain] = pool.tunnels[tunnel.Subdomain]
+		pool.tunnels[tunnel.Subdomain] = state
+		changed = append(changed, tunnel)
+	}
+	pool.lock.Unlock()
+
+	for _, tunnel := range changed {
+		url := publicURL(target, tunnel.Subdomain)
+		before := previous[tunnel.Subdomain]
+
+		switch {
+		case rejected[tunnel.Subdomain] != "":
+			log.Println(color.Red(fmt.Sprintf("Tunnel %s unavailable: %s", url, rejected[tunnel.Subdomain])))
+		case before.target == "" || before.err != "":
+			log.Println(color.Green(fmt.Sprintf("Tunnel connected %s -> %s", url, localServer(tunnel))))
+		default:
+			log.Println(color.Yellow(fmt.Sprintf("Tunnel moved to %s -> %s", url, localServer(tunnel))))
+		}
+	}
+}
+
+// Status returns the status of each tunnel on each server
+func (c *Client) Status() []TunnelStatus {
+	tunnels := c.Tunnels()
+
+	c.tunnelLock.RLock()
+	paused := make(map[string]bool, len(c.paused))
+	for subdomain := range c.paused {
+		paused[subdomain] = true
+	}
+	c.tunnelLock.RUnlock()
+
+	var statuses []TunnelStatus
+	for _, pool := range c.pools {
+		pool.lock.RLock()
+		connections := 0
+		for _, connection := range pool.connections {
+			if connection.getStatus() != CONNECTING {
+				connections++
+			}
+		}
+		for _, tunnel := range tunnels {
+			state := pool.tunnels[tunnel.Subdomain]
+			target := state.target
+			if target == "" {
+				target = pool.target
+			}
+			statuses = append(statuses, TunnelStatus{
+				Name:        tunnel.Name,
+				Subdomain:   tunnel.Subdomain,
+				Port:        tunnel.Port,
+				Local:       localServer(tunnel),
+				URL:         publicURL(target, tunnel.Subdomain),
+				Connected:   connections > 0 && state.target != "" && state.err == "",
+				Connections: connections,
+				Paused:      paused[tunnel.Subdomain],
+				Error:       state.err,
+			})
+		}
+		pool.lock.RUnlock()
+	}
+
+	sort.SliceStable(statuses, func(i, j int) bool { return statuses[i].Subdomain < statuses[j].Subdomain })
+	return statuses
+}
+
+// AddTunnel adds a tunnel to the running session, a random subdomain is used if it has none
+func (c *Client) AddTunnel(tunnel TunnelConfig) (TunnelConfig, error) {
+	if err := prepareTunnel(&tunnel); err != nil {
+		return tunnel, err
+	}
+	if tunnel.Port < 0 || (tunnel.Port == 0 && tunnel.Mocks == "" && tunnel.Dir == "") {
+		return tunnel, fmt.Errorf("invalid port: %d", tunnel.Port)
+	}
+
+	c.tunnelLock.Lock()
+	for _, t := range c.tunnels {
+		if t.Subdomain == tunnel.Subdomain {
+			c.tunnelLock.Unlock()
+			return tunnel, fmt.Errorf("duplicate subdomain: '%s'", tunnel.Subdomain)
+		}
+	}
+	c.tunnels = append(c.tunnels, tunnel)
+	c.tunnelLock.Unlock()
+
+	log.Printf("Adding tunnel %s -> %s", tunnel.Subdomain, localServer(tunnel))
+	c.reregister()
+	return tunnel, nil
+}
+
+// RemoveTunnel removes a tunnel from the running session
+func (c *Client) RemoveTunnel(subdomain string) error {
+	c.tunnelLock.Lock()
+	index := -1
+	for i, tunnel := range c.tunnels {
+		if tunnel.Subdomain == subdomain {
+			index = i
+		}
+	}
+	if index < 0 {
+		c.tunnelLock.Unlock()
+		return ErrTunnelNotFound
+	}
+	// The session needs at least one tunnel to stay registered
+	if len(c.tunnels) == 1 {
+		c.tunnelLock.Unlock()
+		return fmt.Errorf("unable to remove the last tunnel")
+	}
+	c.tunnels = append(c.tunnels[:index:index], c.tunnels[index+1:]...)
+	delete(c.paused, subdomain)
+	c.tunnelLock.Unlock()
+
+	for _, pool := range c.pools {
+		pool.lock.Lock()
+		delete(pool.tunnels, subdomain)
+		pool.lock.Unlock()
+	}
+
+	log.Printf("Removing tunnel %s", subdomain)
+	c.reregister()
+	return nil
+}
+
+// PauseTunnel stops forwarding the requests of a tunnel, which keeps its subdomain meanwhile
+func (c *Client) PauseTunnel(subdomain string, paused bool) error {
+	c.tunnelLock.Lock()
+	defer c.tunnelLock.Unlock()
+
+	for _, tunnel := range c.tunnels {
+		if tunnel.Subdomain != subdomain {
+			continue
+		}
+		if paused {
+			c.paused[subdomain] = true
+			log.Println(color.Yellow(fmt.Sprintf("Tunnel %s paused", subdomain)))
+		} else {
+			delete(c.paused, subdomain)
+			log.Println(color.Green(fmt.Sprintf("Tunnel %s resumed", subdomain)))
+		}
+		return nil
+	}
+	return ErrTunnelNotFound
+}
+
+// isPaused reports whether the requests of a tunnel must not be forwarded
+func (c *Client) isPaused(subdomain string) bool {
+	c.tunnelLock.RLock()
+	defer c.tunnelLock.RUnlock()
+
+	return c.paused[subdomain]
+}
+
+// reregister opens new connections announcing the current tunnels to the servers
+func (c *Client) reregister() {
+	for _, pool := range c.pools {
+		pool.retarget(pool.getTarget())
+	}
+}
+
+// ReloadTunnels applies a new list of tunnels to the running session.
+// Tunnels are matched by name, or by subdomain when they have none: unchanged tunnels keep running,
+// a tunnel without a subdomain keeps its random one.
+func (c *Client) ReloadTunnels(tunnels []TunnelConfig) error {
+	if len(tunnels) == 0 {
+		return fmt.Errorf("no tunnel to start")
+	}
+
+	c.tunnelLock.Lock()
+
+	key := func(tunnel TunnelConfig) string {
+		if tunnel.Name != "" {
+			return "name:" + tunnel.Name
+		}
+		return "subdomain:" + tunnel.Subdomain
+	}
+	current := make(map[string]TunnelConfig, len(c.tunnels))
+	for _, tunnel := range c.tunnels {
+		current[key(tunnel)] = tunnel
+	}
+
+	var next []TunnelConfig
+	var changes []string
+	subdomains := make(map[string]struct{})
+	for _, tunnel := range tunnels {
+		previous, ok := current[key(tunnel)]
+		if ok && tunnel.Subdomain == "" {
+			tunnel.Subdomain = previous.Subdomain
+		}
+		if err := prepareTunnel(&tunnel); err != nil {
+			c.tunnelLock.Unlock()
+			return err
+		}
+		if _, ok := subdomains[tunnel.Subdomain]; ok {
+			c.tunnelLock.Unlock()
+			return fmt.Errorf("duplicate subdomain: '%s'", tunnel.Subdomain)
+		}
+		subdomains[tunnel.Subdomain] = struct{}{}
+
+		switch {
+		case !ok:
+			changes = append(changes, fmt.Sprintf("added %s -> %s", tunnel.Subdomain, localServer(tunnel)))
+		case !reflect.DeepEqual(previous, tunnel):
+			changes = append(changes, fmt.Sprintf("updated %s -> %s", tunnel.Subdomain, localServer(tunnel)))
+		}
+		delete(current, key(tunnel))
+		next = append(next, tunnel)
+	}
+	for _, tunnel := range current {
+		changes = append(changes, fmt.Sprintf("removed %s", tunnel.Subdomain))
+		delete(c.paused, tunnel.Subdomain)
+	}
+
+	c.tunnels = next
+	c.tunnelLock.Unlock()
+
+	if len(changes) == 0 {
+		log.Println("Configuration reloaded, no tunnel changed")
+		return nil
+	}
+
+	for _, pool := range c.pools {
+		pool.lock.Lock()
+		for subdomain := range pool.tunnels {
+			if _, ok := subdomains[subdomain]; !ok {
+				delete(pool.tunnels, subdomain)
+			}
+		}
+		pool.lock.Unlock()
+	}
+
+	sort.Strings(changes)
+	log.Printf("Configuration reloaded, tunnels %s", strings.Join(changes, ", "))
+	c.reregister()
+	return nil
+}
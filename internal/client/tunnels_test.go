@@ -0,0 +1,52 @@
+package client
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReloadTunnels(t *testing.T) {
+	server := newFakeServer(t, "server")
+	client := newTestClient(server.target())
+	client.tunnels = []TunnelConfig{
+		{Name: "web", Subdomain: "web", Port: 8000},
+		{Name: "random", Subdomain: "abcdef", Port: 8001},
+		{Subdomain: "old", Port: 8002},
+	}
+	client.paused["old"] = true
+	pool := NewPool(client, server.target())
+	client.pools[client.Config.id] = pool
+	defer pool.Shutdown()
+
+	pool.connector(context.Background())
+	assert.Eventually(t, func() bool { return server.count("server") == 1 }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, "web,abcdef,old", server.lastHeader().Get("X-TUNNEL-SUBDOMAIN"))
+
+	// Tunnels are matched by name, a tunnel without a subdomain keeps its random one
+	assert.NoError(t, client.ReloadTunnels([]TunnelConfig{
+		{Name: "web", Subdomain: "web", Port: 9000},
+		{Name: "random", Port: 8001},
+		{Subdomain: "new", Port: 8003},
+	}))
+	assert.Equal(t, []TunnelConfig{
+		{Name: "web", Subdomain: "web", Port: 9000},
+		{Name: "random", Subdomain: "abcdef", Port: 8001},
+		{Subdomain: "new", Port: 8003},
+	}, client.Tunnels())
+	assert.False(t, client.isPaused("old"))
+
+	// The session registers its new tunnels
+	assert.Eventually(t, func() bool { return server.count("server") == 0 }, time.Second, 10*time.Millisecond)
+	pool.connector(context.Background())
+	assert.Eventually(t, func() bool { return server.count("server") == 1 }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, "web,abcdef,new", server.lastHeader().Get("X-TUNNEL-SUBDOMAIN"))
+	assert.Equal(t, "http://localhost:9000,http://localhost:8001,http://localhost:8003", server.lastHeader().Get("X-LOCAL-SERVER"))
+
+	// An invalid configuration leaves the tunnels as they are
+	assert.EqualError(t, client.ReloadTunnels(nil), "no tunnel to start")
+	assert.EqualError(t, client.ReloadTunnels([]TunnelConfig{{Subdomain: "web", Port: 1}, {Subdomain: "web", Port: 2}}), "duplicate subdomain: 'web'")
+	assert.Len(t, client.Tunnels(), 3)
+}
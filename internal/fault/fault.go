@@ -0,0 +1,281 @@
+// Package fault injects faults into HTTP traffic, to test how apps handle a flaky upstream
+package fault
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Header set on the synthetic error responses
+const Header = "X-Beaver-Fault"
+
+// ErrReset is the error of the responses reset by a rule
+var ErrReset = fmt.Errorf("fault injection: %w", syscall.ECONNRESET)
+
+// Rule describes the faults injected into the requests matching a path
+type Rule struct {
+	// Path of the requests, a trailing * matches any suffix, every path when empty
+	Path string
+	// Delay before the request is sent, plus or minus up to Jitter
+	Latency time.Duration
+	Jitter  time.Duration
+	// Fraction of the requests answered with a synthetic Status response
+	Error  float64
+	Status int
+	// Fraction of the responses cut off before their end
+	Reset float64
+	// Maximum speed of the response bodies (bytes per second), 0 for no limit
+	Bandwidth int64
+}
+
+// ParseRule parses a rule written as comma separated key=value pairs, eg:
+//
+//	latency=200ms,jitter=50ms,error=5%,status=502,reset=1%,bandwidth=64KB,path=/api/*
+func ParseRule(s string) (Rule, error) {
+	options := make(map[string]string)
+	for _, option := range strings.Split(s, ",") {
+		if strings.TrimSpace(option) == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(option, "=")
+		if !ok {
+			return Rule{}, fmt.Errorf("invalid fault %q : expected key=value", option)
+		}
+		options[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+	return newRule(options)
+}
+
+// UnmarshalYAML reads a rule from a mapping using the same values as ParseRule
+func (r *Rule) UnmarshalYAML(node *yaml.Node) error {
+	var options map[string]string
+	if err := node.Decode(&options); err != nil {
+		return err
+	}
+	rule, err := newRule(options)
+	if err != nil {
+		return err
+	}
+	*r = rule
+	return nil
+}
+
+func newRule(options map[string]string) (rule Rule, err error) {
+	rule.Status = http.StatusServiceUnavailable
+
+	for key, value := range options {
+		switch strings.ToLower(key) {
+		case "path":
+			rule.Path = value
+		case "latency":
+			rule.Latency, err = time.ParseDuration(value)
+		case "jitter":
+			rule.Jitter, err = time.ParseDuration(value)
+		case "error":
+			rule.Error, err = parseRate(value)
+		case "status":
+			rule.Status, err = strconv.Atoi(value)
+			if err == nil && (rule.Status < 100 || rule.Status > 599) {
+				err = errors.New("not a status code")
+			}
+		case "reset":
+			rule.Reset, err = parseRate(value)
+		case "bandwidth":
+			rule.Bandwidth, err = parseSize(value)
+		default:
+			return Rule{}, fmt.Errorf("unknown fault %q, expected latency, jitter, error, status, reset, bandwidth or path", key)
+		}
+		if err != nil {
+			return Rule{}, fmt.Errorf("invalid fault %s=%s : %w", key, value, err)
+		}
+	}
+	return rule, nil
+}
+
+// parseRate parses a percentage, eg: 5%, or a fraction, eg: 0.05
+func parseRate(s string) (float64, error) {
+	percent := strings.HasSuffix(s, "%")
+	rate, err := strconv.ParseFloat(strings.TrimSuffix(s, "%"), 64)
+	if err != nil {
+		return 0, err
+	}
+	if percent {
+		rate /= 100
+	}
+	if rate < 0 || rate > 1 {
+		return 0, errors.New("must be between 0% and 100%")
+	}
+	return rate, nil
+}
+
+// parseSize parses a size in bytes, with an optional KB or MB unit
+func parseSize(s string) (int64, error) {
+	units := []struct {
+		suffix string
+		size   int64
+	}{{"kb", 1024}, {"mb", 1024 * 1024}, {"b", 1}}
+
+	s = strings.ToLower(s)
+	multiplier := int64(1)
+	for _, unit := range units {
+		if strings.HasSuffix(s, unit.suffix) {
+			s, multiplier = strings.TrimSuffix(s, unit.suffix), unit.size
+			break
+		}
+	}
+	size, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+	if err != nil || size <= 0 {
+		return 0, errors.New("must be a positive size, eg: 64KB")
+	}
+	return size * multiplier, nil
+}
+
+func (r *Rule) matches(path string) bool {
+	if prefix, ok := strings.CutSuffix(r.Path, "*"); ok {
+		return strings.HasPrefix(path, prefix)
+	}
+	return r.Path == "" || r.Path == path
+}
+
+// delay returns the latency of a request
+func (r *Rule) delay() time.Duration {
+	delay := r.Latency
+	if r.Jitter > 0 {
+		delay += time.Duration(rand.Int63n(int64(2*r.Jitter)+1)) - r.Jitter
+	}
+	if delay < 0 {
+		return 0
+	}
+	return delay
+}
+
+// Transport injects the faults of the first rule matching the path of a request
+type Transport struct {
+	Rules []Rule
+	Next  http.RoundTripper
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var rule *Rule
+	for i := range t.Rules {
+		if t.Rules[i].matches(req.URL.Path) {
+			rule = &t.Rules[i]
+			break
+		}
+	}
+	if rule == nil {
+		return t.Next.RoundTrip(req)
+	}
+
+	if delay := rule.delay(); delay > 0 {
+		select {
+		case <-time.After(delay):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+	}
+
+	if rule.Error > 0 && rand.Float64() < rule.Error {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return errorResponse(req, rule.Status), nil
+	}
+
+	res, err := t.Next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if rule.Reset > 0 && rand.Float64() < rule.Reset {
+		// Cut the body off somewhere before its end
+		limit := int64(0)
+		if res.ContentLength > 0 {
+			limit = rand.Int63n(res.ContentLength)
+		}
+		res.Body = &resetBody{body: res.Body, remaining: limit}
+	}
+	if rule.Bandwidth > 0 {
+		res.Body = &throttledBody{body: res.Body, bandwidth: rule.Bandwidth, start: time.Now()}
+	}
+	return res, nil
+}
+
+func errorResponse(req *http.Request, status int) *http.Response {
+	body := fmt.Sprintf("fault injection: %d %s\n", status, http.StatusText(status))
+	return &http.Response{
+		Status:     fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode: status,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header: http.Header{
+			"Content-Type":   {"text/plain; charset=utf-8"},
+			"Content-Length": {strconv.Itoa(len(body))},
+			Header:           {"error"},
+		},
+		Body:          io.NopCloser(strings.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+}
+
+// resetBody fails with ErrReset once remaining bytes are read
+type resetBody struct {
+	body      io.ReadCloser
+	remaining int64
+}
+
+func (b *resetBody) Read(p []byte) (int, error) {
+	if b.remaining <= 0 {
+		return 0, ErrReset
+	}
+	if int64(len(p)) > b.remaining {
+		p = p[:b.remaining]
+	}
+	n, err := b.body.Read(p)
+	b.remaining -= int64(n)
+	return n, err
+}
+
+func (b *resetBody) Close() error {
+	return b.body.Close()
+}
+
+// throttledBody reads at most bandwidth bytes per second
+type throttledBody struct {
+	body      io.ReadCloser
+	bandwidth int64
+	start     time.Time
+	read      int64
+}
+
+func (b *throttledBody) Read(p []byte) (int, error) {
+	// Small reads keep the speed steady
+	if chunk := b.bandwidth / 10; chunk > 0 && int64(len(p)) > chunk {
+		p = p[:chunk]
+	}
+
+	// Wait until the bytes already read fit the bandwidth
+	expected := time.Duration(float64(b.read) / float64(b.bandwidth) * float64(time.Second))
+	if wait := expected - time.Since(b.start); wait > 0 {
+		time.Sleep(wait)
+	}
+
+	n, err := b.body.Read(p)
+	b.read += int64(n)
+	return n, err
+}
+
+func (b *throttledBody) Close() error {
+	return b.body.Close()
+}
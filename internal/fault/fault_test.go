@@ -0,0 +1,107 @@
+package fault
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+)
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func upstream(body string) http.RoundTripper {
+	return roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		return &http.Response{
+			StatusCode:    200,
+			Header:        http.Header{},
+			Body:          io.NopCloser(strings.NewReader(body)),
+			ContentLength: int64(len(body)),
+		}, nil
+	})
+}
+
+func TestParseRule(t *testing.T) {
+	rule, err := ParseRule("latency=200ms, jitter=50ms,error=5%,reset=0.01,bandwidth=64KB,path=/api/*")
+	assert.NoError(t, err)
+	assert.Equal(t, Rule{
+		Path:      "/api/*",
+		Latency:   200 * time.Millisecond,
+		Jitter:    50 * time.Millisecond,
+		Error:     0.05,
+		Status:    503,
+		Reset:     0.01,
+		Bandwidth: 64 * 1024,
+	}, rule)
+
+	for _, invalid := range []string{"latency=fast", "error=150%", "status=99", "bandwidth=0", "speed=1", "latency"} {
+		_, err := ParseRule(invalid)
+		assert.Error(t, err, invalid)
+	}
+
+	var rules []Rule
+	assert.NoError(t, yaml.Unmarshal([]byte("- path: /hooks\n  error: 50%\n  status: 502\n"), &rules))
+	assert.Equal(t, []Rule{{Path: "/hooks", Error: 0.5, Status: 502}}, rules)
+}
+
+func TestTransport(t *testing.T) {
+	transport := &Transport{
+		Rules: []Rule{
+			{Path: "/down", Error: 1, Status: 502},
+			{Path: "/reset/*", Reset: 1},
+			{Path: "/slow", Latency: 50 * time.Millisecond},
+		},
+		Next: upstream("0123456789"),
+	}
+
+	res, err := transport.RoundTrip(httptest.NewRequest("GET", "/down", nil))
+	assert.NoError(t, err)
+	assert.Equal(t, 502, res.StatusCode)
+	assert.Equal(t, "error", res.Header.Get(Header))
+
+	res, err = transport.RoundTrip(httptest.NewRequest("GET", "/reset/a", nil))
+	assert.NoError(t, err)
+	body, err := io.ReadAll(res.Body)
+	assert.ErrorIs(t, err, ErrReset)
+	assert.Less(t, len(body), 10)
+
+	start := time.Now()
+	res, err = transport.RoundTrip(httptest.NewRequest("GET", "/slow", nil))
+	assert.NoError(t, err)
+	assert.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+
+	// Requests without a matching rule are left alone
+	res, err = transport.RoundTrip(httptest.NewRequest("GET", "/other", nil))
+	assert.NoError(t, err)
+	body, _ = io.ReadAll(res.Body)
+	assert.Equal(t, "0123456789", string(body))
+}
+
+func TestBandwidth(t *testing.T) {
+	transport := &Transport{Rules: []Rule{{Bandwidth: 1000}}, Next: upstream(strings.Repeat("x", 300))}
+
+	start := time.Now()
+	res, err := transport.RoundTrip(httptest.NewRequest("GET", "/", nil))
+	assert.NoError(t, err)
+	body, _ := io.ReadAll(res.Body)
+	assert.Len(t, body, 300)
+	// 300 bytes at 1000 bytes per second
+	assert.GreaterOrEqual(t, time.Since(start), 200*time.Millisecond)
+}
+
+func TestJitter(t *testing.T) {
+	rule := Rule{Latency: 10 * time.Millisecond, Jitter: 20 * time.Millisecond}
+	for i := 0; i < 100; i++ {
+		delay := rule.delay()
+		assert.GreaterOrEqual(t, delay, time.Duration(0))
+		assert.LessOrEqual(t, delay, 30*time.Millisecond)
+	}
+}
@@ -0,0 +1,111 @@
+// Package fileserver serves the files of a directory, for tunnels without a local server
+package fileserver
+
+import (
+	"errors"
+	"io/fs"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// Options of a file server
+type Options struct {
+	// List the content of the directories without an index.html
+	Listing bool
+	// Serve /index.html instead of a 404 for the paths without an extension, for single page apps
+	SPA bool
+}
+
+// New returns a handler serving the files of dir. It supports range and conditional requests,
+// and guesses the content types from the extensions, then from the content of the files.
+// Hidden files, but .well-known, are not served.
+func New(dir string, options Options) http.Handler {
+	fsys := fileSystem{root: http.Dir(dir), listing: options.Listing}
+	files := http.FileServer(fsys)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+
+		if options.SPA && path.Ext(r.URL.Path) == "" && !fsys.exists(r.URL.Path) {
+			r = r.Clone(r.Context())
+			r.URL.Path = "/"
+			r.URL.RawPath = ""
+		}
+		files.ServeHTTP(w, r)
+	})
+}
+
+// fileSystem hides the dot files, and the directories without an index.html unless listing is enabled
+type fileSystem struct {
+	root    http.FileSystem
+	listing bool
+}
+
+func (f fileSystem) Open(name string) (http.File, error) {
+	if hidden(name) {
+		return nil, fs.ErrNotExist
+	}
+
+	file, err := f.root.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	if f.listing {
+		return listedFile{file}, nil
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	if info.IsDir() {
+		index, err := f.root.Open(path.Join(name, "index.html"))
+		if err != nil {
+			file.Close()
+			return nil, fs.ErrNotExist
+		}
+		index.Close()
+	}
+	return file, nil
+}
+
+// exists reports whether a request path is served, either as a file or a directory
+func (f fileSystem) exists(name string) bool {
+	file, err := f.Open(path.Clean("/" + name))
+	if err != nil {
+		return !errors.Is(err, fs.ErrNotExist)
+	}
+	file.Close()
+	return true
+}
+
+// listedFile leaves the hidden files out of the directory listings
+type listedFile struct {
+	http.File
+}
+
+func (f listedFile) Readdir(count int) ([]fs.FileInfo, error) {
+	infos, err := f.File.Readdir(count)
+	visible := infos[:0]
+	for _, info := range infos {
+		if !hidden(info.Name()) {
+			visible = append(visible, info)
+		}
+	}
+	return visible, err
+}
+
+func hidden(name string) bool {
+	for _, segment := range strings.Split(name, "/") {
+		if strings.HasPrefix(segment, ".") && segment != ".well-known" {
+			return true
+		}
+	}
+	return false
+}
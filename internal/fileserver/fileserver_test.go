@@ -0,0 +1,106 @@
+package fileserver
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestDir(t *testing.T) string {
+	dir := t.TempDir()
+	files := map[string]string{
+		"index.html":        "<h1>app</h1>",
+		"app.js":            "console.log(1)",
+		"data.json":         `{"a": 1}`,
+		"report/page.txt":   "0123456789",
+		".env":              "SECRET=1",
+		".well-known/x.txt": "ok",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(path), 0o700)
+		assert.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	}
+	return dir
+}
+
+func get(handler http.Handler, method, path string, header http.Header) (*http.Response, string) {
+	req := httptest.NewRequest(method, path, nil)
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+	res := recorder.Result()
+	body, _ := io.ReadAll(res.Body)
+	return res, string(body)
+}
+
+func TestServeFiles(t *testing.T) {
+	handler := New(newTestDir(t), Options{})
+
+	res, body := get(handler, "GET", "/", nil)
+	assert.Equal(t, 200, res.StatusCode)
+	assert.Equal(t, "<h1>app</h1>", body)
+
+	res, _ = get(handler, "GET", "/app.js", nil)
+	assert.Contains(t, res.Header.Get("Content-Type"), "javascript")
+	res, _ = get(handler, "GET", "/data.json", nil)
+	assert.Equal(t, "application/json", res.Header.Get("Content-Type"))
+
+	res, body = get(handler, "GET", "/report/page.txt", http.Header{"Range": {"bytes=2-4"}})
+	assert.Equal(t, http.StatusPartialContent, res.StatusCode)
+	assert.Equal(t, "234", body)
+	assert.Equal(t, "bytes 2-4/10", res.Header.Get("Content-Range"))
+
+	// No listing by default
+	res, _ = get(handler, "GET", "/report/", nil)
+	assert.Equal(t, 404, res.StatusCode)
+
+	res, _ = get(handler, "GET", "/.env", nil)
+	assert.Equal(t, 404, res.StatusCode)
+	res, _ = get(handler, "GET", "/.well-known/x.txt", nil)
+	assert.Equal(t, 200, res.StatusCode)
+
+	res, _ = get(handler, "POST", "/", nil)
+	assert.Equal(t, http.StatusMethodNotAllowed, res.StatusCode)
+}
+
+func TestListing(t *testing.T) {
+	handler := New(newTestDir(t), Options{Listing: true})
+
+	res, body := get(handler, "GET", "/report/", nil)
+	assert.Equal(t, 200, res.StatusCode)
+	assert.Contains(t, body, "page.txt")
+
+	_, body = get(handler, "GET", "/.well-known/", nil)
+	assert.Contains(t, body, "x.txt")
+
+	// The root has an index.html, dot files are left out of listings anyway
+	dir := newTestDir(t)
+	os.Remove(filepath.Join(dir, "index.html"))
+	_, body = get(New(dir, Options{Listing: true}), "GET", "/", nil)
+	assert.Contains(t, body, "app.js")
+	assert.NotContains(t, body, ".env")
+}
+
+func TestSPA(t *testing.T) {
+	handler := New(newTestDir(t), Options{SPA: true})
+
+	res, body := get(handler, "GET", "/users/42", nil)
+	assert.Equal(t, 200, res.StatusCode)
+	assert.Equal(t, "<h1>app</h1>", body)
+
+	// Missing assets are still reported
+	res, _ = get(handler, "GET", "/missing.js", nil)
+	assert.Equal(t, 404, res.StatusCode)
+
+	res, body = get(handler, "GET", "/report/page.txt", nil)
+	assert.Equal(t, 200, res.StatusCode)
+	assert.Equal(t, "0123456789", body)
+}
@@ -0,0 +1,350 @@
+// Package har converts captured tunnel traffic to and from HAR 1.2 files,
+// see http://www.softwareishard.com/blog/har-12-spec/
+package har
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/amalshaji/beaver/internal/traffic"
+)
+
+const Version = "1.2"
+
+// HAR is the root of a HAR file
+type HAR struct {
+	Log Log `json:"log"`
+}
+
+type Log struct {
+	Version string  `json:"version"`
+	Creator Creator `json:"creator"`
+	Entries []Entry `json:"entries"`
+}
+
+type Creator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+// Entry is a request and its response.
+// Fields starting with an underscore are beaver extensions, other tools ignore them.
+type Entry struct {
+	StartedDateTime time.Time `json:"startedDateTime"`
+	// Total time of the request (milliseconds)
+	Time     float64  `json:"time"`
+	Request  Request  `json:"request"`
+	Response Response `json:"response"`
+	Cache    struct{} `json:"cache"`
+	Timings  Timings  `json:"timings"`
+	Comment  string   `json:"comment,omitempty"`
+
+	Subdomain string `json:"_subdomain,omitempty"`
+	Port      int    `json:"_port,omitempty"`
+	Error     string `json:"_error,omitempty"`
+}
+
+type Request struct {
+	Method      string      `json:"method"`
+	URL         string      `json:"url"`
+	HTTPVersion string      `json:"httpVersion"`
+	Cookies     []Cookie    `json:"cookies"`
+	Headers     []NameValue `json:"headers"`
+	QueryString []NameValue `json:"queryString"`
+	PostData    *PostData   `json:"postData,omitempty"`
+	HeadersSize int         `json:"headersSize"`
+	BodySize    int64       `json:"bodySize"`
+}
+
+type Response struct {
+	Status      int         `json:"status"`
+	StatusText  string      `json:"statusText"`
+	HTTPVersion string      `json:"httpVersion"`
+	Cookies     []Cookie    `json:"cookies"`
+	Headers     []NameValue `json:"headers"`
+	Content     Content     `json:"content"`
+	RedirectURL string      `json:"redirectURL"`
+	HeadersSize int         `json:"headersSize"`
+	BodySize    int64       `json:"bodySize"`
+}
+
+type Cookie struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type NameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type PostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+
+	// Binary bodies are base64 encoded, and bodies may be truncated by the capture
+	Encoding  string `json:"_encoding,omitempty"`
+	Truncated bool   `json:"_truncated,omitempty"`
+}
+
+type Content struct {
+	Size     int64  `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text,omitempty"`
+	Encoding string `json:"encoding,omitempty"`
+
+	Truncated bool `json:"_truncated,omitempty"`
+}
+
+// Timings of the request (milliseconds), -1 when unknown
+type Timings struct {
+	Blocked float64 `json:"blocked"`
+	DNS     float64 `json:"dns"`
+	Connect float64 `json:"connect"`
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+	SSL     float64 `json:"ssl"`
+}
+
+// New returns a HAR of the exchanges, in chronological order
+func New(creator Creator, exchanges []*traffic.Exchange) *HAR {
+	h := &HAR{Log: Log{Version: Version, Creator: creator, Entries: []Entry{}}}
+	for _, exchange := range exchanges {
+		h.Log.Entries = append(h.Log.Entries, NewEntry(exchange))
+	}
+	sort.SliceStable(h.Log.Entries, func(i, j int) bool {
+		return h.Log.Entries[i].StartedDateTime.Before(h.Log.Entries[j].StartedDateTime)
+	})
+	return h
+}
+
+// NewEntry converts a captured exchange to a HAR entry
+func NewEntry(exchange *traffic.Exchange) Entry {
+	req := exchange.Request
+	ms := float64(exchange.Duration) / float64(time.Millisecond)
+
+	entry := Entry{
+		StartedDateTime: exchange.Time,
+		Time:            ms,
+		Request: Request{
+			Method:      req.Method,
+			URL:         fmt.Sprintf("%s://%s%s", scheme(req.Header), req.Host, req.URL),
+			HTTPVersion: "HTTP/1.1",
+			Cookies:     cookies((&http.Request{Header: req.Header}).Cookies()),
+			Headers:     headers(req.Header),
+			QueryString: queryString(req.URL),
+			HeadersSize: -1,
+			BodySize:    req.BodySize,
+		},
+		Response: Response{
+			HTTPVersion: "HTTP/1.1",
+			Cookies:     []Cookie{},
+			Headers:     []NameValue{},
+			HeadersSize: -1,
+		},
+		Timings:   Timings{Blocked: -1, DNS: -1, Connect: -1, SSL: -1, Wait: ms},
+		Subdomain: exchange.Subdomain,
+		Port:      exchange.Port,
+		Error:     exchange.Error,
+	}
+	if exchange.ReplayOf != 0 {
+		entry.Comment = fmt.Sprintf("replay of request #%d", exchange.ReplayOf)
+	}
+
+	if req.BodySize > 0 {
+		text, encoding := encode(req.Body)
+		entry.Request.PostData = &PostData{
+			MimeType:  req.Header.Get("Content-Type"),
+			Text:      text,
+			Encoding:  encoding,
+			Truncated: req.Truncated,
+		}
+	}
+
+	if res := exchange.Response; res != nil {
+		text, encoding := encode(res.Body)
+		entry.Response.Status = res.StatusCode
+		entry.Response.StatusText = http.StatusText(res.StatusCode)
+		entry.Response.Cookies = cookies((&http.Response{Header: res.Header}).Cookies())
+		entry.Response.Headers = headers(res.Header)
+		entry.Response.RedirectURL = res.Header.Get("Location")
+		entry.Response.BodySize = res.BodySize
+		entry.Response.Content = Content{
+			Size:      res.BodySize,
+			MimeType:  res.Header.Get("Content-Type"),
+			Text:      text,
+			Encoding:  encoding,
+			Truncated: res.Truncated,
+		}
+	}
+	return entry
+}
+
+// Exchange converts a HAR entry back to an exchange, so that it can be replayed
+func (entry *Entry) Exchange() (*traffic.Exchange, error) {
+	u, err := url.Parse(entry.Request.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid url %s : %w", entry.Request.URL, err)
+	}
+
+	req := &traffic.Request{
+		Method: entry.Request.Method,
+		URL:    u.RequestURI(),
+		Host:   u.Host,
+		Header: header(entry.Request.Headers),
+	}
+	if postData := entry.Request.PostData; postData != nil {
+		if req.Body, err = decode(postData.Text, postData.Encoding); err != nil {
+			return nil, err
+		}
+		req.Truncated = postData.Truncated
+	}
+	req.BodySize = entry.Request.BodySize
+	if req.BodySize < int64(len(req.Body)) {
+		req.BodySize = int64(len(req.Body))
+	}
+
+	exchange := &traffic.Exchange{
+		Time:      entry.StartedDateTime,
+		Duration:  time.Duration(entry.Time * float64(time.Millisecond)),
+		Subdomain: entry.Subdomain,
+		Port:      entry.Port,
+		Request:   req,
+		Error:     entry.Error,
+	}
+
+	// Entries of failed requests have no response
+	if entry.Response.Status != 0 {
+		res := &traffic.Response{
+			StatusCode: entry.Response.Status,
+			Header:     header(entry.Response.Headers),
+			BodySize:   entry.Response.Content.Size,
+			Truncated:  entry.Response.Content.Truncated,
+		}
+		if res.Body, err = decode(entry.Response.Content.Text, entry.Response.Content.Encoding); err != nil {
+			return nil, err
+		}
+		exchange.Response = res
+	}
+	return exchange, nil
+}
+
+// Exchanges converts the entries of the HAR
+func (h *HAR) Exchanges() ([]*traffic.Exchange, error) {
+	exchanges := make([]*traffic.Exchange, 0, len(h.Log.Entries))
+	for i := range h.Log.Entries {
+		exchange, err := h.Log.Entries[i].Exchange()
+		if err != nil {
+			return nil, fmt.Errorf("entry %d : %w", i, err)
+		}
+		exchanges = append(exchanges, exchange)
+	}
+	return exchanges, nil
+}
+
+// Write encodes the HAR to w
+func (h *HAR) Write(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(h)
+}
+
+// Read decodes a HAR from r
+func Read(r io.Reader) (*HAR, error) {
+	h := new(HAR)
+	if err := json.NewDecoder(r).Decode(h); err != nil {
+		return nil, fmt.Errorf("invalid HAR file : %w", err)
+	}
+	if h.Log.Version == "" {
+		return nil, fmt.Errorf("invalid HAR file : missing log version")
+	}
+	return h, nil
+}
+
+// scheme returns the scheme of the public URL of a request, tunnels are reached over http unless a proxy says otherwise
+func scheme(header http.Header) string {
+	if proto := header.Get("X-Forwarded-Proto"); proto != "" {
+		return proto
+	}
+	return "http"
+}
+
+func encode(body []byte) (text string, encoding string) {
+	if utf8.Valid(body) {
+		return string(body), ""
+	}
+	return base64.StdEncoding.EncodeToString(body), "base64"
+}
+
+func decode(text string, encoding string) ([]byte, error) {
+	if encoding == "base64" {
+		return base64.StdEncoding.DecodeString(text)
+	}
+	return []byte(text), nil
+}
+
+// headers lists the headers sorted by name, as http.Header is a map
+func headers(header http.Header) []NameValue {
+	names := make([]string, 0, len(header))
+	for name := range header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	list := []NameValue{}
+	for _, name := range names {
+		for _, value := range header[name] {
+			list = append(list, NameValue{Name: name, Value: value})
+		}
+	}
+	return list
+}
+
+func header(list []NameValue) http.Header {
+	header := make(http.Header)
+	for _, nv := range list {
+		// Skip the HTTP/2 pseudo headers recorded by browsers, eg: :authority
+		if strings.HasPrefix(nv.Name, ":") {
+			continue
+		}
+		header.Add(nv.Name, nv.Value)
+	}
+	return header
+}
+
+func queryString(requestURI string) []NameValue {
+	list := []NameValue{}
+	u, err := url.ParseRequestURI(requestURI)
+	if err != nil {
+		return list
+	}
+	query := u.Query()
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, value := range query[name] {
+			list = append(list, NameValue{Name: name, Value: value})
+		}
+	}
+	return list
+}
+
+func cookies(list []*http.Cookie) []Cookie {
+	cookies := []Cookie{}
+	for _, cookie := range list {
+		cookies = append(cookies, Cookie{Name: cookie.Name, Value: cookie.Value})
+	}
+	return cookies
+}
@@ -0,0 +1,100 @@
+package har
+
+import (
+	"bytes"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/amalshaji/beaver/internal/traffic"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRoundTrip(t *testing.T) {
+	start := time.Date(2023, 5, 1, 10, 0, 0, 0, time.UTC)
+	exchanges := []*traffic.Exchange{
+		{
+			ID:        2,
+			Time:      start.Add(time.Second),
+			Duration:  5 * time.Millisecond,
+			Subdomain: "api",
+			Port:      9000,
+			Request:   &traffic.Request{Method: "GET", URL: "/missing", Host: "api.example.com", Header: http.Header{}},
+			Error:     "connection refused",
+		},
+		{
+			ID:        1,
+			Time:      start,
+			Duration:  20 * time.Millisecond,
+			Subdomain: "api",
+			Port:      9000,
+			Request: &traffic.Request{
+				Method:   "POST",
+				URL:      "/hook?event=push&event=ping",
+				Host:     "api.example.com",
+				Header:   http.Header{"Content-Type": {"application/json"}, "Cookie": {"session=abc"}},
+				Body:     []byte(`{"a":1}`),
+				BodySize: 7,
+			},
+			Response: &traffic.Response{
+				StatusCode: 201,
+				Header:     http.Header{"Content-Type": {"application/octet-stream"}, "Set-Cookie": {"id=1; Path=/"}},
+				Body:       []byte{0xff, 0xfe},
+				BodySize:   10,
+				Truncated:  true,
+			},
+		},
+	}
+
+	var buf bytes.Buffer
+	assert.NoError(t, New(Creator{Name: "beaver", Version: "test"}, exchanges).Write(&buf))
+
+	h, err := Read(&buf)
+	assert.NoError(t, err)
+	assert.Equal(t, Version, h.Log.Version)
+
+	// Entries are in chronological order
+	entry := h.Log.Entries[0]
+	assert.Equal(t, "http://api.example.com/hook?event=push&event=ping", entry.Request.URL)
+	assert.Equal(t, []NameValue{{Name: "event", Value: "push"}, {Name: "event", Value: "ping"}}, entry.Request.QueryString)
+	assert.Equal(t, []Cookie{{Name: "session", Value: "abc"}}, entry.Request.Cookies)
+	assert.Equal(t, []Cookie{{Name: "id", Value: "1"}}, entry.Response.Cookies)
+	assert.Equal(t, "base64", entry.Response.Content.Encoding)
+	assert.Equal(t, float64(20), entry.Time)
+
+	imported, err := h.Exchanges()
+	assert.NoError(t, err)
+	assert.Len(t, imported, 2)
+	assert.Equal(t, exchanges[1].Request, imported[0].Request)
+	assert.Equal(t, exchanges[1].Response, imported[0].Response)
+	assert.Equal(t, exchanges[1].Duration, imported[0].Duration)
+	assert.Equal(t, "api", imported[0].Subdomain)
+	assert.Nil(t, imported[1].Response)
+	assert.Equal(t, "connection refused", imported[1].Error)
+}
+
+func TestImportBrowserHar(t *testing.T) {
+	// Browsers record HTTP/2 pseudo headers and no beaver extension
+	h, err := Read(bytes.NewBufferString(`{"log": {"version": "1.2", "creator": {"name": "WebInspector", "version": "537.36"}, "entries": [
+		{"startedDateTime": "2023-05-01T10:00:00.000Z", "time": 12.5,
+		 "request": {"method": "PUT", "url": "https://app.example.com/items/1", "httpVersion": "h2",
+		  "headers": [{"name": ":authority", "value": "app.example.com"}, {"name": "content-type", "value": "text/plain"}],
+		  "postData": {"mimeType": "text/plain", "text": "hello"}, "bodySize": 5},
+		 "response": {"status": 204, "statusText": "No Content", "headers": [], "content": {"size": 0, "mimeType": ""}}}
+	]}}`))
+	assert.NoError(t, err)
+
+	exchanges, err := h.Exchanges()
+	assert.NoError(t, err)
+	req := exchanges[0].Request
+	assert.Equal(t, "/items/1", req.URL)
+	assert.Equal(t, "app.example.com", req.Host)
+	assert.Equal(t, http.Header{"Content-Type": {"text/plain"}}, req.Header)
+	assert.Equal(t, "hello", string(req.Body))
+	assert.Equal(t, 204, exchanges[0].Response.StatusCode)
+}
+
+func TestReadInvalid(t *testing.T) {
+	_, err := Read(bytes.NewBufferString(`{"entries": []}`))
+	assert.Error(t, err)
+}
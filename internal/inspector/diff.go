@@ -0,0 +1,237 @@
+package inspector
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/amalshaji/beaver/internal/traffic"
+)
+
+// Bodies are compared line by line up to this number of lines, larger ones are shown as replaced
+const maxDiffLines = 2000
+
+// Line operations of a body diff
+const (
+	LineEqual   = " "
+	LineRemoved = "-"
+	LineAdded   = "+"
+)
+
+// Change is a value which differs between two exchanges
+type Change struct {
+	Name string `json:"name"`
+	A    string `json:"a"`
+	B    string `json:"b"`
+}
+
+// Line is a line of a body diff
+type Line struct {
+	Op   string `json:"op"`
+	Text string `json:"text"`
+}
+
+// Diff compares two exchanges
+type Diff struct {
+	A int64 `json:"a"`
+	B int64 `json:"b"`
+	// Request line, status and error
+	Fields          []Change `json:"fields"`
+	RequestHeaders  []Change `json:"requestHeaders"`
+	ResponseHeaders []Change `json:"responseHeaders"`
+	RequestBody     []Line   `json:"requestBody"`
+	ResponseBody    []Line   `json:"responseBody"`
+}
+
+// Ignore lists what a comparison leaves out, eg: the values changing on every request
+type Ignore struct {
+	Headers []string
+	// Values of the JSON bodies
+	Paths []JSONPath
+}
+
+// Equal reports whether the exchanges are the same
+func (d *Diff) Equal() bool {
+	return len(d.Fields) == 0 && len(d.RequestHeaders) == 0 && len(d.ResponseHeaders) == 0 &&
+		len(d.RequestBody) == 0 && len(d.ResponseBody) == 0
+}
+
+// Compare returns the differences between two exchanges
+func Compare(a, b *traffic.Exchange) *Diff {
+	return CompareIgnoring(a, b, Ignore{})
+}
+
+// CompareIgnoring returns the differences between two exchanges, leaving out the ignored headers and JSON values
+func CompareIgnoring(a, b *traffic.Exchange, ignore Ignore) *Diff {
+	diff := &Diff{A: a.ID, B: b.ID}
+
+	ra, rb := a.Response, b.Response
+	if ra == nil {
+		ra = &traffic.Response{}
+	}
+	if rb == nil {
+		rb = &traffic.Response{}
+	}
+
+	fields := []Change{
+		{Name: "method", A: a.Request.Method, B: b.Request.Method},
+		{Name: "url", A: a.Request.URL, B: b.Request.URL},
+		{Name: "host", A: a.Request.Host, B: b.Request.Host},
+		{Name: "status", A: formatStatus(ra.StatusCode), B: formatStatus(rb.StatusCode)},
+		{Name: "error", A: a.Error, B: b.Error},
+	}
+	for _, field := range fields {
+		if field.A != field.B {
+			diff.Fields = append(diff.Fields, field)
+		}
+	}
+
+	diff.RequestBody = compareBodies(a.Request.Body, b.Request.Body, ignore.Paths)
+	diff.ResponseBody = compareBodies(ra.Body, rb.Body, ignore.Paths)
+	diff.RequestHeaders = compareHeaders(a.Request.Header, b.Request.Header, ignoredHeaders(ignore, diff.RequestBody))
+	diff.ResponseHeaders = compareHeaders(ra.Header, rb.Header, ignoredHeaders(ignore, diff.ResponseBody))
+	return diff
+}
+
+// ignoredHeaders returns the headers to leave out, the length of bodies which only differ by ignored values included
+func ignoredHeaders(ignore Ignore, body []Line) []string {
+	if len(body) == 0 && len(ignore.Paths) > 0 {
+		return append([]string{"Content-Length"}, ignore.Headers...)
+	}
+	return ignore.Headers
+}
+
+func formatStatus(status int) string {
+	if status == 0 {
+		return ""
+	}
+	return fmt.Sprint(status)
+}
+
+// compareHeaders returns the headers which differ, sorted by name
+func compareHeaders(a, b http.Header, ignored []string) []Change {
+	names := make(map[string]struct{})
+	for name := range a {
+		names[name] = struct{}{}
+	}
+	for name := range b {
+		names[name] = struct{}{}
+	}
+	for _, name := range ignored {
+		delete(names, http.CanonicalHeaderKey(name))
+	}
+
+	var changes []Change
+	for name := range names {
+		va, vb := strings.Join(a.Values(name), ", "), strings.Join(b.Values(name), ", ")
+		if va != vb {
+			changes = append(changes, Change{Name: name, A: va, B: vb})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Name < changes[j].Name })
+	return changes
+}
+
+// compareBodies returns a line diff of two bodies, JSON bodies are indented first
+func compareBodies(a, b []byte, ignored []JSONPath) []Line {
+	if bytes.Equal(a, b) {
+		return nil
+	}
+	if !utf8.Valid(a) || !utf8.Valid(b) {
+		return []Line{{Op: LineRemoved, Text: fmt.Sprintf("binary body (%d bytes)", len(a))}, {Op: LineAdded, Text: fmt.Sprintf("binary body (%d bytes)", len(b))}}
+	}
+
+	a, b = normalizeJSON(a, ignored), normalizeJSON(b, ignored)
+	if bytes.Equal(a, b) {
+		return nil
+	}
+	return diffLines(splitLines(a), splitLines(b))
+}
+
+// normalizeJSON indents a JSON body with its members sorted and without the ignored values,
+// other bodies are returned as is
+func normalizeJSON(body []byte, ignored []JSONPath) []byte {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	var document any
+	if !json.Valid(body) || decoder.Decode(&document) != nil {
+		return body
+	}
+	for _, path := range ignored {
+		path.Remove(document)
+	}
+
+	var out bytes.Buffer
+	encoder := json.NewEncoder(&out)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	if encoder.Encode(document) != nil {
+		return body
+	}
+	return out.Bytes()
+}
+
+func splitLines(body []byte) []string {
+	if len(body) == 0 {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(string(body), "\n"), "\n")
+}
+
+// diffLines computes the longest common subsequence of two texts
+func diffLines(a, b []string) []Line {
+	var lines []Line
+	if len(a) > maxDiffLines || len(b) > maxDiffLines {
+		for _, text := range a {
+			lines = append(lines, Line{Op: LineRemoved, Text: text})
+		}
+		for _, text := range b {
+			lines = append(lines, Line{Op: LineAdded, Text: text})
+		}
+		return lines
+	}
+
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			lines = append(lines, Line{Op: LineEqual, Text: a[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			lines = append(lines, Line{Op: LineRemoved, Text: a[i]})
+			i++
+		default:
+			lines = append(lines, Line{Op: LineAdded, Text: b[j]})
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		lines = append(lines, Line{Op: LineRemoved, Text: a[i]})
+	}
+	for ; j < len(b); j++ {
+		lines = append(lines, Line{Op: LineAdded, Text: b[j]})
+	}
+	return lines
+}
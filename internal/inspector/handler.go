@@ -0,0 +1,214 @@
+package inspector
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/amalshaji/beaver/internal/traffic"
+)
+
+//go:embed ui.html
+var ui []byte
+
+// Replayer sends a request to the local server of the tunnel of an exchange, and records the new exchange
+type Replayer func(ctx context.Context, original *traffic.Exchange, req *traffic.Request) (*traffic.Exchange, error)
+
+// Edit changes a request before replaying it, the fields left empty are not changed
+type Edit struct {
+	Method string      `json:"method"`
+	URL    string      `json:"url"`
+	Header http.Header `json:"header"`
+	Body   *string     `json:"body"`
+}
+
+// apply returns a copy of req with the changes
+func (e Edit) apply(req *traffic.Request) *traffic.Request {
+	edited := *req
+	edited.Header = req.Header.Clone()
+
+	if e.Method != "" {
+		edited.Method = strings.ToUpper(e.Method)
+	}
+	if e.URL != "" {
+		edited.URL = e.URL
+		if !strings.HasPrefix(edited.URL, "/") {
+			edited.URL = "/" + edited.URL
+		}
+	}
+	if e.Header != nil {
+		edited.Header = e.Header.Clone()
+	}
+	if e.Body != nil {
+		edited.Body = []byte(*e.Body)
+		edited.BodySize = int64(len(edited.Body))
+		edited.Truncated = false
+		if edited.Header.Get("Content-Length") != "" {
+			edited.Header.Set("Content-Length", strconv.Itoa(len(edited.Body)))
+		}
+	}
+	return &edited
+}
+
+// Handler serves the web UI of the inspector and its JSON API :
+//
+//	GET    /                          web UI
+//	GET    /api/requests              list the captured requests, the latest first (?subdomain= to filter)
+//	DELETE /api/requests              clear the captured requests
+//	GET    /api/requests/:id          request and response with their headers and bodies
+//	POST   /api/requests/:id/replay   send the request again, optionally edited, eg: {"method": "POST", "url": "/hook", "header": {...}, "body": "..."}
+//	GET    /api/diff?a=:id&b=:id      differences between two requests
+type Handler struct {
+	store  *Store
+	replay Replayer
+	// Address the handler is served on, eg: localhost:4040
+	addr string
+}
+
+// NewHandler returns a Handler for the exchanges of store served on addr, replay may be nil to disable replays
+func NewHandler(store *Store, replay Replayer, addr string) *Handler {
+	return &Handler{store: store, replay: replay, addr: addr}
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// Other sites must not reach the API, through a domain resolving to localhost (DNS rebinding)
+	// or with a form posting to it, which cannot send JSON
+	if !h.allowedHost(r.Host) {
+		writeJSON(w, http.StatusForbidden, map[string]string{"error": "invalid host"})
+		return
+	}
+	if r.Method == http.MethodPost {
+		if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "application/json" {
+			writeJSON(w, http.StatusUnsupportedMediaType, map[string]string{"error": "content type must be application/json"})
+			return
+		}
+	}
+
+	path := strings.Trim(r.URL.Path, "/")
+	parts := strings.Split(path, "/")
+
+	switch {
+	case path == "" && r.Method == http.MethodGet:
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(ui)
+
+	case path == "api/requests" && r.Method == http.MethodGet:
+		subdomain := r.URL.Query().Get("subdomain")
+		summaries := []traffic.Summary{}
+		for _, exchange := range h.store.List() {
+			if subdomain == "" || exchange.Subdomain == subdomain {
+				summaries = append(summaries, exchange.Summary())
+			}
+		}
+		writeJSON(w, http.StatusOK, summaries)
+
+	case path == "api/requests" && r.Method == http.MethodDelete:
+		h.store.Clear()
+		writeJSON(w, http.StatusOK, map[string]string{"message": "ok"})
+
+	case path == "api/diff" && r.Method == http.MethodGet:
+		a, err := h.exchange(r.URL.Query().Get("a"))
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		b, err := h.exchange(r.URL.Query().Get("b"))
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, Compare(a, b))
+
+	case len(parts) == 3 && parts[0] == "api" && parts[1] == "requests" && r.Method == http.MethodGet:
+		exchange, err := h.exchange(parts[2])
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, exchange)
+
+	case len(parts) == 4 && parts[0] == "api" && parts[1] == "requests" && parts[3] == "replay" && r.Method == http.MethodPost:
+		h.serveReplay(w, r, parts[2])
+
+	case strings.HasPrefix(path, "api/"):
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "not found"})
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (h *Handler) serveReplay(w http.ResponseWriter, r *http.Request, id string) {
+	if h.replay == nil {
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "replay is disabled"})
+		return
+	}
+
+	original, err := h.exchange(id)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	var edit Edit
+	if err := json.NewDecoder(r.Body).Decode(&edit); err != nil && !errors.Is(err, io.EOF) {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid payload"})
+		return
+	}
+
+	exchange, err := h.replay(r.Context(), original, edit.apply(original.Request))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, exchange)
+}
+
+// allowedHost accepts localhost, IP addresses and the host of the address the handler is served on
+func (h *Handler) allowedHost(host string) bool {
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		host = hostname
+	}
+	host = strings.Trim(host, "[]")
+	if strings.EqualFold(host, "localhost") || net.ParseIP(host) != nil {
+		return true
+	}
+
+	addr := h.addr
+	if hostname, _, err := net.SplitHostPort(addr); err == nil {
+		addr = hostname
+	}
+	return addr != "" && strings.EqualFold(host, addr)
+}
+
+func (h *Handler) exchange(id string) (*traffic.Exchange, error) {
+	n, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return nil, ErrExchangeNotFound
+	}
+	return h.store.Get(n)
+}
+
+func writeJSON(w http.ResponseWriter, status int, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	status := http.StatusBadGateway
+	switch {
+	case errors.Is(err, ErrExchangeNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, traffic.ErrBodyTruncated):
+		status = http.StatusBadRequest
+	}
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,229 @@
+package inspector
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/amalshaji/beaver/internal/traffic"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStoreRing(t *testing.T) {
+	store := NewStore(2, 1024)
+	for i := 0; i < 3; i++ {
+		store.Add(&traffic.Exchange{Request: &traffic.Request{Method: "GET", URL: "/"}})
+	}
+
+	exchanges := store.List()
+	assert.Len(t, exchanges, 2)
+	assert.Equal(t, int64(3), exchanges[0].ID)
+	assert.Equal(t, int64(2), exchanges[1].ID)
+
+	_, err := store.Get(1)
+	assert.ErrorIs(t, err, ErrExchangeNotFound)
+
+	store.Clear()
+	assert.Empty(t, store.List())
+	// IDs keep increasing after a clear
+	assert.Equal(t, int64(4), store.Add(&traffic.Exchange{Request: &traffic.Request{}}).ID)
+}
+
+func TestCompare(t *testing.T) {
+	a := &traffic.Exchange{
+		ID:       1,
+		Request:  &traffic.Request{Method: "POST", URL: "/hook", Header: http.Header{"X-Signature": {"a"}, "Accept": {"*/*"}}, Body: []byte(`{"event":"push","id":1}`)},
+		Response: &traffic.Response{StatusCode: 500},
+	}
+	b := &traffic.Exchange{
+		ID:       2,
+		Request:  &traffic.Request{Method: "POST", URL: "/hook", Header: http.Header{"X-Signature": {"b"}, "Accept": {"*/*"}}, Body: []byte(`{"event":"push","id":2}`)},
+		Response: &traffic.Response{StatusCode: 200},
+	}
+
+	diff := Compare(a, b)
+	assert.Equal(t, []Change{{Name: "status", A: "500", B: "200"}}, diff.Fields)
+	assert.Equal(t, []Change{{Name: "X-Signature", A: "a", B: "b"}}, diff.RequestHeaders)
+	assert.Empty(t, diff.ResponseHeaders)
+	assert.Equal(t, []Line{
+		{Op: LineEqual, Text: "{"},
+		{Op: LineEqual, Text: `  "event": "push",`},
+		{Op: LineRemoved, Text: `  "id": 1`},
+		{Op: LineAdded, Text: `  "id": 2`},
+		{Op: LineEqual, Text: "}"},
+	}, diff.RequestBody)
+	assert.Nil(t, diff.ResponseBody)
+}
+
+func TestHandlerReplay(t *testing.T) {
+	store := NewStore(10, 1024)
+	original := store.Add(&traffic.Exchange{
+		Time:     time.Now(),
+		Request:  &traffic.Request{Method: "POST", URL: "/hook", Header: http.Header{}, Body: []byte("hello")},
+		Response: &traffic.Response{StatusCode: 200},
+	})
+
+	var replayed *traffic.Request
+	handler := NewHandler(store, func(ctx context.Context, o *traffic.Exchange, req *traffic.Request) (*traffic.Exchange, error) {
+		replayed = req
+		return store.Add(&traffic.Exchange{Request: req, ReplayOf: o.ID, Response: &traffic.Response{StatusCode: 201}}), nil
+	}, "localhost:4040")
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	res, err := http.Post(server.URL+"/api/requests/1/replay", "application/json", strings.NewReader(`{"url": "other", "body": "edited"}`))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+
+	var exchange traffic.Exchange
+	json.NewDecoder(res.Body).Decode(&exchange)
+	assert.Equal(t, int64(2), exchange.ID)
+	assert.Equal(t, original.ID, exchange.ReplayOf)
+	assert.Equal(t, "/other", replayed.URL)
+	assert.Equal(t, "edited", string(replayed.Body))
+	// The original request is left untouched
+	assert.Equal(t, "hello", string(original.Request.Body))
+
+	res, err = http.Get(server.URL + "/api/diff?a=1&b=2")
+	assert.NoError(t, err)
+	var diff Diff
+	json.NewDecoder(res.Body).Decode(&diff)
+	assert.Equal(t, []Change{{Name: "url", A: "/hook", B: "/other"}, {Name: "status", A: "200", B: "201"}}, diff.Fields)
+
+	res, err = http.Get(server.URL + "/api/requests/42")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, res.StatusCode)
+}
+
+func TestHandlerRefusesOtherSites(t *testing.T) {
+	store := NewStore(10, 1024)
+	store.Add(&traffic.Exchange{Time: time.Now(), Request: &traffic.Request{Method: "GET", URL: "/", Header: http.Header{}}})
+	handler := NewHandler(store, func(ctx context.Context, o *traffic.Exchange, req *traffic.Request) (*traffic.Exchange, error) {
+		return store.Add(&traffic.Exchange{Request: req, ReplayOf: o.ID}), nil
+	}, "inspector.test:4040")
+
+	for host, status := range map[string]int{
+		"localhost:4040":      http.StatusOK,
+		"127.0.0.1:4040":      http.StatusOK,
+		"[::1]:4040":          http.StatusOK,
+		"inspector.test:4040": http.StatusOK,
+		// A domain of another site resolving to localhost
+		"evil.example.com:4040": http.StatusForbidden,
+	} {
+		req := httptest.NewRequest("GET", "/api/requests", nil)
+		req.Host = host
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		assert.Equal(t, status, rec.Code, host)
+	}
+
+	// A form of another site cannot send JSON
+	for contentType, status := range map[string]int{
+		"":                                  http.StatusUnsupportedMediaType,
+		"text/plain":                        http.StatusUnsupportedMediaType,
+		"application/x-www-form-urlencoded": http.StatusUnsupportedMediaType,
+		"application/json; charset=utf-8":   http.StatusOK,
+	} {
+		req := httptest.NewRequest("POST", "http://localhost:4040/api/requests/1/replay", strings.NewReader("{}"))
+		req.Header.Set("Content-Type", contentType)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		assert.Equal(t, status, rec.Code, contentType)
+	}
+}
+
+func TestReplayTruncatedBody(t *testing.T) {
+	req := &traffic.Request{Method: "POST", URL: "/", Body: []byte("ab"), BodySize: 10, Truncated: true}
+	_, err := req.HTTPRequest(context.Background(), "http://localhost:8000")
+	assert.ErrorIs(t, err, traffic.ErrBodyTruncated)
+
+	body := "new"
+	edited := Edit{Body: &body}.apply(req)
+	httpRequest, err := edited.HTTPRequest(context.Background(), "http://localhost:8000")
+	assert.NoError(t, err)
+	assert.Equal(t, "http://localhost:8000/", httpRequest.URL.String())
+	assert.Equal(t, int64(3), httpRequest.ContentLength)
+}
+
+func TestCompareIgnoring(t *testing.T) {
+	a := &traffic.Exchange{
+		Request:  &traffic.Request{Method: "GET", URL: "/items"},
+		Response: &traffic.Response{StatusCode: 200, Header: http.Header{"Date": {"1"}, "X-Request-Id": {"a"}}, Body: []byte(`{"id":1,"items":[{"name":"a","createdAt":"1"}]}`)},
+	}
+	b := &traffic.Exchange{
+		Request:  &traffic.Request{Method: "GET", URL: "/items"},
+		Response: &traffic.Response{StatusCode: 200, Header: http.Header{"Date": {"2"}, "X-Request-Id": {"b"}}, Body: []byte(`{"items":[{"createdAt":"2","name":"a"}],"id":2}`)},
+	}
+
+	assert.False(t, Compare(a, b).Equal())
+
+	id, _ := ParseJSONPath("$.id")
+	createdAt, _ := ParseJSONPath("$..createdAt")
+	diff := CompareIgnoring(a, b, Ignore{Headers: []string{"date", "X-Request-Id"}, Paths: []JSONPath{id, createdAt}})
+	assert.True(t, diff.Equal(), diff)
+
+	// Other changes are still reported
+	b.Response.Body = []byte(`{"items":[{"createdAt":"2","name":"b"}],"id":2}`)
+	diff = CompareIgnoring(a, b, Ignore{Headers: []string{"date", "X-Request-Id"}, Paths: []JSONPath{id, createdAt}})
+	assert.Equal(t, []Line{
+		{Op: LineEqual, Text: "{"},
+		{Op: LineEqual, Text: `  "items": [`},
+		{Op: LineEqual, Text: "    {"},
+		{Op: LineRemoved, Text: `      "name": "a"`},
+		{Op: LineAdded, Text: `      "name": "b"`},
+		{Op: LineEqual, Text: "    }"},
+		{Op: LineEqual, Text: "  ]"},
+		{Op: LineEqual, Text: "}"},
+	}, diff.ResponseBody)
+}
+
+func TestStoreTruncatesBodies(t *testing.T) {
+	store := NewStore(10, 4)
+	exchange := &traffic.Exchange{
+		Request:  &traffic.Request{Body: []byte("abcdef"), BodySize: 6},
+		Response: &traffic.Response{Body: []byte("ok"), BodySize: 2},
+	}
+
+	stored := store.Add(exchange)
+	assert.Equal(t, "abcd", string(stored.Request.Body))
+	assert.Equal(t, 4, cap(stored.Request.Body))
+	assert.True(t, stored.Request.Truncated)
+	assert.Equal(t, "ok", string(stored.Response.Body))
+	assert.False(t, stored.Response.Truncated)
+	// The exchange given to the store is left untouched
+	assert.Equal(t, "abcdef", string(exchange.Request.Body))
+	assert.Equal(t, stored.ID, exchange.ID)
+}
+
+func TestRecording(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "session.jsonl")
+
+	recorder, err := NewRecorder(path)
+	assert.NoError(t, err)
+	assert.NoError(t, recorder.Record(&traffic.Exchange{ID: 1, Subdomain: "api", Request: &traffic.Request{Method: "POST", URL: "/hook", Body: []byte{0, 1, 0xff}, BodySize: 3}}))
+	assert.NoError(t, recorder.Record(&traffic.Exchange{ID: 2, Subdomain: "api", Request: &traffic.Request{Method: "GET", URL: "/"}, Response: &traffic.Response{StatusCode: 204}}))
+	assert.NoError(t, recorder.Close())
+
+	// A new recorder appends to the file
+	recorder, err = NewRecorder(path)
+	assert.NoError(t, err)
+	assert.NoError(t, recorder.Record(&traffic.Exchange{ID: 3, Request: &traffic.Request{Method: "GET", URL: "/other"}}))
+	recorder.Close()
+
+	exchanges, err := ReadRecording(path)
+	assert.NoError(t, err)
+	assert.Len(t, exchanges, 3)
+	assert.Equal(t, []byte{0, 1, 0xff}, exchanges[0].Request.Body)
+	assert.Equal(t, 204, exchanges[1].Response.StatusCode)
+	assert.Equal(t, "/other", exchanges[2].Request.URL)
+
+	os.WriteFile(path, []byte("{}\nnot json\n"), 0o600)
+	_, err = ReadRecording(path)
+	assert.ErrorContains(t, err, "session.jsonl:2")
+}
@@ -0,0 +1,178 @@
+package inspector
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type segmentKind int
+
+const (
+	segmentKey segmentKind = iota
+	segmentIndex
+	segmentWildcard
+	// Matches the next segment at any depth
+	segmentDescendants
+)
+
+type pathSegment struct {
+	kind  segmentKind
+	key   string
+	index int
+}
+
+// JSONPath selects values of a JSON document, it supports a subset of the JSONPath syntax :
+//
+//	$.id                  member of the root object, the leading `$.` can be omitted
+//	$.items[0].id         element of an array
+//	$.items[*].id         every element of an array, or every member of an object with .*
+//	$..updatedAt          member at any depth
+//	$['x-key']            member whose name is not an identifier
+type JSONPath struct {
+	path     string
+	segments []pathSegment
+}
+
+// ParseJSONPath parses a JSONPath expression
+func ParseJSONPath(path string) (JSONPath, error) {
+	expr := strings.TrimSpace(path)
+	if !strings.HasPrefix(expr, "$") {
+		expr = "$." + expr
+	}
+	rest := expr[1:]
+
+	var segments []pathSegment
+	for rest != "" {
+		switch {
+		case strings.HasPrefix(rest, ".."):
+			segments = append(segments, pathSegment{kind: segmentDescendants})
+			rest = rest[1:]
+			fallthrough
+
+		case strings.HasPrefix(rest, "."):
+			rest = rest[1:]
+			end := strings.IndexAny(rest, ".[")
+			if end == -1 {
+				end = len(rest)
+			}
+			name := rest[:end]
+			rest = rest[end:]
+			switch name {
+			case "":
+				return JSONPath{}, fmt.Errorf("invalid JSON path %s : empty member name", path)
+			case "*":
+				segments = append(segments, pathSegment{kind: segmentWildcard})
+			default:
+				segments = append(segments, pathSegment{kind: segmentKey, key: name})
+			}
+
+		case strings.HasPrefix(rest, "["):
+			end := strings.Index(rest, "]")
+			if end == -1 {
+				return JSONPath{}, fmt.Errorf("invalid JSON path %s : missing ]", path)
+			}
+			selector := rest[1:end]
+			rest = rest[end+1:]
+			if selector == "*" {
+				segments = append(segments, pathSegment{kind: segmentWildcard})
+			} else if unquoted, ok := unquote(selector); ok {
+				segments = append(segments, pathSegment{kind: segmentKey, key: unquoted})
+			} else if index, err := strconv.Atoi(selector); err == nil && index >= 0 {
+				segments = append(segments, pathSegment{kind: segmentIndex, index: index})
+			} else {
+				return JSONPath{}, fmt.Errorf("invalid JSON path %s : invalid selector [%s]", path, selector)
+			}
+
+		default:
+			return JSONPath{}, fmt.Errorf("invalid JSON path %s : unexpected %q", path, rest[:1])
+		}
+	}
+
+	if len(segments) == 0 {
+		return JSONPath{}, fmt.Errorf("invalid JSON path %s : it selects the whole document", path)
+	}
+	return JSONPath{path: path, segments: segments}, nil
+}
+
+func unquote(s string) (string, bool) {
+	if len(s) >= 2 && (s[0] == '\'' || s[0] == '"') && s[len(s)-1] == s[0] {
+		return s[1 : len(s)-1], true
+	}
+	return "", false
+}
+
+func (p JSONPath) String() string {
+	return p.path
+}
+
+// Remove removes the selected values from a decoded JSON document, the members of objects
+// are deleted while the elements of arrays are set to null to keep the other indexes
+func (p JSONPath) Remove(document any) {
+	remove(document, p.segments)
+}
+
+func remove(value any, segments []pathSegment) {
+	segment, last := segments[0], len(segments) == 1
+
+	switch segment.kind {
+	case segmentDescendants:
+		remove(value, segments[1:])
+		forEachChild(value, func(child any) {
+			remove(child, segments)
+		})
+
+	case segmentKey:
+		object, ok := value.(map[string]any)
+		if !ok {
+			return
+		}
+		if last {
+			delete(object, segment.key)
+		} else if child, ok := object[segment.key]; ok {
+			remove(child, segments[1:])
+		}
+
+	case segmentIndex:
+		array, ok := value.([]any)
+		if !ok || segment.index >= len(array) {
+			return
+		}
+		if last {
+			array[segment.index] = nil
+		} else {
+			remove(array[segment.index], segments[1:])
+		}
+
+	case segmentWildcard:
+		if !last {
+			forEachChild(value, func(child any) {
+				remove(child, segments[1:])
+			})
+			return
+		}
+		switch v := value.(type) {
+		case map[string]any:
+			for key := range v {
+				delete(v, key)
+			}
+		case []any:
+			for i := range v {
+				v[i] = nil
+			}
+		}
+	}
+}
+
+func forEachChild(value any, fn func(any)) {
+	switch v := value.(type) {
+	case map[string]any:
+		for _, child := range v {
+			fn(child)
+		}
+	case []any:
+		for _, child := range v {
+			fn(child)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package inspector
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJSONPathRemove(t *testing.T) {
+	tests := []struct {
+		path     string
+		expected string
+	}{
+		{"$.id", `{"items":[{"id":1,"name":"a"},{"id":2,"name":"b"}],"meta":{"id":3,"x-key":4}}`},
+		{"id", `{"items":[{"id":1,"name":"a"},{"id":2,"name":"b"}],"meta":{"id":3,"x-key":4}}`},
+		{"$.items[0]", `{"id":0,"items":[null,{"id":2,"name":"b"}],"meta":{"id":3,"x-key":4}}`},
+		{"$.items[*].id", `{"id":0,"items":[{"name":"a"},{"name":"b"}],"meta":{"id":3,"x-key":4}}`},
+		{"$.meta.*", `{"id":0,"items":[{"id":1,"name":"a"},{"id":2,"name":"b"}],"meta":{}}`},
+		{"$..id", `{"items":[{"name":"a"},{"name":"b"}],"meta":{"x-key":4}}`},
+		{"$.meta['x-key']", `{"id":0,"items":[{"id":1,"name":"a"},{"id":2,"name":"b"}],"meta":{"id":3}}`},
+		{"$.missing.id", `{"id":0,"items":[{"id":1,"name":"a"},{"id":2,"name":"b"}],"meta":{"id":3,"x-key":4}}`},
+		{"$.items[5].id", `{"id":0,"items":[{"id":1,"name":"a"},{"id":2,"name":"b"}],"meta":{"id":3,"x-key":4}}`},
+	}
+
+	for _, test := range tests {
+		var document any
+		json.Unmarshal([]byte(`{"id":0,"items":[{"id":1,"name":"a"},{"id":2,"name":"b"}],"meta":{"id":3,"x-key":4}}`), &document)
+
+		path, err := ParseJSONPath(test.path)
+		assert.NoError(t, err, test.path)
+		path.Remove(document)
+
+		result, _ := json.Marshal(document)
+		assert.Equal(t, test.expected, string(result), test.path)
+	}
+}
+
+func TestParseJSONPathErrors(t *testing.T) {
+	for _, path := range []string{"$", "$.", "$.items[", "$.items[-1]", "$.items[a]", "$x"} {
+		_, err := ParseJSONPath(path)
+		assert.Error(t, err, path)
+	}
+}
@@ -0,0 +1,71 @@
+package inspector
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/amalshaji/beaver/internal/traffic"
+)
+
+// RecordMaxBodySize is the size limit of the bodies of a recording, larger requests cannot be replayed
+const RecordMaxBodySize = 10 * 1024 * 1024
+
+// Recorder appends exchanges to a file, one JSON object per line
+type Recorder struct {
+	file    *os.File
+	encoder *json.Encoder
+	lock    sync.Mutex
+}
+
+// NewRecorder appends to the file at path, it is created if needed
+func NewRecorder(path string) (*Recorder, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	return &Recorder{file: file, encoder: json.NewEncoder(file)}, nil
+}
+
+// Record appends an exchange
+func (r *Recorder) Record(exchange *traffic.Exchange) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	return r.encoder.Encode(exchange)
+}
+
+// Close closes the file
+func (r *Recorder) Close() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	return r.file.Close()
+}
+
+// ReadRecording returns the exchanges of a file written by a Recorder, in order
+func ReadRecording(path string) ([]*traffic.Exchange, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var exchanges []*traffic.Exchange
+	scanner := bufio.NewScanner(file)
+	// Lines hold the base64 encoded bodies
+	scanner.Buffer(nil, 4*RecordMaxBodySize)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		exchange := new(traffic.Exchange)
+		if err := json.Unmarshal(scanner.Bytes(), exchange); err != nil {
+			return nil, fmt.Errorf("%s:%d : %w", path, line, err)
+		}
+		exchanges = append(exchanges, exchange)
+	}
+	return exchanges, scanner.Err()
+}
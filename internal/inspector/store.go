@@ -0,0 +1,102 @@
+package inspector
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/amalshaji/beaver/internal/traffic"
+)
+
+var ErrExchangeNotFound = errors.New("request not found")
+
+// Store keeps the most recent exchanges in a ring buffer
+type Store struct {
+	size        int
+	maxBodySize int64
+
+	exchanges []*traffic.Exchange
+	nextID    int64
+	lock      sync.RWMutex
+}
+
+// NewStore keeps up to size exchanges, with bodies of up to maxBodySize bytes
+func NewStore(size int, maxBodySize int64) *Store {
+	return &Store{size: size, maxBodySize: maxBodySize, nextID: 1}
+}
+
+// MaxBodySize returns the size limit of the captured bodies
+func (s *Store) MaxBodySize() int64 {
+	return s.maxBodySize
+}
+
+// Add records an exchange and assigns its ID, the store keeps a copy when its bodies are over the size limit
+func (s *Store) Add(exchange *traffic.Exchange) *traffic.Exchange {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	exchange.ID = s.nextID
+	s.nextID++
+
+	if int64(len(exchange.Request.Body)) > s.maxBodySize || (exchange.Response != nil && int64(len(exchange.Response.Body)) > s.maxBodySize) {
+		truncated := *exchange
+		request := *exchange.Request
+		request.Body, request.Truncated = truncateBody(request.Body, request.Truncated, s.maxBodySize)
+		truncated.Request = &request
+		if exchange.Response != nil {
+			response := *exchange.Response
+			response.Body, response.Truncated = truncateBody(response.Body, response.Truncated, s.maxBodySize)
+			truncated.Response = &response
+		}
+		exchange = &truncated
+	}
+
+	s.exchanges = append(s.exchanges, exchange)
+	if len(s.exchanges) > s.size {
+		s.exchanges[0] = nil
+		s.exchanges = s.exchanges[1:]
+	}
+	return exchange
+}
+
+// truncateBody returns the first limit bytes of body, copied so the larger body can be released
+func truncateBody(body []byte, truncated bool, limit int64) ([]byte, bool) {
+	if int64(len(body)) <= limit {
+		return body, truncated
+	}
+	truncatedBody := make([]byte, limit)
+	copy(truncatedBody, body)
+	return truncatedBody, true
+}
+
+// List returns the exchanges, the latest first
+func (s *Store) List() []*traffic.Exchange {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	exchanges := make([]*traffic.Exchange, 0, len(s.exchanges))
+	for i := len(s.exchanges) - 1; i >= 0; i-- {
+		exchanges = append(exchanges, s.exchanges[i])
+	}
+	return exchanges
+}
+
+// Get returns an exchange by ID
+func (s *Store) Get(id int64) (*traffic.Exchange, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	for _, exchange := range s.exchanges {
+		if exchange.ID == id {
+			return exchange, nil
+		}
+	}
+	return nil, ErrExchangeNotFound
+}
+
+// Clear removes all the exchanges
+func (s *Store) Clear() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.exchanges = nil
+}
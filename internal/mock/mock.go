@@ -0,0 +1,305 @@
+// Package mock answers requests with canned responses defined in a YAML file, eg:
+//
+//	routes:
+//	  - method: POST
+//	    path: /webhooks/:provider
+//	    status: 202
+//	    headers:
+//	      X-Provider: "{{ .Params.provider }}"
+//	    body: '{"received": true, "event": {{ json .JSON.type }}}'
+//	  - path: /static/*
+//	    bodyfile: ./offline.html
+//
+// Bodies and header values are Go templates, see Request for the data they are executed with.
+package mock
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// MaxBodySize is the size limit of the request bodies available to the templates
+const MaxBodySize = 1024 * 1024
+
+// Header set on every mock response
+const Header = "X-Beaver-Mock"
+
+var ErrNoRoute = errors.New("no mock route")
+
+// Route answers the requests matching a method and a path pattern
+type Route struct {
+	// HTTP method, any method when empty
+	Method string
+	// Path pattern, segments starting with : match any segment and a trailing * matches the rest of the path
+	Path string
+	// Response status, 200 when empty
+	Status  int
+	Headers map[string]string
+	Body    string
+	// File whose content is the body, relative to the mocks file
+	BodyFile string
+	// Time to wait before answering (milliseconds)
+	Delay int
+
+	segments []string
+	body     *template.Template
+	headers  map[string]*template.Template
+}
+
+// Mocks is a list of routes, the first one matching a request answers it
+type Mocks struct {
+	Routes []*Route
+}
+
+// Request is the data the templates are executed with
+type Request struct {
+	Method string
+	Path   string
+	Query  url.Values
+	Header http.Header
+	// Values of the : and * segments of the path pattern, by name (* for the rest of the path)
+	Params map[string]string
+	Body   string
+	// Decoded body, for JSON requests
+	JSON any
+}
+
+var funcs = template.FuncMap{
+	"now": time.Now,
+	"json": func(v any) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+}
+
+// Load reads and compiles a mocks file
+func Load(path string) (*Mocks, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var mocks Mocks
+	if err := yaml.Unmarshal(data, &mocks); err != nil {
+		return nil, fmt.Errorf("%s : %w", path, err)
+	}
+
+	for i, route := range mocks.Routes {
+		if err := route.compile(filepath.Dir(path)); err != nil {
+			return nil, fmt.Errorf("%s : route %d (%s %s) : %w", path, i+1, route.Method, route.Path, err)
+		}
+	}
+	return &mocks, nil
+}
+
+func (r *Route) compile(dir string) (err error) {
+	if !strings.HasPrefix(r.Path, "/") {
+		return errors.New("path must start with /")
+	}
+	r.segments = splitPath(r.Path)
+	r.Method = strings.ToUpper(r.Method)
+	if r.Status == 0 {
+		r.Status = http.StatusOK
+	}
+
+	if r.BodyFile != "" {
+		if r.Body != "" {
+			return errors.New("body and bodyfile are exclusive")
+		}
+		if !filepath.IsAbs(r.BodyFile) {
+			r.BodyFile = filepath.Join(dir, r.BodyFile)
+		}
+		data, err := os.ReadFile(r.BodyFile)
+		if err != nil {
+			return err
+		}
+		r.Body = string(data)
+	}
+
+	if r.body, err = template.New("body").Funcs(funcs).Parse(r.Body); err != nil {
+		return err
+	}
+	r.headers = make(map[string]*template.Template, len(r.Headers))
+	for name, value := range r.Headers {
+		if r.headers[name], err = template.New(name).Funcs(funcs).Parse(value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func splitPath(path string) []string {
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return nil
+	}
+	return strings.Split(path, "/")
+}
+
+// match returns the values of the parameters of the path pattern, or false if the request does not match
+func (r *Route) match(method, path string) (map[string]string, bool) {
+	if r.Method != "" && r.Method != "*" && r.Method != method {
+		return nil, false
+	}
+
+	params := make(map[string]string)
+	segments := splitPath(path)
+	for i, pattern := range r.segments {
+		if pattern == "*" && i == len(r.segments)-1 {
+			params["*"] = strings.Join(segments[i:], "/")
+			return params, true
+		}
+		if i >= len(segments) {
+			return nil, false
+		}
+		switch {
+		case pattern == "*":
+		case strings.HasPrefix(pattern, ":"):
+			params[pattern[1:]] = segments[i]
+		case pattern != segments[i]:
+			return nil, false
+		}
+	}
+	return params, len(segments) == len(r.segments)
+}
+
+// Match returns the first route matching a request and the values of its path parameters
+func (m *Mocks) Match(method, path string) (*Route, map[string]string, bool) {
+	for _, route := range m.Routes {
+		if params, ok := route.match(method, path); ok {
+			return route, params, true
+		}
+	}
+	return nil, nil, false
+}
+
+// Respond answers a request from the first matching route, it reads the request body
+func (m *Mocks) Respond(req *http.Request) (*http.Response, error) {
+	route, params, ok := m.Match(req.Method, req.URL.Path)
+	if !ok {
+		return nil, fmt.Errorf("%w for %s %s", ErrNoRoute, req.Method, req.URL.Path)
+	}
+	return route.Respond(req, params)
+}
+
+// Respond answers a request, params are the values of the path parameters
+func (r *Route) Respond(req *http.Request, params map[string]string) (*http.Response, error) {
+	data := &Request{
+		Method: req.Method,
+		Path:   req.URL.Path,
+		Query:  req.URL.Query(),
+		Header: req.Header,
+		Params: params,
+	}
+	if req.Body != nil {
+		body, err := io.ReadAll(io.LimitReader(req.Body, MaxBodySize))
+		if err != nil {
+			return nil, fmt.Errorf("unable to read request body : %w", err)
+		}
+		data.Body = string(body)
+		// JSON is left nil when the body is not JSON
+		_ = json.Unmarshal(body, &data.JSON)
+	}
+
+	var body bytes.Buffer
+	if err := r.body.Execute(&body, data); err != nil {
+		return nil, err
+	}
+
+	header := make(http.Header)
+	for name, tmpl := range r.headers {
+		var value strings.Builder
+		if err := tmpl.Execute(&value, data); err != nil {
+			return nil, err
+		}
+		header.Set(name, value.String())
+	}
+	if header.Get("Content-Type") == "" && body.Len() > 0 {
+		header.Set("Content-Type", contentType(body.Bytes()))
+	}
+	header.Set("Content-Length", strconv.Itoa(body.Len()))
+	header.Set(Header, "true")
+
+	if r.Delay > 0 {
+		select {
+		case <-time.After(time.Duration(r.Delay) * time.Millisecond):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+	}
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", r.Status, http.StatusText(r.Status)),
+		StatusCode:    r.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(&body),
+		ContentLength: int64(body.Len()),
+		Request:       req,
+	}, nil
+}
+
+func contentType(body []byte) string {
+	if json.Valid(body) {
+		return "application/json"
+	}
+	return http.DetectContentType(body)
+}
+
+// File is a mocks file, reloaded when it changes
+type File struct {
+	path    string
+	modTime time.Time
+	mocks   *Mocks
+	lock    sync.Mutex
+}
+
+// NewFile returns the mocks file at path, it is loaded on first use
+func NewFile(path string) *File {
+	return &File{path: path}
+}
+
+// Mocks returns the routes of the file, the previous ones are kept when the edited file is invalid
+func (f *File) Mocks() (*Mocks, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	info, err := os.Stat(f.path)
+	if err != nil {
+		if f.mocks != nil {
+			return f.mocks, nil
+		}
+		return nil, err
+	}
+	if f.mocks != nil && info.ModTime().Equal(f.modTime) {
+		return f.mocks, nil
+	}
+
+	mocks, err := Load(f.path)
+	if err != nil {
+		if f.mocks != nil {
+			// Report the error once, until the file is edited again
+			f.modTime = info.ModTime()
+			return f.mocks, err
+		}
+		return nil, err
+	}
+	f.mocks, f.modTime = mocks, info.ModTime()
+	return mocks, nil
+}
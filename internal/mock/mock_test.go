@@ -0,0 +1,117 @@
+package mock
+
+import (
+	"io"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testMocks = `
+routes:
+  - method: post
+    path: /webhooks/:provider
+    status: 202
+    headers:
+      X-Provider: "{{ .Params.provider }}"
+    body: '{"received": true, "event": {{ json .JSON.type }}, "query": "{{ .Query.Get "a" }}"}'
+  - path: /static/*
+    bodyfile: offline.html
+  - method: GET
+    path: /
+    body: home
+`
+
+func writeMocks(t *testing.T, content string) string {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "offline.html"), []byte("<p>offline {{ .Params }}</p>"), 0o600)
+	path := filepath.Join(dir, "mocks.yaml")
+	assert.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func TestMatch(t *testing.T) {
+	mocks, err := Load(writeMocks(t, testMocks))
+	assert.NoError(t, err)
+
+	route, params, ok := mocks.Match("POST", "/webhooks/github/")
+	assert.True(t, ok)
+	assert.Equal(t, 202, route.Status)
+	assert.Equal(t, map[string]string{"provider": "github"}, params)
+
+	_, _, ok = mocks.Match("GET", "/webhooks/github")
+	assert.False(t, ok)
+	_, _, ok = mocks.Match("POST", "/webhooks/github/push")
+	assert.False(t, ok)
+
+	_, params, ok = mocks.Match("DELETE", "/static/css/app.css")
+	assert.True(t, ok)
+	assert.Equal(t, "css/app.css", params["*"])
+
+	_, _, ok = mocks.Match("GET", "/")
+	assert.True(t, ok)
+	_, _, ok = mocks.Match("GET", "/other")
+	assert.False(t, ok)
+}
+
+func TestRespond(t *testing.T) {
+	mocks, err := Load(writeMocks(t, testMocks))
+	assert.NoError(t, err)
+
+	req := httptest.NewRequest("POST", "/webhooks/stripe?a=1", strings.NewReader(`{"type": "charge.succeeded"}`))
+	res, err := mocks.Respond(req)
+	assert.NoError(t, err)
+	assert.Equal(t, 202, res.StatusCode)
+	assert.Equal(t, "stripe", res.Header.Get("X-Provider"))
+	assert.Equal(t, "application/json", res.Header.Get("Content-Type"))
+	assert.Equal(t, "true", res.Header.Get(Header))
+	body, _ := io.ReadAll(res.Body)
+	assert.JSONEq(t, `{"received": true, "event": "charge.succeeded", "query": "1"}`, string(body))
+
+	res, err = mocks.Respond(httptest.NewRequest("GET", "/static/index.html", nil))
+	assert.NoError(t, err)
+	assert.Equal(t, 200, res.StatusCode)
+	assert.Equal(t, "text/html; charset=utf-8", res.Header.Get("Content-Type"))
+	body, _ = io.ReadAll(res.Body)
+	assert.Equal(t, "<p>offline map[*:index.html]</p>", string(body))
+
+	_, err = mocks.Respond(httptest.NewRequest("PUT", "/", nil))
+	assert.ErrorIs(t, err, ErrNoRoute)
+}
+
+func TestLoadErrors(t *testing.T) {
+	_, err := Load(writeMocks(t, "routes:\n  - path: nope\n"))
+	assert.ErrorContains(t, err, "route 1")
+
+	_, err = Load(writeMocks(t, "routes:\n  - path: /\n    body: '{{ .Body '\n"))
+	assert.Error(t, err)
+}
+
+func TestFileReload(t *testing.T) {
+	path := writeMocks(t, "routes:\n  - path: /\n    body: one\n")
+	file := NewFile(path)
+
+	mocks, err := file.Mocks()
+	assert.NoError(t, err)
+	assert.Equal(t, "one", mocks.Routes[0].Body)
+
+	// An invalid edit keeps the previous routes
+	later := time.Now().Add(time.Second)
+	os.WriteFile(path, []byte("routes: ["), 0o600)
+	os.Chtimes(path, later, later)
+	mocks, err = file.Mocks()
+	assert.Error(t, err)
+	assert.Equal(t, "one", mocks.Routes[0].Body)
+
+	later = later.Add(time.Second)
+	os.WriteFile(path, []byte("routes:\n  - path: /\n    body: two\n"), 0o600)
+	os.Chtimes(path, later, later)
+	mocks, err = file.Mocks()
+	assert.NoError(t, err)
+	assert.Equal(t, "two", mocks.Routes[0].Body)
+}
@@ -0,0 +1,169 @@
+// Package rewrite rewrites the path and the headers of the requests sent to a local server, and the headers of its responses
+package rewrite
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// HostHeader carries the Host rewritten by the server to the client, which routes the requests with their original Host
+const HostHeader = "X-Beaver-Host"
+
+// Number of compiled patterns remembered, the cache is emptied when it is full
+const patternCacheSize = 1000
+
+var ErrInvalidRule = errors.New("invalid rewrite rule")
+
+// Rules rewrite the requests of a tunnel before they reach the local server, and its responses
+type Rules struct {
+	// Host header sent to the local server, eg: myapp.test
+	Host string
+	// Path prefix removed from the requests, eg: /api turns /api/users into /users
+	StripPrefix string
+	// Path prefix added to the requests, after StripPrefix
+	AddPrefix string
+	Request   HeaderRules
+	Response  HeaderRules
+}
+
+// HeaderRules are applied in order: the headers are removed, then set, then their values are rewritten
+type HeaderRules struct {
+	// Headers removed
+	Remove []string
+	// Headers set, replacing their values, eg: Authorization: Bearer ...
+	Set map[string]string
+	// Values rewritten with a regular expression
+	Replace []Replace
+}
+
+// Replace rewrites the values of a header matching Pattern, Replacement can use $1 for the groups of the pattern, eg:
+//
+//	{Header: "Location", Pattern: "^http://localhost:3000", Replacement: "https://app.example.com"}
+type Replace struct {
+	Header      string
+	Pattern     string
+	Replacement string
+}
+
+// Validate checks the prefixes, the header names and the patterns of the rules
+func (r *Rules) Validate() error {
+	for _, prefix := range []string{r.StripPrefix, r.AddPrefix} {
+		if prefix != "" && !strings.HasPrefix(prefix, "/") {
+			return fmt.Errorf("%w : path prefix '%s' must start with /", ErrInvalidRule, prefix)
+		}
+	}
+	if strings.ContainsAny(r.Host, " /\t\r\n") {
+		return fmt.Errorf("%w : invalid host '%s'", ErrInvalidRule, r.Host)
+	}
+	if err := r.Request.validate(); err != nil {
+		return err
+	}
+	return r.Response.validate()
+}
+
+func (h *HeaderRules) validate() error {
+	names := append([]string(nil), h.Remove...)
+	for name := range h.Set {
+		names = append(names, name)
+	}
+	for _, replace := range h.Replace {
+		names = append(names, replace.Header)
+		if _, err := compile(replace.Pattern); err != nil {
+			return fmt.Errorf("%w : invalid pattern for %s : %s", ErrInvalidRule, replace.Header, err)
+		}
+	}
+
+	for _, name := range names {
+		if name == "" || strings.ContainsAny(name, " :\t\r\n") {
+			return fmt.Errorf("%w : invalid header name '%s'", ErrInvalidRule, name)
+		}
+		// The Host header routes the request to its tunnel, it is rewritten with Host
+		if http.CanonicalHeaderKey(name) == "Host" {
+			return fmt.Errorf("%w : use host to rewrite the Host header", ErrInvalidRule)
+		}
+	}
+	return nil
+}
+
+// RewriteRequest rewrites the Host, the path and the headers of a request
+func (r *Rules) RewriteRequest(req *http.Request) {
+	if r.Host != "" {
+		req.Host = r.Host
+		if req.Header.Get("Host") != "" {
+			req.Header.Set("Host", r.Host)
+		}
+	}
+	if r.StripPrefix != "" || r.AddPrefix != "" {
+		req.URL.Path = r.rewritePath(req.URL.Path)
+		req.URL.RawPath = ""
+	}
+	r.Request.apply(req.Header)
+}
+
+// RewriteResponse rewrites the headers of a response
+func (r *Rules) RewriteResponse(header http.Header) {
+	r.Response.apply(header)
+}
+
+// rewritePath removes StripPrefix, when the path is under it, then adds AddPrefix
+func (r *Rules) rewritePath(path string) string {
+	if prefix := strings.TrimSuffix(r.StripPrefix, "/"); prefix != "" {
+		if path == prefix || strings.HasPrefix(path, prefix+"/") {
+			path = strings.TrimPrefix(path, prefix)
+		}
+	}
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	if prefix := strings.TrimSuffix(r.AddPrefix, "/"); prefix != "" {
+		path = prefix + path
+	}
+	return path
+}
+
+func (h *HeaderRules) apply(header http.Header) {
+	for _, name := range h.Remove {
+		header.Del(name)
+	}
+	for name, value := range h.Set {
+		header.Set(name, value)
+	}
+	for _, replace := range h.Replace {
+		pattern, err := compile(replace.Pattern)
+		if err != nil {
+			continue
+		}
+		values := header[http.CanonicalHeaderKey(replace.Header)]
+		for i, value := range values {
+			values[i] = pattern.ReplaceAllString(value, replace.Replacement)
+		}
+	}
+}
+
+// patterns caches the compiled patterns, the rules are applied on every request
+var patterns = struct {
+	lock    sync.Mutex
+	entries map[string]*regexp.Regexp
+}{}
+
+func compile(pattern string) (*regexp.Regexp, error) {
+	patterns.lock.Lock()
+	defer patterns.lock.Unlock()
+
+	if re, ok := patterns.entries[pattern]; ok {
+		return re, nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	if patterns.entries == nil || len(patterns.entries) >= patternCacheSize {
+		patterns.entries = make(map[string]*regexp.Regexp)
+	}
+	patterns.entries[pattern] = re
+	return re, nil
+}
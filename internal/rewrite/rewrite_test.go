@@ -0,0 +1,107 @@
+package rewrite
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+)
+
+func TestRewriteRequest(t *testing.T) {
+	rules := &Rules{
+		Host:        "myapp.test",
+		StripPrefix: "/api/",
+		AddPrefix:   "/v1",
+		Request: HeaderRules{
+			Remove:  []string{"Cookie"},
+			Set:     map[string]string{"Authorization": "Bearer internal"},
+			Replace: []Replace{{Header: "Referer", Pattern: `^https://(\w+)\.example\.com`, Replacement: "http://$1.test"}},
+		},
+	}
+	assert.NoError(t, rules.Validate())
+
+	req := httptest.NewRequest("GET", "http://web.localhost/api/users/1?page=2", nil)
+	req.Header.Set("Cookie", "session=1")
+	req.Header.Set("Authorization", "Bearer visitor")
+	req.Header.Set("Referer", "https://web.example.com/login")
+	rules.RewriteRequest(req)
+
+	assert.Equal(t, "myapp.test", req.Host)
+	assert.Equal(t, "/v1/users/1?page=2", req.URL.RequestURI())
+	assert.Empty(t, req.Header.Get("Cookie"))
+	assert.Equal(t, "Bearer internal", req.Header.Get("Authorization"))
+	assert.Equal(t, "http://web.test/login", req.Header.Get("Referer"))
+}
+
+func TestRewritePath(t *testing.T) {
+	for _, test := range []struct {
+		rules    Rules
+		path     string
+		expected string
+	}{
+		{Rules{StripPrefix: "/api"}, "/api/users", "/users"},
+		{Rules{StripPrefix: "/api"}, "/api", "/"},
+		// Only whole segments are stripped
+		{Rules{StripPrefix: "/api"}, "/apiv2/users", "/apiv2/users"},
+		{Rules{AddPrefix: "/app/"}, "/", "/app/"},
+		{Rules{StripPrefix: "/old", AddPrefix: "/new"}, "/old/page", "/new/page"},
+	} {
+		assert.Equal(t, test.expected, test.rules.rewritePath(test.path), test.path)
+	}
+}
+
+func TestRewriteResponse(t *testing.T) {
+	rules := &Rules{Response: HeaderRules{
+		Remove:  []string{"Server"},
+		Set:     map[string]string{"Cache-Control": "no-store"},
+		Replace: []Replace{{Header: "Location", Pattern: "^http://localhost:3000", Replacement: "https://app.example.com"}},
+	}}
+
+	header := http.Header{}
+	header.Set("Server", "WEBrick")
+	header.Set("Location", "http://localhost:3000/login")
+	rules.RewriteResponse(header)
+
+	assert.Equal(t, http.Header{
+		"Cache-Control": {"no-store"},
+		"Location":      {"https://app.example.com/login"},
+	}, header)
+}
+
+func TestValidate(t *testing.T) {
+	for _, rules := range []Rules{
+		{StripPrefix: "api"},
+		{Host: "my app"},
+		{Request: HeaderRules{Set: map[string]string{"Host": "myapp.test"}}},
+		{Request: HeaderRules{Remove: []string{"X Bad"}}},
+		{Response: HeaderRules{Replace: []Replace{{Header: "Location", Pattern: "("}}}},
+	} {
+		assert.ErrorIs(t, rules.Validate(), ErrInvalidRule, rules)
+	}
+}
+
+func TestUnmarshalYAML(t *testing.T) {
+	var rules Rules
+	err := yaml.Unmarshal([]byte(`
+host: myapp.test
+stripprefix: /api
+request:
+  set:
+    X-Token: secret
+  remove: [Cookie]
+response:
+  replace:
+    - header: Location
+      pattern: ^http://localhost:3000
+      replacement: https://app.example.com
+`), &rules)
+	assert.NoError(t, err)
+	assert.Equal(t, Rules{
+		Host:        "myapp.test",
+		StripPrefix: "/api",
+		Request:     HeaderRules{Set: map[string]string{"X-Token": "secret"}, Remove: []string{"Cookie"}},
+		Response:    HeaderRules{Replace: []Replace{{Header: "Location", Pattern: "^http://localhost:3000", Replacement: "https://app.example.com"}}},
+	}, rules)
+}
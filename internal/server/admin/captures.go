@@ -0,0 +1,161 @@
+package admin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var ErrCapturedRequestNotFound = errors.New("captured request does not exist")
+var ErrInvalidStatusFilter = errors.New("status must be a status code or a class such as 5xx")
+
+// Number of captured requests listed when the filter has no limit
+const defaultCaptureListLimit = 100
+
+// CaptureFilter selects captured requests, empty fields match every request
+type CaptureFilter struct {
+	Subdomain string
+	Method    string
+	// Status code, eg: 404, or class, eg: 5xx
+	Status string
+	// Prefix of the path
+	Path  string
+	Since time.Time
+	Until time.Time
+	// Only the requests captured before this one, to page through the results
+	Before uint
+	Limit  int
+}
+
+type CaptureService struct {
+	DB *gorm.DB
+}
+
+func NewCaptureService(store *gorm.DB) *CaptureService {
+	return &CaptureService{DB: store}
+}
+
+func (s *CaptureService) SaveCapturedRequest(ctx context.Context, captured *CapturedRequest) error {
+	return s.DB.Create(captured).Error
+}
+
+func (s *CaptureService) GetCapturedRequest(ctx context.Context, id uint) (*CapturedRequest, error) {
+	var captured CapturedRequest
+
+	result := s.DB.First(&captured, id)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, ErrCapturedRequestNotFound
+		}
+		return nil, result.Error
+	}
+
+	return &captured, nil
+}
+
+// ListCapturedRequests returns the captured requests matching the filter, the latest first
+func (s *CaptureService) ListCapturedRequests(ctx context.Context, filter CaptureFilter) ([]CapturedRequest, error) {
+	query := s.DB.Model(&CapturedRequest{})
+
+	if filter.Subdomain != "" {
+		query = query.Where("subdomain = ?", filter.Subdomain)
+	}
+	if filter.Method != "" {
+		query = query.Where("method = ?", strings.ToUpper(filter.Method))
+	}
+	if filter.Status != "" {
+		min, max, err := parseStatusFilter(filter.Status)
+		if err != nil {
+			return nil, err
+		}
+		query = query.Where("status_code BETWEEN ? AND ?", min, max)
+	}
+	if filter.Path != "" {
+		query = query.Where("path LIKE ? ESCAPE '\\'", escapeLike(filter.Path)+"%")
+	}
+	if !filter.Since.IsZero() {
+		query = query.Where("time >= ?", filter.Since)
+	}
+	if !filter.Until.IsZero() {
+		query = query.Where("time < ?", filter.Until)
+	}
+	if filter.Before != 0 {
+		query = query.Where("id < ?", filter.Before)
+	}
+
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = defaultCaptureListLimit
+	}
+
+	var captured []CapturedRequest
+	result := query.Order("id DESC").Limit(limit).Find(&captured)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return captured, nil
+}
+
+// DeleteCapturedRequests deletes the captured requests of a tunnel, or of all of them when subdomain is empty
+func (s *CaptureService) DeleteCapturedRequests(ctx context.Context, subdomain string) (int64, error) {
+	query := s.DB.Where("1 = 1")
+	if subdomain != "" {
+		query = s.DB.Where("subdomain = ?", subdomain)
+	}
+	result := query.Delete(&CapturedRequest{})
+	return result.RowsAffected, result.Error
+}
+
+// PurgeCapturedRequests deletes the requests of a tunnel captured more than retention ago,
+// and the oldest ones beyond maxRequests
+func (s *CaptureService) PurgeCapturedRequests(ctx context.Context, subdomain string, retention time.Duration, maxRequests int) (int64, error) {
+	result := s.DB.Where("subdomain = ? AND time < ?", subdomain, time.Now().Add(-retention)).Delete(&CapturedRequest{})
+	if result.Error != nil {
+		return 0, result.Error
+	}
+	deleted := result.RowsAffected
+
+	// ID of the newest request beyond the limit
+	var ids []uint
+	result = s.DB.Model(&CapturedRequest{}).Where("subdomain = ?", subdomain).Order("id DESC").Offset(maxRequests).Limit(1).Pluck("id", &ids)
+	if result.Error != nil {
+		return deleted, result.Error
+	}
+	if len(ids) == 0 {
+		return deleted, nil
+	}
+
+	result = s.DB.Where("subdomain = ? AND id <= ?", subdomain, ids[0]).Delete(&CapturedRequest{})
+	return deleted + result.RowsAffected, result.Error
+}
+
+// CapturedSubdomains returns the subdomains which have captured requests
+func (s *CaptureService) CapturedSubdomains(ctx context.Context) ([]string, error) {
+	var subdomains []string
+	result := s.DB.Model(&CapturedRequest{}).Distinct().Pluck("subdomain", &subdomains)
+	return subdomains, result.Error
+}
+
+// parseStatusFilter returns the range of status codes matched by a status filter
+func parseStatusFilter(status string) (int, int, error) {
+	status = strings.ToLower(strings.TrimSpace(status))
+	if len(status) == 3 && strings.HasSuffix(status, "xx") && status[0] >= '1' && status[0] <= '5' {
+		class := int(status[0]-'0') * 100
+		return class, class + 99, nil
+	}
+
+	code, err := strconv.Atoi(status)
+	if err != nil || code < 100 || code > 599 {
+		return 0, 0, fmt.Errorf("%w : '%s'", ErrInvalidStatusFilter, status)
+	}
+	return code, code, nil
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
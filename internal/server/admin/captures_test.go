@@ -0,0 +1,97 @@
+package admin
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/amalshaji/beaver/internal/traffic"
+	"github.com/stretchr/testify/assert"
+)
+
+func saveTestCapture(t *testing.T, captures *CaptureService, subdomain, method, url string, status int, at time.Time) *CapturedRequest {
+	captured := NewCapturedRequest(&traffic.Exchange{
+		Time:      at,
+		Subdomain: subdomain,
+		Request:   &traffic.Request{Method: method, URL: url, Header: http.Header{}, Body: []byte("hello"), BodySize: 5},
+		Response:  &traffic.Response{StatusCode: status, Header: http.Header{}},
+	})
+	assert.NoError(t, captures.SaveCapturedRequest(context.Background(), captured))
+	return captured
+}
+
+func TestListCapturedRequests(t *testing.T) {
+	defer func() {
+		resetTestStores()
+	}()
+
+	ctx := context.Background()
+	captures := NewCaptureService(db)
+	now := time.Now()
+
+	saveTestCapture(t, captures, "web", "GET", "/", 200, now.Add(-time.Hour))
+	hook := saveTestCapture(t, captures, "api", "POST", "/hooks/github?delivery=1", 500, now.Add(-time.Minute))
+	saveTestCapture(t, captures, "api", "POST", "/hooks_old", 404, now)
+
+	all, err := captures.ListCapturedRequests(ctx, CaptureFilter{})
+	assert.NoError(t, err)
+	assert.Len(t, all, 3)
+	// The latest first
+	assert.Equal(t, "/hooks_old", all[0].Path)
+
+	filtered, err := captures.ListCapturedRequests(ctx, CaptureFilter{Subdomain: "api", Method: "post", Status: "5xx", Path: "/hooks/"})
+	assert.NoError(t, err)
+	assert.Len(t, filtered, 1)
+	assert.Equal(t, hook.ID, filtered[0].ID)
+	assert.Equal(t, "hello", string(filtered[0].Request.Body))
+
+	// _ is not a wildcard
+	filtered, _ = captures.ListCapturedRequests(ctx, CaptureFilter{Path: "/hooks_"})
+	assert.Len(t, filtered, 1)
+
+	filtered, _ = captures.ListCapturedRequests(ctx, CaptureFilter{Since: now.Add(-2 * time.Minute), Until: now.Add(-time.Second)})
+	assert.Len(t, filtered, 1)
+
+	filtered, _ = captures.ListCapturedRequests(ctx, CaptureFilter{Before: hook.ID, Limit: 1})
+	assert.Len(t, filtered, 1)
+	assert.Equal(t, "web", filtered[0].Subdomain)
+
+	_, err = captures.ListCapturedRequests(ctx, CaptureFilter{Status: "9xx"})
+	assert.ErrorIs(t, err, ErrInvalidStatusFilter)
+
+	_, err = captures.GetCapturedRequest(ctx, 4242)
+	assert.Equal(t, ErrCapturedRequestNotFound, err)
+}
+
+func TestPurgeCapturedRequests(t *testing.T) {
+	defer func() {
+		resetTestStores()
+	}()
+
+	ctx := context.Background()
+	captures := NewCaptureService(db)
+	now := time.Now()
+
+	saveTestCapture(t, captures, "api", "GET", "/old", 200, now.Add(-48*time.Hour))
+	for i := 0; i < 4; i++ {
+		saveTestCapture(t, captures, "api", "GET", "/", 200, now)
+	}
+	saveTestCapture(t, captures, "web", "GET", "/old", 200, now.Add(-48*time.Hour))
+
+	deleted, err := captures.PurgeCapturedRequests(ctx, "api", 24*time.Hour, 3)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), deleted)
+
+	remaining, _ := captures.ListCapturedRequests(ctx, CaptureFilter{Subdomain: "api"})
+	assert.Len(t, remaining, 3)
+
+	// Other tunnels are left untouched
+	subdomains, err := captures.CapturedSubdomains(ctx)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"api", "web"}, subdomains)
+
+	deleted, err = captures.DeleteCapturedRequests(ctx, "")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(4), deleted)
+}
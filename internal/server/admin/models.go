@@ -1,10 +1,17 @@
 package admin
 
 import (
+	"crypto/subtle"
 	"errors"
+	"fmt"
+	"net/netip"
+	"strings"
 	"time"
 
+	"github.com/amalshaji/beaver/internal/rewrite"
+	"github.com/amalshaji/beaver/internal/traffic"
 	"github.com/amalshaji/beaver/internal/utils"
+	"github.com/amalshaji/beaver/internal/webhook"
 	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
 )
@@ -62,6 +69,9 @@ type TunnelUser struct {
 	SecretKey    *string `gorm:"index,unique" json:"-"`
 	Active       bool
 	LastActiveAt *time.Time
+
+	// Maximum number of tunnel connections, 0 means the server default
+	PoolMaxSize int
 }
 
 func (t *TunnelUser) RotateSecretKey() string {
@@ -69,3 +79,169 @@ func (t *TunnelUser) RotateSecretKey() string {
 	t.SecretKey = &newSecretKey
 	return newSecretKey
 }
+
+// CompressionSettings controls edge compression of tunnel responses
+type CompressionSettings struct {
+	Enabled   bool
+	MinSize   int64
+	MimeTypes []string
+}
+
+type TunnelSettings struct {
+	gorm.Model
+
+	Subdomain   string               `gorm:"index,unique"`
+	Compression *CompressionSettings `gorm:"serializer:json"`
+	Capture     *CaptureSettings     `gorm:"serializer:json"`
+	BasicAuth   *BasicAuthSettings   `gorm:"serializer:json"`
+	OIDC        *OIDCSettings        `gorm:"serializer:json"`
+	IPFilter    *IPFilterSettings    `gorm:"serializer:json"`
+	Webhook     *webhook.Spec        `gorm:"serializer:json"`
+	Rewrite     *rewrite.Rules       `gorm:"serializer:json"`
+}
+
+// IPFilterSettings restricts the addresses a tunnel accepts requests from, as CIDRs or single IPs.
+// Denied addresses are refused first, then only the allowed ones are accepted, unless Allow is empty.
+type IPFilterSettings struct {
+	Allow []string
+	Deny  []string
+}
+
+// Validate checks every range of the filter
+func (f *IPFilterSettings) Validate() error {
+	for _, r := range append(append([]string(nil), f.Allow...), f.Deny...) {
+		if _, err := parseIPRange(r); err != nil {
+			return fmt.Errorf("%w: '%s'", ErrInvalidIPRange, r)
+		}
+	}
+	return nil
+}
+
+// Allows reports whether the filter accepts a client address, invalid ranges never match
+func (f *IPFilterSettings) Allows(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	contains := func(ranges []string) bool {
+		for _, r := range ranges {
+			if prefix, err := parseIPRange(r); err == nil && prefix.Contains(ip) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if contains(f.Deny) {
+		return false
+	}
+	return len(f.Allow) == 0 || contains(f.Allow)
+}
+
+func parseIPRange(r string) (netip.Prefix, error) {
+	r = strings.TrimSpace(r)
+	if strings.Contains(r, "/") {
+		prefix, err := netip.ParsePrefix(r)
+		return prefix.Masked(), err
+	}
+	ip, err := netip.ParseAddr(r)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return netip.PrefixFrom(ip.Unmap(), ip.Unmap().BitLen()), nil
+}
+
+// OIDCSettings restricts a tunnel to the users of the OpenID Connect provider of the server.
+// The lists of the server apply when both are empty.
+type OIDCSettings struct {
+	Enabled        bool
+	AllowedDomains []string
+	AllowedEmails  []string
+}
+
+// BasicAuthSettings protects a tunnel with HTTP Basic Auth, the password is only stored hashed
+type BasicAuthSettings struct {
+	Username string
+	// Only set in updates, it is replaced by PasswordHash when saved
+	Password     string `json:",omitempty"`
+	PasswordHash string
+}
+
+func (b *BasicAuthSettings) SetPassword(rawPassword string) error {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(rawPassword), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	b.Password = ""
+	b.PasswordHash = string(hashedPassword)
+	return nil
+}
+
+// CheckCredentials reports whether the credentials of a request match, bcrypt makes it slow on purpose
+func (b *BasicAuthSettings) CheckCredentials(username, password string) bool {
+	usernameMatches := subtle.ConstantTimeCompare([]byte(username), []byte(b.Username)) == 1
+	passwordMatches := bcrypt.CompareHashAndPassword([]byte(b.PasswordHash), []byte(password)) == nil
+	return usernameMatches && passwordMatches
+}
+
+// CaptureSettings controls the capture of the requests of a tunnel, 0 values fall back to the server defaults
+type CaptureSettings struct {
+	Enabled bool
+	// Fraction of the requests captured, between 0 and 1
+	SampleRate float64
+	// Captured requests are deleted after this many hours
+	Retention int
+	// Number of captured requests kept
+	MaxRequests int
+	// Bodies are captured up to this size (bytes)
+	MaxBodySize int64
+}
+
+// CapturedRequest is a request proxied to a tunnel along with its response
+type CapturedRequest struct {
+	ID        uint      `gorm:"primarykey"`
+	Time      time.Time `gorm:"index"`
+	Duration  time.Duration
+	Subdomain string `gorm:"index"`
+
+	// Copied from the request and response to filter on them
+	Method     string
+	Path       string
+	StatusCode int
+
+	Request  *traffic.Request  `gorm:"serializer:json"`
+	Response *traffic.Response `gorm:"serializer:json"`
+
+	// ID of the captured request this one replays
+	ReplayOf uint
+}
+
+// NewCapturedRequest returns the captured request of an exchange
+func NewCapturedRequest(exchange *traffic.Exchange) *CapturedRequest {
+	captured := &CapturedRequest{
+		Time:      exchange.Time,
+		Duration:  exchange.Duration,
+		Subdomain: exchange.Subdomain,
+		Request:   exchange.Request,
+		Response:  exchange.Response,
+		ReplayOf:  uint(exchange.ReplayOf),
+	}
+	if exchange.Request != nil {
+		captured.Method = exchange.Request.Method
+		captured.Path, _, _ = strings.Cut(exchange.Request.URL, "?")
+	}
+	if exchange.Response != nil {
+		captured.StatusCode = exchange.Response.StatusCode
+	}
+	return captured
+}
+
+// Exchange returns the captured request as an exchange, to diff or export it
+func (c *CapturedRequest) Exchange() *traffic.Exchange {
+	return &traffic.Exchange{
+		ID:        int64(c.ID),
+		Time:      c.Time,
+		Duration:  c.Duration,
+		Subdomain: c.Subdomain,
+		Request:   c.Request,
+		Response:  c.Response,
+		ReplayOf:  int64(c.ReplayOf),
+	}
+}
@@ -0,0 +1,103 @@
+package admin
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/amalshaji/beaver/internal/utils"
+	"gorm.io/gorm"
+)
+
+var ErrShareLinkNotFound = errors.New("share link does not exist")
+var ErrShareLinkExpired = errors.New("share link expired or revoked")
+var ErrInvalidShareLinkDuration = errors.New("share link duration must be between 1 minute and 30 days")
+
+// Bounds of the lifetime of a share link
+const (
+	MinShareLinkDuration = time.Minute
+	MaxShareLinkDuration = 30 * 24 * time.Hour
+)
+
+// ShareLink grants access to a tunnel without an account, until it expires or is revoked
+type ShareLink struct {
+	ID        uint `gorm:"primarykey"`
+	CreatedAt time.Time
+	Subdomain string `gorm:"index"`
+	ExpiresAt time.Time
+	RevokedAt *time.Time
+	// Admin or tunnel user who created the link
+	CreatedBy string
+	Note      string
+}
+
+// Active reports whether the link still grants access
+func (l *ShareLink) Active() bool {
+	return l.RevokedAt == nil && time.Now().Before(l.ExpiresAt)
+}
+
+type ShareLinkService struct {
+	DB *gorm.DB
+}
+
+func NewShareLinkService(store *gorm.DB) *ShareLinkService {
+	return &ShareLinkService{DB: store}
+}
+
+func (s *ShareLinkService) CreateShareLink(ctx context.Context, subdomain string, duration time.Duration, createdBy, note string) (*ShareLink, error) {
+	subdomain = utils.SanitizeString(subdomain)
+	if err := utils.ValidateSubdomain(subdomain); err != nil {
+		return nil, err
+	}
+	if duration < MinShareLinkDuration || duration > MaxShareLinkDuration {
+		return nil, ErrInvalidShareLinkDuration
+	}
+
+	link := &ShareLink{
+		Subdomain: subdomain,
+		ExpiresAt: time.Now().Add(duration),
+		CreatedBy: createdBy,
+		Note:      note,
+	}
+	if err := s.DB.Create(link).Error; err != nil {
+		return nil, err
+	}
+	return link, nil
+}
+
+func (s *ShareLinkService) GetShareLink(ctx context.Context, id uint) (*ShareLink, error) {
+	var link ShareLink
+
+	result := s.DB.First(&link, id)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, ErrShareLinkNotFound
+		}
+		return nil, result.Error
+	}
+
+	return &link, nil
+}
+
+// ListShareLinks returns the share links of a tunnel, the latest first
+func (s *ShareLinkService) ListShareLinks(ctx context.Context, subdomain string) ([]ShareLink, error) {
+	var links []ShareLink
+	result := s.DB.Where(&ShareLink{Subdomain: subdomain}).Order("id DESC").Find(&links)
+	return links, result.Error
+}
+
+// RevokeShareLink ends the access granted by a link, the visitors who already used it included
+func (s *ShareLinkService) RevokeShareLink(ctx context.Context, id uint) (*ShareLink, error) {
+	link, err := s.GetShareLink(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if link.RevokedAt == nil {
+		now := time.Now()
+		link.RevokedAt = &now
+		if err := s.DB.Save(link).Error; err != nil {
+			return nil, err
+		}
+	}
+	return link, nil
+}
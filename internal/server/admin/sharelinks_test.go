@@ -0,0 +1,49 @@
+package admin
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShareLinks(t *testing.T) {
+	defer func() {
+		resetTestStores()
+	}()
+
+	ctx := context.Background()
+	links := NewShareLinkService(db)
+
+	link, err := links.CreateShareLink(ctx, "web", 24*time.Hour, "test@beaver.com", "review")
+	assert.NoError(t, err)
+	assert.True(t, link.Active())
+	assert.WithinDuration(t, time.Now().Add(24*time.Hour), link.ExpiresAt, time.Minute)
+
+	_, err = links.CreateShareLink(ctx, "web", 31*24*time.Hour, "test@beaver.com", "")
+	assert.Equal(t, ErrInvalidShareLinkDuration, err)
+	_, err = links.CreateShareLink(ctx, "in_valid", time.Hour, "test@beaver.com", "")
+	assert.Error(t, err)
+
+	other, err := links.CreateShareLink(ctx, "web", time.Hour, "test@beaver.com", "")
+	assert.NoError(t, err)
+
+	list, err := links.ListShareLinks(ctx, "web")
+	assert.NoError(t, err)
+	assert.Equal(t, []uint{other.ID, link.ID}, []uint{list[0].ID, list[1].ID})
+
+	revoked, err := links.RevokeShareLink(ctx, link.ID)
+	assert.NoError(t, err)
+	assert.False(t, revoked.Active())
+
+	link, err = links.GetShareLink(ctx, link.ID)
+	assert.NoError(t, err)
+	assert.NotNil(t, link.RevokedAt)
+
+	_, err = links.RevokeShareLink(ctx, 1000)
+	assert.Equal(t, ErrShareLinkNotFound, err)
+
+	expired := &ShareLink{ExpiresAt: time.Now().Add(-time.Second)}
+	assert.False(t, expired.Active())
+}
@@ -0,0 +1,154 @@
+package admin
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/amalshaji/beaver/internal/utils"
+	"github.com/amalshaji/beaver/internal/webhook"
+	"gorm.io/gorm"
+)
+
+var ErrTunnelSettingsNotFound = errors.New("tunnel settings does not exist")
+var ErrInvalidSampleRate = errors.New("capture sample rate must be between 0 and 1")
+var ErrInvalidBasicAuth = errors.New("basic auth requires a username and a password")
+var ErrInvalidIPRange = errors.New("invalid IP range, expected a CIDR or an IP")
+
+type TunnelService struct {
+	DB *gorm.DB
+}
+
+func NewTunnelService(store *gorm.DB) *TunnelService {
+	return &TunnelService{DB: store}
+}
+
+func (t *TunnelService) GetTunnelSettings(ctx context.Context, subdomain string) (*TunnelSettings, error) {
+	subdomain = utils.SanitizeString(subdomain)
+
+	var tunnelSettings TunnelSettings
+
+	result := t.DB.Where(&TunnelSettings{Subdomain: subdomain}).First(&tunnelSettings)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, ErrTunnelSettingsNotFound
+		}
+		return nil, result.Error
+	}
+
+	return &tunnelSettings, nil
+}
+
+func (t *TunnelService) UpdateTunnelSettings(ctx context.Context, subdomain string, settings *TunnelSettings) (*TunnelSettings, error) {
+	subdomain = utils.SanitizeString(subdomain)
+
+	if err := utils.ValidateSubdomain(subdomain); err != nil {
+		return nil, err
+	}
+
+	if settings.Capture != nil && (settings.Capture.SampleRate < 0 || settings.Capture.SampleRate > 1) {
+		return nil, ErrInvalidSampleRate
+	}
+
+	if settings.IPFilter != nil {
+		if err := settings.IPFilter.Validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	if settings.Rewrite != nil {
+		if err := settings.Rewrite.Validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	tunnelSettings, err := t.GetTunnelSettings(ctx, subdomain)
+	if err != nil && !errors.Is(err, ErrTunnelSettingsNotFound) {
+		return nil, err
+	}
+
+	if tunnelSettings == nil {
+		tunnelSettings = &TunnelSettings{Subdomain: subdomain}
+	}
+
+	basicAuth, err := updateBasicAuth(tunnelSettings.BasicAuth, settings.BasicAuth)
+	if err != nil {
+		return nil, err
+	}
+	webhookSpec, err := updateWebhook(tunnelSettings.Webhook, settings.Webhook)
+	if err != nil {
+		return nil, err
+	}
+
+	tunnelSettings.Compression = settings.Compression
+	tunnelSettings.Capture = settings.Capture
+	tunnelSettings.BasicAuth = basicAuth
+	tunnelSettings.OIDC = settings.OIDC
+	tunnelSettings.IPFilter = settings.IPFilter
+	tunnelSettings.Webhook = webhookSpec
+	tunnelSettings.Rewrite = settings.Rewrite
+
+	result := t.DB.Save(tunnelSettings)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return tunnelSettings, nil
+}
+
+// updateBasicAuth hashes the new password, the current hash is kept when the username is unchanged and no password is given
+func updateBasicAuth(current, update *BasicAuthSettings) (*BasicAuthSettings, error) {
+	if update == nil {
+		return nil, nil
+	}
+
+	basicAuth := &BasicAuthSettings{Username: utils.SanitizeString(update.Username)}
+	if basicAuth.Username == "" || strings.Contains(basicAuth.Username, ":") {
+		return nil, ErrInvalidBasicAuth
+	}
+
+	if update.Password == "" {
+		if current == nil || current.Username != basicAuth.Username {
+			return nil, ErrInvalidBasicAuth
+		}
+		basicAuth.PasswordHash = current.PasswordHash
+		return basicAuth, nil
+	}
+
+	if err := basicAuth.SetPassword(update.Password); err != nil {
+		return nil, err
+	}
+	return basicAuth, nil
+}
+
+// updateWebhook validates the new signature spec, the current secret is kept when none is given
+func updateWebhook(current, update *webhook.Spec) (*webhook.Spec, error) {
+	if update == nil {
+		return nil, nil
+	}
+
+	spec := *update
+	spec.Provider = strings.ToLower(spec.Provider)
+	spec.Algorithm = strings.ToLower(spec.Algorithm)
+	spec.Encoding = strings.ToLower(spec.Encoding)
+	if spec.Secret == "" && current != nil {
+		spec.Secret = current.Secret
+	}
+	if err := spec.Validate(); err != nil {
+		return nil, err
+	}
+	return &spec, nil
+}
+
+func (t *TunnelService) DeleteTunnelSettings(ctx context.Context, subdomain string) error {
+	subdomain = utils.SanitizeString(subdomain)
+
+	result := t.DB.Unscoped().Where(&TunnelSettings{Subdomain: subdomain}).Delete(&TunnelSettings{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrTunnelSettingsNotFound
+	}
+	return nil
+}
@@ -0,0 +1,176 @@
+package admin
+
+import (
+	"context"
+	"net/netip"
+	"testing"
+
+	"github.com/amalshaji/beaver/internal/rewrite"
+	"github.com/amalshaji/beaver/internal/webhook"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUpdateTunnelSettings(t *testing.T) {
+	defer func() {
+		resetTestStores()
+	}()
+
+	ctx := context.Background()
+	tunnel := NewTunnelService(db)
+
+	_, err := tunnel.GetTunnelSettings(ctx, "test")
+	assert.Equal(t, ErrTunnelSettingsNotFound, err)
+
+	_, err = tunnel.UpdateTunnelSettings(ctx, "test", &TunnelSettings{
+		Compression: &CompressionSettings{Enabled: true, MinSize: 512},
+	})
+	assert.NoError(t, err)
+
+	// Updating again should not create a duplicate
+	_, err = tunnel.UpdateTunnelSettings(ctx, "test", &TunnelSettings{
+		Compression: &CompressionSettings{Enabled: false, MimeTypes: []string{"text/html"}},
+	})
+	assert.NoError(t, err)
+
+	var count int64
+	_ = db.Model(&TunnelSettings{}).Count(&count)
+	assert.Equal(t, int64(1), count)
+
+	ts, err := tunnel.GetTunnelSettings(ctx, "test")
+	assert.NoError(t, err)
+	assert.False(t, ts.Compression.Enabled)
+	assert.Equal(t, []string{"text/html"}, ts.Compression.MimeTypes)
+
+	_, err = tunnel.UpdateTunnelSettings(ctx, "in_valid", &TunnelSettings{})
+	assert.Error(t, err)
+}
+
+func TestDeleteTunnelSettings(t *testing.T) {
+	defer func() {
+		resetTestStores()
+	}()
+
+	ctx := context.Background()
+	tunnel := NewTunnelService(db)
+
+	_, _ = tunnel.UpdateTunnelSettings(ctx, "test", &TunnelSettings{})
+
+	assert.NoError(t, tunnel.DeleteTunnelSettings(ctx, " test "))
+	assert.Equal(t, ErrTunnelSettingsNotFound, tunnel.DeleteTunnelSettings(ctx, "test"))
+}
+
+func TestUpdateTunnelSettingsBasicAuth(t *testing.T) {
+	defer func() {
+		resetTestStores()
+	}()
+
+	ctx := context.Background()
+	tunnel := NewTunnelService(db)
+
+	ts, err := tunnel.UpdateTunnelSettings(ctx, "test", &TunnelSettings{
+		BasicAuth: &BasicAuthSettings{Username: "admin", Password: "secret"},
+	})
+	assert.NoError(t, err)
+	assert.Empty(t, ts.BasicAuth.Password)
+	assert.NotEqual(t, "secret", ts.BasicAuth.PasswordHash)
+	assert.True(t, ts.BasicAuth.CheckCredentials("admin", "secret"))
+	assert.False(t, ts.BasicAuth.CheckCredentials("admin", "wrong"))
+	assert.False(t, ts.BasicAuth.CheckCredentials("other", "secret"))
+
+	// The hash is kept when the password is left out
+	_, err = tunnel.UpdateTunnelSettings(ctx, "test", &TunnelSettings{
+		BasicAuth: &BasicAuthSettings{Username: "admin"},
+	})
+	assert.NoError(t, err)
+	ts, err = tunnel.GetTunnelSettings(ctx, "test")
+	assert.NoError(t, err)
+	assert.True(t, ts.BasicAuth.CheckCredentials("admin", "secret"))
+
+	// A new username requires a new password
+	_, err = tunnel.UpdateTunnelSettings(ctx, "test", &TunnelSettings{
+		BasicAuth: &BasicAuthSettings{Username: "root"},
+	})
+	assert.Equal(t, ErrInvalidBasicAuth, err)
+
+	ts, err = tunnel.UpdateTunnelSettings(ctx, "test", &TunnelSettings{})
+	assert.NoError(t, err)
+	assert.Nil(t, ts.BasicAuth)
+}
+
+func TestIPFilterSettings(t *testing.T) {
+	filter := &IPFilterSettings{
+		Allow: []string{"192.30.252.0/22", "2a0a:a440::/29", "10.0.0.1"},
+		Deny:  []string{"192.30.253.0/24"},
+	}
+	assert.NoError(t, filter.Validate())
+
+	assert.True(t, filter.Allows(netip.MustParseAddr("192.30.252.10")))
+	assert.True(t, filter.Allows(netip.MustParseAddr("::ffff:192.30.252.10")))
+	assert.True(t, filter.Allows(netip.MustParseAddr("2a0a:a440::1")))
+	assert.True(t, filter.Allows(netip.MustParseAddr("10.0.0.1")))
+	assert.False(t, filter.Allows(netip.MustParseAddr("10.0.0.2")))
+	assert.False(t, filter.Allows(netip.MustParseAddr("192.30.253.1")))
+
+	// Without an allow list, only the denied addresses are refused
+	filter = &IPFilterSettings{Deny: []string{"203.0.113.0/24"}}
+	assert.True(t, filter.Allows(netip.MustParseAddr("198.51.100.1")))
+	assert.False(t, filter.Allows(netip.MustParseAddr("203.0.113.7")))
+
+	_, err := NewTunnelService(db).UpdateTunnelSettings(context.Background(), "test", &TunnelSettings{
+		IPFilter: &IPFilterSettings{Allow: []string{"10.0.0.0/33"}},
+	})
+	assert.ErrorIs(t, err, ErrInvalidIPRange)
+}
+
+func TestUpdateTunnelSettingsWebhook(t *testing.T) {
+	defer func() {
+		resetTestStores()
+	}()
+
+	ctx := context.Background()
+	tunnel := NewTunnelService(db)
+
+	ts, err := tunnel.UpdateTunnelSettings(ctx, "hooks", &TunnelSettings{
+		Webhook: &webhook.Spec{Provider: "GitHub", Secret: "secret"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, &webhook.Spec{Provider: webhook.GitHub, Secret: "secret"}, ts.Webhook)
+
+	// The secret is kept when it is left out
+	_, err = tunnel.UpdateTunnelSettings(ctx, "hooks", &TunnelSettings{
+		Webhook: &webhook.Spec{Header: "X-Signature", Prefix: "sha256="},
+	})
+	assert.NoError(t, err)
+	ts, err = tunnel.GetTunnelSettings(ctx, "hooks")
+	assert.NoError(t, err)
+	assert.Equal(t, &webhook.Spec{Header: "X-Signature", Prefix: "sha256=", Secret: "secret"}, ts.Webhook)
+
+	_, err = tunnel.UpdateTunnelSettings(ctx, "hooks", &TunnelSettings{
+		Webhook: &webhook.Spec{Provider: "gitlab", Secret: "secret"},
+	})
+	assert.ErrorIs(t, err, webhook.ErrInvalidSpec)
+
+	_, err = tunnel.UpdateTunnelSettings(ctx, "other", &TunnelSettings{
+		Webhook: &webhook.Spec{Provider: webhook.Slack},
+	})
+	assert.ErrorIs(t, err, webhook.ErrInvalidSpec)
+}
+
+func TestUpdateTunnelSettingsRewrite(t *testing.T) {
+	defer func() {
+		resetTestStores()
+	}()
+
+	ctx := context.Background()
+	tunnel := NewTunnelService(db)
+
+	rules := &rewrite.Rules{Host: "myapp.test", StripPrefix: "/api", Response: rewrite.HeaderRules{Remove: []string{"Server"}}}
+	_, err := tunnel.UpdateTunnelSettings(ctx, "web", &TunnelSettings{Rewrite: rules})
+	assert.NoError(t, err)
+	ts, err := tunnel.GetTunnelSettings(ctx, "web")
+	assert.NoError(t, err)
+	assert.Equal(t, rules, ts.Rewrite)
+
+	_, err = tunnel.UpdateTunnelSettings(ctx, "web", &TunnelSettings{Rewrite: &rewrite.Rules{StripPrefix: "api"}})
+	assert.ErrorIs(t, err, rewrite.ErrInvalidRule)
+}
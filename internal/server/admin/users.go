@@ -285,3 +285,28 @@ func (u *UserService) DeleteTunnelUser(ctx context.Context, id uint) error {
 	}
 	return nil
 }
+
+func (u *UserService) SetTunnelUserPoolMaxSize(ctx context.Context, id uint, poolMaxSize int) (*TunnelUser, error) {
+	if poolMaxSize < 0 {
+		return nil, fmt.Errorf("pool max size must not be negative")
+	}
+
+	var tunnelUser TunnelUser
+
+	result := u.DB.First(&tunnelUser, id)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, ErrTunnelUserNotFound
+		}
+		return nil, result.Error
+	}
+
+	tunnelUser.PoolMaxSize = poolMaxSize
+
+	result = u.DB.Save(&tunnelUser)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return &tunnelUser, nil
+}
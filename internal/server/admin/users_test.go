@@ -18,7 +18,7 @@ func newTestStore() *gorm.DB {
 	}
 
 	// should automigrate here?
-	db.AutoMigrate(AdminUser{}, TunnelUser{}, Session{})
+	db.AutoMigrate(AdminUser{}, TunnelUser{}, Session{}, TunnelSettings{}, CapturedRequest{}, ShareLink{})
 
 	return db
 }
@@ -27,6 +27,9 @@ func resetTestStores() {
 	db.Unscoped().Where("1 = 1").Delete(&AdminUser{})
 	db.Unscoped().Where("1 = 1").Delete(&TunnelUser{})
 	db.Unscoped().Where("1 = 1").Delete(&Session{})
+	db.Unscoped().Where("1 = 1").Delete(&TunnelSettings{})
+	db.Unscoped().Where("1 = 1").Delete(&CapturedRequest{})
+	db.Unscoped().Where("1 = 1").Delete(&ShareLink{})
 }
 
 var db = newTestStore()
@@ -320,3 +323,24 @@ func TestDeleteTunnelUser(t *testing.T) {
 	assert.Equal(t, 1, len(tu))
 	assert.Equal(t, tunnelUser2.ID, tu[0].ID)
 }
+
+func TestSetTunnelUserPoolMaxSize(t *testing.T) {
+	defer func() {
+		resetTestStores()
+	}()
+
+	ctx := context.Background()
+	user := NewUserService(db)
+
+	tunnelUser, _ := user.CreateTunnelUser(ctx, "test@beaver.com")
+
+	tu, err := user.SetTunnelUserPoolMaxSize(ctx, tunnelUser.ID, 5)
+	assert.NoError(t, err)
+	assert.Equal(t, 5, tu.PoolMaxSize)
+
+	_, err = user.SetTunnelUserPoolMaxSize(ctx, tunnelUser.ID, -1)
+	assert.Error(t, err)
+
+	_, err = user.SetTunnelUserPoolMaxSize(ctx, tunnelUser.ID+1, 5)
+	assert.Equal(t, ErrTunnelUserNotFound, err)
+}
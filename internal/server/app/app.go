@@ -1,6 +1,8 @@
 package app
 
 import (
+	"context"
+
 	"github.com/amalshaji/beaver/internal/server/admin"
 	"github.com/amalshaji/beaver/internal/server/db"
 	"github.com/amalshaji/beaver/internal/server/tunnel"
@@ -8,17 +10,21 @@ import (
 )
 
 type App struct {
-	DB     *gorm.DB
-	User   *admin.UserService
-	Server *tunnel.Server
+	DB      *gorm.DB
+	User    *admin.UserService
+	Tunnel  *admin.TunnelService
+	Capture *admin.CaptureService
+	Server  *tunnel.Server
 }
 
 func NewApp(configFile string) *App {
 	db := db.NewStore()
 	return &App{
-		DB:     db,
-		User:   admin.NewUserService(db),
-		Server: tunnel.NewServer(configFile, db),
+		DB:      db,
+		User:    admin.NewUserService(db),
+		Tunnel:  admin.NewTunnelService(db),
+		Capture: admin.NewCaptureService(db),
+		Server:  tunnel.NewServer(configFile, db),
 	}
 }
 
@@ -26,6 +32,12 @@ func (app *App) Start() {
 	app.Server.Start()
 }
 
+// Drain lets in-flight requests finish before shutdown,
+// reconnect tells the clients that a new process already accepts connections
+func (app *App) Drain(ctx context.Context, reconnect bool) {
+	app.Server.Drain(ctx, reconnect)
+}
+
 func (app *App) Shutdown() {
 	// Shutdown the tunnel server
 	app.Server.Shutdown()
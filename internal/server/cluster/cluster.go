@@ -0,0 +1,83 @@
+// Package cluster lets several beaver_server nodes run behind a load balancer.
+// Nodes share a registry of which node owns which subdomain, and a request
+// arriving at a node that does not hold the tunnel is forwarded to its owner.
+package cluster
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"time"
+)
+
+var ErrOwnedElsewhere = errors.New("subdomain is in use on another node")
+
+// Node is a beaver_server instance of the cluster
+type Node struct {
+	ID string
+	// Address other nodes use to reach this node, eg: http://10.0.0.1:8080
+	Addr string
+}
+
+// Registry keeps track of the node owning each subdomain
+type Registry interface {
+	// Claim marks the subdomain as owned by the local node.
+	// It fails with ErrOwnedElsewhere if another node owns it.
+	Claim(subdomain string) error
+	// Release gives up the ownership of the subdomain
+	Release(subdomain string)
+	// Lookup returns the node owning the subdomain, it may be the local node
+	Lookup(subdomain string) (Node, bool)
+	// Close stops the registry
+	Close()
+}
+
+// Config configures the cluster mode of a server
+type Config struct {
+	Enabled bool
+	NodeID  string
+	// Address other nodes use to reach this node
+	AdvertiseAddr string
+	// Addresses of the other nodes
+	Peers []string
+	// Shared secret authenticating node to node requests
+	Secret string
+	// Time between two gossip rounds (milliseconds)
+	GossipInterval int
+}
+
+// GetGossipInterval returns the time between two gossip rounds
+func (c Config) GetGossipInterval() time.Duration {
+	if c.GossipInterval <= 0 {
+		return time.Second
+	}
+	return time.Duration(c.GossipInterval) * time.Millisecond
+}
+
+// PeerIPs returns the IP addresses of the other nodes, their host names are resolved.
+// The requests they forward to each other carry the address of the client in X-Forwarded-For.
+func (c Config) PeerIPs() ([]string, error) {
+	var ips []string
+	for _, peer := range c.Peers {
+		URL, err := url.Parse(peer)
+		if err != nil || URL.Hostname() == "" {
+			return nil, fmt.Errorf("invalid cluster peer '%s', expected an URL, eg: http://10.0.0.2:8080", peer)
+		}
+		if ip := net.ParseIP(URL.Hostname()); ip != nil {
+			ips = append(ips, ip.String())
+			continue
+		}
+
+		resolved, err := net.LookupIP(URL.Hostname())
+		if err != nil {
+			log.Printf("Unable to resolve cluster peer %s : %v", peer, err)
+			continue
+		}
+		for _, ip := range resolved {
+			ips = append(ips, ip.String())
+		}
+	}
+	return ips, nil
+}
@@ -0,0 +1,213 @@
+package cluster
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryRegistry(t *testing.T) {
+	store := NewMemoryStore()
+	a := NewMemoryRegistry(store, Node{ID: "a", Addr: "http://a"})
+	b := NewMemoryRegistry(store, Node{ID: "b", Addr: "http://b"})
+
+	assert.NoError(t, a.Claim("test"))
+	// Claiming twice from the same node is fine
+	assert.NoError(t, a.Claim("test"))
+	assert.ErrorIs(t, b.Claim("test"), ErrOwnedElsewhere)
+
+	owner, ok := b.Lookup("test")
+	assert.True(t, ok)
+	assert.Equal(t, "a", owner.ID)
+
+	// Only the owner can release the subdomain
+	b.Release("test")
+	_, ok = a.Lookup("test")
+	assert.True(t, ok)
+
+	a.Release("test")
+	_, ok = a.Lookup("test")
+	assert.False(t, ok)
+	assert.NoError(t, b.Claim("test"))
+}
+
+// newGossipNodes starts registries peered with each other, each listening on an httptest server
+func newGossipNodes(t *testing.T, ids ...string) ([]*GossipRegistry, []*httptest.Server) {
+	registries := make([]*GossipRegistry, len(ids))
+	servers := make([]*httptest.Server, len(ids))
+	ready := make(chan struct{})
+
+	for i := range ids {
+		i := i
+		servers[i] = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			<-ready
+			registries[i].ServeHTTP(w, r)
+		}))
+		t.Cleanup(servers[i].Close)
+	}
+
+	for i, id := range ids {
+		var peers []string
+		for j, server := range servers {
+			if j != i {
+				peers = append(peers, server.URL)
+			}
+		}
+		registries[i] = NewGossipRegistry(Config{
+			Enabled:        true,
+			NodeID:         id,
+			AdvertiseAddr:  servers[i].URL,
+			Peers:          peers,
+			Secret:         "secret",
+			GossipInterval: 20,
+		}, nil)
+		t.Cleanup(registries[i].Close)
+	}
+	close(ready)
+
+	return registries, servers
+}
+
+func TestGossipRegistry(t *testing.T) {
+	nodes, servers := newGossipNodes(t, "a", "b")
+	a, b := nodes[0], nodes[1]
+
+	assert.NoError(t, a.Claim("test"))
+
+	assert.Eventually(t, func() bool {
+		owner, ok := b.Lookup("test")
+		return ok && owner.ID == "a" && owner.Addr == servers[0].URL
+	}, time.Second, 10*time.Millisecond)
+	assert.ErrorIs(t, b.Claim("test"), ErrOwnedElsewhere)
+
+	a.Release("test")
+	assert.Eventually(t, func() bool {
+		_, ok := b.Lookup("test")
+		return !ok
+	}, time.Second, 10*time.Millisecond)
+	assert.NoError(t, b.Claim("test"))
+}
+
+func TestGossipRegistryExpiresSilentNodes(t *testing.T) {
+	nodes, _ := newGossipNodes(t, "a", "b")
+	a, b := nodes[0], nodes[1]
+
+	assert.NoError(t, a.Claim("test"))
+	assert.Eventually(t, func() bool {
+		_, ok := b.Lookup("test")
+		return ok
+	}, time.Second, 10*time.Millisecond)
+
+	// The node goes away without releasing its subdomains
+	a.Close()
+	assert.Eventually(t, func() bool {
+		_, ok := b.Lookup("test")
+		return !ok
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestGossipRegistryConflictingClaims(t *testing.T) {
+	var lost []string
+	a := NewGossipRegistry(Config{NodeID: "a", Secret: "secret", GossipInterval: 20}, func(subdomain string) {
+		lost = append(lost, subdomain)
+	})
+	defer a.Close()
+
+	gossip := func(node string, claims ...claim) {
+		body, _ := json.Marshal(gossipMessage{Node: Node{ID: node, Addr: "http://" + node}, Claims: claims})
+		req := httptest.NewRequest(http.MethodPost, GossipPath, bytes.NewReader(body))
+		req.Header.Set(SecretHeader, "secret")
+		rec := httptest.NewRecorder()
+		a.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusNoContent, rec.Code)
+	}
+
+	// Both nodes claimed the subdomains before hearing from each other
+	assert.NoError(t, a.Claim("early"))
+	assert.NoError(t, a.Claim("late"))
+	assert.NoError(t, a.Claim("tie"))
+	claimed := a.local["tie"]
+	gossip("b",
+		claim{Subdomain: "early", Time: claimed.Add(-time.Second)},
+		claim{Subdomain: "late", Time: claimed.Add(time.Second)},
+		claim{Subdomain: "tie", Time: claimed},
+	)
+	gossip("0", claim{Subdomain: "tie", Time: claimed})
+
+	// The earliest claim wins, then the lowest node id
+	for subdomain, id := range map[string]string{"early": "b", "late": "a", "tie": "0"} {
+		owner, ok := a.Lookup(subdomain)
+		assert.True(t, ok, subdomain)
+		assert.Equal(t, id, owner.ID, subdomain)
+	}
+	assert.Equal(t, []string{"early", "tie"}, lost)
+}
+
+func TestGossipRejectsInvalidSecret(t *testing.T) {
+	_, servers := newGossipNodes(t, "a")
+
+	req, _ := http.NewRequest(http.MethodPost, servers[0].URL+GossipPath, nil)
+	req.Header.Set(SecretHeader, "invalid")
+	res, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+}
+
+func TestForward(t *testing.T) {
+	owner := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "test.beaver.local", r.Host)
+		assert.Equal(t, "a", r.Header.Get(ForwardedHeader))
+		assert.True(t, IsForwarded(r, "secret"))
+		assert.False(t, IsForwarded(r, "other"))
+		w.WriteHeader(http.StatusTeapot)
+		io.WriteString(w, r.URL.Path)
+	}))
+	defer owner.Close()
+
+	req := httptest.NewRequest(http.MethodGet, "http://test.beaver.local/hello", nil)
+	assert.False(t, IsForwarded(req, "secret"))
+
+	rec := httptest.NewRecorder()
+	err := Forward(Node{ID: "a"}, Node{ID: "b", Addr: owner.URL}, "secret", rec, req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusTeapot, rec.Code)
+	assert.Equal(t, "/hello", rec.Body.String())
+
+	// Unreachable node
+	rec = httptest.NewRecorder()
+	err = Forward(Node{ID: "a"}, Node{ID: "c", Addr: "http://127.0.0.1:1"}, "secret", rec, req)
+	assert.Error(t, err)
+}
+
+func TestIsForwardedRequiresTheSignature(t *testing.T) {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	signed := func(method, path, timestamp string) *http.Request {
+		req := httptest.NewRequest(method, "http://test.beaver.local"+path, nil)
+		req.Header.Set(ForwardedHeader, "a")
+		req.Header.Set(ForwardedSignatureHeader, timestamp+"."+forwardSignature("secret", http.MethodGet, "test.beaver.local", "/hello", "a", timestamp))
+		return req
+	}
+	assert.True(t, IsForwarded(signed(http.MethodGet, "/hello", timestamp), "secret"))
+
+	// The header alone is not enough
+	req := httptest.NewRequest(http.MethodGet, "http://test.beaver.local/hello", nil)
+	req.Header.Set(ForwardedHeader, "a")
+	assert.False(t, IsForwarded(req, "secret"))
+
+	// The signature covers the method and the path, and expires
+	assert.False(t, IsForwarded(signed(http.MethodPost, "/hello", timestamp), "secret"))
+	assert.False(t, IsForwarded(signed(http.MethodGet, "/admin", timestamp), "secret"))
+	expired := strconv.FormatInt(time.Now().Add(-2*forwardedMaxAge).Unix(), 10)
+	assert.False(t, IsForwarded(signed(http.MethodGet, "/hello", expired), "secret"))
+
+	// Without a secret, nothing is forwarded
+	assert.False(t, IsForwarded(signed(http.MethodGet, "/hello", timestamp), ""))
+}
@@ -0,0 +1,82 @@
+package cluster
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ForwardedHeader marks a request forwarded by another node, it carries the node id
+const ForwardedHeader = "X-BEAVER-FORWARDED"
+
+// ForwardedSignatureHeader authenticates a forwarded request, as timestamp.signature,
+// see forwardSignature
+const ForwardedSignatureHeader = "X-BEAVER-FORWARDED-SIGNATURE"
+
+// Maximum age of the signature of a forwarded request
+const forwardedMaxAge = time.Minute
+
+// IsForwarded reports whether the request was already forwarded by another node of the cluster.
+// Such a request must not be forwarded again, to avoid loops while the registry converges.
+// Anyone can set the headers, the request is only considered forwarded when it is signed with the secret.
+func IsForwarded(req *http.Request, secret string) bool {
+	node := req.Header.Get(ForwardedHeader)
+	timestamp, signature, ok := strings.Cut(req.Header.Get(ForwardedSignatureHeader), ".")
+	if secret == "" || node == "" || !ok {
+		return false
+	}
+
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return false
+	}
+	if age := time.Since(time.Unix(unix, 0)); age > forwardedMaxAge || age < -forwardedMaxAge {
+		return false
+	}
+
+	expected := forwardSignature(secret, req.Method, req.Host, req.URL.Path, node, timestamp)
+	return hmac.Equal([]byte(signature), []byte(expected))
+}
+
+// Forward proxies a request to the node owning its tunnel, keeping the original Host header.
+// The request is signed with the secret of the cluster.
+func Forward(from Node, to Node, secret string, w http.ResponseWriter, req *http.Request) error {
+	target, err := url.Parse(to.Addr)
+	if err != nil {
+		return fmt.Errorf("invalid address for node %s : %w", to.ID, err)
+	}
+
+	var proxyErr error
+	proxy := &httputil.ReverseProxy{
+		Director: func(r *http.Request) {
+			host := r.Host
+			r.URL.Scheme = target.Scheme
+			r.URL.Host = target.Host
+			r.Host = host
+
+			timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+			r.Header.Set(ForwardedHeader, from.ID)
+			r.Header.Set(ForwardedSignatureHeader, timestamp+"."+forwardSignature(secret, r.Method, host, r.URL.Path, from.ID, timestamp))
+		},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			proxyErr = fmt.Errorf("unable to forward request to node %s : %w", to.ID, err)
+		},
+	}
+	proxy.ServeHTTP(w, req)
+
+	return proxyErr
+}
+
+// forwardSignature is the hex encoded HMAC-SHA256 of a forwarded request
+func forwardSignature(secret, method, host, path, node, timestamp string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strings.Join([]string{method, host, path, node, timestamp}, "\n")))
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,243 @@
+package cluster
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SecretHeader authenticates node to node requests
+const SecretHeader = "X-CLUSTER-SECRET"
+
+// GossipPath is the endpoint receiving the state of the other nodes
+const GossipPath = "/cluster/gossip"
+
+// gossipMessage is the state a node pushes to its peers
+type gossipMessage struct {
+	Node   Node
+	Claims []claim
+}
+
+// claim is a subdomain owned by a node, and the time it claimed it
+type claim struct {
+	Subdomain string
+	Time      time.Time
+}
+
+type remoteOwner struct {
+	node    Node
+	claimed time.Time
+	expires time.Time
+}
+
+// GossipRegistry is a Registry where every node periodically pushes the subdomains
+// it owns to its peers. Entries of a node expire when it stops gossiping.
+//
+// Two nodes may claim the same subdomain before they hear from each other.
+// The earliest claim wins, or the lowest node id for claims made at the same time,
+// the other node gives the subdomain up and reports it to lost.
+type GossipRegistry struct {
+	config Config
+	node   Node
+	client *http.Client
+	lost   func(subdomain string)
+
+	local  map[string]time.Time
+	remote map[string]remoteOwner
+	lock   sync.RWMutex
+
+	done chan struct{}
+	once sync.Once
+}
+
+// NewGossipRegistry creates a GossipRegistry and starts gossiping.
+// lost is called with the subdomains claimed by another node first, it may be nil.
+func NewGossipRegistry(config Config, lost func(subdomain string)) *GossipRegistry {
+	r := &GossipRegistry{
+		config: config,
+		node:   Node{ID: config.NodeID, Addr: strings.TrimSuffix(config.AdvertiseAddr, "/")},
+		client: &http.Client{Timeout: 5 * time.Second},
+		lost:   lost,
+		local:  make(map[string]time.Time),
+		remote: make(map[string]remoteOwner),
+		done:   make(chan struct{}),
+	}
+	go r.loop()
+	return r
+}
+
+func (r *GossipRegistry) Claim(subdomain string) error {
+	r.lock.Lock()
+	if owner, ok := r.remote[subdomain]; ok && time.Now().Before(owner.expires) {
+		r.lock.Unlock()
+		return ErrOwnedElsewhere
+	}
+	if _, ok := r.local[subdomain]; !ok {
+		// Without the monotonic clock reading, the time compares like the claims of the peers
+		r.local[subdomain] = time.Now().Round(0)
+	}
+	r.lock.Unlock()
+
+	// Let the peers know right away instead of waiting for the next round
+	go r.gossip()
+	return nil
+}
+
+func (r *GossipRegistry) Release(subdomain string) {
+	r.lock.Lock()
+	delete(r.local, subdomain)
+	r.lock.Unlock()
+
+	go r.gossip()
+}
+
+func (r *GossipRegistry) Lookup(subdomain string) (Node, bool) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	if _, ok := r.local[subdomain]; ok {
+		return r.node, true
+	}
+	if owner, ok := r.remote[subdomain]; ok && time.Now().Before(owner.expires) {
+		return owner.node, true
+	}
+	return Node{}, false
+}
+
+func (r *GossipRegistry) Close() {
+	r.once.Do(func() { close(r.done) })
+}
+
+func (r *GossipRegistry) loop() {
+	ticker := time.NewTicker(r.config.GetGossipInterval())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.done:
+			return
+		case <-ticker.C:
+			r.gossip()
+			r.expire()
+		}
+	}
+}
+
+// gossip pushes the local state to every peer
+func (r *GossipRegistry) gossip() {
+	r.lock.RLock()
+	message := gossipMessage{Node: r.node, Claims: make([]claim, 0, len(r.local))}
+	for subdomain, claimed := range r.local {
+		message.Claims = append(message.Claims, claim{Subdomain: subdomain, Time: claimed})
+	}
+	r.lock.RUnlock()
+	sort.Slice(message.Claims, func(i, j int) bool { return message.Claims[i].Subdomain < message.Claims[j].Subdomain })
+
+	body, err := json.Marshal(message)
+	if err != nil {
+		return
+	}
+
+	for _, peer := range r.config.Peers {
+		req, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(peer, "/")+GossipPath, bytes.NewReader(body))
+		if err != nil {
+			log.Printf("Invalid cluster peer %s : %v", peer, err)
+			continue
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(SecretHeader, r.config.Secret)
+
+		res, err := r.client.Do(req)
+		if err != nil {
+			continue
+		}
+		res.Body.Close()
+	}
+}
+
+// expire forgets the subdomains of nodes which stopped gossiping
+func (r *GossipRegistry) expire() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	now := time.Now()
+	for subdomain, owner := range r.remote {
+		if now.After(owner.expires) {
+			delete(r.remote, subdomain)
+		}
+	}
+}
+
+// ServeHTTP receives the state of a peer
+func (r *GossipRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if r.config.Secret == "" || subtle.ConstantTimeCompare([]byte(req.Header.Get(SecretHeader)), []byte(r.config.Secret)) != 1 {
+		http.Error(w, "invalid cluster secret", http.StatusUnauthorized)
+		return
+	}
+
+	var message gossipMessage
+	if err := json.NewDecoder(req.Body).Decode(&message); err != nil {
+		http.Error(w, "invalid payload", http.StatusBadRequest)
+		return
+	}
+	if message.Node.ID == "" || message.Node.ID == r.node.ID {
+		http.Error(w, "invalid node", http.StatusBadRequest)
+		return
+	}
+
+	r.lock.Lock()
+	// Replace everything previously announced by this node
+	for subdomain, owner := range r.remote {
+		if owner.node.ID == message.Node.ID {
+			delete(r.remote, subdomain)
+		}
+	}
+	now := time.Now()
+	expires := now.Add(3 * r.config.GetGossipInterval())
+	var lost []string
+	for _, claim := range message.Claims {
+		// The peer gives the subdomain up when it hears from this node
+		if claimed, ok := r.local[claim.Subdomain]; ok {
+			if wins(r.node, claimed, message.Node, claim.Time) {
+				continue
+			}
+			delete(r.local, claim.Subdomain)
+			lost = append(lost, claim.Subdomain)
+		}
+		if owner, ok := r.remote[claim.Subdomain]; ok && now.Before(owner.expires) && wins(owner.node, owner.claimed, message.Node, claim.Time) {
+			continue
+		}
+		r.remote[claim.Subdomain] = remoteOwner{node: message.Node, claimed: claim.Time, expires: expires}
+	}
+	r.lock.Unlock()
+
+	for _, subdomain := range lost {
+		log.Printf("Subdomain %s was claimed first by node %s", subdomain, message.Node.ID)
+		if r.lost != nil {
+			r.lost(subdomain)
+		}
+	}
+	if len(lost) > 0 {
+		go r.gossip()
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// wins reports whether the claim of node a takes precedence over the one of node b
+func wins(a Node, aClaimed time.Time, b Node, bClaimed time.Time) bool {
+	if !aClaimed.Equal(bClaimed) {
+		return aClaimed.Before(bClaimed)
+	}
+	return a.ID < b.ID
+}
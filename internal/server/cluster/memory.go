@@ -0,0 +1,55 @@
+package cluster
+
+import "sync"
+
+// MemoryStore is the state shared by the MemoryRegistry of nodes running in the same process
+type MemoryStore struct {
+	owners map[string]Node
+	lock   sync.RWMutex
+}
+
+// NewMemoryStore creates a new MemoryStore
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{owners: make(map[string]Node)}
+}
+
+// MemoryRegistry is a Registry backed by a MemoryStore
+type MemoryRegistry struct {
+	store *MemoryStore
+	node  Node
+}
+
+// NewMemoryRegistry returns the view of a node on the store
+func NewMemoryRegistry(store *MemoryStore, node Node) *MemoryRegistry {
+	return &MemoryRegistry{store: store, node: node}
+}
+
+func (r *MemoryRegistry) Claim(subdomain string) error {
+	r.store.lock.Lock()
+	defer r.store.lock.Unlock()
+
+	if owner, ok := r.store.owners[subdomain]; ok && owner.ID != r.node.ID {
+		return ErrOwnedElsewhere
+	}
+	r.store.owners[subdomain] = r.node
+	return nil
+}
+
+func (r *MemoryRegistry) Release(subdomain string) {
+	r.store.lock.Lock()
+	defer r.store.lock.Unlock()
+
+	if owner, ok := r.store.owners[subdomain]; ok && owner.ID == r.node.ID {
+		delete(r.store.owners, subdomain)
+	}
+}
+
+func (r *MemoryRegistry) Lookup(subdomain string) (Node, bool) {
+	r.store.lock.RLock()
+	defer r.store.lock.RUnlock()
+
+	owner, ok := r.store.owners[subdomain]
+	return owner, ok
+}
+
+func (r *MemoryRegistry) Close() {}
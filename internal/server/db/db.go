@@ -22,7 +22,7 @@ func NewStore() *gorm.DB {
 	}
 
 	// should automigrate here?
-	db.AutoMigrate(&admin.AdminUser{}, &admin.TunnelUser{}, &admin.Session{})
+	db.AutoMigrate(&admin.AdminUser{}, &admin.TunnelUser{}, &admin.Session{}, &admin.TunnelSettings{}, &admin.CapturedRequest{}, &admin.ShareLink{})
 
 	return db
 }
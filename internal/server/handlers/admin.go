@@ -1,7 +1,9 @@
 package handler
 
 import (
+	"encoding/base64"
 	"errors"
+	"fmt"
 	"io/fs"
 	"log"
 	"net/http"
@@ -12,10 +14,13 @@ import (
 
 	"github.com/amalshaji/beaver/internal/server/admin"
 	"github.com/amalshaji/beaver/internal/server/app"
+	"github.com/amalshaji/beaver/internal/server/cluster"
+	"github.com/amalshaji/beaver/internal/server/oidc"
 	"github.com/amalshaji/beaver/internal/server/static"
 	"github.com/amalshaji/beaver/internal/server/tunnel"
 	"github.com/amalshaji/beaver/internal/server/web"
 	"github.com/amalshaji/beaver/internal/utils"
+	"github.com/amalshaji/beaver/internal/webhook"
 	"github.com/labstack/echo/v4"
 	"github.com/shirou/gopsutil/v3/cpu"
 	"github.com/shirou/gopsutil/v3/mem"
@@ -25,21 +30,98 @@ import (
 func register(c echo.Context) error {
 	app := c.Get("app").(*app.App)
 
-	subdomain := c.Request().Header.Get("X-TUNNEL-SUBDOMAIN")
-	if err := utils.ValidateSubdomain(subdomain); err != nil {
-		return utils.ProxyErrorf(c, "invalid subdomain: '%s'; %s", subdomain, utils.ErrInvalidSubdomain.Error())
+	if app.Server.IsDraining() {
+		return utils.HttpServiceUnavailable(c, "server is draining")
 	}
 
-	localServer := c.Request().Header.Get("X-LOCAL-SERVER")
-
+	// Unknown clients are refused before their tunnels are parsed, hashing their passwords is costly
 	secretKey := c.Request().Header.Get("X-SECRET-KEY")
-	greeting := c.Request().Header.Get("X-GREETING-MESSAGE")
-
 	tunnelUser, err := app.User.GetTunnelUserBySecret(c.Request().Context(), secretKey)
-	if err != nil && errors.Is(err, admin.ErrTunnelUserNotFound) {
+	if err != nil {
 		return utils.ProxyErrorf(c, "invalid secretKey - unregistered tunnel user")
 	}
 
+	// A client session registers all its tunnels at once, as comma separated lists
+	subdomains := strings.Split(c.Request().Header.Get("X-TUNNEL-SUBDOMAIN"), ",")
+	localServers := strings.Split(c.Request().Header.Get("X-LOCAL-SERVER"), ",")
+	if len(subdomains) != len(localServers) {
+		return utils.ProxyErrorf(c, "each subdomain requires a local server")
+	}
+
+	tunnels := make([]tunnel.Tunnel, 0, len(subdomains))
+	for i, subdomain := range subdomains {
+		subdomain = strings.TrimSpace(subdomain)
+		if err := utils.ValidateSubdomain(subdomain); err != nil {
+			return utils.ProxyErrorf(c, "invalid subdomain: '%s'; %s", subdomain, utils.ErrInvalidSubdomain.Error())
+		}
+		tunnels = append(tunnels, tunnel.Tunnel{Subdomain: subdomain, LocalServer: strings.TrimSpace(localServers[i])})
+	}
+
+	// Credentials of the tunnels protected with basic auth, as subdomain=base64(username:password)
+	for _, value := range c.Request().Header.Values("X-TUNNEL-AUTH") {
+		subdomain, encoded, _ := strings.Cut(value, "=")
+		credentials, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+		if err != nil {
+			return utils.ProxyErrorf(c, "invalid basic auth for '%s'", subdomain)
+		}
+		username, password, ok := strings.Cut(string(credentials), ":")
+		if !ok || username == "" || password == "" {
+			return utils.ProxyErrorf(c, "invalid basic auth for '%s'; %s", subdomain, admin.ErrInvalidBasicAuth)
+		}
+		for i := range tunnels {
+			if tunnels[i].Subdomain != strings.TrimSpace(subdomain) {
+				continue
+			}
+			tunnels[i].BasicAuth, err = app.Server.RegisteredBasicAuth(tunnels[i].Subdomain, username, password)
+			if err != nil {
+				return utils.ProxyError(c, err)
+			}
+		}
+	}
+
+	// Addresses the tunnels accept requests from, as subdomain=range range
+	for header, deny := range map[string]bool{"X-TUNNEL-ALLOW-IPS": false, "X-TUNNEL-DENY-IPS": true} {
+		for _, value := range c.Request().Header.Values(header) {
+			subdomain, ranges, _ := strings.Cut(value, "=")
+			for i := range tunnels {
+				if tunnels[i].Subdomain != strings.TrimSpace(subdomain) {
+					continue
+				}
+				if tunnels[i].IPFilter == nil {
+					tunnels[i].IPFilter = &admin.IPFilterSettings{}
+				}
+				if deny {
+					tunnels[i].IPFilter.Deny = append(tunnels[i].IPFilter.Deny, strings.Fields(ranges)...)
+				} else {
+					tunnels[i].IPFilter.Allow = append(tunnels[i].IPFilter.Allow, strings.Fields(ranges)...)
+				}
+				if err := tunnels[i].IPFilter.Validate(); err != nil {
+					return utils.ProxyErrorf(c, "invalid IP filter for '%s'; %s", subdomain, err)
+				}
+			}
+		}
+	}
+
+	// Signatures of the webhooks received by the tunnels, as subdomain=base64(spec), see webhook.ParseSpec
+	for _, value := range c.Request().Header.Values("X-TUNNEL-WEBHOOK") {
+		subdomain, encoded, _ := strings.Cut(value, "=")
+		decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+		if err != nil {
+			return utils.ProxyErrorf(c, "invalid webhook signature for '%s'", subdomain)
+		}
+		spec, err := webhook.ParseSpec(string(decoded))
+		if err != nil {
+			return utils.ProxyErrorf(c, "invalid webhook signature for '%s'; %s", subdomain, err)
+		}
+		for i := range tunnels {
+			if tunnels[i].Subdomain == strings.TrimSpace(subdomain) {
+				tunnels[i].Webhook = &spec
+			}
+		}
+	}
+
+	greeting := c.Request().Header.Get("X-GREETING-MESSAGE")
+
 	// Parse the greeting message
 	split := strings.Split(string(greeting), "_")
 	id := tunnel.PoolID(split[0])
@@ -53,16 +135,32 @@ func register(c echo.Context) error {
 	app.Server.Lock.Lock()
 	defer app.Server.Lock.Unlock()
 
-	pool, err := app.Server.GetOrCreatePoolForUser(subdomain, localServer, tunnelUser.Email, id)
+	// Refuse connections beyond the user's limit, whatever the client is configured with
+	maxSize := app.Server.PoolMaxSizeForUser(tunnelUser)
+	userConnections := app.Server.CountUserConnections(tunnelUser.Email)
+	if userConnections >= maxSize {
+		return utils.HttpTooManyRequests(c, "connection limit reached (%d)", maxSize)
+	}
+
+	pool, rejected, err := app.Server.GetOrCreatePoolForUser(tunnels, tunnelUser.Email, id)
 	if err != nil {
-		return utils.ProxyErrorf(c, "subdomain already in use")
+		return utils.ProxyErrorf(c, err.Error())
 	}
 
-	// update pool size
-	pool.SetSize(size)
+	// Advertise the pool sizes, connections of the user's other pools count against the limit
+	poolSize := pool.Size()
+	limits := pool.Limits(size, maxSize-userConnections+poolSize.Idle+poolSize.Busy)
 
 	// Upgrade the received HTTP request to a WebSocket connection
-	ws, err := app.Server.Upgrader.Upgrade(c.Response(), c.Request(), nil)
+	header := http.Header{
+		"X-POOL-IDLE-SIZE": {strconv.Itoa(limits.IdleSize)},
+		"X-POOL-MAX-SIZE":  {strconv.Itoa(limits.MaxSize)},
+	}
+	// Report the tunnels which could not be registered, the others are served anyway
+	for subdomain, err := range rejected {
+		header.Add("X-TUNNEL-REJECTED", fmt.Sprintf("%s: %s", subdomain, err))
+	}
+	ws, err := app.Server.Upgrader.Upgrade(c.Response(), c.Request(), header)
 	if err != nil {
 		return utils.ProxyErrorf(c, "HTTP upgrade error : %v", err)
 	}
@@ -79,10 +177,53 @@ func register(c echo.Context) error {
 	return nil
 }
 
+// control receives the WebSocket upgrade handshake request of the control channel of a client session
+func control(c echo.Context) error {
+	app := c.Get("app").(*app.App)
+
+	if app.Server.IsDraining() {
+		return utils.HttpServiceUnavailable(c, "server is draining")
+	}
+
+	secretKey := c.Request().Header.Get("X-SECRET-KEY")
+	id := tunnel.PoolID(c.Request().Header.Get("X-CLIENT-ID"))
+	if id == "" {
+		return utils.HttpBadRequest(c, "client id required")
+	}
+
+	tunnelUser, err := app.User.GetTunnelUserBySecret(c.Request().Context(), secretKey)
+	if err != nil {
+		return utils.ProxyErrorf(c, "invalid secretKey - unregistered tunnel user")
+	}
+	if err := app.Server.CheckClientID(id, tunnelUser.Email); err != nil {
+		return utils.HttpForbidden(c, err.Error())
+	}
+
+	ws, err := app.Server.Upgrader.Upgrade(c.Response(), c.Request(), nil)
+	if err != nil {
+		return utils.ProxyErrorf(c, "HTTP upgrade error : %v", err)
+	}
+
+	if _, err := app.Server.RegisterControlChannel(id, tunnelUser.Email, ws); err != nil {
+		log.Printf("Refusing control channel from %s : %v", id, err)
+	}
+
+	return nil
+}
+
 func status(c echo.Context) error {
 	return c.JSON(200, map[string]string{"message": "ok"})
 }
 
+// ready reports whether the server accepts new clients
+func ready(c echo.Context) error {
+	app := c.Get("app").(*app.App)
+	if app.Server.IsDraining() {
+		return utils.HttpServiceUnavailable(c, "server is draining")
+	}
+	return c.JSON(200, map[string]string{"message": "ok"})
+}
+
 var ErrAuthRequired = errors.New("authentication required")
 
 type AuthPayload struct {
@@ -127,6 +268,22 @@ func setupApiRoutes(e *echo.Echo) {
 	g.POST("/tunnel-users", createTunnelUser, authRequiredMiddleware)
 	g.PUT("/tunnel-users", rotateTunnelUserSecretKey, authRequiredMiddleware)
 	g.DELETE("/tunnel-users/:id", deleteTunnelUser, authRequiredMiddleware)
+	g.PUT("/tunnel-users/:id/limits", setTunnelUserLimits, authRequiredMiddleware)
+	g.GET("/tunnels", getTunnels, authRequiredMiddleware)
+	g.POST("/tunnels/:subdomain/messages", sendTunnelMessage, authRequiredMiddleware)
+	g.GET("/tunnels/:subdomain/settings", getTunnelSettings, authRequiredMiddleware)
+	g.PUT("/tunnels/:subdomain/settings", updateTunnelSettings, authRequiredMiddleware)
+	g.DELETE("/tunnels/:subdomain/settings", deleteTunnelSettings, authRequiredMiddleware)
+	g.GET("/requests", getCapturedRequests, authRequiredMiddleware)
+	g.DELETE("/requests", deleteCapturedRequests, authRequiredMiddleware)
+	g.GET("/requests/har", exportRequestsHar, authRequiredMiddleware)
+	g.GET("/requests/:id", getCapturedRequest, authRequiredMiddleware)
+	g.POST("/requests/:id/replay", replayCapturedRequest, authRequiredMiddleware)
+	g.GET("/oidc/callback", oidcCallback)
+	// Also open to the tunnel user serving the tunnel, with its secret key
+	g.GET("/tunnels/:subdomain/share-links", getShareLinks)
+	g.POST("/tunnels/:subdomain/share-links", createShareLink)
+	g.DELETE("/share-links/:id", revokeShareLink)
 }
 
 func superUserSignupApi(c echo.Context) error {
@@ -259,6 +416,13 @@ func rotateTunnelUserSecretKey(c echo.Context) error {
 	if err != nil {
 		return utils.HttpBadRequest(c, err.Error())
 	}
+
+	// Running clients keep their connections, but need the new key to reconnect
+	app.Server.NotifyUser(tunnelUser.Email, &utils.ControlMessage{
+		Type:    utils.ControlNotice,
+		Message: "Your secret key was rotated, update your client config before restarting it",
+	})
+
 	return c.JSON(http.StatusOK, map[string]string{"SecretKey": *tunnelUser.SecretKey})
 }
 
@@ -278,6 +442,161 @@ func deleteTunnelUser(c echo.Context) error {
 	return c.JSON(http.StatusOK, map[string]string{})
 }
 
+type tunnelUserLimitsPayload struct {
+	PoolMaxSize int
+}
+
+func setTunnelUserLimits(c echo.Context) error {
+	userId, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return utils.HttpBadRequest(c, "id must be a positive number")
+	}
+
+	var payload tunnelUserLimitsPayload
+	if err := c.Bind(&payload); err != nil {
+		return utils.HttpBadRequest(c, "invalid payload")
+	}
+
+	app := c.Get("app").(*app.App)
+	tunnelUser, err := app.User.SetTunnelUserPoolMaxSize(c.Request().Context(), uint(userId), payload.PoolMaxSize)
+	if err != nil {
+		return utils.HttpBadRequest(c, err.Error())
+	}
+
+	// Apply the new limit to the running clients
+	app.Server.NotifyUser(tunnelUser.Email, &utils.ControlMessage{
+		Type:        utils.ControlConfig,
+		PoolMaxSize: app.Server.PoolMaxSizeForUser(tunnelUser),
+	})
+	return c.JSON(http.StatusOK, tunnelUser)
+}
+
+func sendTunnelMessage(c echo.Context) error {
+	var payload utils.ControlMessage
+	if err := c.Bind(&payload); err != nil {
+		return utils.HttpBadRequest(c, "invalid payload")
+	}
+
+	app := c.Get("app").(*app.App)
+
+	app.Server.Lock.RLock()
+	pool, ok := app.Server.Pools[c.Param("subdomain")]
+	app.Server.Lock.RUnlock()
+	if !ok {
+		return utils.HttpBadRequest(c, "unregistered tunnel subdomain")
+	}
+
+	var err error
+	switch payload.Type {
+	case utils.ControlNotice, utils.ControlDrain:
+		err = app.Server.SendControlMessage(pool.ID, &payload)
+	case utils.ControlKick:
+		err = app.Server.Kick(pool.ID, payload.Message)
+	default:
+		return utils.HttpBadRequest(c, "unsupported message type: '%s'", payload.Type)
+	}
+	if err != nil {
+		return utils.HttpBadRequest(c, err.Error())
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"message": "ok"})
+}
+
+func getTunnels(c echo.Context) error {
+	app := c.Get("app").(*app.App)
+	return c.JSON(http.StatusOK, app.Server.ListPools())
+}
+
+// tunnelSettingsResponse is the form of the settings of a tunnel returned by the API, without its secrets
+type tunnelSettingsResponse struct {
+	*admin.TunnelSettings
+	BasicAuth *basicAuthResponse
+	Webhook   *webhookResponse
+}
+
+// basicAuthResponse only tells whether a password is set, the hash never leaves the server
+type basicAuthResponse struct {
+	Username    string
+	PasswordSet bool
+}
+
+// webhookResponse is the signature spec of a tunnel, it only tells whether a secret is set
+type webhookResponse struct {
+	Provider  string
+	SecretSet bool
+	Header    string
+	Algorithm string
+	Encoding  string
+	Prefix    string
+}
+
+func newTunnelSettingsResponse(tunnelSettings *admin.TunnelSettings) *tunnelSettingsResponse {
+	response := &tunnelSettingsResponse{TunnelSettings: tunnelSettings}
+	if tunnelSettings.BasicAuth != nil {
+		response.BasicAuth = &basicAuthResponse{
+			Username:    tunnelSettings.BasicAuth.Username,
+			PasswordSet: tunnelSettings.BasicAuth.PasswordHash != "",
+		}
+	}
+	if spec := tunnelSettings.Webhook; spec != nil {
+		response.Webhook = &webhookResponse{
+			Provider:  spec.Provider,
+			SecretSet: spec.Secret != "",
+			Header:    spec.Header,
+			Algorithm: spec.Algorithm,
+			Encoding:  spec.Encoding,
+			Prefix:    spec.Prefix,
+		}
+	}
+	return response
+}
+
+func getTunnelSettings(c echo.Context) error {
+	app := c.Get("app").(*app.App)
+	tunnelSettings, err := app.Tunnel.GetTunnelSettings(c.Request().Context(), c.Param("subdomain"))
+	if err != nil {
+		return utils.HttpBadRequest(c, err.Error())
+	}
+	return c.JSON(http.StatusOK, newTunnelSettingsResponse(tunnelSettings))
+}
+
+func updateTunnelSettings(c echo.Context) error {
+	var payload admin.TunnelSettings
+	if err := c.Bind(&payload); err != nil {
+		return utils.HttpBadRequest(c, "invalid payload")
+	}
+
+	app := c.Get("app").(*app.App)
+	if payload.OIDC != nil && payload.OIDC.Enabled && app.Server.OIDC == nil {
+		return utils.HttpBadRequest(c, oidc.ErrNotConfigured.Error())
+	}
+
+	tunnelSettings, err := app.Tunnel.UpdateTunnelSettings(c.Request().Context(), c.Param("subdomain"), &payload)
+	if err != nil {
+		return utils.HttpBadRequest(c, err.Error())
+	}
+
+	// Apply the new settings to the live tunnel
+	app.Server.ApplyTunnelSettings(tunnelSettings.Subdomain, tunnelSettings)
+
+	return c.JSON(http.StatusOK, newTunnelSettingsResponse(tunnelSettings))
+}
+
+func deleteTunnelSettings(c echo.Context) error {
+	app := c.Get("app").(*app.App)
+	subdomain := c.Param("subdomain")
+
+	err := app.Tunnel.DeleteTunnelSettings(c.Request().Context(), subdomain)
+	if err != nil {
+		return utils.HttpBadRequest(c, err.Error())
+	}
+
+	// Fall back to the server defaults
+	app.Server.ApplyTunnelSettings(subdomain, nil)
+
+	return c.JSON(http.StatusOK, map[string]string{})
+}
+
 func GetAdminHandler(app *app.App) *echo.Echo {
 	adminRouter := echo.New()
 
@@ -367,8 +686,15 @@ func GetAdminHandler(app *app.App) *echo.Echo {
 		return c.Blob(http.StatusOK, "image/x-icon", static.Favicon)
 	})
 
+	// Node to node endpoints
+	if handler, ok := app.Server.Registry.(http.Handler); ok {
+		adminRouter.POST(cluster.GossipPath, echo.WrapHandler(handler))
+	}
+
 	adminRouter.GET("/register", register)
+	adminRouter.GET("/control", control)
 	adminRouter.GET("/status", status)
+	adminRouter.GET("/ready", ready)
 
 	// Setup API routes
 	setupApiRoutes(adminRouter)
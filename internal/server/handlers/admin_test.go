@@ -0,0 +1,111 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/amalshaji/beaver/internal/server/admin"
+	"github.com/amalshaji/beaver/internal/server/app"
+	"github.com/amalshaji/beaver/internal/server/tunnel"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestDrainingRefusesClients(t *testing.T) {
+	server := new(tunnel.Server)
+	server.Config = tunnel.NewConfig()
+	handler := GetAdminHandler(&app.App{Server: server})
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/ready", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	server.Drain(context.Background(), false)
+
+	for _, path := range []string{"/ready", "/register", "/control"} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		assert.Equal(t, http.StatusServiceUnavailable, rec.Code, path)
+		assert.JSONEq(t, `{"error":"server is draining"}`, rec.Body.String(), path)
+	}
+
+	// The server is still alive
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/status", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
+func TestRegisterRefusesUnknownClientsFirst(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.AutoMigrate(admin.TunnelUser{})
+
+	server := new(tunnel.Server)
+	server.Config = tunnel.NewConfig()
+	handler := GetAdminHandler(&app.App{Server: server, User: admin.NewUserService(db)})
+
+	// The basic auth of the tunnels is not looked at
+	req := httptest.NewRequest(http.MethodGet, "/register", nil)
+	req.Header.Set("X-SECRET-KEY", "unknown")
+	req.Header.Set("X-TUNNEL-SUBDOMAIN", "web")
+	req.Header.Set("X-LOCAL-SERVER", "http://localhost:8000")
+	req.Header.Set("X-TUNNEL-AUTH", "web=invalid")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	assert.JSONEq(t, `{"error":"invalid secretKey - unregistered tunnel user"}`, rec.Body.String())
+}
+
+func TestTunnelSettingsHideTheSecrets(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.AutoMigrate(&admin.AdminUser{}, &admin.Session{}, &admin.TunnelSettings{})
+
+	users := admin.NewUserService(db)
+	if _, err := users.CreateSuperUser(context.Background(), "admin@beaver.com", "password"); err != nil {
+		t.Fatal(err)
+	}
+	sessionToken, err := users.Login(context.Background(), "admin@beaver.com", "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := new(tunnel.Server)
+	server.Config = tunnel.NewConfig()
+	handler := GetAdminHandler(&app.App{Server: server, User: users, Tunnel: admin.NewTunnelService(db)})
+
+	request := func(method, body string) map[string]any {
+		req := httptest.NewRequest(method, "/api/v1/tunnels/web/settings", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.AddCookie(&http.Cookie{Name: "beaver_session", Value: sessionToken})
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+		var settings map[string]any
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &settings))
+		return settings
+	}
+
+	updated := request(http.MethodPut, `{"BasicAuth": {"Username": "dev", "Password": "secret"}, "Webhook": {"Provider": "github", "Secret": "s3cr3t"}}`)
+	assert.Equal(t, map[string]any{"Username": "dev", "PasswordSet": true}, updated["BasicAuth"])
+	assert.Equal(t, map[string]any{"Provider": "github", "SecretSet": true, "Header": "", "Algorithm": "", "Encoding": "", "Prefix": ""}, updated["Webhook"])
+
+	settings := request(http.MethodGet, "")
+	assert.Equal(t, map[string]any{"Username": "dev", "PasswordSet": true}, settings["BasicAuth"])
+	assert.Equal(t, map[string]any{"Provider": "github", "SecretSet": true, "Header": "", "Algorithm": "", "Encoding": "", "Prefix": ""}, settings["Webhook"])
+
+	// The hash and the secret are still stored
+	stored, err := admin.NewTunnelService(db).GetTunnelSettings(context.Background(), "web")
+	assert.NoError(t, err)
+	assert.True(t, stored.BasicAuth.CheckCredentials("dev", "secret"))
+	assert.Equal(t, "s3cr3t", stored.Webhook.Secret)
+}
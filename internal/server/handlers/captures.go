@@ -0,0 +1,202 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/amalshaji/beaver/internal/har"
+	"github.com/amalshaji/beaver/internal/server/admin"
+	"github.com/amalshaji/beaver/internal/server/app"
+	"github.com/amalshaji/beaver/internal/server/tunnel"
+	"github.com/amalshaji/beaver/internal/traffic"
+	"github.com/amalshaji/beaver/internal/utils"
+	"github.com/labstack/echo/v4"
+)
+
+// captureFilter reads the filter of the captured requests from the query,
+// eg: ?subdomain=api&method=POST&status=5xx&path=/hooks&since=2023-01-02T15:04:05Z&limit=50
+func captureFilter(c echo.Context) (admin.CaptureFilter, error) {
+	filter := admin.CaptureFilter{
+		Subdomain: c.QueryParam("subdomain"),
+		Method:    c.QueryParam("method"),
+		Status:    c.QueryParam("status"),
+		Path:      c.QueryParam("path"),
+	}
+
+	var err error
+	if since := c.QueryParam("since"); since != "" {
+		if filter.Since, err = time.Parse(time.RFC3339, since); err != nil {
+			return filter, errors.New("since must be a RFC 3339 date")
+		}
+	}
+	if until := c.QueryParam("until"); until != "" {
+		if filter.Until, err = time.Parse(time.RFC3339, until); err != nil {
+			return filter, errors.New("until must be a RFC 3339 date")
+		}
+	}
+	if before := c.QueryParam("before"); before != "" {
+		id, err := strconv.ParseUint(before, 10, 0)
+		if err != nil {
+			return filter, errors.New("before must be a request id")
+		}
+		filter.Before = uint(id)
+	}
+	if limit := c.QueryParam("limit"); limit != "" {
+		if filter.Limit, err = strconv.Atoi(limit); err != nil || filter.Limit < 0 {
+			return filter, errors.New("limit must be a positive number")
+		}
+	}
+	return filter, nil
+}
+
+// getCapturedRequests lists the captured requests matching the filter, without their bodies
+func getCapturedRequests(c echo.Context) error {
+	filter, err := captureFilter(c)
+	if err != nil {
+		return utils.HttpBadRequest(c, err.Error())
+	}
+
+	app := c.Get("app").(*app.App)
+	captured, err := app.Capture.ListCapturedRequests(c.Request().Context(), filter)
+	if err != nil {
+		return utils.HttpBadRequest(c, err.Error())
+	}
+
+	summaries := make([]traffic.Summary, 0, len(captured))
+	for _, request := range captured {
+		summaries = append(summaries, request.Exchange().Summary())
+	}
+	return c.JSON(http.StatusOK, summaries)
+}
+
+func getCapturedRequest(c echo.Context) error {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 0)
+	if err != nil {
+		return utils.HttpBadRequest(c, "id must be a positive number")
+	}
+
+	app := c.Get("app").(*app.App)
+	captured, err := app.Capture.GetCapturedRequest(c.Request().Context(), uint(id))
+	if err != nil {
+		return utils.HttpBadRequest(c, err.Error())
+	}
+	return c.JSON(http.StatusOK, captured.Exchange())
+}
+
+// deleteCapturedRequests deletes the captured requests, ?subdomain= to delete the requests of one tunnel
+func deleteCapturedRequests(c echo.Context) error {
+	app := c.Get("app").(*app.App)
+	deleted, err := app.Capture.DeleteCapturedRequests(c.Request().Context(), c.QueryParam("subdomain"))
+	if err != nil {
+		return utils.HttpBadRequest(c, err.Error())
+	}
+	return c.JSON(http.StatusOK, map[string]int64{"deleted": deleted})
+}
+
+// replayCapturedRequest sends a captured request again through its live tunnel, the new exchange is captured as a replay of it
+func replayCapturedRequest(c echo.Context) error {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 0)
+	if err != nil {
+		return utils.HttpBadRequest(c, "id must be a positive number")
+	}
+
+	app := c.Get("app").(*app.App)
+	ctx := c.Request().Context()
+	captured, err := app.Capture.GetCapturedRequest(ctx, uint(id))
+	if err != nil {
+		return utils.HttpBadRequest(c, err.Error())
+	}
+
+	if app.Server.GetDestinationURL(captured.Subdomain) == "" {
+		if _, ok := app.Server.RemoteOwner(captured.Subdomain); !ok {
+			return utils.HttpBadRequest(c, "tunnel '%s' is not connected", captured.Subdomain)
+		}
+	}
+
+	// The request goes through the tunnel handler, as if it came from the internet.
+	// The captured requests have no credentials, replays skip the basic auth of the tunnel.
+	req, err := captured.Request.HTTPRequest(tunnel.WithoutAuth(tunnel.WithoutCapture(ctx)), "http://"+captured.Request.Host)
+	if err != nil {
+		return utils.HttpBadRequest(c, "unable to replay request : %s", err)
+	}
+	if req.Host == "" {
+		req.Host = captured.Subdomain + "." + app.Server.Config.Domain
+	}
+
+	writer := newCaptureWriter(app.Server.CaptureSettings(captured.Subdomain).MaxBodySize)
+	start := time.Now()
+	GetTunnelHandler(app).ServeHTTP(writer, req)
+
+	exchange := &traffic.Exchange{
+		Time:      start,
+		Duration:  time.Since(start),
+		Subdomain: captured.Subdomain,
+		Request:   captured.Request,
+		Response:  writer.response(),
+		ReplayOf:  int64(captured.ID),
+	}
+	replay := admin.NewCapturedRequest(exchange)
+	if err := app.Capture.SaveCapturedRequest(ctx, replay); err != nil {
+		return utils.HttpBadRequest(c, err.Error())
+	}
+	return c.JSON(http.StatusOK, replay.Exchange())
+}
+
+// exportRequestsHar downloads the captured requests matching the filter as a HAR file
+func exportRequestsHar(c echo.Context) error {
+	filter, err := captureFilter(c)
+	if err != nil {
+		return utils.HttpBadRequest(c, err.Error())
+	}
+
+	app := c.Get("app").(*app.App)
+	captured, err := app.Capture.ListCapturedRequests(c.Request().Context(), filter)
+	if err != nil {
+		return utils.HttpBadRequest(c, err.Error())
+	}
+
+	exchanges := make([]*traffic.Exchange, 0, len(captured))
+	for _, request := range captured {
+		exchanges = append(exchanges, request.Exchange())
+	}
+
+	c.Response().Header().Set(echo.HeaderContentType, "application/json")
+	c.Response().Header().Set(echo.HeaderContentDisposition, `attachment; filename="beaver.har"`)
+	c.Response().WriteHeader(http.StatusOK)
+	return har.New(har.Creator{Name: "beaver-server"}, exchanges).Write(c.Response())
+}
+
+// captureWriter is a http.ResponseWriter keeping the response of a replay, with its body truncated
+type captureWriter struct {
+	header     http.Header
+	statusCode int
+	body       *traffic.Capture
+}
+
+func newCaptureWriter(maxBodySize int64) *captureWriter {
+	return &captureWriter{header: make(http.Header), body: traffic.NewCapture(maxBodySize)}
+}
+
+func (w *captureWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *captureWriter) WriteHeader(statusCode int) {
+	if w.statusCode == 0 {
+		w.statusCode = statusCode
+	}
+}
+
+func (w *captureWriter) Write(b []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	return w.body.Write(b)
+}
+
+func (w *captureWriter) response() *traffic.Response {
+	w.WriteHeader(http.StatusOK)
+	response := &traffic.Response{StatusCode: w.statusCode, Header: w.header.Clone()}
+	response.SetBody(w.body)
+	return response
+}
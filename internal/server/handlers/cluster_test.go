@@ -0,0 +1,154 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/amalshaji/beaver/internal/server/admin"
+	"github.com/amalshaji/beaver/internal/server/app"
+	"github.com/amalshaji/beaver/internal/server/cluster"
+	"github.com/amalshaji/beaver/internal/server/tunnel"
+	"github.com/amalshaji/beaver/internal/utils"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// newClusterNode starts a server of a cluster on listener, with its own database and a tunnel user
+func newClusterNode(t *testing.T, id string, listener net.Listener, peer string) (*app.App, string) {
+	db, err := gorm.Open(sqlite.Open(fmt.Sprintf("file:%s-%s?mode=memory&cache=shared", t.Name(), id)), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.AutoMigrate(&admin.AdminUser{}, &admin.TunnelUser{}, &admin.Session{}, &admin.TunnelSettings{}, &admin.CapturedRequest{}, &admin.ShareLink{})
+
+	addr := "http://" + listener.Addr().String()
+	config := filepath.Join(t.TempDir(), "beaver_server.yaml")
+	err = os.WriteFile(config, []byte(fmt.Sprintf(`
+domain: beaver.test
+cluster:
+  enabled: true
+  nodeid: %s
+  advertiseaddr: %s
+  peers: [%s]
+  secret: secret
+  gossipinterval: 20
+`, id, addr, peer)), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	node := &app.App{
+		DB:      db,
+		User:    admin.NewUserService(db),
+		Tunnel:  admin.NewTunnelService(db),
+		Capture: admin.NewCaptureService(db),
+		Server:  tunnel.NewServer(config, db),
+	}
+	user, err := node.User.CreateTunnelUser(context.Background(), "test@beaver.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewUnstartedServer(GetHandler(node))
+	server.Listener.Close()
+	server.Listener = listener
+	server.Start()
+	node.Start()
+	t.Cleanup(func() {
+		server.Close()
+		node.Shutdown()
+	})
+
+	return node, *user.SecretKey
+}
+
+// serveTunnel registers a tunnel on a node and answers its requests with the received headers
+func serveTunnel(t *testing.T, addr string, secretKey string, subdomain string) {
+	header := http.Header{
+		"X-SECRET-KEY":       {secretKey},
+		"X-TUNNEL-SUBDOMAIN": {subdomain},
+		"X-LOCAL-SERVER":     {"http://localhost:8000"},
+		"X-GREETING-MESSAGE": {"session-1_1"},
+	}
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(addr, "http")+"/register", header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ws.Close() })
+
+	go func() {
+		for {
+			var request utils.HTTPRequest
+			if err := ws.ReadJSON(&request); err != nil {
+				return
+			}
+			if _, _, err := ws.NextReader(); err != nil {
+				return
+			}
+
+			body, _ := json.Marshal(request.Header)
+			ws.WriteJSON(utils.HTTPResponse{StatusCode: http.StatusOK, Header: http.Header{"Content-Type": {"application/json"}}, ContentLength: int64(len(body))})
+			ws.WriteMessage(websocket.BinaryMessage, body)
+		}
+	}()
+}
+
+func TestForwardToTheNodeOfTheTunnel(t *testing.T) {
+	listeners := make([]net.Listener, 2)
+	for i := range listeners {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		listeners[i] = listener
+	}
+	addrA, addrB := "http://"+listeners[0].Addr().String(), "http://"+listeners[1].Addr().String()
+
+	nodeA, secretKey := newClusterNode(t, "node-a", listeners[0], addrB)
+	nodeB, _ := newClusterNode(t, "node-b", listeners[1], addrA)
+
+	// The tunnel is connected to node A, node B learns about it from the gossip
+	serveTunnel(t, addrA, secretKey, "web")
+	assert.Eventually(t, func() bool {
+		owner, ok := nodeB.Server.RemoteOwner("web")
+		return ok && owner.ID == nodeA.Server.Node.ID
+	}, time.Second, 10*time.Millisecond)
+
+	// The visitor reaches node B, which forwards the request to node A
+	req, _ := http.NewRequest(http.MethodGet, addrB+"/hello", nil)
+	req.Host = "web.beaver.test"
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+
+	var received http.Header
+	assert.NoError(t, json.NewDecoder(res.Body).Decode(&received))
+	assert.Equal(t, "node-b", received.Get(cluster.ForwardedHeader))
+
+	// A request claiming to be forwarded without the signature is forwarded again
+	req, _ = http.NewRequest(http.MethodGet, addrB+"/hello", nil)
+	req.Host = "web.beaver.test"
+	req.Header.Set(cluster.ForwardedHeader, "node-a")
+	res, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.Copy(io.Discard, res.Body)
+	res.Body.Close()
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+}
@@ -0,0 +1,71 @@
+package handler
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/amalshaji/beaver/internal/server/app"
+	"github.com/amalshaji/beaver/internal/server/oidc"
+	"github.com/amalshaji/beaver/internal/utils"
+	"github.com/labstack/echo/v4"
+)
+
+// oidcCallback receives the users logged in by the provider, and sends them back to their tunnel
+func oidcCallback(c echo.Context) error {
+	app := c.Get("app").(*app.App)
+	if app.Server.OIDC == nil {
+		return utils.HttpBadRequest(c, oidc.ErrNotConfigured.Error())
+	}
+
+	if err := app.Server.OIDC.Callback(c.Response(), c.Request()); err != nil {
+		log.Printf("OpenID Connect login failed : %v", err)
+		return utils.HttpUnauthorized(c, err.Error())
+	}
+	return nil
+}
+
+// oidcGate lets the users allowed on a tunnel restricted with OpenID Connect through, with their identity
+// in the headers of the request, and sends the others to the provider. It returns false when it answered the request.
+// The visitors of a share link go through without an identity.
+func oidcGate(c echo.Context, app *app.App, subdomain string, shared bool) (bool, error) {
+	req := c.Request()
+	policy, ok := app.Server.OIDCPolicy(subdomain, req)
+	if !ok {
+		return true, nil
+	}
+	gate := app.Server.OIDC
+
+	if shared {
+		oidc.Forward(req, nil)
+		return true, nil
+	}
+
+	if req.URL.Path == oidc.SessionPath {
+		err := gate.StartSession(c.Response(), req, subdomain, policy)
+		switch {
+		case errors.Is(err, oidc.ErrForbidden):
+			return false, utils.HttpForbidden(c, err.Error())
+		case err != nil:
+			return false, utils.HttpUnauthorized(c, "login failed, %s", err)
+		}
+		return false, nil
+	}
+
+	if identity, ok := gate.Identity(req, subdomain); ok {
+		if !policy.Allows(identity) {
+			return false, utils.HttpForbidden(c, oidc.ErrForbidden.Error())
+		}
+		oidc.Forward(req, identity)
+		return true, nil
+	}
+
+	// Only the pages a browser navigates to can go through the login
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return false, utils.HttpUnauthorized(c, "login required")
+	}
+	if err := gate.Login(c.Response(), req); err != nil {
+		return false, utils.ProxyError(c, err)
+	}
+	return false, nil
+}
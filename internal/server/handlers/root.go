@@ -2,50 +2,75 @@ package handler
 
 import (
 	"context"
+	"errors"
 	"log"
+	"net"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
 	"github.com/amalshaji/beaver/internal/server/app"
+	"github.com/amalshaji/beaver/internal/server/upgrade"
 	"github.com/labstack/echo/v4"
 )
 
 func Start(configFile string) {
-	e := echo.New()
-	e.HideBanner = true
-
 	_app := app.NewApp(configFile)
+	e := GetHandler(_app)
 
-	adminHandler := GetAdminHandler(_app)
-	tunnelHandler := GetTunnelHandler(_app)
-
-	e.Any("/*", func(c echo.Context) error {
-		req := c.Request()
-		res := c.Response()
+	// Reuse the listener of the previous process after a binary upgrade
+	listener, err := upgrade.Listen(_app.Server.Config.GetAddr())
+	if err != nil {
+		log.Fatal(err)
+	}
+	e.Listener = listener
 
-		_, err := _app.Server.GetSubdomainFromHost(req.Host)
-		if err != nil {
-			adminHandler.ServeHTTP(res, req)
-		} else {
-			tunnelHandler.ServeHTTP(res, req)
+	go func() {
+		err := e.Start(_app.Server.Config.GetAddr())
+		// The listener is closed on purpose once a new process took over
+		if err != nil && !errors.Is(err, http.ErrServerClosed) && !errors.Is(err, net.ErrClosed) {
+			log.Fatal(err)
 		}
-		return nil
-	})
-
-	go func() { log.Fatal(e.Start(_app.Server.Config.GetAddr())) }()
+	}()
 
 	// Start the app
 	_app.Start()
 
+	// Let the previous process drain its tunnels
+	if err := upgrade.Ready(); err != nil {
+		log.Printf("Unable to notify the previous process : %v", err)
+	}
+
 	// Wait signals
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
-	<-quit
+	upgrade.Notify(quit)
 
-	// Shutdown the app
-	_app.Shutdown()
+	replaced := false
+	for !replaced {
+		sig := <-quit
+		if !upgrade.IsUpgradeSignal(sig) {
+			break
+		}
+
+		log.Println("Starting a new process for the upgrade")
+		if _, err := upgrade.Spawn(listener, 30*time.Second); err != nil {
+			log.Printf("Upgrade failed : %v", err)
+			continue
+		}
+
+		// New connections go to the new process from now on
+		listener.Close()
+		replaced = true
+	}
+
+	// Stop accepting new clients and let in-flight requests finish.
+	// If a new process took over, the clients reconnect to it right away.
+	drainCtx, cancelDrain := context.WithTimeout(context.Background(), _app.Server.Config.GetDrainTimeout())
+	defer cancelDrain()
+	_app.Drain(drainCtx, replaced)
 
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
@@ -54,4 +79,30 @@ func Start(configFile string) {
 	if err := e.Shutdown(ctx); err != nil {
 		e.Logger.Fatal(err)
 	}
+
+	// Shutdown the app, closing the remaining tunnel connections
+	_app.Shutdown()
+}
+
+// GetHandler routes the requests for a subdomain to its tunnel, and the others to the admin
+func GetHandler(_app *app.App) *echo.Echo {
+	e := echo.New()
+	e.HideBanner = true
+
+	adminHandler := GetAdminHandler(_app)
+	tunnelHandler := GetTunnelHandler(_app)
+
+	e.Any("/*", func(c echo.Context) error {
+		req := c.Request()
+		res := c.Response()
+
+		_, err := _app.Server.GetSubdomainFromHost(req.Host)
+		if err != nil {
+			adminHandler.ServeHTTP(res, req)
+		} else {
+			tunnelHandler.ServeHTTP(res, req)
+		}
+		return nil
+	})
+	return e
 }
@@ -0,0 +1,174 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/amalshaji/beaver/internal/server/admin"
+	"github.com/amalshaji/beaver/internal/server/app"
+	"github.com/amalshaji/beaver/internal/server/tunnel"
+	"github.com/amalshaji/beaver/internal/utils"
+	"github.com/labstack/echo/v4"
+)
+
+// Key of the share link granting access to a request, in the echo context
+const shareLinkKey = "shareLink"
+
+// ShareLinkPayload creates a share link, Duration is a Go duration, eg: 24h
+type ShareLinkPayload struct {
+	Duration string
+	Note     string
+}
+
+// shareLinkResponse is a share link along with its URL, which is only known when it is created
+type shareLinkResponse struct {
+	*admin.ShareLink
+	URL string `json:"URL,omitempty"`
+}
+
+// shareLinkUser returns who manages the share links of a tunnel: an admin, or the tunnel user serving it
+func shareLinkUser(c echo.Context, subdomain string) (string, error) {
+	app := c.Get("app").(*app.App)
+	ctx := c.Request().Context()
+
+	if sessionToken, err := c.Request().Cookie("beaver_session"); err == nil {
+		if adminUser, err := app.User.ValidateSession(ctx, sessionToken.Value); err == nil {
+			return adminUser.Email, nil
+		}
+	}
+
+	tunnelUser, err := app.User.GetTunnelUserBySecret(ctx, c.Request().Header.Get("X-SECRET-KEY"))
+	if err != nil {
+		return "", ErrAuthRequired
+	}
+	if owner, ok := app.Server.TunnelOwner(subdomain); !ok || owner != tunnelUser.Email {
+		return "", fmt.Errorf("tunnel '%s' is not connected with this secret key", subdomain)
+	}
+	return tunnelUser.Email, nil
+}
+
+func createShareLink(c echo.Context) error {
+	subdomain := c.Param("subdomain")
+	user, err := shareLinkUser(c, subdomain)
+	if err != nil {
+		return utils.HttpUnauthorized(c, err.Error())
+	}
+
+	var payload ShareLinkPayload
+	if err := c.Bind(&payload); err != nil {
+		return utils.HttpBadRequest(c, "invalid payload")
+	}
+	duration := 24 * time.Hour
+	if payload.Duration != "" {
+		if duration, err = time.ParseDuration(payload.Duration); err != nil {
+			return utils.HttpBadRequest(c, "invalid duration: '%s'", payload.Duration)
+		}
+	}
+
+	app := c.Get("app").(*app.App)
+	link, err := app.Server.ShareLinks.CreateShareLink(c.Request().Context(), subdomain, duration, user, payload.Note)
+	if err != nil {
+		return utils.HttpBadRequest(c, err.Error())
+	}
+	shareURL, err := app.Server.ShareLinkURL(link)
+	if err != nil {
+		return utils.HttpBadRequest(c, err.Error())
+	}
+	return c.JSON(http.StatusOK, shareLinkResponse{ShareLink: link, URL: shareURL})
+}
+
+func getShareLinks(c echo.Context) error {
+	subdomain := c.Param("subdomain")
+	if _, err := shareLinkUser(c, subdomain); err != nil {
+		return utils.HttpUnauthorized(c, err.Error())
+	}
+
+	app := c.Get("app").(*app.App)
+	links, err := app.Server.ShareLinks.ListShareLinks(c.Request().Context(), subdomain)
+	if err != nil {
+		return utils.HttpBadRequest(c, err.Error())
+	}
+	return c.JSON(http.StatusOK, links)
+}
+
+func revokeShareLink(c echo.Context) error {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 0)
+	if err != nil {
+		return utils.HttpBadRequest(c, "id must be a positive number")
+	}
+
+	app := c.Get("app").(*app.App)
+	ctx := c.Request().Context()
+	link, err := app.Server.ShareLinks.GetShareLink(ctx, uint(id))
+	if err != nil {
+		return utils.HttpBadRequest(c, err.Error())
+	}
+	if _, err := shareLinkUser(c, link.Subdomain); err != nil {
+		return utils.HttpUnauthorized(c, err.Error())
+	}
+
+	link, err = app.Server.ShareLinks.RevokeShareLink(ctx, link.ID)
+	if err != nil {
+		return utils.HttpBadRequest(c, err.Error())
+	}
+	return c.JSON(http.StatusOK, link)
+}
+
+// shareLinks lets the visitors of a share link through the login gates of its tunnel.
+// The token of the link is traded for a cookie on the first visit, which is checked on each request
+// so that a revoked link stops working right away.
+func shareLinks(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		app := c.Get("app").(*app.App)
+		req := c.Request()
+
+		subdomain, err := app.Server.GetSubdomainFromHost(req.Host)
+		if err != nil {
+			return next(c)
+		}
+
+		if token := req.URL.Query().Get(tunnel.ShareLinkParam); token != "" {
+			link, err := app.Server.VerifyShareLink(req.Context(), token, subdomain)
+			if err != nil {
+				return utils.HttpForbidden(c, err.Error())
+			}
+
+			c.SetCookie(&http.Cookie{
+				Name:     tunnel.ShareLinkCookie,
+				Value:    token,
+				Path:     "/",
+				Expires:  link.ExpiresAt,
+				HttpOnly: true,
+				Secure:   app.Server.Config.Secure,
+				SameSite: http.SameSiteLaxMode,
+			})
+
+			// Drop the token from the address bar
+			location := *req.URL
+			query := location.Query()
+			query.Del(tunnel.ShareLinkParam)
+			location.RawQuery = query.Encode()
+			return c.Redirect(http.StatusFound, location.RequestURI())
+		}
+
+		if cookie, err := req.Cookie(tunnel.ShareLinkCookie); err == nil {
+			if link, err := app.Server.VerifyShareLink(req.Context(), cookie.Value, subdomain); err == nil {
+				c.Set(shareLinkKey, link)
+			}
+		}
+		return next(c)
+	}
+}
+
+// removeCookie removes a cookie from a request, before it is sent to the local server
+func removeCookie(req *http.Request, name string) {
+	cookies := req.Cookies()
+	req.Header.Del("Cookie")
+	for _, cookie := range cookies {
+		if cookie.Name != name {
+			req.AddCookie(cookie)
+		}
+	}
+}
@@ -1,11 +1,14 @@
 package handler
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"net/url"
 
+	"github.com/amalshaji/beaver/internal/server/admin"
 	"github.com/amalshaji/beaver/internal/server/app"
+	"github.com/amalshaji/beaver/internal/server/cluster"
 	"github.com/amalshaji/beaver/internal/server/tunnel"
 	"github.com/amalshaji/beaver/internal/utils"
 	"github.com/labstack/echo/v4"
@@ -29,9 +32,51 @@ func request(c echo.Context) error {
 	var dstURL string
 
 	if dstURL = app.Server.GetDestinationURL(subdomain); dstURL == "" {
+		// The tunnel might be connected to another node of the cluster
+		if node, ok := app.Server.RemoteOwner(subdomain); ok && !cluster.IsForwarded(c.Request(), app.Server.Config.Cluster.Secret) {
+			log.Printf("[%s] forwarding %s to node %s", c.Request().Method, subdomain, node.ID)
+			if !app.Server.BeginRequest() {
+				return utils.ProxyErrorf(c, "server is shutting down")
+			}
+			defer app.Server.EndRequest()
+
+			if err := cluster.Forward(app.Server.Node, node, app.Server.Config.Cluster.Secret, c.Response(), c.Request()); err != nil {
+				return utils.ProxyError(c, err)
+			}
+			return nil
+		}
 		return utils.ProxyErrorf(c, "unregistered tunnel subdomain")
 	}
 
+	// Tunnels restricted to some addresses, eg: the ranges of a webhook provider
+	if !app.Server.AllowsIP(subdomain, c.Request(), c.RealIP()) {
+		log.Printf("[%s] %s refused request from %s", c.Request().Method, subdomain, c.RealIP())
+		return utils.HttpForbidden(c, "your address is not allowed to access this tunnel")
+	}
+
+	// Webhooks are verified before they use a connection of the tunnel
+	if err := app.Server.VerifyWebhook(subdomain, c.Request()); err != nil {
+		log.Printf("[%s] %s rejected webhook from %s: %s", c.Request().Method, subdomain, c.RealIP(), err)
+		return utils.HttpUnauthorized(c, err.Error())
+	}
+
+	// The visitors of a share link skip the login gates
+	_, shared := c.Get(shareLinkKey).(*admin.ShareLink)
+	removeCookie(c.Request(), tunnel.ShareLinkCookie)
+
+	// Tunnels protected with basic auth are not reachable without their credentials
+	if err := app.Server.AuthorizeRequest(subdomain, c.Request(), c.RealIP()); err != nil && !shared {
+		if errors.Is(err, tunnel.ErrTooManyLogins) {
+			log.Printf("[%s] %s refused login from %s: %s", c.Request().Method, subdomain, c.RealIP(), err)
+			return utils.HttpTooManyRequests(c, err.Error())
+		}
+		c.Response().Header().Set(echo.HeaderWWWAuthenticate, `Basic realm="beaver", charset="UTF-8"`)
+		return utils.HttpUnauthorized(c, err.Error())
+	}
+	if ok, err := oidcGate(c, app, subdomain, shared); !ok {
+		return err
+	}
+
 	dstURL = fmt.Sprintf("%s/%s", dstURL, c.Param("*"))
 
 	if c.QueryString() != "" {
@@ -51,6 +96,12 @@ func request(c echo.Context) error {
 		return utils.ProxyErrorf(c, "No proxy available")
 	}
 
+	// Keep track of the request until it is done, so a drain can wait for it
+	if !app.Server.BeginRequest() {
+		return utils.ProxyErrorf(c, "server is shutting down")
+	}
+	defer app.Server.EndRequest()
+
 	// [2]: Take an WebSocket connection available from pools for relaying received requests.
 	request := tunnel.NewConnectionRequest(app.Server.Config.GetTimeout(), subdomain)
 	// "Dispatcher" is running in a separate thread from the server by `go s.dispatchConnections()`.
@@ -73,7 +124,7 @@ func request(c echo.Context) error {
 	}
 
 	// [3]: Send the request to the peer through the WebSocket connection.
-	if err := connection.ProxyRequest(c); err != nil {
+	if err := connection.ProxyRequest(c, subdomain); err != nil {
 		// An error occurred throw the connection away
 		log.Println(err)
 		connection.Close()
@@ -87,6 +138,8 @@ func request(c echo.Context) error {
 
 func GetTunnelHandler(app *app.App) *echo.Echo {
 	tunnelRouter := echo.New()
+	// Validated when the server starts
+	tunnelRouter.IPExtractor, _ = app.Server.Config.IPExtractor()
 
 	tunnelRouter.Use(func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
@@ -94,6 +147,7 @@ func GetTunnelHandler(app *app.App) *echo.Echo {
 			return next(c)
 		}
 	})
+	tunnelRouter.Use(shareLinks)
 
 	tunnelRouter.GET("*", request)
 	tunnelRouter.POST("*", request)
@@ -0,0 +1,208 @@
+package oidc
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// SessionPath is the path of the tunnels where the login flow ends, it sets the session cookie
+	SessionPath = "/_beaver/oidc/session"
+
+	SessionCookie = "beaver_oidc"
+	nonceCookie   = "beaver_oidc_nonce"
+
+	// Identity of the user, forwarded to the local server
+	HeaderSubject = "X-Beaver-User-Sub"
+	HeaderEmail   = "X-Beaver-User-Email"
+	HeaderName    = "X-Beaver-User-Name"
+
+	// Time to complete the login on the provider
+	stateTTL = 10 * time.Minute
+	// Time to follow the redirection from the callback to the tunnel
+	ticketTTL = time.Minute
+)
+
+var ErrForbidden = errors.New("you are not allowed to access this tunnel")
+
+// state is carried through the provider, from the tunnel to the callback
+type state struct {
+	Host  string `json:"host"`
+	Path  string `json:"path"`
+	Nonce string `json:"nonce"`
+}
+
+// ticket carries the user from the callback, on the domain of the server, back to the tunnel
+type ticket struct {
+	Identity *Identity `json:"identity"`
+	Host     string    `json:"host"`
+	Path     string    `json:"path"`
+	Nonce    string    `json:"nonce"`
+}
+
+// session is the value of the session cookie of a tunnel
+type session struct {
+	Identity  *Identity `json:"identity"`
+	Subdomain string    `json:"subdomain"`
+}
+
+// Gate runs the login flow in front of the tunnels:
+//  1. Login redirects a visitor of a tunnel to the provider
+//  2. Callback receives the code on the domain of the server, and redirects back to the tunnel with a ticket
+//  3. StartSession trades the ticket for a session cookie on the tunnel subdomain
+type Gate struct {
+	provider    *Provider
+	signer      *Signer
+	redirectURL string
+	secure      bool
+	duration    time.Duration
+
+	// Users allowed on the tunnels without their own policy
+	Policy Policy
+}
+
+func NewGate(config Config, redirectURL string, secure bool) *Gate {
+	if config.RedirectURL != "" {
+		redirectURL = config.RedirectURL
+	}
+	return &Gate{
+		provider:    NewProvider(config),
+		signer:      NewSigner(config.Secret),
+		redirectURL: redirectURL,
+		secure:      secure,
+		duration:    config.GetSessionDuration(),
+		Policy:      Policy{AllowedDomains: config.AllowedDomains, AllowedEmails: config.AllowedEmails},
+	}
+}
+
+// Login redirects a visitor to the provider, the nonce cookie makes sure the login ends in the same browser
+func (g *Gate) Login(w http.ResponseWriter, r *http.Request) error {
+	nonce := randomString()
+	token, err := g.signer.Sign("state", state{Host: r.Host, Path: r.URL.RequestURI(), Nonce: nonce}, stateTTL)
+	if err != nil {
+		return err
+	}
+	authURL, err := g.provider.AuthCodeURL(r.Context(), g.redirectURL, token)
+	if err != nil {
+		return err
+	}
+
+	http.SetCookie(w, g.cookie(nonceCookie, nonce, stateTTL))
+	http.Redirect(w, r, authURL, http.StatusFound)
+	return nil
+}
+
+// Callback receives the user from the provider, and sends them back to the tunnel they came from
+func (g *Gate) Callback(w http.ResponseWriter, r *http.Request) error {
+	query := r.URL.Query()
+	if reason := query.Get("error"); reason != "" {
+		if description := query.Get("error_description"); description != "" {
+			reason += ": " + description
+		}
+		return errors.New("login failed, " + reason)
+	}
+
+	var s state
+	if err := g.signer.Verify("state", query.Get("state"), &s); err != nil {
+		return err
+	}
+	identity, err := g.provider.Exchange(r.Context(), g.redirectURL, query.Get("code"))
+	if err != nil {
+		return err
+	}
+
+	token, err := g.signer.Sign("ticket", ticket{Identity: identity, Host: s.Host, Path: s.Path, Nonce: s.Nonce}, ticketTTL)
+	if err != nil {
+		return err
+	}
+	scheme := "http"
+	if g.secure {
+		scheme = "https"
+	}
+	sessionURL := url.URL{Scheme: scheme, Host: s.Host, Path: SessionPath, RawQuery: url.Values{"ticket": {token}}.Encode()}
+	http.Redirect(w, r, sessionURL.String(), http.StatusFound)
+	return nil
+}
+
+// StartSession sets the session cookie of a tunnel from the ticket of the callback, if the policy allows the user
+func (g *Gate) StartSession(w http.ResponseWriter, r *http.Request, subdomain string, policy Policy) error {
+	var t ticket
+	if err := g.signer.Verify("ticket", r.URL.Query().Get("ticket"), &t); err != nil {
+		return err
+	}
+	nonce, err := r.Cookie(nonceCookie)
+	if err != nil || t.Host != r.Host || nonce.Value != t.Nonce {
+		return ErrInvalidToken
+	}
+	if !policy.Allows(t.Identity) {
+		return ErrForbidden
+	}
+
+	token, err := g.signer.Sign("session", session{Identity: t.Identity, Subdomain: subdomain}, g.duration)
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, g.cookie(SessionCookie, token, g.duration))
+	http.SetCookie(w, g.cookie(nonceCookie, "", -1))
+
+	path := t.Path
+	if !strings.HasPrefix(path, "/") || strings.HasPrefix(path, "//") {
+		path = "/"
+	}
+	http.Redirect(w, r, path, http.StatusFound)
+	return nil
+}
+
+// Identity returns the user of a request to a tunnel, from its session cookie
+func (g *Gate) Identity(r *http.Request, subdomain string) (*Identity, bool) {
+	cookie, err := r.Cookie(SessionCookie)
+	if err != nil {
+		return nil, false
+	}
+	var s session
+	if err := g.signer.Verify("session", cookie.Value, &s); err != nil || s.Subdomain != subdomain || s.Identity == nil {
+		return nil, false
+	}
+	return s.Identity, true
+}
+
+// Forward prepares a request for the local server: the cookies of the gate are removed,
+// and the identity headers are replaced by the ones of the user
+func Forward(r *http.Request, identity *Identity) {
+	cookies := r.Cookies()
+	r.Header.Del("Cookie")
+	for _, cookie := range cookies {
+		if cookie.Name != SessionCookie && cookie.Name != nonceCookie {
+			r.AddCookie(cookie)
+		}
+	}
+
+	r.Header.Del(HeaderSubject)
+	r.Header.Del(HeaderEmail)
+	r.Header.Del(HeaderName)
+	if identity == nil {
+		return
+	}
+	r.Header.Set(HeaderSubject, identity.Subject)
+	if identity.Email != "" {
+		r.Header.Set(HeaderEmail, identity.Email)
+	}
+	if identity.Name != "" {
+		r.Header.Set(HeaderName, identity.Name)
+	}
+}
+
+func (g *Gate) cookie(name, value string, maxAge time.Duration) *http.Cookie {
+	return &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   int(maxAge.Seconds()),
+		HttpOnly: true,
+		Secure:   g.secure,
+		SameSite: http.SameSiteLaxMode,
+	}
+}
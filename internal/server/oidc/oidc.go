@@ -0,0 +1,227 @@
+// Package oidc restricts the tunnels to the users of an OpenID Connect provider, with the authorization code flow
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrNotConfigured    = errors.New("OpenID Connect is not configured on the server")
+	ErrEmailNotVerified = errors.New("the email of the user is not verified")
+)
+
+type Config struct {
+	// Provider, its endpoints are discovered from {Issuer}/.well-known/openid-configuration
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	// Callback registered with the provider, on the domain of the server (default: {scheme}://{domain}/api/v1/oidc/callback)
+	RedirectURL string
+	// Default: openid, email and profile
+	Scopes []string
+	// Signs the session cookies, they do not survive a restart when empty. Nodes of a cluster need the same secret
+	Secret string
+	// Time before the users have to log in again (hours)
+	SessionDuration int
+	// Default policy of the tunnels without their own lists
+	AllowedDomains []string
+	AllowedEmails  []string
+}
+
+// Enabled reports whether a provider is configured
+func (c Config) Enabled() bool {
+	return c.Issuer != "" && c.ClientID != ""
+}
+
+// GetSessionDuration returns the lifetime of a session
+func (c Config) GetSessionDuration() time.Duration {
+	if c.SessionDuration <= 0 {
+		return 12 * time.Hour
+	}
+	return time.Duration(c.SessionDuration) * time.Hour
+}
+
+// Identity is the user returned by the userinfo endpoint of the provider
+type Identity struct {
+	Subject       string `json:"sub"`
+	Email         string `json:"email"`
+	EmailVerified *bool  `json:"email_verified,omitempty"`
+	Name          string `json:"name,omitempty"`
+}
+
+// Policy lists the users allowed to reach a tunnel, anyone who logs in with the provider when both lists are empty
+type Policy struct {
+	AllowedDomains []string
+	AllowedEmails  []string
+}
+
+// Allows reports whether a user may reach the tunnel
+func (p Policy) Allows(identity *Identity) bool {
+	if len(p.AllowedDomains) == 0 && len(p.AllowedEmails) == 0 {
+		return true
+	}
+
+	email := strings.ToLower(identity.Email)
+	if email == "" {
+		return false
+	}
+	for _, allowed := range p.AllowedEmails {
+		if strings.ToLower(allowed) == email {
+			return true
+		}
+	}
+	_, domain, _ := strings.Cut(email, "@")
+	for _, allowed := range p.AllowedDomains {
+		if strings.ToLower(strings.TrimPrefix(allowed, "@")) == domain {
+			return true
+		}
+	}
+	return false
+}
+
+// endpoints of the provider, from its discovery document
+type endpoints struct {
+	Authorization string `json:"authorization_endpoint"`
+	Token         string `json:"token_endpoint"`
+	UserInfo      string `json:"userinfo_endpoint"`
+}
+
+// Provider talks to the OpenID Connect provider
+type Provider struct {
+	config Config
+	client *http.Client
+
+	lock      sync.Mutex
+	endpoints *endpoints
+}
+
+func NewProvider(config Config) *Provider {
+	if len(config.Scopes) == 0 {
+		config.Scopes = []string{"openid", "email", "profile"}
+	}
+	return &Provider{config: config, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+// discover returns the endpoints of the provider, the discovery document is fetched once
+func (p *Provider) discover(ctx context.Context) (*endpoints, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.endpoints != nil {
+		return p.endpoints, nil
+	}
+
+	discoveryURL := strings.TrimSuffix(p.config.Issuer, "/") + "/.well-known/openid-configuration"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, discoveryURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	var discovered endpoints
+	if err := p.do(req, &discovered); err != nil {
+		return nil, fmt.Errorf("unable to discover the provider : %w", err)
+	}
+	if discovered.Authorization == "" || discovered.Token == "" || discovered.UserInfo == "" {
+		return nil, fmt.Errorf("unable to discover the provider : missing endpoints in %s", discoveryURL)
+	}
+	p.endpoints = &discovered
+	return p.endpoints, nil
+}
+
+// AuthCodeURL returns the login page of the provider, which redirects to the callback with a code and the state
+func (p *Provider) AuthCodeURL(ctx context.Context, redirectURL, state string) (string, error) {
+	endpoints, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	authURL, err := url.Parse(endpoints.Authorization)
+	if err != nil {
+		return "", err
+	}
+	query := authURL.Query()
+	query.Set("response_type", "code")
+	query.Set("client_id", p.config.ClientID)
+	query.Set("redirect_uri", redirectURL)
+	query.Set("scope", strings.Join(p.config.Scopes, " "))
+	query.Set("state", state)
+	authURL.RawQuery = query.Encode()
+	return authURL.String(), nil
+}
+
+// Exchange trades the code of the callback for an access token, and returns the user it was issued for
+func (p *Provider) Exchange(ctx context.Context, redirectURL, code string) (*Identity, error) {
+	endpoints, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{
+		"grant_type":   {"authorization_code"},
+		"code":         {code},
+		"redirect_uri": {redirectURL},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoints.Token, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(p.config.ClientID), url.QueryEscape(p.config.ClientSecret))
+
+	var token struct {
+		AccessToken string `json:"access_token"`
+		TokenType   string `json:"token_type"`
+	}
+	if err := p.do(req, &token); err != nil {
+		return nil, fmt.Errorf("unable to exchange the code : %w", err)
+	}
+	if token.AccessToken == "" {
+		return nil, errors.New("unable to exchange the code : no access token")
+	}
+
+	// The userinfo endpoint is asked directly, there is no need to verify the signature of an ID token
+	req, err = http.NewRequestWithContext(ctx, http.MethodGet, endpoints.UserInfo, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token.AccessToken)
+
+	var identity Identity
+	if err := p.do(req, &identity); err != nil {
+		return nil, fmt.Errorf("unable to get the user : %w", err)
+	}
+	if identity.Subject == "" {
+		return nil, errors.New("unable to get the user : no subject")
+	}
+	if identity.EmailVerified != nil && !*identity.EmailVerified {
+		return nil, ErrEmailNotVerified
+	}
+	return &identity, nil
+}
+
+// do sends a request to the provider and decodes its JSON response
+func (p *Provider) do(req *http.Request, v any) error {
+	req.Header.Set("Accept", "application/json")
+	res, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(res.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %d: %s", req.URL.Redacted(), res.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return json.Unmarshal(body, v)
+}
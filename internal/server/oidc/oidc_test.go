@@ -0,0 +1,189 @@
+package oidc
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// mockProvider is an OpenID Connect provider logging in the user of its identity without asking
+func mockProvider(t *testing.T, identity map[string]any) *httptest.Server {
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 server.URL,
+			"authorization_endpoint": server.URL + "/authorize",
+			"token_endpoint":         server.URL + "/token",
+			"userinfo_endpoint":      server.URL + "/userinfo",
+		})
+	})
+	mux.HandleFunc("/authorize", func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		redirect, _ := url.Parse(query.Get("redirect_uri"))
+		redirect.RawQuery = url.Values{"code": {"code-1"}, "state": {query.Get("state")}}.Encode()
+		http.Redirect(w, r, redirect.String(), http.StatusFound)
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		id, secret, _ := r.BasicAuth()
+		if id != "beaver" || secret != "secret" || r.FormValue("code") != "code-1" {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"access_token": "token-1", "token_type": "Bearer"})
+	})
+	mux.HandleFunc("/userinfo", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token-1" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(identity)
+	})
+	return server
+}
+
+func testGate(t *testing.T, identity map[string]any) *Gate {
+	provider := mockProvider(t, identity)
+	return NewGate(Config{
+		Issuer:       provider.URL,
+		ClientID:     "beaver",
+		ClientSecret: "secret",
+		Secret:       "cookie-secret",
+	}, "http://localhost:8080/api/v1/oidc/callback", false)
+}
+
+// follow sends a request and returns the location it redirects to
+func follow(t *testing.T, handler func(http.ResponseWriter, *http.Request) error, req *http.Request) (*url.URL, []*http.Cookie) {
+	w := httptest.NewRecorder()
+	assert.NoError(t, handler(w, req))
+	assert.Equal(t, http.StatusFound, w.Code)
+	location, err := url.Parse(w.Header().Get("Location"))
+	assert.NoError(t, err)
+	return location, w.Result().Cookies()
+}
+
+// login runs the flow of a visitor of web.localhost/private?a=1, up to the session endpoint of the tunnel
+func login(t *testing.T, gate *Gate) (*http.Request, []*http.Cookie) {
+	req := httptest.NewRequest("GET", "http://web.localhost/private?a=1", nil)
+	authURL, cookies := follow(t, gate.Login, req)
+
+	// The provider logs the user in and redirects to the callback
+	res, err := http.DefaultTransport.RoundTrip(httptest.NewRequest("GET", authURL.String(), nil).WithContext(req.Context()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	callbackURL, _ := url.Parse(res.Header.Get("Location"))
+	assert.Equal(t, "/api/v1/oidc/callback", callbackURL.Path)
+
+	sessionURL, _ := follow(t, gate.Callback, httptest.NewRequest("GET", callbackURL.String(), nil))
+	assert.Equal(t, "web.localhost", sessionURL.Host)
+	assert.Equal(t, SessionPath, sessionURL.Path)
+
+	req = httptest.NewRequest("GET", sessionURL.String(), nil)
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+	return req, cookies
+}
+
+func TestGate(t *testing.T) {
+	gate := testGate(t, map[string]any{"sub": "42", "email": "jane@example.com", "email_verified": true, "name": "Jane"})
+
+	req, _ := login(t, gate)
+	location, cookies := follow(t, func(w http.ResponseWriter, r *http.Request) error {
+		return gate.StartSession(w, r, "web", Policy{AllowedDomains: []string{"example.com"}})
+	}, req)
+	assert.Equal(t, "/private?a=1", location.String())
+
+	req = httptest.NewRequest("GET", "http://web.localhost/private", nil)
+	for _, cookie := range cookies {
+		if cookie.MaxAge > 0 {
+			req.AddCookie(cookie)
+		}
+	}
+	req.AddCookie(&http.Cookie{Name: "app", Value: "1"})
+	req.Header.Set(HeaderEmail, "spoofed@example.com")
+
+	identity, ok := gate.Identity(req, "web")
+	assert.True(t, ok)
+	assert.Equal(t, "jane@example.com", identity.Email)
+
+	// The cookie is only valid on its tunnel
+	_, ok = gate.Identity(req, "api")
+	assert.False(t, ok)
+
+	Forward(req, identity)
+	assert.Equal(t, "42", req.Header.Get(HeaderSubject))
+	assert.Equal(t, "jane@example.com", req.Header.Get(HeaderEmail))
+	assert.Equal(t, "Jane", req.Header.Get(HeaderName))
+	assert.Equal(t, "app=1", req.Header.Get("Cookie"))
+}
+
+func TestGateRefusals(t *testing.T) {
+	gate := testGate(t, map[string]any{"sub": "42", "email": "jane@example.com"})
+
+	// Users outside of the policy
+	req, _ := login(t, gate)
+	err := gate.StartSession(httptest.NewRecorder(), req, "web", Policy{AllowedDomains: []string{"beaver.com"}})
+	assert.Equal(t, ErrForbidden, err)
+
+	// The ticket must be used in the browser which started the login
+	req, _ = login(t, gate)
+	req.Header.Del("Cookie")
+	err = gate.StartSession(httptest.NewRecorder(), req, "web", Policy{})
+	assert.Equal(t, ErrInvalidToken, err)
+
+	// On the tunnel it was issued for
+	req, _ = login(t, gate)
+	req.Host = "api.localhost"
+	err = gate.StartSession(httptest.NewRecorder(), req, "api", Policy{})
+	assert.Equal(t, ErrInvalidToken, err)
+
+	// A forged state
+	err = gate.Callback(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/v1/oidc/callback?code=code-1&state=forged", nil))
+	assert.Equal(t, ErrInvalidToken, err)
+
+	unverified := testGate(t, map[string]any{"sub": "42", "email": "jane@example.com", "email_verified": false})
+	req = httptest.NewRequest("GET", "http://web.localhost/", nil)
+	authURL, _ := follow(t, unverified.Login, req)
+	state := authURL.Query().Get("state")
+	err = unverified.Callback(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/v1/oidc/callback?code=code-1&state="+url.QueryEscape(state), nil))
+	assert.Equal(t, ErrEmailNotVerified, err)
+}
+
+func TestPolicy(t *testing.T) {
+	jane := &Identity{Subject: "1", Email: "Jane@Example.com"}
+
+	assert.True(t, Policy{}.Allows(jane))
+	assert.True(t, Policy{AllowedDomains: []string{"example.com"}}.Allows(jane))
+	assert.True(t, Policy{AllowedDomains: []string{"@example.com"}}.Allows(jane))
+	assert.True(t, Policy{AllowedEmails: []string{"jane@example.com"}}.Allows(jane))
+	assert.False(t, Policy{AllowedDomains: []string{"beaver.com"}, AllowedEmails: []string{"john@example.com"}}.Allows(jane))
+	assert.False(t, Policy{AllowedDomains: []string{"example.com"}}.Allows(&Identity{Subject: "2"}))
+}
+
+func TestSigner(t *testing.T) {
+	signer := NewSigner("secret")
+
+	token, err := signer.Sign("session", "value", time.Minute)
+	assert.NoError(t, err)
+
+	var value string
+	assert.NoError(t, signer.Verify("session", token, &value))
+	assert.Equal(t, "value", value)
+
+	assert.Equal(t, ErrInvalidToken, signer.Verify("state", token, &value))
+	assert.Equal(t, ErrInvalidToken, NewSigner("other").Verify("session", token, &value))
+	assert.Equal(t, ErrInvalidToken, signer.Verify("session", token+"x", &value))
+
+	expired, _ := signer.Sign("session", "value", -time.Minute)
+	assert.Equal(t, ErrInvalidToken, signer.Verify("session", expired, &value))
+}
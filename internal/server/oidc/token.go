@@ -0,0 +1,85 @@
+package oidc
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+var ErrInvalidToken = errors.New("invalid or expired token")
+
+// Signer signs the values carried by the browser: the state, the ticket and the session cookie
+type Signer struct {
+	key []byte
+}
+
+// NewSigner returns a signer using the secret, or a random key when it is empty
+func NewSigner(secret string) *Signer {
+	if secret != "" {
+		return &Signer{key: []byte(secret)}
+	}
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		panic(err)
+	}
+	return &Signer{key: key}
+}
+
+type envelope struct {
+	Expires int64           `json:"exp"`
+	Value   json.RawMessage `json:"v"`
+}
+
+// Sign returns a token holding the value until ttl, it is only valid for the same purpose
+func (s *Signer) Sign(purpose string, value any, ttl time.Duration) (string, error) {
+	v, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(envelope{Expires: time.Now().Add(ttl).Unix(), Value: v})
+	if err != nil {
+		return "", err
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + s.mac(purpose, encoded), nil
+}
+
+// Verify decodes the value of a token signed for the purpose
+func (s *Signer) Verify(purpose, token string, value any) error {
+	encoded, mac, ok := strings.Cut(token, ".")
+	if !ok || !hmac.Equal([]byte(mac), []byte(s.mac(purpose, encoded))) {
+		return ErrInvalidToken
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return ErrInvalidToken
+	}
+	var e envelope
+	if err := json.Unmarshal(payload, &e); err != nil || time.Now().Unix() > e.Expires {
+		return ErrInvalidToken
+	}
+	if err := json.Unmarshal(e.Value, value); err != nil {
+		return ErrInvalidToken
+	}
+	return nil
+}
+
+func (s *Signer) mac(purpose, encoded string) string {
+	h := hmac.New(sha256.New, s.key)
+	h.Write([]byte(purpose + "." + encoded))
+	return base64.RawURLEncoding.EncodeToString(h.Sum(nil))
+}
+
+// randomString returns a random URL safe string
+func randomString() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,242 @@
+package tunnel
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"net/http"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/amalshaji/beaver/internal/server/admin"
+	"github.com/amalshaji/beaver/internal/server/oidc"
+)
+
+// Number of verified credentials remembered, the cache is emptied when it is full
+const basicAuthCacheSize = 10000
+
+// Failed basic auth logins allowed per client address within loginWindow, the next credentials are not checked
+const (
+	maxFailedLogins = 10
+	loginWindow     = time.Minute
+	// Number of client addresses tracked, the oldest are forgotten when it is full
+	loginThrottleSize = 10000
+)
+
+var (
+	ErrAuthRequired  = errors.New("authentication required")
+	ErrTooManyLogins = errors.New("too many failed logins, try again later")
+)
+
+type skipAuthKey struct{}
+
+// WithoutAuth returns a context whose requests skip the tunnel gates, eg: replays started by an admin
+func WithoutAuth(ctx context.Context) context.Context {
+	return context.WithValue(ctx, skipAuthKey{}, true)
+}
+
+// basicAuthCache remembers the credentials which matched a password hash, bcrypt is too slow to run on every request
+type basicAuthCache struct {
+	lock    sync.Mutex
+	entries map[[sha256.Size]byte]struct{}
+}
+
+func (c *basicAuthCache) check(auth *admin.BasicAuthSettings, username, password string) bool {
+	// The hash is part of the key, credentials are checked again once the password changes
+	key := sha256.Sum256([]byte(auth.PasswordHash + "\x00" + username + "\x00" + password))
+
+	c.lock.Lock()
+	_, ok := c.entries[key]
+	c.lock.Unlock()
+	if ok {
+		return true
+	}
+
+	if !auth.CheckCredentials(username, password) {
+		return false
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.entries == nil || len(c.entries) >= basicAuthCacheSize {
+		c.entries = make(map[[sha256.Size]byte]struct{})
+	}
+	c.entries[key] = struct{}{}
+	return true
+}
+
+// loginThrottle counts the failed basic auth logins of each client address.
+// Passwords cannot be guessed at the rate the server runs bcrypt.
+type loginThrottle struct {
+	lock    sync.Mutex
+	entries map[string]*failedLogins
+}
+
+type failedLogins struct {
+	count int
+	since time.Time
+}
+
+// allowed reports whether the credentials sent from an address may be checked
+func (t *loginThrottle) allowed(clientIP string) bool {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	entry, ok := t.entries[clientIP]
+	return !ok || entry.count < maxFailedLogins || time.Since(entry.since) > loginWindow
+}
+
+// failed counts a failed login from an address
+func (t *loginThrottle) failed(clientIP string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if t.entries == nil {
+		t.entries = make(map[string]*failedLogins)
+	}
+	if len(t.entries) >= loginThrottleSize {
+		for ip, entry := range t.entries {
+			if time.Since(entry.since) > loginWindow {
+				delete(t.entries, ip)
+			}
+		}
+		if len(t.entries) >= loginThrottleSize {
+			t.entries = make(map[string]*failedLogins)
+		}
+	}
+
+	entry, ok := t.entries[clientIP]
+	if !ok || time.Since(entry.since) > loginWindow {
+		entry = &failedLogins{since: time.Now()}
+		t.entries[clientIP] = entry
+	}
+	entry.count++
+}
+
+// RegisteredBasicAuth returns the basic auth a client registers for a tunnel.
+// The hash of the previous registration is reused when the credentials did not change.
+func (s *Server) RegisteredBasicAuth(subdomain, username, password string) (*admin.BasicAuthSettings, error) {
+	s.Lock.RLock()
+	var previous *admin.BasicAuthSettings
+	if pool, ok := s.Pools[subdomain]; ok {
+		pool.lock.RLock()
+		previous = pool.basicAuth[subdomain]
+		pool.lock.RUnlock()
+	}
+	s.Lock.RUnlock()
+
+	if previous != nil && s.basicAuth.check(previous, username, password) {
+		return previous, nil
+	}
+
+	auth := &admin.BasicAuthSettings{Username: username}
+	if err := auth.SetPassword(password); err != nil {
+		return nil, err
+	}
+	return auth, nil
+}
+
+// BasicAuth returns the basic auth of a tunnel, the admin settings take precedence over the client registration
+func (pool *Pool) BasicAuth(subdomain string) *admin.BasicAuthSettings {
+	pool.lock.RLock()
+	defer pool.lock.RUnlock()
+
+	if settings, ok := pool.settings[subdomain]; ok && settings.BasicAuth != nil {
+		return settings.BasicAuth
+	}
+	return pool.basicAuth[subdomain]
+}
+
+// IPFilter returns the IP filter of a tunnel, the admin settings take precedence over the client registration
+func (pool *Pool) IPFilter(subdomain string) *admin.IPFilterSettings {
+	pool.lock.RLock()
+	defer pool.lock.RUnlock()
+
+	if settings, ok := pool.settings[subdomain]; ok && settings.IPFilter != nil {
+		return settings.IPFilter
+	}
+	return pool.ipFilters[subdomain]
+}
+
+// AllowsIP reports whether a tunnel accepts a request from the client address
+func (s *Server) AllowsIP(subdomain string, req *http.Request, clientIP string) bool {
+	if req.Context().Value(skipAuthKey{}) != nil {
+		return true
+	}
+
+	s.Lock.RLock()
+	pool, ok := s.Pools[subdomain]
+	s.Lock.RUnlock()
+	if !ok {
+		return true
+	}
+
+	filter := pool.IPFilter(subdomain)
+	if filter == nil {
+		return true
+	}
+	ip, err := netip.ParseAddr(clientIP)
+	return err == nil && filter.Allows(ip)
+}
+
+// OIDCPolicy returns the users allowed on a tunnel restricted with OpenID Connect, ok is false for the other tunnels
+func (s *Server) OIDCPolicy(subdomain string, req *http.Request) (policy oidc.Policy, ok bool) {
+	if s.OIDC == nil || req.Context().Value(skipAuthKey{}) != nil {
+		return policy, false
+	}
+
+	s.Lock.RLock()
+	pool, ok := s.Pools[subdomain]
+	s.Lock.RUnlock()
+	if !ok {
+		return policy, false
+	}
+
+	pool.lock.RLock()
+	defer pool.lock.RUnlock()
+
+	settings, ok := pool.settings[subdomain]
+	if !ok || settings.OIDC == nil || !settings.OIDC.Enabled {
+		return policy, false
+	}
+	if len(settings.OIDC.AllowedDomains) == 0 && len(settings.OIDC.AllowedEmails) == 0 {
+		return s.OIDC.Policy, true
+	}
+	return oidc.Policy{AllowedDomains: settings.OIDC.AllowedDomains, AllowedEmails: settings.OIDC.AllowedEmails}, true
+}
+
+// AuthorizeRequest checks the credentials of a request to a tunnel with basic auth,
+// it returns ErrTooManyLogins once the client address failed too many times.
+// The Authorization header is removed, the local server never sees the credentials.
+func (s *Server) AuthorizeRequest(subdomain string, req *http.Request, clientIP string) error {
+	s.Lock.RLock()
+	pool, ok := s.Pools[subdomain]
+	s.Lock.RUnlock()
+	if !ok {
+		return nil
+	}
+
+	auth := pool.BasicAuth(subdomain)
+	if auth == nil {
+		return nil
+	}
+
+	username, password, ok := req.BasicAuth()
+	req.Header.Del("Authorization")
+
+	if req.Context().Value(skipAuthKey{}) != nil {
+		return nil
+	}
+	if !ok {
+		return ErrAuthRequired
+	}
+	if !s.loginThrottle.allowed(clientIP) {
+		return ErrTooManyLogins
+	}
+	if !s.basicAuth.check(auth, username, password) {
+		s.loginThrottle.failed(clientIP)
+		return ErrAuthRequired
+	}
+	return nil
+}
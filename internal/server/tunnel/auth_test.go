@@ -0,0 +1,198 @@
+package tunnel
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/amalshaji/beaver/internal/server/admin"
+	"github.com/amalshaji/beaver/internal/server/cluster"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuthorizeRequest(t *testing.T) {
+	server := newTestServer(t)
+
+	auth, err := server.RegisteredBasicAuth("web", "dev", "secret")
+	assert.NoError(t, err)
+	_, _, err = server.GetOrCreatePoolForUser([]Tunnel{
+		{Subdomain: "web", LocalServer: "http://localhost:8000", BasicAuth: auth},
+		{Subdomain: "api", LocalServer: "http://localhost:9000"},
+	}, "test@beaver.com", "session-1")
+	assert.NoError(t, err)
+
+	req := httptest.NewRequest("GET", "/", nil)
+	assert.ErrorIs(t, server.AuthorizeRequest("web", req, "203.0.113.1"), ErrAuthRequired)
+
+	req.SetBasicAuth("dev", "wrong")
+	assert.ErrorIs(t, server.AuthorizeRequest("web", req, "203.0.113.1"), ErrAuthRequired)
+
+	req.SetBasicAuth("dev", "secret")
+	assert.NoError(t, server.AuthorizeRequest("web", req, "203.0.113.1"))
+	// The credentials are not forwarded to the local server
+	assert.Empty(t, req.Header.Get("Authorization"))
+
+	// The tunnels without basic auth forward the header
+	req = httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Authorization", "Bearer token")
+	assert.NoError(t, server.AuthorizeRequest("api", req, "203.0.113.1"))
+	assert.Equal(t, "Bearer token", req.Header.Get("Authorization"))
+
+	// Replays skip the gate
+	req = httptest.NewRequest("GET", "/", nil).WithContext(WithoutAuth(context.Background()))
+	assert.NoError(t, server.AuthorizeRequest("web", req, "203.0.113.1"))
+
+	// The admin settings take precedence over the registration
+	settings := &admin.BasicAuthSettings{Username: "admin"}
+	assert.NoError(t, settings.SetPassword("password"))
+	server.ApplyTunnelSettings("web", &admin.TunnelSettings{BasicAuth: settings})
+
+	req = httptest.NewRequest("GET", "/", nil)
+	req.SetBasicAuth("dev", "secret")
+	assert.ErrorIs(t, server.AuthorizeRequest("web", req, "203.0.113.1"), ErrAuthRequired)
+	req.SetBasicAuth("admin", "password")
+	assert.NoError(t, server.AuthorizeRequest("web", req, "203.0.113.1"))
+}
+
+func TestAuthorizeRequestThrottlesFailedLogins(t *testing.T) {
+	server := newTestServer(t)
+
+	auth, err := server.RegisteredBasicAuth("web", "dev", "secret")
+	assert.NoError(t, err)
+	_, _, err = server.GetOrCreatePoolForUser([]Tunnel{
+		{Subdomain: "web", LocalServer: "http://localhost:8000", BasicAuth: auth},
+	}, "test@beaver.com", "session-1")
+	assert.NoError(t, err)
+
+	req := httptest.NewRequest("GET", "/", nil)
+	for i := 0; i < maxFailedLogins; i++ {
+		req.SetBasicAuth("dev", "guess")
+		assert.ErrorIs(t, server.AuthorizeRequest("web", req, "203.0.113.1"), ErrAuthRequired)
+	}
+
+	// The right credentials are not checked either until the window is over
+	req.SetBasicAuth("dev", "secret")
+	assert.ErrorIs(t, server.AuthorizeRequest("web", req, "203.0.113.1"), ErrTooManyLogins)
+	// Requests without credentials are asked for them
+	assert.ErrorIs(t, server.AuthorizeRequest("web", req, "203.0.113.1"), ErrAuthRequired)
+
+	// Other addresses are not affected
+	req.SetBasicAuth("dev", "secret")
+	assert.NoError(t, server.AuthorizeRequest("web", req, "203.0.113.2"))
+
+	server.loginThrottle.entries["203.0.113.1"].since = time.Now().Add(-2 * loginWindow)
+	req.SetBasicAuth("dev", "secret")
+	assert.NoError(t, server.AuthorizeRequest("web", req, "203.0.113.1"))
+}
+
+func TestRegisteredBasicAuthReusesTheHash(t *testing.T) {
+	server := newTestServer(t)
+
+	auth, err := server.RegisteredBasicAuth("web", "dev", "secret")
+	assert.NoError(t, err)
+	_, _, err = server.GetOrCreatePoolForUser([]Tunnel{
+		{Subdomain: "web", LocalServer: "http://localhost:8000", BasicAuth: auth},
+	}, "test@beaver.com", "session-1")
+	assert.NoError(t, err)
+
+	// Each connection of the session registers the tunnels again
+	again, err := server.RegisteredBasicAuth("web", "dev", "secret")
+	assert.NoError(t, err)
+	assert.Same(t, auth, again)
+
+	changed, err := server.RegisteredBasicAuth("web", "dev", "other")
+	assert.NoError(t, err)
+	assert.NotEqual(t, auth.PasswordHash, changed.PasswordHash)
+}
+
+func TestAllowsIP(t *testing.T) {
+	server := newTestServer(t)
+
+	_, _, err := server.GetOrCreatePoolForUser([]Tunnel{
+		{Subdomain: "hooks", LocalServer: "http://localhost:8000", IPFilter: &admin.IPFilterSettings{Allow: []string{"192.30.252.0/22"}}},
+		{Subdomain: "web", LocalServer: "http://localhost:9000"},
+	}, "test@beaver.com", "session-1")
+	assert.NoError(t, err)
+
+	req := httptest.NewRequest("POST", "/", nil)
+	assert.True(t, server.AllowsIP("hooks", req, "192.30.252.1"))
+	assert.False(t, server.AllowsIP("hooks", req, "203.0.113.1"))
+	assert.False(t, server.AllowsIP("hooks", req, ""))
+	assert.True(t, server.AllowsIP("web", req, "203.0.113.1"))
+
+	// The admin settings take precedence over the registration
+	server.ApplyTunnelSettings("hooks", &admin.TunnelSettings{IPFilter: &admin.IPFilterSettings{Deny: []string{"192.30.252.1"}}})
+	assert.False(t, server.AllowsIP("hooks", req, "192.30.252.1"))
+	assert.True(t, server.AllowsIP("hooks", req, "203.0.113.1"))
+}
+
+func TestIPExtractor(t *testing.T) {
+	req := httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "10.0.0.2:41000"
+	req.Header.Set("X-Forwarded-For", "198.51.100.7, 203.0.113.1")
+
+	// Without trusted proxies the header is ignored
+	extract, err := Config{}.IPExtractor()
+	assert.NoError(t, err)
+	assert.Equal(t, "10.0.0.2", extract(req))
+
+	// The first address which is not a trusted proxy is the client, from the right
+	extract, err = Config{TrustedProxies: []string{"10.0.0.0/8", "203.0.113.1"}}.IPExtractor()
+	assert.NoError(t, err)
+	assert.Equal(t, "198.51.100.7", extract(req))
+
+	// A request which does not come from a trusted proxy cannot spoof its address
+	req.RemoteAddr = "198.51.100.9:41000"
+	assert.Equal(t, "198.51.100.9", extract(req))
+
+	_, err = Config{TrustedProxies: []string{"10.0.0.0/40"}}.IPExtractor()
+	assert.Error(t, err)
+	_, err = Config{Cluster: cluster.Config{Enabled: true, Peers: []string{"10.0.0.2"}}}.IPExtractor()
+	assert.Error(t, err)
+}
+
+func TestAllowsIPOfForwardedRequests(t *testing.T) {
+	server := newTestServer(t)
+	_, _, err := server.GetOrCreatePoolForUser([]Tunnel{
+		{Subdomain: "hooks", LocalServer: "http://localhost:8000", IPFilter: &admin.IPFilterSettings{Allow: []string{"192.30.252.0/22"}}},
+	}, "test@beaver.com", "session-1")
+	assert.NoError(t, err)
+
+	// The node holding the tunnel trusts the other nodes of the cluster
+	server.Config.Cluster = cluster.Config{Enabled: true, Peers: []string{"http://127.0.0.1:8080"}}
+	extract, err := server.Config.IPExtractor()
+	assert.NoError(t, err)
+	owner := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !server.AllowsIP("hooks", r, extract(r)) {
+			w.WriteHeader(http.StatusForbidden)
+		}
+	}))
+	defer owner.Close()
+
+	// The node receiving the request forwards it with the address of the client
+	var clientIP string
+	node := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.RemoteAddr = clientIP + ":41000"
+		cluster.Forward(cluster.Node{ID: "node-1"}, cluster.Node{ID: "node-2", Addr: owner.URL}, "secret", w, r)
+	}))
+	defer node.Close()
+
+	for ip, status := range map[string]int{"192.30.252.1": http.StatusOK, "203.0.113.1": http.StatusForbidden} {
+		clientIP = ip
+		res, err := http.Get(node.URL)
+		assert.NoError(t, err)
+		res.Body.Close()
+		assert.Equal(t, status, res.StatusCode, ip)
+	}
+
+	// The filter applies to the address of the connection without the cluster
+	extract = echo.ExtractIPDirect()
+	clientIP = "192.30.252.1"
+	res, err := http.Get(node.URL)
+	assert.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, http.StatusForbidden, res.StatusCode)
+}
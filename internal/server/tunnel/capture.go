@@ -0,0 +1,125 @@
+package tunnel
+
+import (
+	"context"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"time"
+
+	"github.com/amalshaji/beaver/internal/server/admin"
+	"github.com/amalshaji/beaver/internal/traffic"
+	"github.com/amalshaji/beaver/internal/utils"
+)
+
+// Interval between two purges of the captured requests
+const capturePurgeInterval = time.Minute
+
+type skipCaptureKey struct{}
+
+// WithoutCapture returns a context whose requests are not captured, eg: replays which are saved by the caller
+func WithoutCapture(ctx context.Context) context.Context {
+	return context.WithValue(ctx, skipCaptureKey{}, true)
+}
+
+// mergeCaptureSettings returns the capture settings of a tunnel, the unset values are the server defaults
+func mergeCaptureSettings(defaults admin.CaptureSettings, settings *admin.TunnelSettings) *admin.CaptureSettings {
+	merged := defaults
+	if settings == nil || settings.Capture == nil {
+		return &merged
+	}
+
+	capture := settings.Capture
+	merged.Enabled = capture.Enabled
+	if capture.SampleRate != 0 {
+		merged.SampleRate = capture.SampleRate
+	}
+	if capture.Retention != 0 {
+		merged.Retention = capture.Retention
+	}
+	if capture.MaxRequests != 0 {
+		merged.MaxRequests = capture.MaxRequests
+	}
+	if capture.MaxBodySize != 0 {
+		merged.MaxBodySize = capture.MaxBodySize
+	}
+	return &merged
+}
+
+// CaptureSettings returns the capture settings of a tunnel, whether it is connected or not
+func (s *Server) CaptureSettings(subdomain string) *admin.CaptureSettings {
+	return mergeCaptureSettings(s.Config.Capture, s.loadTunnelSettings(subdomain))
+}
+
+// sampleCapture returns the capture settings of a request, nil when it is not captured
+func (pool *Pool) sampleCapture(subdomain string, req *http.Request) *admin.CaptureSettings {
+	if req.Context().Value(skipCaptureKey{}) != nil {
+		return nil
+	}
+
+	settings := pool.CaptureSettings(subdomain)
+	if !settings.Enabled || rand.Float64() >= settings.SampleRate {
+		return nil
+	}
+	return settings
+}
+
+// newBodyCapture returns a capture of a request or response body, nil when the request is not captured
+func newBodyCapture(settings *admin.CaptureSettings) *traffic.Capture {
+	if settings == nil {
+		return nil
+	}
+	return traffic.NewCapture(settings.MaxBodySize)
+}
+
+// teeBody copies what is written to w to the capture, if any
+func teeBody(w io.Writer, capture *traffic.Capture) io.Writer {
+	if capture == nil {
+		return w
+	}
+	return io.MultiWriter(w, capture)
+}
+
+// captureExchange saves a request proxied to a tunnel
+func (s *Server) captureExchange(settings *admin.CaptureSettings, subdomain string, start time.Time, req *utils.HTTPRequest, requestBody *traffic.Capture, res *utils.HTTPResponse, responseBody *traffic.Capture) {
+	if settings == nil || s.Captures == nil {
+		return
+	}
+
+	exchange := &traffic.Exchange{
+		Time:      start,
+		Duration:  time.Since(start),
+		Subdomain: subdomain,
+		Request:   traffic.FromHTTPRequest(req),
+		Response:  traffic.FromHTTPResponse(res),
+	}
+	exchange.Request.SetBody(requestBody)
+	exchange.Response.SetBody(responseBody)
+
+	if err := s.Captures.SaveCapturedRequest(context.Background(), admin.NewCapturedRequest(exchange)); err != nil {
+		log.Printf("Unable to capture request to %s: %v", subdomain, err)
+	}
+}
+
+// purgeCaptures deletes the captured requests beyond the retention of their tunnel
+func (s *Server) purgeCaptures() {
+	if s.Captures == nil {
+		return
+	}
+
+	ctx := context.Background()
+	subdomains, err := s.Captures.CapturedSubdomains(ctx)
+	if err != nil {
+		log.Printf("Unable to purge captured requests: %v", err)
+		return
+	}
+
+	for _, subdomain := range subdomains {
+		settings := s.CaptureSettings(subdomain)
+		retention := time.Duration(settings.Retention) * time.Hour
+		if _, err := s.Captures.PurgeCapturedRequests(ctx, subdomain, retention, settings.MaxRequests); err != nil {
+			log.Printf("Unable to purge captured requests of %s: %v", subdomain, err)
+		}
+	}
+}
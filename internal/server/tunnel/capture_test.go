@@ -0,0 +1,77 @@
+package tunnel
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/amalshaji/beaver/internal/server/admin"
+	"github.com/amalshaji/beaver/internal/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMergeCaptureSettings(t *testing.T) {
+	defaults := NewConfig().Capture
+
+	assert.Equal(t, defaults, *mergeCaptureSettings(defaults, nil))
+
+	merged := mergeCaptureSettings(defaults, &admin.TunnelSettings{Capture: &admin.CaptureSettings{Enabled: true, SampleRate: 0.5}})
+	assert.True(t, merged.Enabled)
+	assert.Equal(t, 0.5, merged.SampleRate)
+	assert.Equal(t, defaults.Retention, merged.Retention)
+	assert.Equal(t, defaults.MaxBodySize, merged.MaxBodySize)
+}
+
+func TestSampleCapture(t *testing.T) {
+	server := newTestServer(t)
+	pool := NewPool(server, "session-1", "web", "http://localhost:8000", "test@beaver.com")
+	req := httptest.NewRequest("GET", "/", nil)
+
+	// Nothing is captured unless enabled
+	assert.Nil(t, pool.sampleCapture("web", req))
+
+	pool.SetSettings("web", &admin.TunnelSettings{Capture: &admin.CaptureSettings{Enabled: true}})
+	assert.NotNil(t, pool.sampleCapture("web", req))
+	assert.Nil(t, pool.sampleCapture("web", req.WithContext(WithoutCapture(context.Background()))))
+
+	pool.SetSettings("web", &admin.TunnelSettings{Capture: &admin.CaptureSettings{Enabled: true, SampleRate: 0.0001}})
+	captured := 0
+	for i := 0; i < 100; i++ {
+		if pool.sampleCapture("web", req) != nil {
+			captured++
+		}
+	}
+	assert.Less(t, captured, 100)
+}
+
+func TestCaptureExchange(t *testing.T) {
+	server := newTestServer(t)
+
+	settings := &admin.CaptureSettings{Enabled: true, MaxBodySize: 4}
+	requestBody, responseBody := newBodyCapture(settings), newBodyCapture(settings)
+	teeBody(io.Discard, requestBody).Write([]byte("hello"))
+	teeBody(io.Discard, responseBody).Write([]byte("ok"))
+
+	server.captureExchange(settings, "web", time.Now(),
+		&utils.HTTPRequest{Method: "POST", URL: "/hook?a=1", Header: http.Header{"Host": {"web.localhost"}}},
+		requestBody,
+		&utils.HTTPResponse{StatusCode: 201, Header: http.Header{}},
+		responseBody,
+	)
+	// Requests which are not sampled are left out
+	server.captureExchange(nil, "web", time.Now(), &utils.HTTPRequest{}, nil, &utils.HTTPResponse{}, nil)
+
+	captured, err := server.Captures.ListCapturedRequests(context.Background(), admin.CaptureFilter{})
+	assert.NoError(t, err)
+	assert.Len(t, captured, 1)
+	assert.Equal(t, "/hook", captured[0].Path)
+	assert.Equal(t, 201, captured[0].StatusCode)
+	assert.Equal(t, "web.localhost", captured[0].Request.Host)
+	assert.Equal(t, "/hook?a=1", captured[0].Request.URL)
+	assert.Equal(t, "hell", string(captured[0].Request.Body))
+	assert.True(t, captured[0].Request.Truncated)
+	assert.Equal(t, "ok", string(captured[0].Response.Body))
+}
@@ -0,0 +1,161 @@
+package tunnel
+
+import (
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/amalshaji/beaver/internal/server/admin"
+	"github.com/amalshaji/beaver/internal/utils"
+	"github.com/andybalholm/brotli"
+)
+
+// Supported content encodings, in order of preference
+const (
+	EncodingBrotli = "br"
+	EncodingGzip   = "gzip"
+)
+
+// DefaultCompressibleMimeTypes is used when compression is enabled without a MIME allowlist
+var DefaultCompressibleMimeTypes = []string{
+	"text/*",
+	"application/javascript",
+	"application/json",
+	"application/xml",
+	"application/wasm",
+	"image/svg+xml",
+}
+
+// NegotiateEncoding picks the best supported encoding from an Accept-Encoding header.
+// It returns an empty string if the caller does not accept any of them.
+func NegotiateEncoding(acceptEncoding string) string {
+	type candidate struct {
+		encoding string
+		q        float64
+	}
+
+	var candidates []candidate
+	wildcard := -1.0
+
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		fields := strings.Split(part, ";")
+		encoding := strings.ToLower(strings.TrimSpace(fields[0]))
+		if encoding == "" {
+			continue
+		}
+
+		q := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if v, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = v
+				}
+			}
+		}
+
+		if encoding == "*" {
+			wildcard = q
+			continue
+		}
+		if encoding == EncodingBrotli || encoding == EncodingGzip {
+			candidates = append(candidates, candidate{encoding, q})
+		}
+	}
+
+	// `*` covers the supported encodings which are not listed explicitly
+	if wildcard > 0 {
+		for _, encoding := range []string{EncodingBrotli, EncodingGzip} {
+			listed := false
+			for _, c := range candidates {
+				if c.encoding == encoding {
+					listed = true
+				}
+			}
+			if !listed {
+				candidates = append(candidates, candidate{encoding, wildcard})
+			}
+		}
+	}
+
+	// Prefer the highest q-value, then brotli over gzip
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].q != candidates[j].q {
+			return candidates[i].q > candidates[j].q
+		}
+		return candidates[i].encoding == EncodingBrotli
+	})
+
+	if len(candidates) == 0 || candidates[0].q <= 0 {
+		return ""
+	}
+	return candidates[0].encoding
+}
+
+// ShouldCompress reports whether a response matches the compression settings
+func ShouldCompress(settings *admin.CompressionSettings, method string, resp *utils.HTTPResponse) bool {
+	if settings == nil || !settings.Enabled {
+		return false
+	}
+
+	if method == http.MethodHead {
+		return false
+	}
+
+	// No body, or a partial body the caller has to reassemble
+	switch resp.StatusCode {
+	case http.StatusNoContent, http.StatusNotModified, http.StatusPartialContent:
+		return false
+	}
+
+	// Already encoded by the local server
+	if resp.Header.Get("Content-Encoding") != "" {
+		return false
+	}
+
+	// Unknown lengths (-1) are streamed, so they are always considered large enough
+	if resp.ContentLength >= 0 && resp.ContentLength < settings.MinSize {
+		return false
+	}
+
+	mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil {
+		return false
+	}
+
+	mimeTypes := settings.MimeTypes
+	if len(mimeTypes) == 0 {
+		mimeTypes = DefaultCompressibleMimeTypes
+	}
+
+	for _, allowed := range mimeTypes {
+		allowed = strings.ToLower(strings.TrimSpace(allowed))
+		if allowed == mediaType {
+			return true
+		}
+		if strings.HasSuffix(allowed, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(allowed, "*")) {
+			return true
+		}
+	}
+	return false
+}
+
+// WeakETag returns the weak version of an entity tag, the compressed body is only semantically equivalent to the original
+func WeakETag(etag string) string {
+	if strings.HasPrefix(etag, "W/") {
+		return etag
+	}
+	return "W/" + etag
+}
+
+// NewCompressWriter wraps w with an encoder for the given encoding
+func NewCompressWriter(encoding string, w io.Writer) io.WriteCloser {
+	if encoding == EncodingBrotli {
+		return brotli.NewWriter(w)
+	}
+	return gzip.NewWriter(w)
+}
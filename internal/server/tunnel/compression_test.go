@@ -0,0 +1,76 @@
+package tunnel
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/amalshaji/beaver/internal/server/admin"
+	"github.com/amalshaji/beaver/internal/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{input: "", want: ""},
+		{input: "identity", want: ""},
+		{input: "gzip", want: "gzip"},
+		{input: "gzip, deflate, br", want: "br"},
+		{input: "br;q=0.5, gzip", want: "gzip"},
+		{input: "br;q=0, gzip;q=0", want: ""},
+		{input: "*", want: "br"},
+		{input: "br;q=0, *", want: "gzip"},
+	}
+
+	for _, tc := range tests {
+		assert.Equal(t, tc.want, NegotiateEncoding(tc.input), tc.input)
+	}
+}
+
+func TestShouldCompress(t *testing.T) {
+	settings := &admin.CompressionSettings{
+		Enabled:   true,
+		MinSize:   100,
+		MimeTypes: []string{"text/*", "application/json"},
+	}
+
+	response := func(status int, contentType string, length int64, encoding string) *utils.HTTPResponse {
+		resp := utils.NewHTTPResponse()
+		resp.StatusCode = status
+		resp.ContentLength = length
+		resp.Header.Set("Content-Type", contentType)
+		if encoding != "" {
+			resp.Header.Set("Content-Encoding", encoding)
+		}
+		return resp
+	}
+
+	tests := []struct {
+		name     string
+		settings *admin.CompressionSettings
+		method   string
+		resp     *utils.HTTPResponse
+		want     bool
+	}{
+		{"html", settings, "GET", response(200, "text/html; charset=utf-8", 1000, ""), true},
+		{"json with unknown length", settings, "GET", response(200, "application/json", -1, ""), true},
+		{"too small", settings, "GET", response(200, "text/html", 10, ""), false},
+		{"not allowed", settings, "GET", response(200, "image/png", 1000, ""), false},
+		{"already encoded", settings, "GET", response(200, "text/html", 1000, "gzip"), false},
+		{"head request", settings, http.MethodHead, response(200, "text/html", 1000, ""), false},
+		{"not modified", settings, "GET", response(304, "text/html", 1000, ""), false},
+		{"disabled", &admin.CompressionSettings{}, "GET", response(200, "text/html", 1000, ""), false},
+		{"default mime types", &admin.CompressionSettings{Enabled: true}, "GET", response(200, "image/svg+xml", 1000, ""), true},
+	}
+
+	for _, tc := range tests {
+		assert.Equal(t, tc.want, ShouldCompress(tc.settings, tc.method, tc.resp), tc.name)
+	}
+}
+
+func TestWeakETag(t *testing.T) {
+	assert.Equal(t, `W/"abc"`, WeakETag(`"abc"`))
+	assert.Equal(t, `W/"abc"`, WeakETag(`W/"abc"`))
+}
@@ -1,10 +1,18 @@
 package tunnel
 
 import (
+	"fmt"
+	"net"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
+	"github.com/amalshaji/beaver/internal/server/admin"
+	"github.com/amalshaji/beaver/internal/server/cluster"
+	"github.com/amalshaji/beaver/internal/server/oidc"
+	"github.com/amalshaji/beaver/internal/traffic"
+	"github.com/labstack/echo/v4"
 	"gopkg.in/yaml.v3"
 )
 
@@ -22,6 +30,37 @@ type Config struct {
 	Timeout     int
 	IdleTimeout int
 	Users       []UserConfig
+
+	// Pool sizes advertised to clients, PoolMaxSize can be overridden per user
+	PoolIdleSize int
+	PoolMaxSize  int
+
+	// Time to let in-flight requests finish on shutdown (milliseconds),
+	// and server the clients are asked to move to while draining
+	DrainTimeout int
+	DrainTarget  string
+
+	// Heartbeat of idle connections (milliseconds)
+	PingInterval int
+	PongTimeout  int
+
+	// Multi-node mode, see the cluster package
+	Cluster cluster.Config
+
+	// Default compression settings for tunnels without their own
+	Compression admin.CompressionSettings
+
+	// Default capture settings for tunnels without their own, see capture.go
+	Capture admin.CaptureSettings
+
+	// Provider of the login gate of the tunnels, see the oidc package
+	OIDC oidc.Config
+
+	// Signs the share links of the tunnels, see sharelink.go
+	ShareLinkSecret string
+
+	// Proxies in front of the server (CIDRs or IPs), the client address is read from their X-Forwarded-For header
+	TrustedProxies []string
 }
 
 // GetAddr returns the address to specify a HTTP server address
@@ -34,6 +73,55 @@ func (c Config) GetTimeout() time.Duration {
 	return time.Duration(c.Timeout) * time.Millisecond
 }
 
+// GetDrainTimeout returns the time to wait for in-flight requests on shutdown
+func (c Config) GetDrainTimeout() time.Duration {
+	return time.Duration(c.DrainTimeout) * time.Millisecond
+}
+
+// GetPingInterval returns the interval between pings of an idle connection
+func (c Config) GetPingInterval() time.Duration {
+	return time.Duration(c.PingInterval) * time.Millisecond
+}
+
+// GetPongTimeout returns the time to wait for a pong before a connection is considered dead
+func (c Config) GetPongTimeout() time.Duration {
+	return time.Duration(c.PongTimeout) * time.Millisecond
+}
+
+// IPExtractor returns how the address of the clients is read from the requests to the tunnels.
+// Without trusted proxies, it is the address of the connection. The other nodes of a cluster are trusted,
+// they forward the requests of the tunnels they do not hold.
+func (c Config) IPExtractor() (echo.IPExtractor, error) {
+	proxies := c.TrustedProxies
+	if c.Cluster.Enabled {
+		peers, err := c.Cluster.PeerIPs()
+		if err != nil {
+			return nil, err
+		}
+		proxies = append(append([]string(nil), proxies...), peers...)
+	}
+	if len(proxies) == 0 {
+		return echo.ExtractIPDirect(), nil
+	}
+
+	options := []echo.TrustOption{echo.TrustLoopback(false), echo.TrustLinkLocal(false), echo.TrustPrivateNet(false)}
+	for _, proxy := range proxies {
+		if !strings.Contains(proxy, "/") {
+			if ip := net.ParseIP(proxy); ip != nil && ip.To4() != nil {
+				proxy += "/32"
+			} else {
+				proxy += "/128"
+			}
+		}
+		_, ipNet, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy '%s' : %w", proxy, err)
+		}
+		options = append(options, echo.TrustIPRange(ipNet))
+	}
+	return echo.ExtractIPFromXFFHeader(options...), nil
+}
+
 // NewConfig creates a new ProxyConfig
 func NewConfig() (config *Config) {
 	config = new(Config)
@@ -41,6 +129,23 @@ func NewConfig() (config *Config) {
 	config.Port = 8080
 	config.Timeout = 1000 // millisecond
 	config.IdleTimeout = 60000
+	config.PoolIdleSize = 1
+	config.PoolMaxSize = 100
+	config.DrainTimeout = 30000
+	config.PingInterval = 15000
+	config.PongTimeout = 5000
+	config.Compression = admin.CompressionSettings{
+		Enabled:   false,
+		MinSize:   1024,
+		MimeTypes: DefaultCompressibleMimeTypes,
+	}
+	config.Capture = admin.CaptureSettings{
+		Enabled:     false,
+		SampleRate:  1,
+		Retention:   24,
+		MaxRequests: 1000,
+		MaxBodySize: traffic.DefaultMaxBodySize,
+	}
 	return
 }
 
@@ -8,6 +8,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/amalshaji/beaver/internal/rewrite"
 	"github.com/amalshaji/beaver/internal/utils"
 	"github.com/gorilla/websocket"
 	"github.com/labstack/echo/v4"
@@ -24,6 +25,17 @@ const (
 	Closed
 )
 
+func (status ConnectionStatus) String() string {
+	switch status {
+	case Idle:
+		return "idle"
+	case Busy:
+		return "busy"
+	default:
+		return "closed"
+	}
+}
+
 // Connection manages a single websocket connection from the peer.
 // wsp supports multiple connections from a single peer at the same time.
 type Connection struct {
@@ -32,6 +44,11 @@ type Connection struct {
 	status    ConnectionStatus
 	idleSince time.Time
 	lock      sync.Mutex
+
+	// Heartbeat state, see heartbeat.go
+	lastSeen   time.Time
+	pingSentAt time.Time
+	rtt        time.Duration
 	// nextResponse is the channel of channel to wait an HTTP response.
 	//
 	// In advance, the `read` function waits to receive the HTTP response as a separate thread "reader".
@@ -55,6 +72,8 @@ func NewConnection(pool *Pool, ws *websocket.Conn) *Connection {
 	c.ws = ws
 	c.nextResponse = make(chan chan io.Reader)
 	c.status = Idle
+	c.lastSeen = time.Now()
+	c.ws.SetPongHandler(c.pong)
 
 	// Mark that this connection is ready to use for relay
 	c.Release()
@@ -117,17 +136,26 @@ func (connection *Connection) read() {
 	}
 }
 
-// Proxy a HTTP request through the Proxy over the websocket connection
-func (connection *Connection) ProxyRequest(c echo.Context) (err error) {
-	log.Printf("proxy request to %s", connection.pool.ID)
+// Proxy a HTTP request for one of the tunnels of the pool through the Proxy over the websocket connection
+func (connection *Connection) ProxyRequest(c echo.Context, subdomain string) (err error) {
+	log.Printf("proxy request to %s (%s)", connection.pool.ID, subdomain)
 
 	// Set host header
 	if c.Request().Header.Get("Host") == "" && c.Request().Host != "" {
 		c.Request().Header.Set("Host", c.Request().Host)
 	}
+	// Only the server rewrites the Host
+	c.Request().Header.Del(rewrite.HostHeader)
+	rules := connection.pool.Rewrite(subdomain)
+
+	start := time.Now()
+	capture := connection.pool.sampleCapture(subdomain, c.Request())
+	requestBody, responseBody := newBodyCapture(capture), newBodyCapture(capture)
 
 	// [1]: Serialize HTTP request
-	jsonReq, err := json.Marshal(utils.SerializeHTTPRequest(c.Request()))
+	// The request is captured as it was received, so a replay is rewritten again
+	httpRequest := utils.SerializeHTTPRequest(c.Request())
+	jsonReq, err := json.Marshal(rewriteRequest(rules, c.Request(), httpRequest))
 	if err != nil {
 		return fmt.Errorf("unable to serialize request : %w", err)
 	}
@@ -150,7 +178,7 @@ func (connection *Connection) ProxyRequest(c echo.Context) (err error) {
 	if err != nil {
 		return fmt.Errorf("unable to get request body writer : %w", err)
 	}
-	if _, err := io.Copy(bodyWriter, c.Request().Body); err != nil {
+	if _, err := io.Copy(teeBody(bodyWriter, requestBody), c.Request().Body); err != nil {
 		return fmt.Errorf("unable to pipe request body : %w", err)
 	}
 	if err := bodyWriter.Close(); err != nil {
@@ -187,12 +215,31 @@ func (connection *Connection) ProxyRequest(c echo.Context) (err error) {
 		return fmt.Errorf("unable to unserialize http response : %w", err)
 	}
 
+	if rules != nil {
+		rules.RewriteResponse(httpResponse.Header)
+	}
+
+	// Compress the response at the edge if the tunnel allows it and the caller accepts it
+	var encoding string
+	if ShouldCompress(connection.pool.CompressionSettings(subdomain), c.Request().Method, httpResponse) {
+		encoding = NegotiateEncoding(c.Request().Header.Get("Accept-Encoding"))
+	}
+
 	// Write response headers back to the client
 	for header, values := range httpResponse.Header {
 		for _, value := range values {
 			c.Response().Header().Add(header, value)
 		}
 	}
+	if encoding != "" {
+		c.Response().Header().Del("Content-Length")
+		c.Response().Header().Set("Content-Encoding", encoding)
+		c.Response().Header().Add("Vary", "Accept-Encoding")
+		// The compressed body is not the one the local server tagged
+		if etag := c.Response().Header().Get("ETag"); etag != "" {
+			c.Response().Header().Set("ETag", WeakETag(etag))
+		}
+	}
 	c.Response().WriteHeader(httpResponse.StatusCode)
 
 	// [5]: Wait the HTTP response body is ready
@@ -212,16 +259,30 @@ func (connection *Connection) ProxyRequest(c echo.Context) (err error) {
 
 	// [6]: Read the HTTP response body from the peer
 	// Pipe the HTTP response body right from the remote Proxy to the client
-	if _, err := io.Copy(c.Response().Writer, responseBodyReader); err != nil {
+	var responseWriter io.Writer = c.Response().Writer
+	var compressWriter io.WriteCloser
+	if encoding != "" {
+		compressWriter = NewCompressWriter(encoding, c.Response().Writer)
+		responseWriter = compressWriter
+	}
+	if _, err := io.Copy(teeBody(responseWriter, responseBody), responseBodyReader); err != nil {
 		close(responseBodyChannel)
 		return fmt.Errorf("unable to pipe response body : %w", err)
 	}
+	if compressWriter != nil {
+		if err := compressWriter.Close(); err != nil {
+			close(responseBodyChannel)
+			return fmt.Errorf("unable to flush compressed response body : %w", err)
+		}
+	}
 
 	// Notify read() that we are done reading the response body
 	close(responseBodyChannel)
 
 	connection.Release()
 
+	connection.pool.server.captureExchange(capture, subdomain, start, httpRequest, requestBody, httpResponse, responseBody)
+
 	return
 }
 
@@ -254,6 +315,10 @@ func (connection *Connection) Release() {
 	connection.idleSince = time.Now()
 	connection.status = Idle
 
+	// A completed request proves the peer is alive
+	connection.lastSeen = connection.idleSince
+	connection.pingSentAt = time.Time{}
+
 	go connection.pool.Offer(connection)
 }
 
@@ -0,0 +1,213 @@
+package tunnel
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/amalshaji/beaver/internal/utils"
+	"github.com/gorilla/websocket"
+)
+
+// ErrClientIDInUse is returned when a client session id is used by another user
+var ErrClientIDInUse = errors.New("client id used by another user")
+
+// ControlChannel is a websocket connection per client session, separate from the pooled
+// request connections, over which the server sends typed messages to the client.
+type ControlChannel struct {
+	server         *Server
+	ID             PoolID
+	UserIdentifier string
+
+	ws   *websocket.Conn
+	lock sync.Mutex
+	done chan struct{}
+}
+
+// CheckClientID refuses a client session id whose pool or control channel belongs to another user
+func (s *Server) CheckClientID(id PoolID, userIdentifier string) error {
+	s.Lock.RLock()
+	for _, pool := range s.uniquePools() {
+		if pool.ID == id && pool.UserIdentifier != userIdentifier {
+			s.Lock.RUnlock()
+			return ErrClientIDInUse
+		}
+	}
+	s.Lock.RUnlock()
+
+	s.controlLock.RLock()
+	defer s.controlLock.RUnlock()
+
+	if control, ok := s.controls[id]; ok && control.UserIdentifier != userIdentifier {
+		return ErrClientIDInUse
+	}
+	return nil
+}
+
+// RegisterControlChannel keeps the control channel of a client session until it is closed.
+// A previous channel of the same session is replaced, unless it belongs to another user.
+func (s *Server) RegisterControlChannel(id PoolID, userIdentifier string, ws *websocket.Conn) (*ControlChannel, error) {
+	control := &ControlChannel{
+		server:         s,
+		ID:             id,
+		UserIdentifier: userIdentifier,
+		ws:             ws,
+		done:           make(chan struct{}),
+	}
+
+	s.controlLock.Lock()
+	previous := s.controls[id]
+	if previous != nil && previous.UserIdentifier != userIdentifier {
+		s.controlLock.Unlock()
+		control.Close()
+		return nil, ErrClientIDInUse
+	}
+	s.controls[id] = control
+	s.controlLock.Unlock()
+
+	if previous != nil {
+		previous.Close()
+	}
+
+	log.Printf("Registering control channel from %s for user %s", id, userIdentifier)
+	go control.read()
+
+	return control, nil
+}
+
+// read discards incoming messages, it keeps processing control frames ( ping / pong / close )
+// and unregisters the channel once the peer is gone.
+func (control *ControlChannel) read() {
+	defer func() {
+		control.Close()
+
+		control.server.controlLock.Lock()
+		if control.server.controls[control.ID] == control {
+			delete(control.server.controls, control.ID)
+		}
+		control.server.controlLock.Unlock()
+	}()
+
+	for {
+		if _, _, err := control.ws.NextReader(); err != nil {
+			return
+		}
+	}
+}
+
+// Send writes a message to the client
+func (control *ControlChannel) Send(message *utils.ControlMessage) error {
+	control.lock.Lock()
+	defer control.lock.Unlock()
+
+	control.ws.SetWriteDeadline(time.Now().Add(5 * time.Second))
+	return control.ws.WriteJSON(message)
+}
+
+// Close the control channel
+func (control *ControlChannel) Close() {
+	control.lock.Lock()
+	defer control.lock.Unlock()
+
+	select {
+	case <-control.done:
+		return
+	default:
+	}
+	close(control.done)
+
+	control.ws.WriteControl(
+		websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""),
+		time.Now().Add(time.Second),
+	)
+	control.ws.Close()
+}
+
+// SendControlMessage sends a message to a client session
+func (s *Server) SendControlMessage(id PoolID, message *utils.ControlMessage) error {
+	s.controlLock.RLock()
+	control, ok := s.controls[id]
+	s.controlLock.RUnlock()
+
+	if !ok {
+		return fmt.Errorf("no control channel for %s", id)
+	}
+	return control.Send(message)
+}
+
+// NotifyUser sends a message to every client session of a user and returns the number of sessions reached
+func (s *Server) NotifyUser(userIdentifier string, message *utils.ControlMessage) int {
+	s.controlLock.RLock()
+	var controls []*ControlChannel
+	for _, control := range s.controls {
+		if control.UserIdentifier == userIdentifier {
+			controls = append(controls, control)
+		}
+	}
+	s.controlLock.RUnlock()
+
+	sent := 0
+	for _, control := range controls {
+		if err := control.Send(message); err != nil {
+			log.Printf("Unable to send %s message to %s : %v", message.Type, control.ID, err)
+			continue
+		}
+		sent++
+	}
+	return sent
+}
+
+// BroadcastControlMessage sends a message to every client session
+func (s *Server) BroadcastControlMessage(message *utils.ControlMessage) {
+	s.controlLock.RLock()
+	var controls []*ControlChannel
+	for _, control := range s.controls {
+		controls = append(controls, control)
+	}
+	s.controlLock.RUnlock()
+
+	for _, control := range controls {
+		if err := control.Send(message); err != nil {
+			log.Printf("Unable to send %s message to %s : %v", message.Type, control.ID, err)
+		}
+	}
+}
+
+// Kick asks a client session to disconnect, then closes its pools and control channel
+func (s *Server) Kick(id PoolID, reason string) error {
+	err := s.SendControlMessage(id, &utils.ControlMessage{Type: utils.ControlKick, Message: reason})
+
+	s.Lock.Lock()
+	for _, pool := range s.uniquePools() {
+		if pool.ID == id {
+			pool.Shutdown()
+		}
+	}
+	s.Lock.Unlock()
+
+	s.controlLock.RLock()
+	control, ok := s.controls[id]
+	s.controlLock.RUnlock()
+	if ok {
+		control.Close()
+	}
+
+	return err
+}
+
+// closeControlChannels closes every control channel, at shutdown
+func (s *Server) closeControlChannels() {
+	s.controlLock.RLock()
+	var controls []*ControlChannel
+	for _, control := range s.controls {
+		controls = append(controls, control)
+	}
+	s.controlLock.RUnlock()
+
+	for _, control := range controls {
+		control.Close()
+	}
+}
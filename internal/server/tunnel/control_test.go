@@ -0,0 +1,69 @@
+package tunnel
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/amalshaji/beaver/internal/utils"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestControlChannel(t *testing.T) {
+	server := new(Server)
+	server.Config = NewConfig()
+	server.Pools = make(map[string]*Pool)
+	server.controls = make(map[PoolID]*ControlChannel)
+
+	upgrader := websocket.Upgrader{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		server.RegisterControlChannel(PoolID(r.Header.Get("X-CLIENT-ID")), r.Header.Get("X-USER"), ws)
+	}))
+	defer ts.Close()
+
+	peer, _, err := websocket.DefaultDialer.Dial(
+		"ws"+strings.TrimPrefix(ts.URL, "http"),
+		http.Header{"X-CLIENT-ID": {"test"}, "X-USER": {"test@beaver.com"}},
+	)
+	assert.NoError(t, err)
+	defer peer.Close()
+
+	assert.Eventually(t, func() bool {
+		return server.NotifyUser("test@beaver.com", &utils.ControlMessage{Type: utils.ControlNotice, Message: "hello"}) == 1
+	}, time.Second, 10*time.Millisecond)
+
+	// Another user cannot take over the session
+	assert.ErrorIs(t, server.CheckClientID("test", "other@beaver.com"), ErrClientIDInUse)
+	assert.NoError(t, server.CheckClientID("test", "test@beaver.com"))
+	intruder, _, err := websocket.DefaultDialer.Dial(
+		"ws"+strings.TrimPrefix(ts.URL, "http"),
+		http.Header{"X-CLIENT-ID": {"test"}, "X-USER": {"other@beaver.com"}},
+	)
+	assert.NoError(t, err)
+	_, _, err = intruder.ReadMessage()
+	assert.Error(t, err)
+	intruder.Close()
+	assert.Equal(t, 0, server.NotifyUser("other@beaver.com", &utils.ControlMessage{Type: utils.ControlNotice}))
+
+	var message utils.ControlMessage
+	assert.NoError(t, peer.ReadJSON(&message))
+	assert.Equal(t, utils.ControlMessage{Type: utils.ControlNotice, Message: "hello"}, message)
+
+	// Kicking the session closes its control channel
+	assert.NoError(t, server.Kick("test", "bye"))
+	assert.NoError(t, peer.ReadJSON(&message))
+	assert.Equal(t, utils.ControlKick, message.Type)
+	_, _, err = peer.ReadMessage()
+	assert.Error(t, err)
+
+	assert.Eventually(t, func() bool {
+		return server.SendControlMessage("test", &utils.ControlMessage{Type: utils.ControlNotice}) != nil
+	}, time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,63 @@
+package tunnel
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/amalshaji/beaver/internal/utils"
+)
+
+// BeginRequest marks a proxied request as in-flight, it returns false if the server is shut down
+func (s *Server) BeginRequest() bool {
+	select {
+	case <-s.done:
+		return false
+	default:
+	}
+	s.inflight.Add(1)
+	return true
+}
+
+// EndRequest marks a proxied request as done
+func (s *Server) EndRequest() {
+	s.inflight.Add(-1)
+}
+
+// IsDraining reports whether the server is draining, it is not ready for new clients
+func (s *Server) IsDraining() bool {
+	return s.draining.Load()
+}
+
+// Drain stops accepting new registrations, asks the clients to move and waits for
+// in-flight requests to finish, until the context is done.
+// With reconnect, the clients reconnect right away as another process took over the listener.
+// The connections are left open, Shutdown closes them.
+func (s *Server) Drain(ctx context.Context, reconnect bool) {
+	if s.draining.Swap(true) {
+		return
+	}
+
+	log.Printf("Draining, %d in-flight requests", s.inflight.Load())
+
+	s.BroadcastControlMessage(&utils.ControlMessage{
+		Type:      utils.ControlDrain,
+		Message:   "server is shutting down",
+		Target:    s.Config.DrainTarget,
+		Reconnect: reconnect,
+	})
+
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	for s.inflight.Load() > 0 {
+		select {
+		case <-ctx.Done():
+			log.Printf("Drain deadline reached, %d in-flight requests will be cut", s.inflight.Load())
+			return
+		case <-ticker.C:
+		}
+	}
+
+	log.Println("Drained")
+}
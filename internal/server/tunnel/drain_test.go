@@ -0,0 +1,111 @@
+package tunnel
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/amalshaji/beaver/internal/utils"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+// newControlPeer connects a client session to the control channel of the server
+func newControlPeer(t *testing.T, server *Server, id PoolID) *websocket.Conn {
+	upgrader := websocket.Upgrader{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		server.RegisterControlChannel(id, "test@beaver.com", ws)
+	}))
+	t.Cleanup(ts.Close)
+
+	peer, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { peer.Close() })
+
+	assert.Eventually(t, func() bool {
+		server.controlLock.RLock()
+		defer server.controlLock.RUnlock()
+		return server.controls[id] != nil
+	}, time.Second, 10*time.Millisecond)
+	return peer
+}
+
+func TestDrainWaitsForInflightRequests(t *testing.T) {
+	server := newTestServer(t)
+	server.Config.DrainTarget = "backup.beaver.com"
+	peer := newControlPeer(t, server, "session-1")
+
+	assert.True(t, server.BeginRequest())
+
+	drained := make(chan struct{})
+	go func() {
+		server.Drain(context.Background(), false)
+		close(drained)
+	}()
+
+	// The clients are asked to move to the drain target
+	var message utils.ControlMessage
+	assert.NoError(t, peer.ReadJSON(&message))
+	assert.Equal(t, utils.ControlMessage{Type: utils.ControlDrain, Message: "server is shutting down", Target: "backup.beaver.com"}, message)
+	assert.True(t, server.IsDraining())
+
+	select {
+	case <-drained:
+		t.Fatal("drain returned with an in-flight request")
+	case <-time.After(300 * time.Millisecond):
+	}
+
+	server.EndRequest()
+	select {
+	case <-drained:
+	case <-time.After(time.Second):
+		t.Fatal("drain did not return once the request ended")
+	}
+
+	// Draining again is a no-op
+	server.Drain(context.Background(), false)
+}
+
+func TestDrainForAnUpgrade(t *testing.T) {
+	server := newTestServer(t)
+	peer := newControlPeer(t, server, "session-1")
+
+	// Another process took over the listener, the clients reconnect to the same address right away
+	server.Drain(context.Background(), true)
+
+	var message utils.ControlMessage
+	assert.NoError(t, peer.ReadJSON(&message))
+	assert.Equal(t, utils.ControlMessage{Type: utils.ControlDrain, Message: "server is shutting down", Reconnect: true}, message)
+}
+
+func TestDrainDeadline(t *testing.T) {
+	server := newTestServer(t)
+	assert.True(t, server.BeginRequest())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	server.Drain(ctx, false)
+	assert.WithinDuration(t, start.Add(200*time.Millisecond), time.Now(), 200*time.Millisecond)
+	assert.EqualValues(t, 1, server.inflight.Load())
+}
+
+func TestBeginRequestAfterShutdown(t *testing.T) {
+	server := newTestServer(t)
+	assert.True(t, server.BeginRequest())
+	server.EndRequest()
+
+	close(server.done)
+	assert.False(t, server.BeginRequest())
+	assert.EqualValues(t, 0, server.inflight.Load())
+}
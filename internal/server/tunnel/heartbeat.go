@@ -0,0 +1,102 @@
+package tunnel
+
+import (
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// ping sends a ping to the peer, carrying the send time to measure the round trip.
+// This MUST be surrounded by connection.lock.Lock()
+func (connection *Connection) ping(now time.Time) error {
+	connection.pingSentAt = now
+	return connection.ws.WriteControl(
+		websocket.PingMessage,
+		[]byte(strconv.FormatInt(now.UnixNano(), 10)),
+		now.Add(connection.pool.server.Config.GetPongTimeout()),
+	)
+}
+
+// pong is the websocket pong handler, it runs in the read() goroutine
+func (connection *Connection) pong(data string) error {
+	connection.lock.Lock()
+	defer connection.lock.Unlock()
+
+	now := time.Now()
+	connection.lastSeen = now
+	connection.pingSentAt = time.Time{}
+
+	// Pongs of pings sent by someone else do not carry a timestamp
+	if sentAt, err := strconv.ParseInt(data, 10, 64); err == nil {
+		connection.rtt = now.Sub(time.Unix(0, sentAt))
+	}
+	return nil
+}
+
+// RTT returns the last measured round trip time of the connection
+func (connection *Connection) RTT() time.Duration {
+	connection.lock.Lock()
+	defer connection.lock.Unlock()
+
+	return connection.rtt
+}
+
+// heartbeat pings idle connections and evicts the ones which did not answer in time.
+// Busy connections are skipped, the peer does not read control messages while it executes a request.
+func (pool *Pool) heartbeat(now time.Time) {
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+
+	interval := pool.server.Config.GetPingInterval()
+	timeout := pool.server.Config.GetPongTimeout()
+
+	var connections []*Connection
+	for _, connection := range pool.connections {
+		connection.lock.Lock()
+		if connection.status == Idle {
+			if !connection.pingSentAt.IsZero() {
+				if now.Sub(connection.pingSentAt) > timeout {
+					log.Printf("Evicting dead connection from %s, no pong in %s", pool.ID, timeout)
+					connection.close()
+					// The peer is gone, don't wait for the close handshake
+					connection.ws.Close()
+				}
+			} else if now.Sub(connection.lastSeen) >= interval {
+				if err := connection.ping(now); err != nil {
+					log.Printf("Unable to ping connection from %s : %v", pool.ID, err)
+					connection.close()
+					connection.ws.Close()
+				}
+			}
+		}
+		closed := connection.status == Closed
+		connection.lock.Unlock()
+
+		if closed {
+			continue
+		}
+		connections = append(connections, connection)
+	}
+	pool.connections = connections
+}
+
+// heartbeat runs the pool heartbeats until the server is shut down
+func (s *Server) heartbeat() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case now := <-ticker.C:
+			s.Lock.RLock()
+			for _, pool := range s.uniquePools() {
+				pool.heartbeat(now)
+			}
+			s.Lock.RUnlock()
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package tunnel
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestPool returns a pool with one connection, and the peer side of the websocket
+func newTestPool(t *testing.T) (*Pool, *websocket.Conn) {
+	server := new(Server)
+	server.Config = NewConfig()
+	server.Config.PingInterval = 10
+	server.Config.PongTimeout = 50
+
+	pool := NewPool(server, "test", "test", "http://localhost:9999", "test@beaver.com")
+
+	registered := make(chan struct{})
+	upgrader := websocket.Upgrader{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pool.Register(ws)
+		close(registered)
+	}))
+	t.Cleanup(ts.Close)
+
+	peer, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { peer.Close() })
+	<-registered
+
+	return pool, peer
+}
+
+func TestHeartbeatMeasuresRTT(t *testing.T) {
+	pool, peer := newTestPool(t)
+
+	// The peer answers pings while it reads
+	go func() {
+		for {
+			if _, _, err := peer.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	time.Sleep(20 * time.Millisecond)
+	pool.heartbeat(time.Now())
+
+	assert.Eventually(t, func() bool {
+		return pool.connections[0].RTT() > 0
+	}, time.Second, 10*time.Millisecond)
+
+	pool.heartbeat(time.Now().Add(time.Second))
+	assert.Equal(t, 1, len(pool.connections))
+}
+
+func TestHeartbeatEvictsDeadConnection(t *testing.T) {
+	pool, _ := newTestPool(t)
+
+	// The peer never reads, so it never answers the ping
+	now := time.Now().Add(time.Second)
+	pool.heartbeat(now)
+	assert.Equal(t, 1, len(pool.connections))
+
+	pool.heartbeat(now.Add(time.Second))
+	assert.Equal(t, 0, len(pool.connections))
+	assert.Nil(t, pool.idleConnection())
+}
@@ -2,22 +2,42 @@ package tunnel
 
 import (
 	"log"
+	"sort"
 	"sync"
 	"time"
 
+	"github.com/amalshaji/beaver/internal/rewrite"
+	"github.com/amalshaji/beaver/internal/server/admin"
+	"github.com/amalshaji/beaver/internal/webhook"
 	"github.com/gorilla/websocket"
 )
 
 // Pool handles all connections from the peer.
+// The connections are shared by every tunnel of the client session.
 type Pool struct {
-	server         *Server
-	ID             PoolID
+	server *Server
+	ID     PoolID
+	// First tunnel registered by the client session
 	Subdomain      string
-	LocalServer    string
 	UserIdentifier string
 
+	// Local server of each tunnel, by subdomain
+	tunnels map[string]string
+
 	size int
 
+	// Observed concurrency, see sizing.go
+	peakBusy         int
+	previousPeakBusy int
+	windowStart      time.Time
+
+	// Per tunnel settings, by subdomain. Missing when the server defaults apply
+	settings map[string]*admin.TunnelSettings
+	// Basic auth, IP filters and webhook signatures registered by the client, by subdomain, see auth.go and webhook.go
+	basicAuth map[string]*admin.BasicAuthSettings
+	ipFilters map[string]*admin.IPFilterSettings
+	webhooks  map[string]*webhook.Spec
+
 	connections []*Connection
 	idle        chan *Connection
 
@@ -34,9 +54,14 @@ func NewPool(server *Server, id PoolID, subdomain, localServer, userIdentifier s
 	p.server = server
 	p.ID = id
 	p.Subdomain = subdomain
-	p.LocalServer = localServer
 	p.UserIdentifier = userIdentifier
+	p.tunnels = map[string]string{subdomain: localServer}
+	p.settings = make(map[string]*admin.TunnelSettings)
+	p.basicAuth = make(map[string]*admin.BasicAuthSettings)
+	p.ipFilters = make(map[string]*admin.IPFilterSettings)
+	p.webhooks = make(map[string]*webhook.Spec)
 	p.idle = make(chan *Connection)
+	p.windowStart = time.Now()
 	return p
 }
 
@@ -63,6 +88,19 @@ func (pool *Pool) Offer(connection *Connection) {
 	pool.idle <- connection
 }
 
+// idleConnection returns the first idle connection of the pool, or nil
+func (pool *Pool) idleConnection() *Connection {
+	pool.lock.RLock()
+	defer pool.lock.RUnlock()
+
+	for _, connection := range pool.connections {
+		if connection.status == Idle {
+			return connection
+		}
+	}
+	return nil
+}
+
 // Clean removes dead connection from the pool
 // Look for dead connection in the pool
 // This MUST be surrounded by pool.lock.Lock()
@@ -140,6 +178,179 @@ func (pool *Pool) Size() (ps *PoolSize) {
 	return
 }
 
-func (pool *Pool) SetSize(n int) {
-	pool.size = n
+// Tunnel maps a subdomain to the local server of the client
+type Tunnel struct {
+	Subdomain   string `json:"subdomain"`
+	LocalServer string `json:"local_server"`
+	// Set when the client protects the tunnel with basic auth, restricts its clients or verifies its webhooks
+	BasicAuth *admin.BasicAuthSettings `json:"-"`
+	IPFilter  *admin.IPFilterSettings  `json:"-"`
+	Webhook   *webhook.Spec            `json:"-"`
+}
+
+// Tunnels returns the tunnels served by the pool, sorted by subdomain
+func (pool *Pool) Tunnels() []Tunnel {
+	pool.lock.RLock()
+	defer pool.lock.RUnlock()
+
+	tunnels := make([]Tunnel, 0, len(pool.tunnels))
+	for subdomain, localServer := range pool.tunnels {
+		tunnels = append(tunnels, Tunnel{Subdomain: subdomain, LocalServer: localServer})
+	}
+	sort.Slice(tunnels, func(i, j int) bool { return tunnels[i].Subdomain < tunnels[j].Subdomain })
+	return tunnels
+}
+
+// LocalServer returns the local server of a tunnel, or an empty string if the pool does not serve it
+func (pool *Pool) LocalServer(subdomain string) string {
+	pool.lock.RLock()
+	defer pool.lock.RUnlock()
+
+	return pool.tunnels[subdomain]
+}
+
+// setTunnels replaces the tunnels served by the pool and returns the subdomains it stopped serving
+func (pool *Pool) setTunnels(tunnels []Tunnel) (removed []string) {
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+
+	current := make(map[string]string, len(tunnels))
+	basicAuth := make(map[string]*admin.BasicAuthSettings)
+	ipFilters := make(map[string]*admin.IPFilterSettings)
+	webhooks := make(map[string]*webhook.Spec)
+	for _, tunnel := range tunnels {
+		current[tunnel.Subdomain] = tunnel.LocalServer
+		if tunnel.BasicAuth != nil {
+			basicAuth[tunnel.Subdomain] = tunnel.BasicAuth
+		}
+		if tunnel.IPFilter != nil {
+			ipFilters[tunnel.Subdomain] = tunnel.IPFilter
+		}
+		if tunnel.Webhook != nil {
+			webhooks[tunnel.Subdomain] = tunnel.Webhook
+		}
+	}
+	for subdomain := range pool.tunnels {
+		if _, ok := current[subdomain]; !ok {
+			removed = append(removed, subdomain)
+			delete(pool.settings, subdomain)
+		}
+	}
+	pool.tunnels = current
+	pool.basicAuth = basicAuth
+	pool.ipFilters = ipFilters
+	pool.webhooks = webhooks
+
+	if _, ok := current[pool.Subdomain]; !ok && len(tunnels) > 0 {
+		pool.Subdomain = tunnels[0].Subdomain
+	}
+	return
+}
+
+// removeTunnel stops serving one of the tunnels of the pool, the others keep their settings
+func (pool *Pool) removeTunnel(subdomain string) {
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+
+	delete(pool.tunnels, subdomain)
+	delete(pool.settings, subdomain)
+	delete(pool.basicAuth, subdomain)
+	delete(pool.ipFilters, subdomain)
+	delete(pool.webhooks, subdomain)
+
+	if pool.Subdomain == subdomain {
+		for other := range pool.tunnels {
+			if pool.Subdomain == subdomain || other < pool.Subdomain {
+				pool.Subdomain = other
+			}
+		}
+	}
+}
+
+// SetSettings replaces the settings of one of the tunnels of the pool
+func (pool *Pool) SetSettings(subdomain string, settings *admin.TunnelSettings) {
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+
+	if settings == nil {
+		delete(pool.settings, subdomain)
+		return
+	}
+	pool.settings[subdomain] = settings
+}
+
+// CompressionSettings returns the compression settings for a tunnel,
+// falling back to the server defaults
+func (pool *Pool) CompressionSettings(subdomain string) *admin.CompressionSettings {
+	pool.lock.RLock()
+	defer pool.lock.RUnlock()
+
+	if settings, ok := pool.settings[subdomain]; ok && settings.Compression != nil {
+		return settings.Compression
+	}
+	return &pool.server.Config.Compression
+}
+
+// Rewrite returns the rewrite rules of a tunnel, nil when it has none.
+// The rules of the client config are applied by the client.
+func (pool *Pool) Rewrite(subdomain string) *rewrite.Rules {
+	pool.lock.RLock()
+	defer pool.lock.RUnlock()
+
+	if settings, ok := pool.settings[subdomain]; ok {
+		return settings.Rewrite
+	}
+	return nil
+}
+
+// CaptureSettings returns the capture settings for a tunnel, falling back to the server defaults
+func (pool *Pool) CaptureSettings(subdomain string) *admin.CaptureSettings {
+	pool.lock.RLock()
+	defer pool.lock.RUnlock()
+
+	return mergeCaptureSettings(pool.server.Config.Capture, pool.settings[subdomain])
+}
+
+// ConnectionInfo describes a pooled connection
+type ConnectionInfo struct {
+	Status    string    `json:"status"`
+	IdleSince time.Time `json:"idle_since"`
+	LastSeen  time.Time `json:"last_seen"`
+	RTT       float64   `json:"rtt_ms"`
+}
+
+// PoolInfo describes a connected tunnel and its connections
+type PoolInfo struct {
+	ID          PoolID           `json:"id"`
+	Subdomain   string           `json:"subdomain"`
+	Tunnels     []Tunnel         `json:"tunnels"`
+	User        string           `json:"user"`
+	Connections []ConnectionInfo `json:"connections"`
+}
+
+// Info returns the state of the pool and of each connection
+func (pool *Pool) Info() *PoolInfo {
+	tunnels := pool.Tunnels()
+
+	pool.lock.RLock()
+	defer pool.lock.RUnlock()
+
+	info := &PoolInfo{
+		ID:          pool.ID,
+		Subdomain:   pool.Subdomain,
+		Tunnels:     tunnels,
+		User:        pool.UserIdentifier,
+		Connections: make([]ConnectionInfo, 0, len(pool.connections)),
+	}
+	for _, connection := range pool.connections {
+		connection.lock.Lock()
+		info.Connections = append(info.Connections, ConnectionInfo{
+			Status:    connection.status.String(),
+			IdleSince: connection.idleSince,
+			LastSeen:  connection.lastSeen,
+			RTT:       float64(connection.rtt.Microseconds()) / 1000,
+		})
+		connection.lock.Unlock()
+	}
+	return info
 }
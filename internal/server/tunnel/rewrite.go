@@ -0,0 +1,24 @@
+package tunnel
+
+import (
+	"net/http"
+
+	"github.com/amalshaji/beaver/internal/rewrite"
+	"github.com/amalshaji/beaver/internal/utils"
+)
+
+// rewriteRequest returns the request sent to the client, rewritten with the rules of the tunnel.
+// The client routes the request with its Host header, the rewritten Host is sent in rewrite.HostHeader.
+func rewriteRequest(rules *rewrite.Rules, req *http.Request, httpRequest *utils.HTTPRequest) *utils.HTTPRequest {
+	if rules == nil {
+		return httpRequest
+	}
+
+	rewritten := req.Clone(req.Context())
+	rules.RewriteRequest(rewritten)
+	if rules.Host != "" {
+		rewritten.Header.Set("Host", req.Header.Get("Host"))
+		rewritten.Header.Set(rewrite.HostHeader, rules.Host)
+	}
+	return utils.SerializeHTTPRequest(rewritten)
+}
@@ -0,0 +1,33 @@
+package tunnel
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/amalshaji/beaver/internal/rewrite"
+	"github.com/amalshaji/beaver/internal/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRewriteRequest(t *testing.T) {
+	req := httptest.NewRequest("GET", "http://localhost:8000/api/users", nil)
+	req.Header.Set("Host", "web.localhost")
+	req.Header.Set("Cookie", "session=1")
+	received := utils.SerializeHTTPRequest(req)
+
+	assert.Same(t, received, rewriteRequest(nil, req, received))
+
+	rules := &rewrite.Rules{Host: "myapp.test", StripPrefix: "/api", Request: rewrite.HeaderRules{Remove: []string{"Cookie"}}}
+	forwarded := rewriteRequest(rules, req, received)
+	assert.Equal(t, "http://localhost:8000/users", forwarded.URL)
+	// The client still routes the request with its Host header
+	assert.Equal(t, "web.localhost", http.Header(forwarded.Header).Get("Host"))
+	assert.Equal(t, "myapp.test", http.Header(forwarded.Header).Get(rewrite.HostHeader))
+	assert.Empty(t, http.Header(forwarded.Header).Get("Cookie"))
+
+	// The received request is captured as is
+	assert.Equal(t, "http://localhost:8000/api/users", received.URL)
+	assert.Equal(t, "session=1", http.Header(received.Header).Get("Cookie"))
+	assert.Empty(t, http.Header(received.Header).Get(rewrite.HostHeader))
+}
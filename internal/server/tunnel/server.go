@@ -2,15 +2,21 @@ package tunnel
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"math/rand"
 	"net/url"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/amalshaji/beaver/internal/server/admin"
+	"github.com/amalshaji/beaver/internal/server/cluster"
+	"github.com/amalshaji/beaver/internal/server/oidc"
+	"github.com/amalshaji/beaver/internal/utils"
 	"github.com/gorilla/websocket"
 	"gorm.io/gorm"
 )
@@ -38,13 +44,42 @@ type Server struct {
 	Lock sync.RWMutex
 	done chan struct{}
 
+	// Graceful shutdown, see drain.go
+	draining atomic.Bool
+	inflight atomic.Int64
+
 	// Through Dispatcher channel it communicates between "server" thread and "Dispatcher" thread.
 	// "server" thread sends the value to this channel when accepting requests in the endpoint /requests,
 	// and "Dispatcher" thread reads this channel.
 	Dispatcher chan *ConnectionRequest
 
+	// Control channels of the client sessions
+	controls    map[PoolID]*ControlChannel
+	controlLock sync.RWMutex
+
 	// DB connection
 	DB *gorm.DB
+
+	// Per tunnel settings store
+	TunnelSettings *admin.TunnelService
+
+	// Registry of the subdomains owned by each node, nil when not running in cluster mode
+	Registry cluster.Registry
+	Node     cluster.Node
+
+	// Captured requests store, see capture.go
+	Captures *admin.CaptureService
+
+	// Credentials verified by the basic auth of the tunnels, and failed logins, see auth.go
+	basicAuth     basicAuthCache
+	loginThrottle loginThrottle
+
+	// Login gate of the tunnels, nil when no OpenID Connect provider is configured
+	OIDC *oidc.Gate
+
+	// Share links granting access to the tunnels, see sharelink.go
+	ShareLinks  *admin.ShareLinkService
+	shareSigner *oidc.Signer
 }
 
 // ConnectionRequest is used to request a proxy connection from the dispatcher
@@ -77,11 +112,36 @@ func NewServer(configFile string, db *gorm.DB) (server *Server) {
 	server.Config = config
 	server.Upgrader = websocket.Upgrader{}
 	server.Pools = make(map[string]*Pool)
+	server.controls = make(map[PoolID]*ControlChannel)
 
 	server.done = make(chan struct{})
 	server.Dispatcher = make(chan *ConnectionRequest)
 
 	server.DB = db
+	server.TunnelSettings = admin.NewTunnelService(db)
+	server.Captures = admin.NewCaptureService(db)
+	server.ShareLinks = admin.NewShareLinkService(db)
+	server.shareSigner = newShareSigner(config.ShareLinkSecret)
+
+	if config.Cluster.Enabled {
+		if config.Cluster.NodeID == "" || config.Cluster.AdvertiseAddr == "" || config.Cluster.Secret == "" {
+			log.Fatal("Cluster mode requires a nodeid, an advertiseaddr and a secret")
+		}
+		server.Node = cluster.Node{ID: config.Cluster.NodeID, Addr: config.Cluster.AdvertiseAddr}
+		server.Registry = cluster.NewGossipRegistry(config.Cluster, server.loseSubdomain)
+	}
+
+	if _, err := config.IPExtractor(); err != nil {
+		log.Fatal(err)
+	}
+
+	if config.OIDC.Enabled() {
+		scheme := "http"
+		if config.Secure {
+			scheme = "https"
+		}
+		server.OIDC = oidc.NewGate(config.OIDC, fmt.Sprintf("%s://%s/api/v1/oidc/callback", scheme, config.Domain), config.Secure)
+	}
 
 	return
 }
@@ -100,6 +160,23 @@ func (s *Server) Start() {
 		}
 	}()
 
+	// Delete the captured requests beyond their retention
+	go func() {
+		ticker := time.NewTicker(capturePurgeInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-s.done:
+				return
+			case <-ticker.C:
+				s.purgeCaptures()
+			}
+		}
+	}()
+
+	// Ping idle connections and evict the dead ones
+	go s.heartbeat()
+
 	// Dispatch connection from available pools to clients requests
 	// in a separate thread from the server thread.
 	go s.DispatchConnections()
@@ -121,14 +198,24 @@ func (s *Server) clean() {
 	var inactiveConnections = make([]string, 0)
 
 	pools := make(map[string]*Pool)
-	for subdomain, pool := range s.Pools {
+	for _, pool := range s.uniquePools() {
+		tunnels := pool.Tunnels()
+
 		if pool.IsEmpty() {
 			inactiveConnections = append(inactiveConnections, pool.UserIdentifier)
 
 			log.Printf("Removing empty connection pool : %s", pool.ID)
 			pool.Shutdown()
+
+			if s.Registry != nil {
+				for _, tunnel := range tunnels {
+					s.Registry.Release(tunnel.Subdomain)
+				}
+			}
 		} else {
-			pools[subdomain] = pool
+			for _, tunnel := range tunnels {
+				pools[tunnel.Subdomain] = pool
+			}
 		}
 
 		ps := pool.Size()
@@ -138,7 +225,7 @@ func (s *Server) clean() {
 
 	s.updateInactiveStatusForClosedConnections(inactiveConnections...)
 
-	log.Printf("%d pools, %d idle, %d busy", len(pools), idle, busy)
+	log.Printf("%d tunnels, %d idle, %d busy", len(pools), idle, busy)
 
 	s.Pools = pools
 }
@@ -192,13 +279,7 @@ func (s *Server) DispatchConnections() {
 				break
 			}
 
-			var connection *Connection
-			for _, conn := range pool.connections {
-				if conn.status == Idle {
-					connection = conn
-					break
-				}
-			}
+			connection := pool.idleConnection()
 			s.Lock.RUnlock()
 
 			if connection == nil {
@@ -207,6 +288,7 @@ func (s *Server) DispatchConnections() {
 
 			// [2]: Verify that we can use this connection and take it.
 			if connection.Take() {
+				pool.observeConcurrency()
 				request.Connection <- connection
 				break
 			}
@@ -244,27 +326,182 @@ func (s *Server) GetSubdomainFromHost(host string) (string, error) {
 func (s *Server) Shutdown() {
 	close(s.done)
 	close(s.Dispatcher)
-	for _, pool := range s.Pools {
+	for _, pool := range s.uniquePools() {
 		pool.Shutdown()
 	}
+	s.closeControlChannels()
 	s.clean()
+
+	if s.Registry != nil {
+		s.Registry.Close()
+	}
 }
 
-func (s *Server) GetOrCreatePoolForUser(subdomain, localServer, userIdentifier string, id PoolID) (*Pool, error) {
-	// There is no need to create a new pool,
-	// if it is already registered in current pools.
-	p, ok := s.Pools[subdomain]
+// GetOrCreatePoolForUser returns the pool of a client session and updates the tunnels it serves.
+// Tunnels whose subdomain is used by another session are left out and returned in rejected,
+// an error is returned if none of them could be registered.
+// This MUST be surrounded by s.Lock.Lock()
+func (s *Server) GetOrCreatePoolForUser(tunnels []Tunnel, userIdentifier string, id PoolID) (pool *Pool, rejected map[string]error, err error) {
+	rejected = make(map[string]error)
+
+	// The session id must not let a user take over the pool of another one
+	for _, p := range s.Pools {
+		if p.ID == id && p.UserIdentifier != userIdentifier {
+			return nil, rejected, ErrClientIDInUse
+		}
+	}
+
+	var accepted []Tunnel
+	for _, tunnel := range tunnels {
+		// There is no need to claim the subdomain if the session already serves it
+		if p, ok := s.Pools[tunnel.Subdomain]; ok {
+			if p.ID != id {
+				rejected[tunnel.Subdomain] = fmt.Errorf("subdomain already in use")
+				continue
+			}
+		} else if s.Registry != nil {
+			// In cluster mode, the subdomain might be served by another node
+			if err := s.Registry.Claim(tunnel.Subdomain); err != nil {
+				rejected[tunnel.Subdomain] = err
+				continue
+			}
+		}
+		accepted = append(accepted, tunnel)
+	}
+
+	if len(accepted) == 0 {
+		for _, tunnel := range tunnels {
+			return nil, rejected, rejected[tunnel.Subdomain]
+		}
+		return nil, rejected, fmt.Errorf("no tunnel to register")
+	}
+
+	for _, p := range s.Pools {
+		if p.ID == id {
+			pool = p
+			break
+		}
+	}
+	if pool == nil {
+		pool = NewPool(s, id, accepted[0].Subdomain, accepted[0].LocalServer, userIdentifier)
+	}
+
+	// The client session might have added or removed tunnels since its previous connection
+	for _, subdomain := range pool.setTunnels(accepted) {
+		delete(s.Pools, subdomain)
+		if s.Registry != nil {
+			s.Registry.Release(subdomain)
+		}
+	}
+	for _, tunnel := range accepted {
+		if _, ok := s.Pools[tunnel.Subdomain]; !ok {
+			pool.SetSettings(tunnel.Subdomain, s.loadTunnelSettings(tunnel.Subdomain))
+			s.Pools[tunnel.Subdomain] = pool
+		}
+	}
+
+	return pool, rejected, nil
+}
+
+// uniquePools returns every pool once, s.Pools has an entry for each tunnel of a pool.
+// This MUST be surrounded by s.Lock.RLock()
+func (s *Server) uniquePools() []*Pool {
+	seen := make(map[*Pool]struct{}, len(s.Pools))
+	pools := make([]*Pool, 0, len(s.Pools))
+	for _, pool := range s.Pools {
+		if _, ok := seen[pool]; ok {
+			continue
+		}
+		seen[pool] = struct{}{}
+		pools = append(pools, pool)
+	}
+	return pools
+}
+
+// loadTunnelSettings returns the stored settings for a subdomain, or nil if there are none
+func (s *Server) loadTunnelSettings(subdomain string) *admin.TunnelSettings {
+	settings, err := s.TunnelSettings.GetTunnelSettings(context.Background(), subdomain)
+	if err != nil {
+		if !errors.Is(err, admin.ErrTunnelSettingsNotFound) {
+			log.Printf("Unable to load settings for tunnel %s: %v", subdomain, err)
+		}
+		return nil
+	}
+	return settings
+}
+
+// ApplyTunnelSettings updates the settings of a live tunnel, if it is connected
+func (s *Server) ApplyTunnelSettings(subdomain string, settings *admin.TunnelSettings) {
+	s.Lock.RLock()
+	defer s.Lock.RUnlock()
+
+	if pool, ok := s.Pools[subdomain]; ok {
+		pool.SetSettings(subdomain, settings)
+	}
+}
+
+// ListPools returns the state of every connected tunnel
+func (s *Server) ListPools() []*PoolInfo {
+	s.Lock.RLock()
+	defer s.Lock.RUnlock()
+
+	pools := make([]*PoolInfo, 0, len(s.Pools))
+	for _, pool := range s.uniquePools() {
+		pools = append(pools, pool.Info())
+	}
+	sort.Slice(pools, func(i, j int) bool { return pools[i].Subdomain < pools[j].Subdomain })
+	return pools
+}
+
+// RemoteOwner returns the node serving the subdomain, if it is another node of the cluster
+func (s *Server) RemoteOwner(subdomain string) (cluster.Node, bool) {
+	if s.Registry == nil {
+		return cluster.Node{}, false
+	}
+	node, ok := s.Registry.Lookup(subdomain)
+	if !ok || node.ID == s.Node.ID {
+		return cluster.Node{}, false
+	}
+	return node, true
+}
+
+// loseSubdomain stops serving a subdomain claimed first by another node of the cluster.
+// The client session reconnects, its tunnel is then refused and reported as in use on another node.
+func (s *Server) loseSubdomain(subdomain string) {
+	s.Lock.Lock()
+	pool, ok := s.Pools[subdomain]
+	if ok {
+		delete(s.Pools, subdomain)
+		pool.removeTunnel(subdomain)
+	}
+	s.Lock.Unlock()
 	if !ok {
-		pool := NewPool(s, id, subdomain, localServer, userIdentifier)
-		s.Pools[subdomain] = pool
-		return pool, nil
+		return
 	}
 
-	if p.ID != id {
-		return nil, fmt.Errorf("subdomain already in use")
+	log.Printf("Tunnel %s of %s is served by another node", subdomain, pool.ID)
+	messages := []*utils.ControlMessage{
+		{Type: utils.ControlNotice, Message: fmt.Sprintf("Tunnel %s is served by another node of the cluster", subdomain)},
+		{Type: utils.ControlDrain, Reconnect: true},
 	}
+	for _, message := range messages {
+		if err := s.SendControlMessage(pool.ID, message); err != nil {
+			log.Printf("Unable to send %s message to %s : %v", message.Type, pool.ID, err)
+			return
+		}
+	}
+}
+
+// TunnelOwner returns the tunnel user serving a subdomain, if it is connected to this node
+func (s *Server) TunnelOwner(subdomain string) (string, bool) {
+	s.Lock.RLock()
+	defer s.Lock.RUnlock()
 
-	return p, nil
+	pool, ok := s.Pools[subdomain]
+	if !ok {
+		return "", false
+	}
+	return pool.UserIdentifier, true
 }
 
 func (s *Server) GetDestinationURL(subdomain string) string {
@@ -273,5 +510,5 @@ func (s *Server) GetDestinationURL(subdomain string) string {
 		return ""
 	}
 
-	return p.LocalServer
+	return p.LocalServer(subdomain)
 }
@@ -0,0 +1,125 @@
+package tunnel
+
+import (
+	"testing"
+
+	"github.com/amalshaji/beaver/internal/server/admin"
+	"github.com/amalshaji/beaver/internal/server/cluster"
+	"github.com/amalshaji/beaver/internal/server/oidc"
+	"github.com/amalshaji/beaver/internal/utils"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func newTestServer(t *testing.T) *Server {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.AutoMigrate(admin.TunnelSettings{}, admin.CapturedRequest{}, admin.ShareLink{})
+
+	server := new(Server)
+	server.Config = NewConfig()
+	server.Pools = make(map[string]*Pool)
+	server.controls = make(map[PoolID]*ControlChannel)
+	server.done = make(chan struct{})
+	server.TunnelSettings = admin.NewTunnelService(db)
+	server.Captures = admin.NewCaptureService(db)
+	server.ShareLinks = admin.NewShareLinkService(db)
+	server.shareSigner = oidc.NewSigner("secret")
+	return server
+}
+
+func TestGetOrCreatePoolForUserSharesThePool(t *testing.T) {
+	server := newTestServer(t)
+
+	tunnels := []Tunnel{
+		{Subdomain: "web", LocalServer: "http://localhost:8000"},
+		{Subdomain: "api", LocalServer: "http://localhost:9000"},
+	}
+	pool, rejected, err := server.GetOrCreatePoolForUser(tunnels, "test@beaver.com", "session-1")
+	assert.NoError(t, err)
+	assert.Empty(t, rejected)
+	assert.Same(t, pool, server.Pools["web"])
+	assert.Same(t, pool, server.Pools["api"])
+	assert.Len(t, server.uniquePools(), 1)
+	assert.Equal(t, "http://localhost:8000", server.GetDestinationURL("web"))
+	assert.Equal(t, "http://localhost:9000", server.GetDestinationURL("api"))
+
+	// Another session can only register the free subdomains
+	other, rejected, err := server.GetOrCreatePoolForUser([]Tunnel{
+		{Subdomain: "api", LocalServer: "http://localhost:9001"},
+		{Subdomain: "docs", LocalServer: "http://localhost:9002"},
+	}, "test@beaver.com", "session-2")
+	assert.NoError(t, err)
+	assert.Contains(t, rejected, "api")
+	assert.Equal(t, []Tunnel{{Subdomain: "docs", LocalServer: "http://localhost:9002"}}, other.Tunnels())
+	assert.Equal(t, "http://localhost:9000", server.GetDestinationURL("api"))
+
+	_, _, err = server.GetOrCreatePoolForUser(tunnels[:1], "test@beaver.com", "session-2")
+	assert.EqualError(t, err, "subdomain already in use")
+
+	// The session dropped a tunnel
+	same, _, err := server.GetOrCreatePoolForUser(tunnels[1:], "test@beaver.com", "session-1")
+	assert.NoError(t, err)
+	assert.Same(t, pool, same)
+	assert.NotContains(t, server.Pools, "web")
+	assert.Equal(t, "api", pool.Subdomain)
+	assert.Len(t, server.ListPools(), 2)
+
+	// The session id of another user is refused
+	_, _, err = server.GetOrCreatePoolForUser([]Tunnel{{Subdomain: "other", LocalServer: "http://localhost:8000"}}, "other@beaver.com", "session-1")
+	assert.ErrorIs(t, err, ErrClientIDInUse)
+	assert.NotContains(t, server.Pools, "other")
+}
+
+func TestGetOrCreatePoolForUserClaimsSubdomains(t *testing.T) {
+	store := cluster.NewMemoryStore()
+	assert.NoError(t, cluster.NewMemoryRegistry(store, cluster.Node{ID: "other"}).Claim("api"))
+
+	server := newTestServer(t)
+	server.Node = cluster.Node{ID: "local"}
+	server.Registry = cluster.NewMemoryRegistry(store, server.Node)
+
+	_, rejected, err := server.GetOrCreatePoolForUser([]Tunnel{
+		{Subdomain: "web", LocalServer: "http://localhost:8000"},
+		{Subdomain: "api", LocalServer: "http://localhost:9000"},
+	}, "test@beaver.com", "session-1")
+	assert.NoError(t, err)
+	assert.ErrorIs(t, rejected["api"], cluster.ErrOwnedElsewhere)
+
+	owner, ok := server.Registry.Lookup("web")
+	assert.True(t, ok)
+	assert.Equal(t, "local", owner.ID)
+
+	node, ok := server.RemoteOwner("api")
+	assert.True(t, ok)
+	assert.Equal(t, "other", node.ID)
+}
+
+func TestLoseSubdomain(t *testing.T) {
+	server := newTestServer(t)
+	pool, _, err := server.GetOrCreatePoolForUser([]Tunnel{
+		{Subdomain: "api", LocalServer: "http://localhost:9000"},
+		{Subdomain: "web", LocalServer: "http://localhost:8000", BasicAuth: &admin.BasicAuthSettings{Username: "admin"}},
+	}, "test@beaver.com", "session-1")
+	assert.NoError(t, err)
+	peer := newControlPeer(t, server, "session-1")
+
+	// Another node of the cluster claimed the subdomain first
+	server.loseSubdomain("api")
+	assert.Nil(t, server.Pools["api"])
+	assert.Equal(t, pool, server.Pools["web"])
+	assert.Equal(t, []Tunnel{{Subdomain: "web", LocalServer: "http://localhost:8000"}}, pool.Tunnels())
+	assert.Equal(t, "web", pool.Subdomain)
+	assert.NotNil(t, pool.basicAuth["web"])
+
+	// The client reconnects to learn its tunnel is refused
+	var message utils.ControlMessage
+	assert.NoError(t, peer.ReadJSON(&message))
+	assert.Equal(t, utils.ControlNotice, message.Type)
+	var drain utils.ControlMessage
+	assert.NoError(t, peer.ReadJSON(&drain))
+	assert.Equal(t, utils.ControlMessage{Type: utils.ControlDrain, Reconnect: true}, drain)
+}
@@ -0,0 +1,70 @@
+package tunnel
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"time"
+
+	"github.com/amalshaji/beaver/internal/server/admin"
+	"github.com/amalshaji/beaver/internal/server/oidc"
+)
+
+const (
+	// ShareLinkParam is the query parameter of a share link, it is traded for ShareLinkCookie on the first visit
+	ShareLinkParam  = "beaver_share"
+	ShareLinkCookie = "beaver_share"
+)
+
+// shareToken is the signed value of a share link
+type shareToken struct {
+	ID        uint   `json:"id"`
+	Subdomain string `json:"subdomain"`
+}
+
+// newShareSigner returns the signer of the share links, they are invalidated by a restart without a secret
+func newShareSigner(secret string) *oidc.Signer {
+	if secret == "" {
+		log.Println("sharelinksecret is not set, share links stop working when the server restarts")
+	}
+	return oidc.NewSigner(secret)
+}
+
+// ShareLinkURL returns the URL of a share link, the token expires with the link
+func (s *Server) ShareLinkURL(link *admin.ShareLink) (string, error) {
+	token, err := s.shareSigner.Sign("share", shareToken{ID: link.ID, Subdomain: link.Subdomain}, time.Until(link.ExpiresAt))
+	if err != nil {
+		return "", err
+	}
+
+	scheme := "http"
+	if s.Config.Secure {
+		scheme = "https"
+	}
+	shareURL := url.URL{
+		Scheme:   scheme,
+		Host:     fmt.Sprintf("%s.%s", link.Subdomain, s.Config.Domain),
+		Path:     "/",
+		RawQuery: url.Values{ShareLinkParam: {token}}.Encode(),
+	}
+	return shareURL.String(), nil
+}
+
+// VerifyShareLink returns the share link of a token, if it grants access to the tunnel
+func (s *Server) VerifyShareLink(ctx context.Context, token, subdomain string) (*admin.ShareLink, error) {
+	var value shareToken
+	if err := s.shareSigner.Verify("share", token, &value); err != nil || value.Subdomain != subdomain {
+		return nil, admin.ErrShareLinkExpired
+	}
+
+	// Revoked links are only known from the database
+	link, err := s.ShareLinks.GetShareLink(ctx, value.ID)
+	if err != nil {
+		return nil, admin.ErrShareLinkExpired
+	}
+	if !link.Active() || link.Subdomain != subdomain {
+		return nil, admin.ErrShareLinkExpired
+	}
+	return link, nil
+}
@@ -0,0 +1,41 @@
+package tunnel
+
+import (
+	"context"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/amalshaji/beaver/internal/server/admin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShareLinks(t *testing.T) {
+	server := newTestServer(t)
+	server.Config.Domain = "beaver.example.com"
+	ctx := context.Background()
+
+	link, err := server.ShareLinks.CreateShareLink(ctx, "web", time.Hour, "test@beaver.com", "")
+	assert.NoError(t, err)
+
+	shareURL, err := server.ShareLinkURL(link)
+	assert.NoError(t, err)
+	parsed, _ := url.Parse(shareURL)
+	assert.Equal(t, "web.beaver.example.com", parsed.Host)
+	token := parsed.Query().Get(ShareLinkParam)
+
+	verified, err := server.VerifyShareLink(ctx, token, "web")
+	assert.NoError(t, err)
+	assert.Equal(t, link.ID, verified.ID)
+
+	// The token is bound to its tunnel
+	_, err = server.VerifyShareLink(ctx, token, "api")
+	assert.Equal(t, admin.ErrShareLinkExpired, err)
+	_, err = server.VerifyShareLink(ctx, token+"x", "web")
+	assert.Equal(t, admin.ErrShareLinkExpired, err)
+
+	_, err = server.ShareLinks.RevokeShareLink(ctx, link.ID)
+	assert.NoError(t, err)
+	_, err = server.VerifyShareLink(ctx, token, "web")
+	assert.Equal(t, admin.ErrShareLinkExpired, err)
+}
@@ -0,0 +1,99 @@
+package tunnel
+
+import (
+	"time"
+
+	"github.com/amalshaji/beaver/internal/server/admin"
+)
+
+// Window over which the peak concurrency of a pool is observed
+const concurrencyWindow = time.Minute
+
+// PoolLimits are the pool sizes the server advertises to a client during the handshake
+type PoolLimits struct {
+	IdleSize int
+	MaxSize  int
+}
+
+// observeConcurrency records the number of busy connections of the pool.
+// It is called every time a connection is dispatched.
+func (pool *Pool) observeConcurrency() {
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+
+	busy := 0
+	for _, connection := range pool.connections {
+		if connection.status == Busy {
+			busy++
+		}
+	}
+
+	pool.rollConcurrencyWindow(time.Now())
+	if busy > pool.peakBusy {
+		pool.peakBusy = busy
+	}
+}
+
+// rollConcurrencyWindow keeps the peak of the previous window, so the
+// recommendation decays slowly once the traffic goes down.
+// This MUST be surrounded by pool.lock.Lock()
+func (pool *Pool) rollConcurrencyWindow(now time.Time) {
+	if now.Sub(pool.windowStart) < concurrencyWindow {
+		return
+	}
+	if now.Sub(pool.windowStart) < 2*concurrencyWindow {
+		pool.previousPeakBusy = pool.peakBusy
+	} else {
+		pool.previousPeakBusy = 0
+	}
+	pool.peakBusy = 0
+	pool.windowStart = now
+}
+
+// Limits computes the pool sizes for the client from the observed concurrency,
+// the idle size requested by the client and the connections the user may still open.
+// It also updates the number of idle connections the pool keeps open.
+func (pool *Pool) Limits(requestedIdleSize int, maxSize int) PoolLimits {
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+
+	pool.rollConcurrencyWindow(time.Now())
+
+	idleSize := pool.server.Config.PoolIdleSize
+	for _, n := range []int{requestedIdleSize, pool.peakBusy, pool.previousPeakBusy} {
+		if n > idleSize {
+			idleSize = n
+		}
+	}
+	if idleSize > maxSize {
+		idleSize = maxSize
+	}
+	if idleSize < 1 {
+		idleSize = 1
+	}
+
+	pool.size = idleSize
+	return PoolLimits{IdleSize: idleSize, MaxSize: maxSize}
+}
+
+// PoolMaxSizeForUser returns the maximum number of connections a user may open
+func (s *Server) PoolMaxSizeForUser(tunnelUser *admin.TunnelUser) int {
+	if tunnelUser.PoolMaxSize > 0 {
+		return tunnelUser.PoolMaxSize
+	}
+	return s.Config.PoolMaxSize
+}
+
+// CountUserConnections returns the number of open connections of a user across all its pools.
+// This MUST be surrounded by s.Lock.Lock()
+func (s *Server) CountUserConnections(userIdentifier string) int {
+	count := 0
+	for _, pool := range s.uniquePools() {
+		if pool.UserIdentifier != userIdentifier {
+			continue
+		}
+		ps := pool.Size()
+		count += ps.Idle + ps.Busy
+	}
+	return count
+}
@@ -0,0 +1,43 @@
+package tunnel
+
+import (
+	"testing"
+	"time"
+
+	"github.com/amalshaji/beaver/internal/server/admin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPoolLimits(t *testing.T) {
+	server := new(Server)
+	server.Config = NewConfig()
+	server.Config.PoolIdleSize = 2
+
+	pool := NewPool(server, "test", "test", "http://localhost:9999", "test@beaver.com")
+
+	// The server default applies when the client asks for less
+	assert.Equal(t, PoolLimits{IdleSize: 2, MaxSize: 10}, pool.Limits(1, 10))
+	assert.Equal(t, 2, pool.size)
+
+	// The client request is honored up to the limit
+	assert.Equal(t, PoolLimits{IdleSize: 5, MaxSize: 10}, pool.Limits(5, 10))
+	assert.Equal(t, PoolLimits{IdleSize: 3, MaxSize: 3}, pool.Limits(5, 3))
+
+	// The observed concurrency raises the recommendation
+	pool.peakBusy = 7
+	assert.Equal(t, PoolLimits{IdleSize: 7, MaxSize: 10}, pool.Limits(1, 10))
+
+	// And decays over the next windows
+	pool.windowStart = time.Now().Add(-concurrencyWindow)
+	assert.Equal(t, 7, pool.Limits(1, 10).IdleSize)
+	pool.windowStart = time.Now().Add(-2 * concurrencyWindow)
+	assert.Equal(t, 2, pool.Limits(1, 10).IdleSize)
+}
+
+func TestPoolMaxSizeForUser(t *testing.T) {
+	server := new(Server)
+	server.Config = NewConfig()
+
+	assert.Equal(t, 100, server.PoolMaxSizeForUser(&admin.TunnelUser{}))
+	assert.Equal(t, 5, server.PoolMaxSizeForUser(&admin.TunnelUser{PoolMaxSize: 5}))
+}
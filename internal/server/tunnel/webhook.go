@@ -0,0 +1,51 @@
+package tunnel
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+
+	"github.com/amalshaji/beaver/internal/webhook"
+)
+
+// Webhook returns the webhook signature spec of a tunnel, the admin settings take precedence over the client registration
+func (pool *Pool) Webhook(subdomain string) *webhook.Spec {
+	pool.lock.RLock()
+	defer pool.lock.RUnlock()
+
+	if settings, ok := pool.settings[subdomain]; ok && settings.Webhook != nil {
+		return settings.Webhook
+	}
+	return pool.webhooks[subdomain]
+}
+
+// VerifyWebhook checks the signature of a request to a tunnel verifying its webhooks.
+// The body is read to be verified, then put back for the local server.
+func (s *Server) VerifyWebhook(subdomain string, req *http.Request) error {
+	if req.Context().Value(skipAuthKey{}) != nil {
+		return nil
+	}
+
+	s.Lock.RLock()
+	pool, ok := s.Pools[subdomain]
+	s.Lock.RUnlock()
+	if !ok {
+		return nil
+	}
+
+	spec := pool.Webhook(subdomain)
+	if spec == nil {
+		return nil
+	}
+
+	body, err := io.ReadAll(io.LimitReader(req.Body, webhook.MaxBodySize+1))
+	req.Body.Close()
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if len(body) > webhook.MaxBodySize {
+		return webhook.ErrBodyTooLarge
+	}
+	return spec.Verify(req.Header, body)
+}
@@ -0,0 +1,61 @@
+package tunnel
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/amalshaji/beaver/internal/server/admin"
+	"github.com/amalshaji/beaver/internal/webhook"
+	"github.com/stretchr/testify/assert"
+)
+
+func githubSignature(secret, body string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(body))
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestVerifyWebhook(t *testing.T) {
+	server := newTestServer(t)
+
+	_, _, err := server.GetOrCreatePoolForUser([]Tunnel{
+		{Subdomain: "hooks", LocalServer: "http://localhost:8000", Webhook: &webhook.Spec{Provider: webhook.GitHub, Secret: "secret"}},
+		{Subdomain: "web", LocalServer: "http://localhost:9000"},
+	}, "test@beaver.com", "session-1")
+	assert.NoError(t, err)
+
+	body := `{"action":"opened"}`
+	req := httptest.NewRequest("POST", "/", strings.NewReader(body))
+	req.Header.Set("X-Hub-Signature-256", githubSignature("secret", body))
+	assert.NoError(t, server.VerifyWebhook("hooks", req))
+	// The local server gets the body
+	forwarded, _ := io.ReadAll(req.Body)
+	assert.Equal(t, body, string(forwarded))
+
+	req = httptest.NewRequest("POST", "/", strings.NewReader(body))
+	req.Header.Set("X-Hub-Signature-256", githubSignature("wrong", body))
+	assert.Equal(t, webhook.ErrInvalidSignature, server.VerifyWebhook("hooks", req))
+
+	req = httptest.NewRequest("POST", "/", strings.NewReader(body))
+	assert.Equal(t, webhook.ErrMissingSignature, server.VerifyWebhook("hooks", req))
+	assert.NoError(t, server.VerifyWebhook("web", req))
+
+	// Replays skip the verification
+	req = httptest.NewRequest("POST", "/", strings.NewReader(body)).WithContext(WithoutAuth(context.Background()))
+	assert.NoError(t, server.VerifyWebhook("hooks", req))
+
+	// The admin settings take precedence over the registration
+	server.ApplyTunnelSettings("hooks", &admin.TunnelSettings{Webhook: &webhook.Spec{Provider: webhook.GitHub, Secret: "rotated"}})
+	req = httptest.NewRequest("POST", "/", strings.NewReader(body))
+	req.Header.Set("X-Hub-Signature-256", githubSignature("secret", body))
+	assert.Equal(t, webhook.ErrInvalidSignature, server.VerifyWebhook("hooks", req))
+	req.Body = io.NopCloser(strings.NewReader(body))
+	req.Header.Set("X-Hub-Signature-256", githubSignature("rotated", body))
+	assert.NoError(t, server.VerifyWebhook("hooks", req))
+}
@@ -0,0 +1,78 @@
+// Package upgrade lets a new beaver_server process take over the listening socket
+// of a running one, so the binary can be replaced without dropping tunnels.
+//
+// The running process starts the new binary with the listener as an extra file,
+// waits for it to report ready, then stops accepting connections and drains its tunnels.
+package upgrade
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+)
+
+// Environment of the new process, holding the inherited file descriptors
+const (
+	ListenerFdEnv = "BEAVER_LISTENER_FD"
+	ReadyFdEnv    = "BEAVER_READY_FD"
+)
+
+var ErrUnsupported = errors.New("binary upgrade is not supported on this platform")
+
+// Listen returns the listener inherited from the parent process, or a new one bound to addr
+func Listen(addr string) (net.Listener, error) {
+	fd, ok, err := inheritedFd(ListenerFdEnv)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return net.Listen("tcp", addr)
+	}
+
+	file := os.NewFile(fd, "listener")
+	defer file.Close()
+
+	ln, err := net.FileListener(file)
+	if err != nil {
+		return nil, fmt.Errorf("unable to use inherited listener : %w", err)
+	}
+	return ln, nil
+}
+
+// Inherited reports whether the process was started by an upgrade
+func Inherited() bool {
+	return os.Getenv(ListenerFdEnv) != ""
+}
+
+// Ready notifies the parent process that this process serves requests, so it can start draining.
+// It does nothing if the process was not started by an upgrade.
+func Ready() error {
+	fd, ok, err := inheritedFd(ReadyFdEnv)
+	if err != nil || !ok {
+		return err
+	}
+
+	file := os.NewFile(fd, "ready")
+	defer file.Close()
+
+	// Don't pass the descriptors on to a later upgrade
+	os.Unsetenv(ListenerFdEnv)
+	os.Unsetenv(ReadyFdEnv)
+
+	_, err = file.Write([]byte{1})
+	return err
+}
+
+func inheritedFd(env string) (uintptr, bool, error) {
+	value := os.Getenv(env)
+	if value == "" {
+		return 0, false, nil
+	}
+	fd, err := strconv.Atoi(value)
+	if err != nil || fd < 3 {
+		return 0, false, fmt.Errorf("invalid %s: '%s'", env, value)
+	}
+	return uintptr(fd), true, nil
+}
@@ -0,0 +1,86 @@
+//go:build linux
+
+package upgrade
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// Notify relays the upgrade signal (SIGUSR2) to c
+func Notify(c chan<- os.Signal) {
+	signal.Notify(c, syscall.SIGUSR2)
+}
+
+// IsUpgradeSignal reports whether sig asks for an upgrade
+func IsUpgradeSignal(sig os.Signal) bool {
+	return sig == syscall.SIGUSR2
+}
+
+// Spawn starts the current binary again, with the same arguments, handing it the listener.
+// It returns once the new process is ready to serve, or kills it after timeout.
+func Spawn(ln net.Listener, timeout time.Duration) (*os.Process, error) {
+	tcpListener, ok := ln.(*net.TCPListener)
+	if !ok {
+		return nil, fmt.Errorf("unable to pass a %T to the new process", ln)
+	}
+
+	listenerFile, err := tcpListener.File()
+	if err != nil {
+		return nil, fmt.Errorf("unable to get listener file : %w", err)
+	}
+	defer listenerFile.Close()
+
+	readyReader, readyWriter, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	defer readyReader.Close()
+
+	executable, err := os.Executable()
+	if err != nil {
+		readyWriter.Close()
+		return nil, err
+	}
+
+	// ExtraFiles[i] becomes fd 3+i in the new process
+	env := append(os.Environ(), ListenerFdEnv+"=3", ReadyFdEnv+"=4")
+	process, err := os.StartProcess(executable, os.Args, &os.ProcAttr{
+		Env:   env,
+		Files: []*os.File{os.Stdin, os.Stdout, os.Stderr, listenerFile, readyWriter},
+	})
+	// Only the new process holds the write end now, so the read fails if it exits early
+	readyWriter.Close()
+	if err != nil {
+		return nil, fmt.Errorf("unable to start new process : %w", err)
+	}
+
+	ready := make(chan error, 1)
+	go func() {
+		buf := make([]byte, 1)
+		_, err := readyReader.Read(buf)
+		ready <- err
+	}()
+
+	select {
+	case err := <-ready:
+		if err != nil {
+			process.Kill()
+			process.Wait()
+			return nil, fmt.Errorf("new process exited before being ready : %w", err)
+		}
+	case <-time.After(timeout):
+		process.Kill()
+		process.Wait()
+		return nil, fmt.Errorf("new process not ready after %s", timeout)
+	}
+
+	// Reap the new process if it outlives us and then exits
+	go process.Wait()
+
+	return process, nil
+}
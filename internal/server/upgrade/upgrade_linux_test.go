@@ -0,0 +1,89 @@
+//go:build linux
+
+package upgrade
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// handler answers every request with the pid of the process
+var handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/slow" {
+		time.Sleep(500 * time.Millisecond)
+	}
+	fmt.Fprint(w, os.Getpid())
+})
+
+func get(url string) (string, error) {
+	// A new connection per request, keep-alive connections stay with the old process
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+	res, err := client.Get(url)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	return string(body), err
+}
+
+func TestUpgrade(t *testing.T) {
+	// The new process runs this same test, as the child
+	if Inherited() {
+		ln, err := Listen("")
+		if err != nil {
+			os.Exit(1)
+		}
+		go http.Serve(ln, handler)
+		if err := Ready(); err != nil {
+			os.Exit(1)
+		}
+		time.Sleep(3 * time.Second)
+		return
+	}
+
+	ln, err := Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := "http://" + ln.Addr().String()
+
+	go http.Serve(ln, handler)
+
+	parent := fmt.Sprint(os.Getpid())
+	body, err := get(addr)
+	assert.NoError(t, err)
+	assert.Equal(t, parent, body)
+
+	// A request in flight during the upgrade is served by the old process
+	slow := make(chan string, 1)
+	go func() {
+		body, _ := get(addr + "/slow")
+		slow <- body
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	process, err := Spawn(ln, 10*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer process.Kill()
+
+	// Stop accepting, the new process owns the socket now
+	ln.Close()
+
+	child := fmt.Sprint(process.Pid)
+	for i := 0; i < 5; i++ {
+		body, err := get(addr)
+		assert.NoError(t, err)
+		assert.Equal(t, child, body)
+	}
+
+	assert.Equal(t, parent, <-slow)
+}
@@ -0,0 +1,22 @@
+//go:build !linux
+
+package upgrade
+
+import (
+	"net"
+	"os"
+	"time"
+)
+
+// Notify does nothing, binary upgrades are only supported on Linux
+func Notify(c chan<- os.Signal) {}
+
+// IsUpgradeSignal always returns false, binary upgrades are only supported on Linux
+func IsUpgradeSignal(sig os.Signal) bool {
+	return false
+}
+
+// Spawn is only supported on Linux
+func Spawn(ln net.Listener, timeout time.Duration) (*os.Process, error) {
+	return nil, ErrUnsupported
+}
@@ -0,0 +1,312 @@
+// Package traffic holds the HTTP requests and responses captured from the tunnels,
+// for the inspector of the client and the captures of the server.
+// The server saves them in its database in their JSON form, which must stay compatible.
+package traffic
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/amalshaji/beaver/internal/utils"
+)
+
+// DefaultMaxBodySize is the default size limit of the captured bodies
+const DefaultMaxBodySize = 64 * 1024
+
+var ErrBodyTruncated = errors.New("the captured body is truncated, edit it to replay the request")
+
+// Request is a captured HTTP request, its body is kept up to a size limit
+type Request struct {
+	Method string
+	// URL is the path and query of the request
+	URL    string
+	Host   string
+	Header http.Header
+	Body   []byte
+	// BodySize is the size of the whole body
+	BodySize  int64
+	Truncated bool
+}
+
+// Response is a captured HTTP response, its body is kept up to a size limit
+type Response struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+	BodySize   int64
+	Truncated  bool
+}
+
+// Exchange is a request served by a tunnel and the response of the local server
+type Exchange struct {
+	ID        int64         `json:"id"`
+	Time      time.Time     `json:"time"`
+	Duration  time.Duration `json:"duration"`
+	Subdomain string        `json:"subdomain"`
+	Port      int           `json:"port"`
+	Request   *Request      `json:"request"`
+	// Response is nil when the local server could not be reached
+	Response *Response `json:"response,omitempty"`
+	Error    string    `json:"error,omitempty"`
+	// ReplayOf is the exchange this one replays, if any
+	ReplayOf int64 `json:"replayOf,omitempty"`
+}
+
+// Summary is an Exchange without headers and bodies, to list them
+type Summary struct {
+	ID        int64         `json:"id"`
+	Time      time.Time     `json:"time"`
+	Duration  time.Duration `json:"duration"`
+	Subdomain string        `json:"subdomain"`
+	Method    string        `json:"method"`
+	URL       string        `json:"url"`
+	Status    int           `json:"status"`
+	Error     string        `json:"error,omitempty"`
+	ReplayOf  int64         `json:"replayOf,omitempty"`
+}
+
+// Summary returns the summary of the exchange
+func (e *Exchange) Summary() Summary {
+	s := Summary{
+		ID:        e.ID,
+		Time:      e.Time,
+		Duration:  e.Duration,
+		Subdomain: e.Subdomain,
+		Method:    e.Request.Method,
+		URL:       e.Request.URL,
+		Error:     e.Error,
+		ReplayOf:  e.ReplayOf,
+	}
+	if e.Response != nil {
+		s.Status = e.Response.StatusCode
+	}
+	return s
+}
+
+// jsonBody is the JSON form of a body, binary bodies are base64 encoded
+type jsonBody struct {
+	Body         string `json:"body"`
+	BodyEncoding string `json:"bodyEncoding,omitempty"`
+	BodySize     int64  `json:"bodySize"`
+	Truncated    bool   `json:"truncated"`
+}
+
+func encodeBody(body []byte, size int64, truncated bool) jsonBody {
+	b := jsonBody{BodySize: size, Truncated: truncated}
+	if utf8.Valid(body) {
+		b.Body = string(body)
+	} else {
+		b.Body = base64.StdEncoding.EncodeToString(body)
+		b.BodyEncoding = "base64"
+	}
+	return b
+}
+
+func (b jsonBody) decode() ([]byte, error) {
+	if b.BodyEncoding == "base64" {
+		return base64.StdEncoding.DecodeString(b.Body)
+	}
+	return []byte(b.Body), nil
+}
+
+type jsonRequest struct {
+	Method string      `json:"method"`
+	URL    string      `json:"url"`
+	Host   string      `json:"host"`
+	Header http.Header `json:"header"`
+	jsonBody
+}
+
+type jsonResponse struct {
+	StatusCode int         `json:"status"`
+	Header     http.Header `json:"header"`
+	jsonBody
+}
+
+func (r *Request) MarshalJSON() ([]byte, error) {
+	return json.Marshal(jsonRequest{
+		Method:   r.Method,
+		URL:      r.URL,
+		Host:     r.Host,
+		Header:   r.Header,
+		jsonBody: encodeBody(r.Body, r.BodySize, r.Truncated),
+	})
+}
+
+func (r *Request) UnmarshalJSON(data []byte) (err error) {
+	var j jsonRequest
+	if err = json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	*r = Request{Method: j.Method, URL: j.URL, Host: j.Host, Header: j.Header, BodySize: j.BodySize, Truncated: j.Truncated}
+	r.Body, err = j.decode()
+	return err
+}
+
+func (r *Response) MarshalJSON() ([]byte, error) {
+	return json.Marshal(jsonResponse{
+		StatusCode: r.StatusCode,
+		Header:     r.Header,
+		jsonBody:   encodeBody(r.Body, r.BodySize, r.Truncated),
+	})
+}
+
+func (r *Response) UnmarshalJSON(data []byte) (err error) {
+	var j jsonResponse
+	if err = json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	*r = Response{StatusCode: j.StatusCode, Header: j.Header, BodySize: j.BodySize, Truncated: j.Truncated}
+	r.Body, err = j.decode()
+	return err
+}
+
+// Capture is a writer keeping the first bytes written to it, up to a limit, and counting the others
+type Capture struct {
+	limit int64
+	size  int64
+	buf   bytes.Buffer
+}
+
+// NewCapture keeps up to limit bytes
+func NewCapture(limit int64) *Capture {
+	return &Capture{limit: limit}
+}
+
+func (c *Capture) Write(p []byte) (int, error) {
+	if remaining := c.limit - int64(c.buf.Len()); remaining > 0 {
+		if int64(len(p)) > remaining {
+			c.buf.Write(p[:remaining])
+		} else {
+			c.buf.Write(p)
+		}
+	}
+	c.size += int64(len(p))
+	return len(p), nil
+}
+
+// Bytes returns the captured bytes
+func (c *Capture) Bytes() []byte {
+	return bytes.Clone(c.buf.Bytes())
+}
+
+// Size returns the number of bytes written
+func (c *Capture) Size() int64 {
+	return c.size
+}
+
+// Truncated reports whether bytes were dropped
+func (c *Capture) Truncated() bool {
+	return c.size > int64(c.buf.Len())
+}
+
+// NewRequest captures the request line and headers of req, the body is set from a Capture once read
+func NewRequest(req *http.Request) *Request {
+	return &Request{
+		Method: req.Method,
+		URL:    req.URL.RequestURI(),
+		Host:   req.Host,
+		Header: req.Header.Clone(),
+	}
+}
+
+// SetBody sets the body read through capture
+func (r *Request) SetBody(capture *Capture) {
+	r.Body, r.BodySize, r.Truncated = capture.Bytes(), capture.Size(), capture.Truncated()
+}
+
+// NewResponse captures the status and headers of res, the body is set from a Capture once read
+func NewResponse(res *http.Response) *Response {
+	return &Response{
+		StatusCode: res.StatusCode,
+		Header:     res.Header.Clone(),
+	}
+}
+
+// SetBody sets the body read through capture
+func (r *Response) SetBody(capture *Capture) {
+	r.Body, r.BodySize, r.Truncated = capture.Bytes(), capture.Size(), capture.Truncated()
+}
+
+// HTTPRequest builds an http.Request sending the captured request to baseURL (eg: http://localhost:8000)
+func (r *Request) HTTPRequest(ctx context.Context, baseURL string) (*http.Request, error) {
+	if r.Truncated {
+		return nil, ErrBodyTruncated
+	}
+
+	req, err := http.NewRequestWithContext(ctx, r.Method, strings.TrimSuffix(baseURL, "/")+r.URL, bytes.NewReader(r.Body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header = r.Header.Clone()
+	if req.Header == nil {
+		req.Header = make(http.Header)
+	}
+	// The length is the one of the body sent, it may have been edited
+	req.Header.Del("Content-Length")
+	req.Host = r.Host
+	return req, nil
+}
+
+// ReadResponse captures a response, reading and closing its body
+func ReadResponse(res *http.Response, maxBodySize int64) (*Response, error) {
+	defer res.Body.Close()
+
+	response := NewResponse(res)
+	capture := NewCapture(maxBodySize)
+	_, err := io.Copy(capture, res.Body)
+	response.SetBody(capture)
+	return response, err
+}
+
+// Send sends a captured request to baseURL and returns the exchange, the error of a failed request is set in the exchange
+func Send(ctx context.Context, client *http.Client, baseURL string, req *Request, maxBodySize int64) *Exchange {
+	exchange := &Exchange{Time: time.Now(), Request: req}
+
+	httpRequest, err := req.HTTPRequest(ctx, baseURL)
+	if err == nil {
+		var res *http.Response
+		if res, err = client.Do(httpRequest); err == nil {
+			exchange.Response, err = ReadResponse(res, maxBodySize)
+		}
+	}
+	if err != nil {
+		exchange.Error = err.Error()
+	}
+	exchange.Duration = time.Since(exchange.Time)
+	return exchange
+}
+
+// FromHTTPRequest captures the request line and headers of a serialized request
+func FromHTTPRequest(req *utils.HTTPRequest) *Request {
+	r := &Request{
+		Method: req.Method,
+		URL:    req.URL,
+		Host:   http.Header(req.Header).Get("Host"),
+		Header: http.Header(req.Header).Clone(),
+	}
+	if u, err := url.Parse(req.URL); err == nil {
+		r.URL = u.RequestURI()
+		if r.Host == "" {
+			r.Host = u.Host
+		}
+	}
+	return r
+}
+
+// FromHTTPResponse captures the status and headers of a serialized response
+func FromHTTPResponse(res *utils.HTTPResponse) *Response {
+	return &Response{
+		StatusCode: res.StatusCode,
+		Header:     res.Header.Clone(),
+	}
+}
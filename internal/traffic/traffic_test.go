@@ -0,0 +1,44 @@
+package traffic
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCapture(t *testing.T) {
+	capture := NewCapture(4)
+	io.Copy(capture, strings.NewReader("ab"))
+	assert.False(t, capture.Truncated())
+
+	io.Copy(capture, strings.NewReader("cdef"))
+	assert.Equal(t, "abcd", string(capture.Bytes()))
+	assert.Equal(t, int64(6), capture.Size())
+	assert.True(t, capture.Truncated())
+}
+
+func TestExchangeJSON(t *testing.T) {
+	exchange := &Exchange{
+		ID:      1,
+		Request: &Request{Method: "POST", URL: "/hook", Header: http.Header{"X-A": {"1"}}, Body: []byte(`{"a":1}`), BodySize: 7},
+		Response: &Response{
+			StatusCode: 200,
+			Body:       []byte{0xff, 0x00},
+			BodySize:   2,
+		},
+	}
+
+	data, err := json.Marshal(exchange)
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), `"body":"{\"a\":1}"`)
+	assert.Contains(t, string(data), `"bodyEncoding":"base64"`)
+
+	var decoded Exchange
+	assert.NoError(t, json.Unmarshal(data, &decoded))
+	assert.Equal(t, exchange.Request, decoded.Request)
+	assert.Equal(t, exchange.Response.Body, decoded.Response.Body)
+}
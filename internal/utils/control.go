@@ -0,0 +1,29 @@
+package utils
+
+// Types of the messages sent over the control channel
+const (
+	// ControlNotice is an informational message to surface to the user
+	ControlNotice = "notice"
+	// ControlKick asks the client to disconnect and stop
+	ControlKick = "kick"
+	// ControlDrain asks the client to move its connections, to Target if it is set,
+	// or to the same server if Reconnect is set
+	ControlDrain = "drain"
+	// ControlConfig carries updated pool sizes
+	ControlConfig = "config"
+)
+
+// ControlMessage is a message sent by the server to a client session over its control channel
+type ControlMessage struct {
+	Type    string
+	Message string `json:",omitempty"`
+
+	// Server to reconnect to, for drain messages.
+	// Reconnect is set when the same address is already served by a new process.
+	Target    string `json:",omitempty"`
+	Reconnect bool   `json:",omitempty"`
+
+	// Pool sizes, for config messages
+	PoolIdleSize int `json:",omitempty"`
+	PoolMaxSize  int `json:",omitempty"`
+}
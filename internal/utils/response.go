@@ -55,3 +55,24 @@ func HttpUnauthorized(c echo.Context, format string, args ...interface{}) error
 		map[string]string{"error": fmt.Errorf(format, args...).Error()},
 	)
 }
+
+func HttpForbidden(c echo.Context, format string, args ...interface{}) error {
+	return c.JSON(
+		http.StatusForbidden,
+		map[string]string{"error": fmt.Errorf(format, args...).Error()},
+	)
+}
+
+func HttpTooManyRequests(c echo.Context, format string, args ...interface{}) error {
+	return c.JSON(
+		http.StatusTooManyRequests,
+		map[string]string{"error": fmt.Errorf(format, args...).Error()},
+	)
+}
+
+func HttpServiceUnavailable(c echo.Context, format string, args ...interface{}) error {
+	return c.JSON(
+		http.StatusServiceUnavailable,
+		map[string]string{"error": fmt.Errorf(format, args...).Error()},
+	)
+}
@@ -0,0 +1,230 @@
+// Package webhook verifies the HMAC signatures of webhooks, with the schemes of GitHub, Stripe and Slack or a generic one
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	GitHub = "github"
+	Stripe = "stripe"
+	Slack  = "slack"
+
+	// Bodies are read up to this size to be verified, the largest payload GitHub sends
+	MaxBodySize = 25 << 20
+	// Age of the signed timestamps of Stripe and Slack after which a request is considered replayed
+	tolerance = 5 * time.Minute
+)
+
+var (
+	ErrInvalidSpec      = errors.New("invalid webhook signature spec")
+	ErrMissingSignature = errors.New("missing webhook signature")
+	ErrInvalidSignature = errors.New("invalid webhook signature")
+	ErrExpiredTimestamp = errors.New("webhook timestamp is too old")
+	ErrBodyTooLarge     = errors.New("webhook body is too large to be verified")
+)
+
+// Spec describes how the webhooks of a tunnel are signed, with the preset of a provider
+// or a generic header holding the HMAC of the body
+type Spec struct {
+	// github, stripe or slack, the fields below describe the signature when empty
+	Provider string
+	Secret   string
+	// Header holding the signature, eg: X-Signature
+	Header string
+	// Hash of the HMAC: sha1, sha256 (default) or sha512
+	Algorithm string
+	// Encoding of the signature: hex (default) or base64
+	Encoding string
+	// Prefix of the signature in the header, eg: sha256=
+	Prefix string
+}
+
+// ParseSpec parses a spec written as provider:secret, eg: github:s3cr3t, or as comma separated key=value pairs, eg:
+//
+//	header=X-Signature,algorithm=sha256,encoding=hex,prefix=sha256=,secret=s3cr3t
+func ParseSpec(s string) (Spec, error) {
+	if provider, secret, ok := strings.Cut(s, ":"); ok && isPreset(strings.ToLower(provider)) {
+		spec := Spec{Provider: strings.ToLower(provider), Secret: secret}
+		return spec, spec.Validate()
+	}
+
+	var spec Spec
+	for _, option := range strings.Split(s, ",") {
+		if strings.TrimSpace(option) == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(option, "=")
+		if provider, _, colon := strings.Cut(option, ":"); !ok && colon {
+			return Spec{}, fmt.Errorf("%w : unknown provider '%s', expected github, stripe or slack", ErrInvalidSpec, provider)
+		}
+		if !ok {
+			return Spec{}, fmt.Errorf("%w %q : expected provider:secret or key=value", ErrInvalidSpec, option)
+		}
+		value = strings.TrimSpace(value)
+		switch strings.ToLower(strings.TrimSpace(key)) {
+		case "header":
+			spec.Header = value
+		case "algorithm":
+			spec.Algorithm = strings.ToLower(value)
+		case "encoding":
+			spec.Encoding = strings.ToLower(value)
+		case "prefix":
+			spec.Prefix = value
+		case "secret":
+			spec.Secret = value
+		default:
+			return Spec{}, fmt.Errorf("%w : unknown key %q, expected header, algorithm, encoding, prefix or secret", ErrInvalidSpec, key)
+		}
+	}
+	return spec, spec.Validate()
+}
+
+func isPreset(provider string) bool {
+	return provider == GitHub || provider == Stripe || provider == Slack
+}
+
+// Validate checks that the spec describes a signature the server can verify
+func (s *Spec) Validate() error {
+	if s.Secret == "" {
+		return fmt.Errorf("%w : a secret is required", ErrInvalidSpec)
+	}
+	if s.Provider != "" {
+		if !isPreset(s.Provider) {
+			return fmt.Errorf("%w : unknown provider '%s', expected github, stripe or slack", ErrInvalidSpec, s.Provider)
+		}
+		return nil
+	}
+
+	if s.Header == "" {
+		return fmt.Errorf("%w : a header or a provider is required", ErrInvalidSpec)
+	}
+	if _, err := newHash(s.Algorithm); err != nil {
+		return err
+	}
+	if s.Encoding != "" && s.Encoding != "hex" && s.Encoding != "base64" {
+		return fmt.Errorf("%w : unknown encoding '%s', expected hex or base64", ErrInvalidSpec, s.Encoding)
+	}
+	return nil
+}
+
+func newHash(algorithm string) (func() hash.Hash, error) {
+	switch algorithm {
+	case "", "sha256":
+		return sha256.New, nil
+	case "sha1":
+		return sha1.New, nil
+	case "sha512":
+		return sha512.New, nil
+	}
+	return nil, fmt.Errorf("%w : unknown algorithm '%s', expected sha1, sha256 or sha512", ErrInvalidSpec, algorithm)
+}
+
+// Verify checks the signature of a request from its headers and its body
+func (s *Spec) Verify(header http.Header, body []byte) error {
+	switch s.Provider {
+	case GitHub:
+		return verifyHex(header.Get("X-Hub-Signature-256"), "sha256=", sign(sha256.New, s.Secret, body))
+	case Stripe:
+		return s.verifyStripe(header.Get("Stripe-Signature"), body)
+	case Slack:
+		timestamp := header.Get("X-Slack-Request-Timestamp")
+		if err := checkTimestamp(timestamp); err != nil {
+			return err
+		}
+		payload := append([]byte("v0:"+timestamp+":"), body...)
+		return verifyHex(header.Get("X-Slack-Signature"), "v0=", sign(sha256.New, s.Secret, payload))
+	}
+
+	h, err := newHash(s.Algorithm)
+	if err != nil {
+		return err
+	}
+	signature := header.Get(s.Header)
+	if signature == "" {
+		return ErrMissingSignature
+	}
+	if s.Encoding != "base64" {
+		return verifyHex(signature, s.Prefix, sign(h, s.Secret, body))
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(signature, s.Prefix))
+	if err != nil || !hmac.Equal(decoded, sign(h, s.Secret, body)) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// verifyStripe checks a Stripe-Signature header, t=timestamp,v1=signature, one of the v1 signatures must match
+func (s *Spec) verifyStripe(signature string, body []byte) error {
+	if signature == "" {
+		return ErrMissingSignature
+	}
+
+	var timestamp string
+	var signatures []string
+	for _, part := range strings.Split(signature, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch key {
+		case "t":
+			timestamp = value
+		case "v1":
+			signatures = append(signatures, value)
+		}
+	}
+	if err := checkTimestamp(timestamp); err != nil {
+		return err
+	}
+
+	expected := sign(sha256.New, s.Secret, append([]byte(timestamp+"."), body...))
+	for _, signature := range signatures {
+		if verifyHex(signature, "", expected) == nil {
+			return nil
+		}
+	}
+	return ErrInvalidSignature
+}
+
+// checkTimestamp refuses the requests signed too long ago, so a captured request cannot be sent again
+func checkTimestamp(timestamp string) error {
+	if timestamp == "" {
+		return ErrMissingSignature
+	}
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+	if age := time.Since(time.Unix(seconds, 0)); age > tolerance || age < -tolerance {
+		return ErrExpiredTimestamp
+	}
+	return nil
+}
+
+func verifyHex(signature, prefix string, expected []byte) error {
+	if signature == "" {
+		return ErrMissingSignature
+	}
+	decoded, err := hex.DecodeString(strings.TrimPrefix(signature, prefix))
+	if err != nil || !hmac.Equal(decoded, expected) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+func sign(h func() hash.Hash, secret string, payload []byte) []byte {
+	mac := hmac.New(h, []byte(secret))
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
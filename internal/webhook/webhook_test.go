@@ -0,0 +1,110 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"hash"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func hexMAC(h func() hash.Hash, secret, payload string) string {
+	mac := hmac.New(h, []byte(secret))
+	mac.Write([]byte(payload))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestParseSpec(t *testing.T) {
+	spec, err := ParseSpec("GitHub:s3cr3t:with:colons")
+	assert.NoError(t, err)
+	assert.Equal(t, Spec{Provider: GitHub, Secret: "s3cr3t:with:colons"}, spec)
+
+	spec, err = ParseSpec("header=X-Signature, algorithm=SHA1,encoding=base64,prefix=sha1=,secret=abc=")
+	assert.NoError(t, err)
+	assert.Equal(t, Spec{Header: "X-Signature", Algorithm: "sha1", Encoding: "base64", Prefix: "sha1=", Secret: "abc="}, spec)
+
+	for _, invalid := range []string{"github:", "gitlab:secret", "header=X-Signature", "secret=abc", "header=X-Sig,secret=abc,algorithm=md5", "header=X-Sig,secret=abc,encoding=binary", "header=X-Sig,secret=abc,tolerance=5m"} {
+		_, err := ParseSpec(invalid)
+		assert.ErrorIs(t, err, ErrInvalidSpec, invalid)
+	}
+}
+
+func TestVerifyGitHub(t *testing.T) {
+	spec := Spec{Provider: GitHub, Secret: "secret"}
+	body := []byte(`{"action":"opened"}`)
+
+	header := http.Header{}
+	assert.Equal(t, ErrMissingSignature, spec.Verify(header, body))
+
+	header.Set("X-Hub-Signature-256", "sha256="+hexMAC(sha256.New, "secret", string(body)))
+	assert.NoError(t, spec.Verify(header, body))
+	assert.Equal(t, ErrInvalidSignature, spec.Verify(header, []byte(`{"action":"closed"}`)))
+
+	header.Set("X-Hub-Signature-256", "sha256="+hexMAC(sha256.New, "other", string(body)))
+	assert.Equal(t, ErrInvalidSignature, spec.Verify(header, body))
+}
+
+func TestVerifyStripe(t *testing.T) {
+	spec := Spec{Provider: Stripe, Secret: "whsec_test"}
+	body := []byte(`{"type":"charge.succeeded"}`)
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+
+	// Several signatures are sent while a secret is rolled
+	header := http.Header{}
+	header.Set("Stripe-Signature", "t="+now+",v1="+hexMAC(sha256.New, "whsec_old", now+"."+string(body))+",v1="+hexMAC(sha256.New, "whsec_test", now+"."+string(body)))
+	assert.NoError(t, spec.Verify(header, body))
+
+	header.Set("Stripe-Signature", "t="+now+",v1="+hexMAC(sha256.New, "whsec_old", now+"."+string(body)))
+	assert.Equal(t, ErrInvalidSignature, spec.Verify(header, body))
+
+	old := strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)
+	header.Set("Stripe-Signature", "t="+old+",v1="+hexMAC(sha256.New, "whsec_test", old+"."+string(body)))
+	assert.Equal(t, ErrExpiredTimestamp, spec.Verify(header, body))
+
+	header.Set("Stripe-Signature", "v1="+hexMAC(sha256.New, "whsec_test", string(body)))
+	assert.Equal(t, ErrMissingSignature, spec.Verify(header, body))
+}
+
+func TestVerifySlack(t *testing.T) {
+	spec := Spec{Provider: Slack, Secret: "secret"}
+	body := []byte("token=xyz&command=/deploy")
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+
+	header := http.Header{}
+	header.Set("X-Slack-Request-Timestamp", now)
+	header.Set("X-Slack-Signature", "v0="+hexMAC(sha256.New, "secret", "v0:"+now+":"+string(body)))
+	assert.NoError(t, spec.Verify(header, body))
+
+	// The timestamp is part of the signature
+	header.Set("X-Slack-Request-Timestamp", strconv.FormatInt(time.Now().Unix()-1, 10))
+	assert.Equal(t, ErrInvalidSignature, spec.Verify(header, body))
+
+	header.Del("X-Slack-Request-Timestamp")
+	assert.Equal(t, ErrMissingSignature, spec.Verify(header, body))
+}
+
+func TestVerifyGeneric(t *testing.T) {
+	body := []byte("payload")
+
+	spec := Spec{Header: "X-Signature", Secret: "secret"}
+	header := http.Header{}
+	header.Set("X-Signature", hexMAC(sha256.New, "secret", "payload"))
+	assert.NoError(t, spec.Verify(header, body))
+
+	mac := hmac.New(sha1.New, []byte("secret"))
+	mac.Write(body)
+	spec = Spec{Header: "X-Signature", Algorithm: "sha1", Encoding: "base64", Prefix: "sha1=", Secret: "secret"}
+	header.Set("X-Signature", "sha1="+base64.StdEncoding.EncodeToString(mac.Sum(nil)))
+	assert.NoError(t, spec.Verify(header, body))
+	assert.Equal(t, ErrInvalidSignature, spec.Verify(header, []byte("other")))
+
+	header.Del("X-Signature")
+	assert.Equal(t, ErrMissingSignature, spec.Verify(header, body))
+}